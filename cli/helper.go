@@ -3,20 +3,154 @@ package cli
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+	"vm/rmm"
 )
 
+const flagUsage = `
+Flags:
+  --debug, -d            print tokens, instructions and every step
+  -O0, -O1, -O2          optimization level (default -O0); -O1 folds constants,
+                         threads jumps and drops noops and unreachable code;
+                         -O2 also fuses common pairs into superinstructions
+  --trace=FILE           write a JSON Lines record of each executed instruction
+  --trace-top=N          stack values per trace record (default 4)
+  --trace-range=FROM:TO  trace instructions from label or index FROM up to TO
+  --trace-ops=OP,...     trace only these instructions, e.g. call,ret,native
+  --profile[=FILE]       write a pprof profile (default rmm.pprof)
+  --cover[=FILE]         report coverage and write LCOV (default rmm.lcov)
+  --heap-stats           print heap allocator statistics after the run
+  --memcheck             check heap accesses and report leaks after the run
+  --gc                   free unreachable heap blocks automatically
+  --gc-threshold=N       cells allocated between collections (default 65536)
+  --gc-compact           move live blocks together after each collection
+  --max-steps=N          stop with an error after N instructions
+  --timeout=DURATION     stop with an error after DURATION, e.g. 500ms or 2s
+  --max-stack=N          data stack size in values (default 1024)
+  --max-return-stack=N   call depth (default 1024)
+  --max-str-stack=N      string stack size (default 1024)
+  --max-heap=N           heap size in cells (default 4194304)
+  --max-open-files=N     files open at once (default 256)
+  --calls                graph: write the call graph instead of the blocks
+  --json                 graph: write JSON instead of Graphviz DOT
+`
+
 func GetArgs() Args {
-	args := Args{}
+	args := Args{Command: CommandRun}
 	if len(os.Args) < 2 {
-		fmt.Printf("Usage: %s <sourcefile.rmm>\n", os.Args[0])
+		fmt.Printf("Usage: %s <sourcefile.rmm> [flags]\n", os.Args[0])
+		fmt.Printf("       %s exec <program.bin> [flags]\n", os.Args[0])
+		fmt.Printf("       %s disasm <program.bin>\n", os.Args[0])
+		fmt.Printf("       %s debug <sourcefile.rmm|program.bin>\n", os.Args[0])
+		fmt.Printf("       %s verify <sourcefile.rmm|program.bin> [flags]\n", os.Args[0])
+		fmt.Printf("       %s check <sourcefile.rmm|program.bin> [flags]\n", os.Args[0])
+		fmt.Printf("       %s graph <sourcefile.rmm|program.bin> [flags]\n", os.Args[0])
+		fmt.Printf("       %s dap\n", os.Args[0])
+		fmt.Printf("       %s lsp\n", os.Args[0])
+		fmt.Printf("       %s repl\n", os.Args[0])
+		fmt.Print(flagUsage)
+		os.Exit(ExitUsage)
+	}
+
+	rest := os.Args[1:]
+	if rest[0] == CommandDAP || rest[0] == CommandLSP || rest[0] == CommandREPL {
+		args.Command = rest[0]
 		return args
 	}
+	if rest[0] == CommandExec || rest[0] == CommandDisasm || rest[0] == CommandDebug || rest[0] == CommandVerify || rest[0] == CommandCheck || rest[0] == CommandGraph {
+		args.Command = rest[0]
+		rest = rest[1:]
+		if len(rest) == 0 {
+			fmt.Printf("Usage: %s %s <file>\n", os.Args[0], args.Command)
+			os.Exit(ExitUsage)
+		}
+	}
 
-	args.FileName = os.Args[1]
-	for _, arg := range os.Args[2:] {
+	args.FileName = rest[0]
+	for _, arg := range rest[1:] {
 		if arg == "--debug" || arg == "-d" {
 			args.DebugMode = true
+		} else if value, ok := strings.CutPrefix(arg, "-O"); ok {
+			level, err := strconv.Atoi(value)
+			if err != nil || level < 0 || level > rmm.MaxOptLevel {
+				usageError("-O needs a level from 0 to %d, got %q", rmm.MaxOptLevel, arg)
+			}
+			args.OptLevel = level
+		} else if value, ok := strings.CutPrefix(arg, "--trace="); ok {
+			args.TraceFile = value
+		} else if value, ok := strings.CutPrefix(arg, "--trace-top="); ok {
+			args.TraceTop = count("--trace-top", value)
+		} else if value, ok := strings.CutPrefix(arg, "--trace-range="); ok {
+			if !strings.Contains(value, ":") {
+				usageError("--trace-range needs from:to, got %q", value)
+			}
+			args.TraceRange = value
+		} else if value, ok := strings.CutPrefix(arg, "--trace-ops="); ok {
+			args.TraceOps = strings.Split(value, ",")
+		} else if arg == "--profile" {
+			args.ProfileFile = DefaultProfileFile
+		} else if value, ok := strings.CutPrefix(arg, "--profile="); ok {
+			args.ProfileFile = value
+		} else if arg == "--cover" {
+			args.CoverFile = DefaultCoverFile
+		} else if value, ok := strings.CutPrefix(arg, "--cover="); ok {
+			args.CoverFile = value
+		} else if arg == "--heap-stats" {
+			args.HeapStats = true
+		} else if arg == "--memcheck" {
+			args.Memcheck = true
+		} else if arg == "--gc" {
+			args.GC = true
+		} else if value, ok := strings.CutPrefix(arg, "--gc-threshold="); ok {
+			args.GC = true
+			args.GCThreshold = count("--gc-threshold", value)
+		} else if arg == "--gc-compact" {
+			args.GC = true
+			args.GCCompact = true
+		} else if value, ok := strings.CutPrefix(arg, "--max-steps="); ok {
+			steps, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || steps < 1 {
+				usageError("--max-steps needs a positive count, got %q", value)
+			}
+			args.MaxSteps = steps
+		} else if value, ok := strings.CutPrefix(arg, "--max-stack="); ok {
+			args.MaxStack = count("--max-stack", value)
+		} else if value, ok := strings.CutPrefix(arg, "--max-return-stack="); ok {
+			args.MaxReturnStack = count("--max-return-stack", value)
+		} else if value, ok := strings.CutPrefix(arg, "--max-str-stack="); ok {
+			args.MaxStrStack = count("--max-str-stack", value)
+		} else if value, ok := strings.CutPrefix(arg, "--max-heap="); ok {
+			args.MaxHeap = count("--max-heap", value)
+		} else if value, ok := strings.CutPrefix(arg, "--max-open-files="); ok {
+			args.MaxOpenFiles = count("--max-open-files", value)
+		} else if arg == "--calls" {
+			args.GraphCalls = true
+		} else if arg == "--json" {
+			args.GraphJSON = true
+		} else if value, ok := strings.CutPrefix(arg, "--timeout="); ok {
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				usageError("--timeout needs a positive duration such as 500ms or 2s, got %q", value)
+			}
+			args.Timeout = timeout
 		}
 	}
 	return args
 }
+
+// count parses the value of a flag that takes a positive count.
+func count(flag, value string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		usageError("%s needs a positive count, got %q", flag, value)
+	}
+	return n
+}
+
+// usageError reports a bad command line and exits.
+func usageError(format string, a ...any) {
+	fmt.Fprintf(os.Stderr, "ERROR: "+format+"\n", a...)
+	os.Exit(ExitUsage)
+}
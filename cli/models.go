@@ -1,6 +1,74 @@
 package cli
 
+import "time"
+
 type Args struct {
+	Command   string
 	FileName  string
 	DebugMode bool
+	// OptLevel is the optimization level the program is compiled at.
+	OptLevel int
+	// TraceFile, if set, receives a JSON Lines record of every executed
+	// instruction that passes the other trace flags.
+	TraceFile  string
+	TraceTop   int
+	TraceRange string   // from:to, each a label or instruction index
+	TraceOps   []string // instruction kinds to trace; empty traces all
+	// ProfileFile, if set, receives a pprof profile of the run.
+	ProfileFile string
+	// CoverFile, if set, receives an LCOV tracefile of the run, and a
+	// coverage report is printed to stderr.
+	CoverFile string
+	// HeapStats prints the allocator's statistics to stderr after the run.
+	HeapStats bool
+	// Memcheck checks heap accesses and prints a leak report to stderr.
+	Memcheck bool
+	// GC turns on the garbage collector; the other --gc flags imply it.
+	GC          bool
+	GCThreshold int
+	GCCompact   bool
+	// MaxSteps and Timeout bound the run; zero means no bound.
+	MaxSteps int64
+	Timeout  time.Duration
+	// Resource limits; zero uses the machine's defaults.
+	MaxStack       int
+	MaxReturnStack int
+	MaxStrStack    int
+	MaxHeap        int
+	MaxOpenFiles   int
+	// GraphCalls makes graph write the call graph instead of the
+	// control-flow graph, and GraphJSON writes it as JSON instead of DOT.
+	GraphCalls bool
+	GraphJSON  bool
 }
+
+// Where --profile and --cover write without a file name.
+const (
+	DefaultProfileFile = "rmm.pprof"
+	DefaultCoverFile   = "rmm.lcov"
+)
+
+const (
+	CommandRun    = "run"
+	CommandExec   = "exec"
+	CommandDisasm = "disasm"
+	CommandDebug  = "debug"
+	CommandVerify = "verify"
+	CommandCheck  = "check"
+	CommandGraph  = "graph"
+	CommandDAP    = "dap"
+	CommandLSP    = "lsp"
+	CommandREPL   = "repl"
+)
+
+// Exit statuses of the rmm command, taken from BSD sysexits where one fits.
+// A program that calls exit(n) makes rmm exit with n instead.
+const (
+	ExitFailure        = 1   // an error that fits none of the classes below
+	ExitUsage          = 64  // bad command line
+	ExitCompileError   = 65  // the source or program.bin is malformed
+	ExitNoInput        = 66  // the source or program.bin cannot be read
+	ExitRuntimeError   = 70  // the program faulted
+	ExitCantCreate     = 73  // program.bin cannot be written
+	ExitBudgetExceeded = 124 // --max-steps or --timeout ran out; as timeout(1)
+)
@@ -0,0 +1,503 @@
+// Package dap implements `rmm dap`, a Debug Adapter Protocol server over
+// stdio for editors such as VS Code. It runs a single program, on a single
+// thread, through rmm's per-instruction StepHook.
+package dap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"vm/cli"
+	"vm/internal/debugger"
+	"vm/internal/framing"
+	"vm/rmm"
+)
+
+// threadID is the id of the one thread every rmm program has.
+const threadID = 1
+
+// Variable references of the scopes every stack frame shows.
+const (
+	registersReference = iota + 1
+	stackReference
+	strStackReference
+	heapReference
+)
+
+// errTerminated is returned from the hook to abandon the run when the
+// client disconnects or terminates the program.
+var errTerminated = errors.New("terminated by the client")
+
+// Server serves one debugging session.
+type Server struct {
+	in      *bufio.Reader
+	out     io.Writer
+	writeMu sync.Mutex // guards out and seq
+	seq     int
+
+	program     *rmm.Program
+	machine     *rmm.Machine
+	stopOnEntry bool
+	stepper     *debugger.Stepper // only touched by the hook
+
+	mu                  sync.Mutex // guards the fields below
+	lineBreakpoints     map[string][]int
+	functionBreakpoints []int
+	stopped             bool
+	nextBreakpointID    int
+
+	resume     chan debugger.StepMode
+	closeOnce  sync.Once
+	pause      atomic.Bool
+	terminated atomic.Bool
+	done       chan struct{} // closed when the run ends; nil before it starts
+}
+
+// NewServer returns a server reading requests from in and writing
+// responses and events to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:               bufio.NewReader(in),
+		out:              out,
+		lineBreakpoints:  make(map[string][]int),
+		nextBreakpointID: 1,
+		resume:           make(chan debugger.StepMode),
+	}
+}
+
+// Serve handles requests until the client disconnects or the input ends.
+func (s *Server) Serve() error {
+	for {
+		msg := &message{}
+		err := framing.Read(s.in, msg)
+		if err != nil {
+			s.terminate()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if msg.Type != "request" {
+			continue
+		}
+		if s.handle(msg) {
+			return nil
+		}
+	}
+}
+
+// handle answers one request and reports whether the session is over.
+func (s *Server) handle(req *message) bool {
+	var body any
+	var err error
+	switch req.Command {
+	case "initialize":
+		body = map[string]any{
+			"supportsConfigurationDoneRequest": true,
+			"supportsFunctionBreakpoints":      true,
+			"supportsTerminateRequest":         true,
+		}
+	case "launch":
+		err = s.launch(req)
+		if err == nil {
+			s.respond(req, nil, nil)
+			s.sendEvent("initialized", nil)
+			return false
+		}
+	case "setBreakpoints":
+		body, err = s.setBreakpoints(req)
+	case "setFunctionBreakpoints":
+		body, err = s.setFunctionBreakpoints(req)
+	case "setExceptionBreakpoints":
+		body = map[string]any{"breakpoints": []breakpoint{}}
+	case "configurationDone":
+		err = s.start()
+	case "threads":
+		body = map[string]any{"threads": []map[string]any{{"id": threadID, "name": "main"}}}
+	case "stackTrace":
+		body, err = s.stackTrace()
+	case "scopes":
+		body, err = s.scopes()
+	case "variables":
+		body, err = s.variables(req)
+	case "continue":
+		body = map[string]any{"allThreadsContinued": true}
+		err = s.resumeWith(req, debugger.ModeContinue, body)
+		if err == nil {
+			return false
+		}
+	case "next":
+		err = s.resumeWith(req, debugger.ModeNext, nil)
+		if err == nil {
+			return false
+		}
+	case "stepIn":
+		err = s.resumeWith(req, debugger.ModeStep, nil)
+		if err == nil {
+			return false
+		}
+	case "stepOut":
+		err = s.resumeWith(req, debugger.ModeFinish, nil)
+		if err == nil {
+			return false
+		}
+	case "pause":
+		s.pause.Store(true)
+	case "terminate":
+		s.terminate()
+	case "disconnect":
+		s.terminate()
+		s.respond(req, nil, nil)
+		return true
+	default:
+		err = fmt.Errorf("unsupported request %q", req.Command)
+	}
+	s.respond(req, body, err)
+	return false
+}
+
+func (s *Server) launch(req *message) error {
+	var args launchArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return fmt.Errorf("invalid launch arguments: %v", err)
+	}
+	if args.Program == "" {
+		return errors.New("launch needs a program")
+	}
+	path, err := filepath.Abs(args.Program)
+	if err != nil {
+		return err
+	}
+	load := rmm.CompileFile
+	if filepath.Ext(path) == ".bin" {
+		load = rmm.LoadProgram
+	}
+	program, err := load(path)
+	if err != nil {
+		return err
+	}
+	s.program = program
+	s.stopOnEntry = args.StopOnEntry
+	s.stepper = debugger.NewStepper(program)
+	s.machine = rmm.NewMachine(rmm.Options{
+		Stdout: outputWriter{s, "stdout"},
+		Stderr: outputWriter{s, "stderr"},
+		Hook:   s.hook,
+	})
+	return nil
+}
+
+// start runs the launched program on its own goroutine.
+func (s *Server) start() error {
+	if s.program == nil {
+		return errors.New("no program has been launched")
+	}
+	if s.done != nil {
+		return nil
+	}
+	if !s.stopOnEntry {
+		s.stepper.Continue()
+	}
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		s.finish(s.machine.Run(context.Background(), s.program))
+	}()
+	return nil
+}
+
+// finish reports how the run ended.
+func (s *Server) finish(err error) {
+	code := 0
+	var exitErr *rmm.ExitError
+	var runtimeErr *rmm.RuntimeError
+	switch {
+	case errors.Is(err, errTerminated):
+		s.sendEvent("terminated", nil)
+		return
+	case errors.As(err, &exitErr):
+		code = exitErr.Code
+	case errors.As(err, &runtimeErr):
+		s.sendEvent("output", map[string]any{"category": "stderr", "output": err.Error() + "\n" + runtimeErr.StackTrace()})
+		code = cli.ExitRuntimeError
+	case err != nil:
+		s.sendEvent("output", map[string]any{"category": "stderr", "output": err.Error() + "\n"})
+		code = cli.ExitFailure
+	}
+	s.sendEvent("exited", map[string]any{"exitCode": code})
+	s.sendEvent("terminated", nil)
+}
+
+// terminate stops the run, whether it is paused or running, and waits for
+// it to end.
+func (s *Server) terminate() {
+	s.terminated.Store(true)
+	s.closeOnce.Do(func() { close(s.resume) })
+	if s.done != nil {
+		<-s.done
+	}
+}
+
+// hook runs on the machine's goroutine before every instruction. When the
+// program should stop it reports a stopped event and blocks until the
+// client resumes it.
+func (s *Server) hook(m *rmm.Machine) error {
+	if s.terminated.Load() {
+		return errTerminated
+	}
+	var reason string
+	switch {
+	case s.atBreakpoint(m.IP()):
+		reason = "breakpoint"
+	case s.pause.Swap(false):
+		reason = "pause"
+	case s.stepper.ShouldStop(m):
+		reason = "step"
+		if s.stopOnEntry {
+			reason = "entry"
+		}
+	default:
+		return nil
+	}
+	s.stopOnEntry = false
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	s.sendEvent("stopped", map[string]any{"reason": reason, "threadId": threadID, "allThreadsStopped": true})
+	mode, ok := <-s.resume
+	if !ok {
+		return errTerminated
+	}
+	s.stepper.Start(m, mode)
+	return nil
+}
+
+func (s *Server) atBreakpoint(ip int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ips := range s.lineBreakpoints {
+		for _, bp := range ips {
+			if bp == ip {
+				return true
+			}
+		}
+	}
+	for _, bp := range s.functionBreakpoints {
+		if bp == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// resumeWith answers a resuming request and lets the paused hook go on.
+func (s *Server) resumeWith(req *message, mode debugger.StepMode, body any) error {
+	s.mu.Lock()
+	if !s.stopped {
+		s.mu.Unlock()
+		return errors.New("the program is not stopped")
+	}
+	s.stopped = false
+	s.mu.Unlock()
+	s.respond(req, body, nil)
+	s.resume <- mode
+	return nil
+}
+
+// whileStopped runs inspect if the machine is paused in the hook, which
+// is the only time its state is safe to read.
+func (s *Server) whileStopped(inspect func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stopped {
+		return errors.New("the program is not stopped")
+	}
+	inspect()
+	return nil
+}
+
+// ---- Breakpoints ----
+
+func (s *Server) setBreakpoints(req *message) (any, error) {
+	var args setBreakpointsArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, fmt.Errorf("invalid setBreakpoints arguments: %v", err)
+	}
+	if s.program == nil {
+		return nil, errors.New("no program has been launched")
+	}
+	result := []breakpoint{}
+	ips := []int{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, requested := range args.Breakpoints {
+		bp := breakpoint{ID: s.nextBreakpointID, Source: args.Source, Line: requested.Line}
+		s.nextBreakpointID++
+		if ip, ok := debugger.ResolveLine(s.program, args.Source.Path, requested.Line); ok {
+			_, bp.Line = s.program.Location(ip)
+			bp.Verified = true
+			ips = append(ips, ip)
+		} else {
+			bp.Message = "no code at or after this line"
+		}
+		result = append(result, bp)
+	}
+	s.lineBreakpoints[args.Source.Path] = ips
+	return map[string]any{"breakpoints": result}, nil
+}
+
+func (s *Server) setFunctionBreakpoints(req *message) (any, error) {
+	var args setFunctionBreakpointsArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, fmt.Errorf("invalid setFunctionBreakpoints arguments: %v", err)
+	}
+	if s.program == nil {
+		return nil, errors.New("no program has been launched")
+	}
+	symbols := s.program.Symbols()
+	result := []breakpoint{}
+	ips := []int{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, requested := range args.Breakpoints {
+		bp := breakpoint{ID: s.nextBreakpointID}
+		s.nextBreakpointID++
+		if ip, ok := symbols[requested.Name]; ok {
+			file, line := s.program.Location(ip)
+			bp.Verified, bp.Line = true, line
+			bp.Source = source{Name: filepath.Base(file), Path: file}
+			ips = append(ips, ip)
+		} else {
+			bp.Message = fmt.Sprintf("no label %q", requested.Name)
+		}
+		result = append(result, bp)
+	}
+	s.functionBreakpoints = ips
+	return map[string]any{"breakpoints": result}, nil
+}
+
+// ---- Inspection ----
+
+func (s *Server) stackTrace() (any, error) {
+	frames := []stackFrame{}
+	err := s.whileStopped(func() {
+		for i, frame := range s.machine.Backtrace() {
+			frames = append(frames, stackFrame{
+				ID:     i + 1,
+				Name:   frame.Function,
+				Source: source{Name: filepath.Base(frame.File), Path: frame.File},
+				Line:   frame.Line,
+				Column: 1,
+			})
+		}
+	})
+	return map[string]any{"stackFrames": frames, "totalFrames": len(frames)}, err
+}
+
+// scopes lists the same machine-wide scopes for every frame; the VM has no
+// per-frame storage.
+func (s *Server) scopes() (any, error) {
+	var scopes []scope
+	err := s.whileStopped(func() {
+		scopes = []scope{
+			{Name: "Registers", VariablesReference: registersReference},
+			{Name: "Stack", VariablesReference: stackReference, IndexedVariables: len(s.machine.Stack())},
+			{Name: "String Stack", VariablesReference: strStackReference, IndexedVariables: len(s.machine.StrStack())},
+			{Name: "Heap", VariablesReference: heapReference, IndexedVariables: len(s.machine.Heap()), Expensive: true},
+		}
+	})
+	return map[string]any{"scopes": scopes}, err
+}
+
+func (s *Server) variables(req *message) (any, error) {
+	var args variablesArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, fmt.Errorf("invalid variables arguments: %v", err)
+	}
+	variables := []variable{}
+	err := s.whileStopped(func() {
+		switch args.VariablesReference {
+		case registersReference:
+			for i, value := range s.machine.Registers() {
+				variables = append(variables, variable{Name: fmt.Sprintf("r%d", i), Value: value.String()})
+			}
+		case stackReference:
+			// Top of stack first, named by the index indup and inswap use
+			stack := s.machine.Stack()
+			for i := len(stack) - 1; i >= 0; i-- {
+				variables = append(variables, variable{Name: fmt.Sprintf("[%d]", i), Value: stack[i].String()})
+			}
+		case strStackReference:
+			strStack := s.machine.StrStack()
+			for i := len(strStack) - 1; i >= 0; i-- {
+				value := fmt.Sprintf("PTR %d %q", strStack[i], s.machine.HeapString(strStack[i]))
+				variables = append(variables, variable{Name: fmt.Sprintf("[%d]", i), Value: value})
+			}
+		case heapReference:
+			heap := s.machine.Heap()
+			from, to := pageBounds(len(heap), args.Start, args.Count)
+			for i := from; i < to; i++ {
+				variables = append(variables, variable{Name: fmt.Sprintf("[%d]", i), Value: heap[i].String()})
+			}
+		}
+	})
+	return map[string]any{"variables": variables}, err
+}
+
+// pageBounds clamps a variables request's start and count (0 meaning all)
+// to a slice of length n.
+func pageBounds(n, start, count int) (int, int) {
+	from := min(max(start, 0), n)
+	to := n
+	if count > 0 {
+		to = min(from+count, n)
+	}
+	return from, to
+}
+
+// ---- Output ----
+
+func (s *Server) respond(req *message, body any, err error) {
+	resp := response{Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+		resp.Body = nil
+	}
+	s.send(func(seq int) any {
+		resp.Seq = seq
+		return resp
+	})
+}
+
+func (s *Server) sendEvent(name string, body any) {
+	s.send(func(seq int) any {
+		return event{Seq: seq, Type: "event", Event: name, Body: body}
+	})
+}
+
+// send numbers and writes one message; build is called under the lock so
+// messages go out in sequence order.
+func (s *Server) send(build func(seq int) any) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.seq++
+	// A client that went away cannot be told anything more.
+	_ = framing.Write(s.out, build(s.seq))
+}
+
+// outputWriter forwards the program's output as output events.
+type outputWriter struct {
+	server   *Server
+	category string
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.server.sendEvent("output", map[string]any{"category": w.category, "output": string(p)})
+	return len(p), nil
+}
@@ -0,0 +1,245 @@
+package dap
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"vm/internal/framing"
+)
+
+const testProgram = `entrypoint main
+square:
+	dup
+	mul
+	ret
+main:
+	push 3
+	call square
+	mov r1 top
+	push 5
+	print
+	halt
+`
+
+// client drives a Server through scripted DAP messages.
+type client struct {
+	t        *testing.T
+	w        io.Writer
+	messages chan map[string]any
+	seq      int
+	pending  []map[string]any // events read while waiting for something else
+}
+
+func newClient(t *testing.T) *client {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &client{t: t, w: clientOut, messages: make(chan map[string]any, 64)}
+	server := NewServer(serverIn, serverOut)
+	go func() {
+		server.Serve()
+		serverOut.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(clientIn)
+		for {
+			var msg map[string]any
+			if err := framing.Read(r, &msg); err != nil {
+				close(c.messages)
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+	t.Cleanup(func() { clientOut.Close() })
+	return c
+}
+
+func (c *client) next() map[string]any {
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatalf("server closed the connection")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("timed out waiting for a message")
+	}
+	return nil
+}
+
+// request sends a request and returns its response body, failing the test
+// if it was not successful.
+func (c *client) request(command string, args any) map[string]any {
+	c.t.Helper()
+	c.seq++
+	if err := framing.Write(c.w, map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": args}); err != nil {
+		c.t.Fatalf("failed to send %s: %v", command, err)
+	}
+	for {
+		msg := c.next()
+		if msg["type"] == "event" {
+			c.pending = append(c.pending, msg)
+			continue
+		}
+		if int(msg["request_seq"].(float64)) != c.seq {
+			continue
+		}
+		if msg["success"] != true {
+			c.t.Fatalf("%s failed: %v", command, msg["message"])
+		}
+		body, _ := msg["body"].(map[string]any)
+		return body
+	}
+}
+
+// event waits for the named event and returns its body.
+func (c *client) event(name string) map[string]any {
+	c.t.Helper()
+	for i, msg := range c.pending {
+		if msg["event"] == name {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			body, _ := msg["body"].(map[string]any)
+			return body
+		}
+	}
+	for {
+		msg := c.next()
+		if msg["type"] == "event" && msg["event"] == name {
+			body, _ := msg["body"].(map[string]any)
+			return body
+		}
+		if msg["type"] == "event" {
+			c.pending = append(c.pending, msg)
+		}
+	}
+}
+
+func (c *client) topFrame() (string, int) {
+	c.t.Helper()
+	frames := c.request("stackTrace", map[string]any{"threadId": threadID})["stackFrames"].([]any)
+	top := frames[0].(map[string]any)
+	return top["name"].(string), int(top["line"].(float64))
+}
+
+func (c *client) variables(reference int) map[string]string {
+	c.t.Helper()
+	values := map[string]string{}
+	for _, v := range c.request("variables", map[string]any{"variablesReference": reference})["variables"].([]any) {
+		variable := v.(map[string]any)
+		values[variable["name"].(string)] = variable["value"].(string)
+	}
+	return values
+}
+
+func writeProgram(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "main.rmm")
+	if err := os.WriteFile(path, []byte(testProgram), 0644); err != nil {
+		t.Fatalf("failed to write program: %v", err)
+	}
+	return path
+}
+
+func TestSession(t *testing.T) {
+	path := writeProgram(t)
+	c := newClient(t)
+
+	caps := c.request("initialize", map[string]any{"adapterID": "rmm"})
+	if caps["supportsConfigurationDoneRequest"] != true {
+		t.Errorf("unexpected capabilities %v", caps)
+	}
+	c.request("launch", map[string]any{"program": path, "stopOnEntry": true})
+	c.event("initialized")
+	bps := c.request("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": path},
+		"breakpoints": []map[string]any{{"line": 2}, {"line": 40}},
+	})["breakpoints"].([]any)
+	wantVerified := []bool{true, false}
+	wantLines := []float64{3}
+	for i, bp := range bps {
+		b := bp.(map[string]any)
+		if b["verified"] != wantVerified[i] {
+			t.Errorf("breakpoint %d: verified %v, want %v", i, b["verified"], wantVerified[i])
+		}
+		if i < len(wantLines) && b["line"] != wantLines[i] {
+			t.Errorf("breakpoint %d: line %v, want %v", i, b["line"], wantLines[i])
+		}
+	}
+	c.request("configurationDone", nil)
+
+	if reason := c.event("stopped")["reason"]; reason != "entry" {
+		t.Fatalf("expected to stop on entry, got %v", reason)
+	}
+	if name, line := c.topFrame(); name != "main" || line != 7 {
+		t.Errorf("entry frame %s:%d, want main:7", name, line)
+	}
+
+	c.request("continue", map[string]any{"threadId": threadID})
+	if reason := c.event("stopped")["reason"]; reason != "breakpoint" {
+		t.Fatalf("expected a breakpoint stop, got %v", reason)
+	}
+	frames := c.request("stackTrace", map[string]any{"threadId": threadID})["stackFrames"].([]any)
+	if len(frames) != 2 || frames[0].(map[string]any)["name"] != "square" || frames[1].(map[string]any)["line"] != float64(8) {
+		t.Errorf("unexpected frames %v", frames)
+	}
+	scopes := c.request("scopes", map[string]any{"frameId": 1})["scopes"].([]any)
+	if len(scopes) != 4 {
+		t.Fatalf("expected 4 scopes, got %v", scopes)
+	}
+	if stack := c.variables(stackReference); stack["[0]"] != "INT 3" {
+		t.Errorf("unexpected stack %v", stack)
+	}
+
+	c.request("stepOut", map[string]any{"threadId": threadID})
+	c.event("stopped")
+	if name, line := c.topFrame(); name != "main" || line != 9 {
+		t.Errorf("after stepOut at %s:%d, want main:9", name, line)
+	}
+	c.request("next", map[string]any{"threadId": threadID})
+	c.event("stopped")
+	if regs := c.variables(registersReference); regs["r1"] != "INT 9" {
+		t.Errorf("unexpected registers r1=%q", regs["r1"])
+	}
+
+	c.request("continue", map[string]any{"threadId": threadID})
+	if output := c.event("output"); output["output"] != "INT 5\n" {
+		t.Errorf("unexpected output %v", output)
+	}
+	if code := c.event("exited")["exitCode"]; code != float64(0) {
+		t.Errorf("unexpected exit code %v", code)
+	}
+	c.event("terminated")
+	c.request("disconnect", nil)
+}
+
+func TestFunctionBreakpointAndHeap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.rmm")
+	src := "push_str \"hey\"\nentrypoint main\nwork:\n\tret\nmain:\n\tcall work\n"
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatalf("failed to write program: %v", err)
+	}
+	c := newClient(t)
+	c.request("initialize", nil)
+	c.request("launch", map[string]any{"program": path})
+	bps := c.request("setFunctionBreakpoints", map[string]any{
+		"breakpoints": []map[string]any{{"name": "work"}, {"name": "nope"}},
+	})["breakpoints"].([]any)
+	if bps[0].(map[string]any)["verified"] != true || bps[1].(map[string]any)["verified"] != false {
+		t.Errorf("unexpected function breakpoints %v", bps)
+	}
+	c.request("configurationDone", nil)
+	if reason := c.event("stopped")["reason"]; reason != "breakpoint" {
+		t.Fatalf("expected a breakpoint stop, got %v", reason)
+	}
+	heap := c.request("variables", map[string]any{"variablesReference": heapReference, "start": 1, "count": 2})["variables"].([]any)
+	if len(heap) != 2 || heap[0].(map[string]any)["name"] != "[1]" || heap[1].(map[string]any)["value"] != "CHAR y" {
+		t.Errorf("unexpected heap page %v", heap)
+	}
+	if strs := c.variables(strStackReference); strs["[0]"] != `PTR 0 "hey"` {
+		t.Errorf("unexpected string stack %v", strs)
+	}
+	// Disconnecting while stopped ends the run
+	c.request("disconnect", nil)
+}
@@ -0,0 +1,491 @@
+// Package debugger implements `rmm debug`, a gdb-style command line
+// debugger. It drives the machine through rmm's per-instruction StepHook.
+package debugger
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"vm/rmm"
+)
+
+// errQuit is returned from the hook to abandon the run when the user quits.
+var errQuit = errors.New("debugger quit")
+
+type breakpoint struct {
+	id   int
+	ip   int
+	spec string
+}
+
+// Debugger reads commands from one input and writes its output to another.
+// The debugged program shares the command input as its stdin.
+type Debugger struct {
+	program     *rmm.Program
+	in          *bufio.Reader
+	out         io.Writer
+	breakpoints []breakpoint
+	nextID      int
+	lastCommand string
+	running     bool
+	stepper     *Stepper
+
+	sources map[string][]string // file -> lines, for list
+}
+
+// New prepares a debugging session for program.
+func New(program *rmm.Program, in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		program: program,
+		in:      bufio.NewReader(in),
+		out:     out,
+		nextID:  1,
+		stepper: NewStepper(program),
+		sources: make(map[string][]string),
+	}
+}
+
+// Run debugs the program until the user quits or the input ends. The
+// program is stopped before its first instruction; once it finishes the
+// session stays open for inspection and `run` starts it again.
+func (d *Debugger) Run(stdout, stderr io.Writer) error {
+	machine := rmm.NewMachine(rmm.Options{
+		Stdin:  d.in,
+		Stdout: stdout,
+		Stderr: stderr,
+		Hook:   d.hook,
+	})
+	for {
+		d.stepper.Stop()
+		d.running = true
+		err := machine.Run(context.Background(), d.program)
+		d.running = false
+		if errors.Is(err, errQuit) {
+			return nil
+		}
+		d.reportExit(err)
+		if err := d.commandLoop(machine); err != nil {
+			if errors.Is(err, errQuit) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (d *Debugger) hook(m *rmm.Machine) error {
+	if !d.shouldStop(m) {
+		return nil
+	}
+	d.printLocation(m)
+	return d.commandLoop(m)
+}
+
+// shouldStop decides, before each instruction, whether to hand control
+// back to the user.
+func (d *Debugger) shouldStop(m *rmm.Machine) bool {
+	for _, bp := range d.breakpoints {
+		if bp.ip == m.IP() {
+			fmt.Fprintf(d.out, "Breakpoint %d, ", bp.id)
+			return true
+		}
+	}
+	return d.stepper.ShouldStop(m)
+}
+
+// commandLoop reads and runs commands until one resumes the program. It
+// returns errQuit when the session should end.
+func (d *Debugger) commandLoop(m *rmm.Machine) error {
+	for {
+		fmt.Fprint(d.out, "(rmm) ")
+		line, err := d.in.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(d.out)
+			return errQuit
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = d.lastCommand
+		}
+		if line == "" {
+			continue
+		}
+		d.lastCommand = line
+		resume, err := d.execute(m, strings.Fields(line))
+		if err != nil {
+			if errors.Is(err, errQuit) {
+				return err
+			}
+			fmt.Fprintf(d.out, "%v\n", err)
+			continue
+		}
+		if resume {
+			return nil
+		}
+	}
+}
+
+// execute runs one command and reports whether the program should resume.
+func (d *Debugger) execute(m *rmm.Machine, args []string) (bool, error) {
+	switch args[0] {
+	case "help", "h":
+		fmt.Fprint(d.out, helpText)
+	case "quit", "q":
+		return false, errQuit
+	case "break", "b":
+		if len(args) != 2 {
+			return false, errors.New("usage: break <label|file:line|line>")
+		}
+		return false, d.addBreakpoint(args[1])
+	case "delete", "d":
+		return false, d.deleteBreakpoints(args[1:])
+	case "info", "i":
+		if len(args) == 2 && strings.HasPrefix("breakpoints", args[1]) {
+			d.listBreakpoints()
+			return false, nil
+		}
+		if len(args) == 2 && strings.HasPrefix("registers", args[1]) {
+			d.printRegisters(m)
+			return false, nil
+		}
+		return false, errors.New("usage: info breakpoints|registers")
+	case "run", "r":
+		if !d.running {
+			return true, nil
+		}
+		return d.resume(m, ModeContinue)
+	case "continue", "c":
+		return d.resume(m, ModeContinue)
+	case "step", "s":
+		return d.resume(m, ModeStep)
+	case "next", "n":
+		return d.resume(m, ModeNext)
+	case "finish", "fin":
+		if m.CallDepth() == 0 {
+			return false, errors.New(`"finish" not meaningful in the outermost frame`)
+		}
+		return d.resume(m, ModeFinish)
+	case "stepi", "si":
+		return d.resume(m, ModeStepInstruction)
+	case "backtrace", "bt", "where":
+		d.printBacktrace(m)
+	case "print", "p":
+		return false, d.print(m, args[1:])
+	case "set":
+		return false, d.set(m, args[1:])
+	case "list", "l":
+		return false, d.list(m, args[1:])
+	default:
+		return false, fmt.Errorf("undefined command: %q, try \"help\"", args[0])
+	}
+	return false, nil
+}
+
+func (d *Debugger) resume(m *rmm.Machine, mode StepMode) (bool, error) {
+	if !d.running {
+		return false, errors.New("the program is not being run")
+	}
+	d.stepper.Start(m, mode)
+	return true, nil
+}
+
+func (d *Debugger) reportExit(err error) {
+	var exitErr *rmm.ExitError
+	var runtimeErr *rmm.RuntimeError
+	switch {
+	case err == nil:
+		fmt.Fprintln(d.out, "[program exited normally]")
+	case errors.As(err, &exitErr):
+		fmt.Fprintf(d.out, "[program exited with code %d]\n", exitErr.Code)
+	case errors.As(err, &runtimeErr):
+		fmt.Fprintln(d.out, err)
+		fmt.Fprint(d.out, runtimeErr.StackTrace())
+		fmt.Fprintln(d.out, "[program terminated by a runtime fault]")
+	default:
+		fmt.Fprintf(d.out, "[program stopped: %v]\n", err)
+	}
+}
+
+// printLocation announces where the program stopped.
+func (d *Debugger) printLocation(m *rmm.Machine) {
+	ip := m.IP()
+	file, line := d.program.Location(ip)
+	function := m.Backtrace()[0].Function
+	fmt.Fprintf(d.out, "%s (%s:%d)\n", function, filepath.Base(file), line)
+	fmt.Fprintf(d.out, "=> %d: %s\n", ip, d.program.InstructionText(ip))
+}
+
+func (d *Debugger) printBacktrace(m *rmm.Machine) {
+	for i, frame := range m.Backtrace() {
+		fmt.Fprintf(d.out, "#%d %s (%s:%d) ip %d\n", i, frame.Function, filepath.Base(frame.File), frame.Line, frame.IP)
+	}
+}
+
+// ---- Breakpoints ----
+
+func (d *Debugger) addBreakpoint(spec string) error {
+	ip, err := d.resolve(spec)
+	if err != nil {
+		return err
+	}
+	bp := breakpoint{id: d.nextID, ip: ip, spec: spec}
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, bp)
+	file, line := d.program.Location(ip)
+	fmt.Fprintf(d.out, "Breakpoint %d at ip %d: %s:%d\n", bp.id, ip, filepath.Base(file), line)
+	return nil
+}
+
+// resolve turns a label, file:line or line (in the entrypoint's file) into
+// an instruction index.
+func (d *Debugger) resolve(spec string) (int, error) {
+	if ip, ok := d.program.Symbols()[spec]; ok {
+		return ip, nil
+	}
+	file, lineText := "", spec
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		file, lineText = spec[:i], spec[i+1:]
+	} else {
+		file, _ = d.program.Location(d.program.Entrypoint())
+	}
+	line, err := strconv.Atoi(lineText)
+	if err != nil {
+		return 0, fmt.Errorf("no label %q", spec)
+	}
+	ip, ok := ResolveLine(d.program, file, line)
+	if !ok {
+		return 0, fmt.Errorf("no code at or after %s", spec)
+	}
+	return ip, nil
+}
+
+// ResolveLine returns the first instruction of line in file. A line without
+// code resolves to the next line that has some, as in gdb. file may be a
+// path or a base name.
+func ResolveLine(program *rmm.Program, file string, line int) (int, bool) {
+	best, bestLine := -1, 0
+	for ip := 0; ip < program.Len(); ip++ {
+		f, l := program.Location(ip)
+		if !sameFile(f, file) || l < line {
+			continue
+		}
+		if best < 0 || l < bestLine {
+			best, bestLine = ip, l
+		}
+	}
+	return best, best >= 0
+}
+
+func sameFile(path, name string) bool {
+	return path == name || filepath.Base(path) == name || strings.HasSuffix(path, string(filepath.Separator)+name)
+}
+
+func (d *Debugger) deleteBreakpoints(ids []string) error {
+	if len(ids) == 0 {
+		d.breakpoints = nil
+		return nil
+	}
+	for _, text := range ids {
+		id, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("invalid breakpoint number %q", text)
+		}
+		found := false
+		for i, bp := range d.breakpoints {
+			if bp.id == id {
+				d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("no breakpoint number %d", id)
+		}
+	}
+	return nil
+}
+
+func (d *Debugger) listBreakpoints() {
+	if len(d.breakpoints) == 0 {
+		fmt.Fprintln(d.out, "No breakpoints.")
+		return
+	}
+	for _, bp := range d.breakpoints {
+		file, line := d.program.Location(bp.ip)
+		fmt.Fprintf(d.out, "%d\t%s\tip %d (%s:%d)\n", bp.id, bp.spec, bp.ip, filepath.Base(file), line)
+	}
+}
+
+// ---- Inspection ----
+
+func (d *Debugger) print(m *rmm.Machine, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: print stack|strstack|regs|r<N>|heap <from> [to]")
+	}
+	switch args[0] {
+	case "stack":
+		stack := m.Stack()
+		if len(stack) == 0 {
+			fmt.Fprintln(d.out, "stack is empty")
+		}
+		for i := len(stack) - 1; i >= 0; i-- {
+			fmt.Fprintf(d.out, "[%d] %s\n", i, stack[i])
+		}
+	case "strstack":
+		strStack := m.StrStack()
+		if len(strStack) == 0 {
+			fmt.Fprintln(d.out, "string stack is empty")
+		}
+		for i := len(strStack) - 1; i >= 0; i-- {
+			fmt.Fprintf(d.out, "[%d] PTR %d %q\n", i, strStack[i], m.HeapString(strStack[i]))
+		}
+	case "regs", "registers":
+		d.printRegisters(m)
+	case "heap":
+		return d.printHeap(m, args[1:])
+	default:
+		index, err := registerIndex(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(d.out, "r%d = %s\n", index, m.Registers()[index])
+	}
+	return nil
+}
+
+func (d *Debugger) printRegisters(m *rmm.Machine) {
+	for i, value := range m.Registers() {
+		fmt.Fprintf(d.out, "r%-3d %s\n", i, value)
+	}
+}
+
+func (d *Debugger) printHeap(m *rmm.Machine, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("usage: print heap <from> [to]")
+	}
+	heap := m.Heap()
+	from, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid heap index %q", args[0])
+	}
+	to := from + 1
+	if len(args) == 2 {
+		if to, err = strconv.Atoi(args[1]); err != nil {
+			return fmt.Errorf("invalid heap index %q", args[1])
+		}
+	}
+	if from < 0 || to > len(heap) || from >= to {
+		return fmt.Errorf("heap range %d..%d out of bounds (size %d)", from, to, len(heap))
+	}
+	for i := from; i < to; i++ {
+		fmt.Fprintf(d.out, "[%d] %s\n", i, heap[i])
+	}
+	return nil
+}
+
+func registerIndex(name string) (int, error) {
+	if len(name) < 2 || name[0] != 'r' {
+		return 0, fmt.Errorf("no symbol %q", name)
+	}
+	index, err := strconv.Atoi(name[1:])
+	if err != nil || index < 0 || index >= rmm.MaxRegisters {
+		return 0, fmt.Errorf("no register %q", name)
+	}
+	return index, nil
+}
+
+// set handles `set rN <value>`, `set stack <index> <value>` and
+// `set heap <index> <value>`.
+func (d *Debugger) set(m *rmm.Machine, args []string) error {
+	usage := errors.New("usage: set r<N> <value> | set stack <index> <value> | set heap <index> <value>")
+	if len(args) < 2 {
+		return usage
+	}
+	if args[0] == "stack" || args[0] == "heap" {
+		if len(args) < 3 {
+			return usage
+		}
+		index, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid index %q", args[1])
+		}
+		value, err := rmm.ParseLiteral(strings.Join(args[2:], " "))
+		if err != nil {
+			return err
+		}
+		if args[0] == "stack" {
+			return m.SetStack(index, value)
+		}
+		return m.SetHeap(index, value)
+	}
+	index, err := registerIndex(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := rmm.ParseLiteral(strings.Join(args[1:], " "))
+	if err != nil {
+		return err
+	}
+	return m.SetRegister(index, value)
+}
+
+// list prints the source around the current line, or around file:line.
+func (d *Debugger) list(m *rmm.Machine, args []string) error {
+	file, line := d.program.Location(m.IP())
+	if len(args) == 1 {
+		i := strings.LastIndex(args[0], ":")
+		if i < 0 {
+			return errors.New("usage: list [file:line]")
+		}
+		n, err := strconv.Atoi(args[0][i+1:])
+		if err != nil {
+			return fmt.Errorf("invalid line %q", args[0][i+1:])
+		}
+		file, line = args[0][:i], n
+	}
+	lines, ok := d.sources[file]
+	if !ok {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("cannot read source: %v", err)
+		}
+		lines = strings.Split(string(data), "\n")
+		d.sources[file] = lines
+	}
+	for n := max(1, line-4); n <= min(len(lines), line+4); n++ {
+		marker := " "
+		if n == line {
+			marker = ">"
+		}
+		fmt.Fprintf(d.out, "%s%4d  %s\n", marker, n, lines[n-1])
+	}
+	return nil
+}
+
+const helpText = `Commands:
+  run, r                   restart a finished program
+  continue, c              run to the next breakpoint
+  step, s                  run to the next source line, entering calls
+  next, n                  run to the next source line, stepping over calls
+  finish, fin              run until the current function returns
+  stepi, si                run one instruction
+  break, b <where>         break at a label, file:line or line
+  delete, d [n...]         delete breakpoints (all if none given)
+  info breakpoints         list breakpoints
+  backtrace, bt            show the call stack
+  print, p stack           show the data stack, top first
+  print strstack           show the string stack
+  print regs | r<N>        show registers
+  print heap <from> [to]   show heap cells from..to-1
+  set r<N> <value>         set a register (42, 1.5, 'c', NULL, PTR 3)
+  set stack <i> <value>    set data stack slot i (0 is the bottom)
+  set heap <i> <value>     set heap cell i
+  list, l [file:line]      show source around the current line
+  quit, q                  leave the debugger
+`
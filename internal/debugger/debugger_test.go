@@ -0,0 +1,124 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"vm/internal/testutil"
+	"vm/rmm"
+)
+
+const squareProgram = `entrypoint main
+square:
+	dup
+	mul
+	ret
+main:
+	push 3
+	call square
+	mov r1 top
+	push 5
+	print
+	halt
+`
+
+// session runs the debugger over a scripted command input and returns
+// everything it and the program wrote.
+func session(t *testing.T, src, commands string) string {
+	t.Helper()
+	program, err := rmm.Compile(src)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	var out bytes.Buffer
+	if err := New(program, strings.NewReader(commands), &out).Run(&out, &out); err != nil {
+		t.Fatalf("debugger failed: %v", err)
+	}
+	return out.String()
+}
+
+func TestBreakpointAndInspect(t *testing.T) {
+	out := session(t, squareProgram, `break square
+continue
+backtrace
+print stack
+set stack 0 4
+finish
+print stack
+quit
+`)
+	testutil.ExpectInOrder(t, out,
+		"main (main.rmm:7)\n=> 3: push 3",
+		"Breakpoint 1 at ip 0: main.rmm:3",
+		"Breakpoint 1, square (main.rmm:3)\n=> 0: dup",
+		"#0 square (main.rmm:3) ip 0\n#1 main (main.rmm:8) ip 4",
+		"[0] INT 3",
+		"main (main.rmm:9)\n=> 5: mov r1 top",
+		"[0] INT 16",
+	)
+}
+
+func TestStepping(t *testing.T) {
+	out := session(t, squareProgram, `step
+step
+step
+next
+next
+next
+print r1
+set r2 'x'
+print r2
+continue
+`)
+	testutil.ExpectInOrder(t, out,
+		"main (main.rmm:8)\n=> 4: call square",
+		"square (main.rmm:3)\n=> 0: dup",
+		"square (main.rmm:4)\n=> 1: mul",
+		"square (main.rmm:5)\n=> 2: ret",
+		"main (main.rmm:9)\n=> 5: mov r1 top",
+		"main (main.rmm:10)",
+		"r1 = INT 9",
+		"r2 = CHAR x",
+		"INT 5\n[program exited normally]",
+	)
+}
+
+func TestBreakpointByLine(t *testing.T) {
+	out := session(t, squareProgram, `break main.rmm:6
+break 10
+info breakpoints
+continue
+continue
+delete 2
+run
+continue
+`)
+	testutil.ExpectInOrder(t, out,
+		"Breakpoint 1 at ip 3: main.rmm:7",
+		"Breakpoint 2 at ip 6: main.rmm:10",
+		"1\tmain.rmm:6\tip 3 (main.rmm:7)\n2\t10\tip 6 (main.rmm:10)",
+		"Breakpoint 2, main (main.rmm:10)",
+		"[program exited normally]",
+		// run restarts the program, stopped at the entrypoint
+		"main (main.rmm:7)\n=> 3: push 3",
+		"[program exited normally]",
+	)
+	if strings.Count(out, "Breakpoint 2,") != 1 {
+		t.Errorf("deleted breakpoint was hit again:\n%s", out)
+	}
+}
+
+func TestRuntimeFault(t *testing.T) {
+	out := session(t, "push 1\npop\npop\n", `continue
+print stack
+step
+quit
+`)
+	testutil.ExpectInOrder(t, out,
+		"ERROR (main.rmm:3): stack underflow",
+		"#0 <0> (main.rmm:3) ip 2",
+		"[program terminated by a runtime fault]",
+		"stack is empty",
+		"the program is not being run",
+	)
+}
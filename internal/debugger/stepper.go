@@ -0,0 +1,67 @@
+package debugger
+
+import "vm/rmm"
+
+// StepMode says how far a resumed program runs before stopping again.
+type StepMode uint8
+
+const (
+	ModeStop            StepMode = iota // stop before the next instruction
+	ModeContinue                        // run to a breakpoint
+	ModeStep                            // run to a different source line, entering calls
+	ModeNext                            // as ModeStep, but run calls to completion
+	ModeFinish                          // run until the current function returns
+	ModeStepInstruction                 // run exactly one instruction
+)
+
+// Stepper tracks the step, next or finish in progress. Breakpoints are
+// left to the caller.
+type Stepper struct {
+	program *rmm.Program
+	mode    StepMode
+	file    string
+	line    int
+	depth   int
+}
+
+// NewStepper returns a Stepper that stops before the first instruction.
+func NewStepper(program *rmm.Program) *Stepper {
+	return &Stepper{program: program}
+}
+
+// Stop makes the next ShouldStop report true.
+func (s *Stepper) Stop() {
+	s.mode = ModeStop
+}
+
+// Continue makes ShouldStop report false until the next Start or Stop.
+func (s *Stepper) Continue() {
+	s.mode = ModeContinue
+}
+
+// Start begins a step of the given mode from where m is stopped.
+func (s *Stepper) Start(m *rmm.Machine, mode StepMode) {
+	s.mode = mode
+	s.file, s.line = s.program.Location(m.IP())
+	s.depth = m.CallDepth()
+}
+
+// ShouldStop reports whether the step has finished before m's next
+// instruction.
+func (s *Stepper) ShouldStop(m *rmm.Machine) bool {
+	file, line := s.program.Location(m.IP())
+	depth := m.CallDepth()
+	sameLine := file == s.file && line == s.line
+	switch s.mode {
+	case ModeStop, ModeStepInstruction:
+		return true
+	case ModeStep:
+		return !sameLine || depth != s.depth
+	case ModeNext:
+		return depth < s.depth || (depth == s.depth && !sameLine)
+	case ModeFinish:
+		return depth < s.depth
+	default:
+		return false
+	}
+}
@@ -0,0 +1,35 @@
+package framing
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	for _, seq := range []int{1, 2} {
+		if err := Write(&buf, map[string]any{"seq": seq}); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+	r := bufio.NewReader(&buf)
+	for _, want := range []float64{1, 2} {
+		var msg map[string]any
+		if err := Read(r, &msg); err != nil {
+			t.Fatalf("failed to read: %v", err)
+		}
+		if msg["seq"] != want {
+			t.Errorf("expected seq %v, got %v", want, msg["seq"])
+		}
+	}
+}
+
+func TestBadHeader(t *testing.T) {
+	var msg map[string]any
+	err := Read(bufio.NewReader(strings.NewReader("Content-Length: x\r\n\r\n{}")), &msg)
+	if err == nil || err.Error() != `invalid Content-Length header "x"` {
+		t.Errorf("expected an invalid header error, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"unicode"
 	"vm/internal/token"
@@ -13,13 +14,31 @@ type Lexer struct {
 	Tokens   []token.Token
 	FileName string
 	Macros   map[string]string
+	// ReadFile loads the root file and every import; it defaults to
+	// os.ReadFile. Editors swap it to lex unsaved buffers.
+	ReadFile func(name string) ([]byte, error)
+	// MacroDefinitions, MacroUses and Imports record where @def names were
+	// defined and expanded and where each @imp pulled in a file, for tools
+	// that navigate source.
+	MacroDefinitions map[string]token.TokenContext
+	MacroUses        []Symbol
+	Imports          []Symbol
+}
+
+// Symbol is a name the lexer saw in the source: a macro expanded at Context,
+// or a file (Name) imported by the @imp directive at Context.
+type Symbol struct {
+	Name    string
+	Context token.TokenContext
 }
 
 func Init(filename string) *Lexer {
 	return &Lexer{
-		Tokens:   []token.Token{},
-		FileName: filename,
-		Macros:   make(map[string]string),
+		Tokens:           []token.Token{},
+		FileName:         filename,
+		Macros:           make(map[string]string),
+		ReadFile:         os.ReadFile,
+		MacroDefinitions: make(map[string]token.TokenContext),
 	}
 }
 
@@ -29,14 +48,27 @@ func (l *Lexer) addToken(token token.Token) *Lexer {
 }
 
 func (l *Lexer) Lex() *Lexer {
-	l.processFile(l.FileName)
+	l.processFile(nil, l.FileName)
+	return l
+}
+
+// LexSource lexes src as the contents of the lexer's file, so imports are
+// still resolved relative to that file name.
+func (l *Lexer) LexSource(src string) *Lexer {
+	l.lexContent(src, l.FileName, 1)
 	return l
 }
 
-func (l *Lexer) processFile(fileName string) {
-	data, err := os.ReadFile(fileName)
+// processFile lexes fileName; ctx is the @imp directive that pulled it in,
+// or nil for the root file.
+func (l *Lexer) processFile(ctx *token.TokenContext, fileName string) {
+	data, err := l.ReadFile(fileName)
 	if err != nil {
-		panic(fmt.Errorf("could not open file %s:  %w", fileName, err))
+		errCtx := token.TokenContext{FileName: fileName}
+		if ctx != nil {
+			errCtx = *ctx
+		}
+		panic(errCtx.Error(token.ErrorImport, fmt.Sprintf("could not open file %s: %v", fileName, err)))
 	}
 	l.lexContent(string(data), fileName, 1)
 }
@@ -51,7 +83,7 @@ func (l *Lexer) processImport(ctx *token.TokenContext, input string, currentInde
 	}
 	// expect quote
 	if currentIndex >= len(input) || input[currentIndex] != '"' {
-		panic(fmt.Sprintf("ERROR (%s:%d): expected filename in quotes after @imp", ctx.FileName, ctx.Line))
+		panic(ctx.Error(token.ErrorSyntax, "expected filename in quotes after @imp"))
 	}
 	currentIndex++ // skip quote
 	importFile := ""
@@ -60,7 +92,7 @@ func (l *Lexer) processImport(ctx *token.TokenContext, input string, currentInde
 		currentIndex++
 	}
 	if currentIndex >= len(input) {
-		panic(fmt.Sprintf("ERROR (%s:%d): unterminated string in @imp", ctx.FileName, ctx.Line))
+		panic(ctx.Error(token.ErrorSyntax, "unterminated string in @imp"))
 	}
 	currentIndex++ // skip closing quote
 	// Resolve import path relative to current file's directory
@@ -69,16 +101,20 @@ func (l *Lexer) processImport(ctx *token.TokenContext, input string, currentInde
 		currentDir := filepath.Dir(ctx.FileName)
 		importPath = filepath.Join(currentDir, importFile)
 	}
-	l.processFile(importPath)
+	l.Imports = append(l.Imports, Symbol{Name: importPath, Context: *ctx})
+	l.processFile(ctx, importPath)
 	return currentIndex
 }
 
 func (l *Lexer) processDef(ctx *token.TokenContext, input string, currentIndex int) int {
+	start := currentIndex
 	// skip whitespace
 	for currentIndex < len(input) && unicode.IsSpace(rune(input[currentIndex])) && input[currentIndex] != '\n' {
 		currentIndex++
 	}
-	// get key
+	// get key; ctx is at the '@' and start is just past "@def"
+	keyCtx := *ctx
+	keyCtx.Character += len("@def") + currentIndex - start
 	key := ""
 	for currentIndex < len(input) && !unicode.IsSpace(rune(input[currentIndex])) {
 		key += string(input[currentIndex])
@@ -95,37 +131,80 @@ func (l *Lexer) processDef(ctx *token.TokenContext, input string, currentIndex i
 		currentIndex++
 	}
 	if _, exists := l.Macros[key]; exists {
-		panic(ctx.Error(fmt.Sprintf("duplicate macro definition found for macro '%s'", key)))
+		panic(ctx.Error(token.ErrorSymbol, fmt.Sprintf("duplicate macro definition found for macro '%s'", key)))
 	}
 	l.Macros[key] = strings.TrimSpace(val)
+	l.MacroDefinitions[key] = keyCtx
 	return currentIndex
 }
 
+// processLine handles `@line N "file"`, which makes the line after the
+// directive report as line N of file (the file name is optional). The
+// disassembler emits it so that re-assembled programs keep their original
+// line table.
+func (l *Lexer) processLine(ctx *token.TokenContext, input string, currentIndex int) (int, int64, string) {
+	for currentIndex < len(input) && unicode.IsSpace(rune(input[currentIndex])) && input[currentIndex] != '\n' {
+		currentIndex++
+	}
+	lineText := ""
+	for currentIndex < len(input) && unicode.IsDigit(rune(input[currentIndex])) {
+		lineText += string(input[currentIndex])
+		currentIndex++
+	}
+	if lineText == "" {
+		panic(ctx.Error(token.ErrorSyntax, "expected line number after @line"))
+	}
+	line, err := strconv.ParseInt(lineText, 10, 64)
+	if err != nil {
+		panic(ctx.Error(token.ErrorSyntax, fmt.Sprintf("invalid line number %s after @line", lineText)))
+	}
+	for currentIndex < len(input) && unicode.IsSpace(rune(input[currentIndex])) && input[currentIndex] != '\n' {
+		currentIndex++
+	}
+	fileName := ctx.FileName
+	if currentIndex < len(input) && input[currentIndex] == '"' {
+		currentIndex++ // skip quote
+		fileName = ""
+		for currentIndex < len(input) && input[currentIndex] != '"' {
+			fileName += string(input[currentIndex])
+			currentIndex++
+		}
+		if currentIndex >= len(input) {
+			panic(ctx.Error(token.ErrorSyntax, "unterminated string in @line"))
+		}
+		currentIndex++ // skip closing quote
+	}
+	// The newline ending the directive moves us onto line N
+	return currentIndex, line - 1, fileName
+}
+
 func (l *Lexer) lexContent(input string, fileName string, startLine int64) {
 	currentIndex := 0
 	line := startLine
-	character := 1
+	lineStart := 0 // index of the first byte of the current line
 
 	for currentIndex < len(input) {
 		var lexedToken token.Token
 
 		// Preprocessor directives
 		if input[currentIndex] == '@' {
+			ctx := token.TokenContext{Line: line, Character: currentIndex - lineStart + 1, FileName: fileName}
 			currentIndex++ // skip '@'
 			directive := ""
 			directive, currentIndex = token.GetWord(input, currentIndex)
-			ctx := token.TokenContext{Line: line, Character: character, FileName: fileName}
 			switch directive {
 			case "imp": // @imp
 				currentIndex = l.processImport(&ctx, input, currentIndex)
 			case "def": // @def
 				currentIndex = l.processDef(&ctx, input, currentIndex)
+			case "line": // @line
+				currentIndex, line, fileName = l.processLine(&ctx, input, currentIndex)
 			default:
-				panic(fmt.Sprintf("ERROR (%s:%d): checking for unknown preprocessor directive @%s", fileName, line, directive))
+				panic(ctx.Error(token.ErrorSyntax, fmt.Sprintf("checking for unknown preprocessor directive @%s", directive)))
 			}
 			continue
 		}
-		ctx := token.TokenContext{Line: line, Character: character, FileName: fileName}
+		ctx := token.TokenContext{Line: line, Character: currentIndex - lineStart + 1, FileName: fileName}
 		if input[currentIndex] == ';' {
 			for currentIndex < len(input) && input[currentIndex] != '\n' {
 				currentIndex++
@@ -136,12 +215,14 @@ func (l *Lexer) lexContent(input string, fileName string, startLine int64) {
 				l.addToken(token.GetNoOpToken(ctx))
 			}
 			line++
-			character = 0
 			currentIndex++
+			lineStart = currentIndex
 		} else if unicode.IsLetter(rune(input[currentIndex])) { // keyword or macro
 			var macroVal string
+			wordStart := currentIndex
 			lexedToken, macroVal, currentIndex = token.GenerateKeyword(input, currentIndex, ctx, l.Macros)
 			if macroVal != "" {
+				l.MacroUses = append(l.MacroUses, Symbol{Name: input[wordStart:currentIndex], Context: ctx})
 				l.lexContent(macroVal, fileName, line)
 			} else {
 				l.addToken(lexedToken)
@@ -158,7 +239,6 @@ func (l *Lexer) lexContent(input string, fileName string, startLine int64) {
 		} else { // whitespace token
 			currentIndex++
 		}
-		character++
 	}
 }
 
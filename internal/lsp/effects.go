@@ -0,0 +1,117 @@
+package lsp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"vm/rmm"
+)
+
+// effect documents an instruction for hover and completion; natives are
+// documented from rmm.Natives. Stack effects are written Forth style,
+// ( before -- after ), with the top of the stack rightmost; "S:" marks the
+// string stack.
+type effect struct {
+	stack   string
+	summary string
+}
+
+var instructionEffects = map[string]effect{
+	"push":       {"( -- v )", "Push an int, float, char, NULL or register value. A string pushes each of its characters."},
+	"push_ptr":   {"( -- ptr )", "Push a pointer to a heap index, or NULL."},
+	"push_str":   {"S: ( -- s )", "Directive: store the string on the heap and its pointer on the string stack at load time."},
+	"get_str":    {"( -- ptr )", "Push the pointer of string stack entry n."},
+	"pop":        {"( a -- )", "Discard the top value."},
+	"dup":        {"( a -- a a )", "Duplicate the top value."},
+	"indup":      {"( -- s[n] )", "Push a copy of stack slot n, counted from the bottom."},
+	"swap":       {"( a b -- b a )", "Swap the top two values."},
+	"inswap":     {"( a -- s[n] )", "Swap the top value with stack slot n, counted from the bottom."},
+	"add":        {"( a b -- a+b )", "Add the top two values."},
+	"sub":        {"( a b -- a-b )", "Subtract the top value from the one below it."},
+	"mul":        {"( a b -- a*b )", "Multiply the top two values."},
+	"div":        {"( a b -- a/b )", "Divide the second value by the top value."},
+	"mod":        {"( a b -- a%b )", "Remainder of the second value divided by the top value."},
+	"cmpe":       {"( a b -- a==b )", "Push 1 if the values are equal, else 0."},
+	"cmpne":      {"( a b -- a!=b )", "Push 1 if the values differ, else 0."},
+	"cmpg":       {"( a b -- a>b )", "Push 1 if a is greater than b, else 0."},
+	"cmpl":       {"( a b -- a<b )", "Push 1 if a is less than b, else 0."},
+	"cmpge":      {"( a b -- a>=b )", "Push 1 if a is greater than or equal to b, else 0."},
+	"cmple":      {"( a b -- a<=b )", "Push 1 if a is less than or equal to b, else 0."},
+	"jmp":        {"( -- )", "Jump to a label."},
+	"zjmp":       {"( cond -- )", "Jump to a label if the popped condition is 0."},
+	"nzjmp":      {"( cond -- )", "Jump to a label if the popped condition is not 0."},
+	"call":       {"( -- )", "Call a label, pushing the return address on the return stack."},
+	"ret":        {"( -- )", "Return to the address on top of the return stack."},
+	"print":      {"( a -- )", "Pop and print the top value."},
+	"native":     {"( ... -- ... )", "Run a native syscall by ID; its stack effect depends on the syscall."},
+	"halt":       {"( -- )", "Stop the program."},
+	"noop":       {"( -- )", "Do nothing."},
+	"entrypoint": {"( -- )", "Directive: start execution at a label."},
+	"itof":       {"( int -- float )", "Convert an integer to a float."},
+	"ftoi":       {"( float -- int )", "Truncate a float to an integer."},
+	"ref":        {"( v -- ptr )", "Store the value in a new heap cell and push a pointer to it."},
+	"deref":      {"( ptr -- v )", "Push the value stored at a heap pointer."},
+	"mov_str":    {"( v -- ) S: ( -- s )", "Push a char (as a new string), int or pointer onto the string stack."},
+	"index":      {"( ptr i c -- ptr )", "Store a char at heap[ptr+i]; with a char operand it is not popped."},
+	"pop_str":    {"S: ( s -- )", "Discard the top string."},
+	"dup_str":    {"S: ( s -- s s )", "Duplicate the top string."},
+	"indup_str":  {"S: ( -- s[n] )", "Push a copy of string stack entry n."},
+	"swap_str":   {"S: ( s t -- t s )", "Swap the top two strings."},
+	"inswap_str": {"S: ( s -- s[n] )", "Swap the top string with string stack entry n."},
+	"mov":        {"( -- ) or ( v -- )", "Set a register to an immediate value, or pop into it with `top`."},
+}
+
+// nativeStack writes a native's signature as a stack effect.
+func nativeStack(native rmm.Native) string {
+	var sb strings.Builder
+	sb.WriteString("( ")
+	for _, param := range native.Params {
+		sb.WriteString(param.Name + " ")
+	}
+	sb.WriteString("-- ")
+	if native.Result != "" {
+		sb.WriteString(native.Result + " ")
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+// nativeDoc describes native syscall id.
+func nativeDoc(id int64) (string, bool) {
+	native, ok := rmm.Natives()[id]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("**native %d** `%s` %s\n\n%s", id, native.Name, nativeStack(native), native.Doc), true
+}
+
+// instructionDoc describes the instruction mnemonic.
+func instructionDoc(mnemonic string) (string, bool) {
+	e, ok := instructionEffects[mnemonic]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("**%s** %s\n\n%s", mnemonic, e.stack, e.summary), true
+}
+
+// staticCompletions lists the mnemonics, registers and natives. Natives
+// insert their `native <id>` form.
+func staticCompletions() []completionItem {
+	var items []completionItem
+	for mnemonic, e := range instructionEffects {
+		items = append(items, completionItem{Label: mnemonic, Kind: kindKeyword, Detail: e.stack})
+	}
+	for i := range rmm.MaxRegisters {
+		items = append(items, completionItem{Label: fmt.Sprintf("r%d", i), Kind: kindVariable, Detail: "register"})
+	}
+	for id, native := range rmm.Natives() {
+		items = append(items, completionItem{
+			Label:      native.Name,
+			Kind:       kindFunction,
+			Detail:     fmt.Sprintf("native %d %s", id, nativeStack(native)),
+			InsertText: fmt.Sprintf("native %d", id),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
@@ -0,0 +1,83 @@
+package lsp
+
+import (
+	"vm/internal/lexer"
+	"vm/internal/token"
+)
+
+// span is where a name appears in a source file; line and column are
+// 1-based like token positions.
+type span struct {
+	file   string
+	line   int
+	column int
+	length int
+}
+
+// index holds the symbols of one root file and everything it imports.
+type index struct {
+	files       map[string]bool
+	labelDefs   map[string][]span
+	labelRefs   map[string][]span
+	macroDefs   map[string]span
+	macroValues map[string]string
+	macroUses   map[string][]span
+	imports     []importSite
+}
+
+// importSite is an @imp directive and the path it resolved to.
+type importSite struct {
+	at   span
+	path string
+}
+
+// buildIndex lexes root and its imports. A lex error stops the lexer where
+// it occurred, so the index covers the source before it.
+func buildIndex(root string, readFile func(string) ([]byte, error)) *index {
+	idx := &index{
+		files:       map[string]bool{root: true},
+		labelDefs:   make(map[string][]span),
+		labelRefs:   make(map[string][]span),
+		macroDefs:   make(map[string]span),
+		macroValues: make(map[string]string),
+		macroUses:   make(map[string][]span),
+	}
+	l := lexer.Init(root)
+	l.ReadFile = readFile
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				if _, ok := r.(*token.Error); !ok {
+					panic(r)
+				}
+			}
+		}()
+		l.Lex()
+	}()
+
+	for _, t := range l.Tokens {
+		s := span{file: t.FileName, line: int(t.Line), column: t.Character, length: len(t.Text)}
+		switch t.Type {
+		case token.TypeLabelDefinition:
+			idx.labelDefs[t.Text] = append(idx.labelDefs[t.Text], s)
+		case token.TypeLabel:
+			idx.labelRefs[t.Text] = append(idx.labelRefs[t.Text], s)
+		}
+	}
+	for name, ctx := range l.MacroDefinitions {
+		idx.macroDefs[name] = contextSpan(ctx, len(name))
+		idx.macroValues[name] = l.Macros[name]
+	}
+	for _, use := range l.MacroUses {
+		idx.macroUses[use.Name] = append(idx.macroUses[use.Name], contextSpan(use.Context, len(use.Name)))
+	}
+	for _, imp := range l.Imports {
+		idx.files[imp.Name] = true
+		idx.imports = append(idx.imports, importSite{at: contextSpan(imp.Context, len("@imp")), path: imp.Name})
+	}
+	return idx
+}
+
+func contextSpan(ctx token.TokenContext, length int) span {
+	return span{file: ctx.FileName, line: int(ctx.Line), column: ctx.Character, length: length}
+}
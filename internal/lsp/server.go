@@ -0,0 +1,559 @@
+// Package lsp implements `rmm lsp`, a Language Server Protocol server over
+// stdio. Diagnostics come from compiling the open files; navigation, hover
+// and completion use an index built by the lexer, following @imp chains.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"vm/internal/framing"
+	"vm/rmm"
+)
+
+// maxWorkspaceFiles bounds the .rmm files indexed from the workspace root.
+const maxWorkspaceFiles = 1000
+
+// Server serves one editor session. Requests are handled one at a time on
+// the goroutine running Serve.
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	docs      map[string]string // open documents by path
+	workspace []string          // .rmm files found under the workspace root
+	indexes   map[string]*index // by root file
+	published map[string]bool   // paths with diagnostics on the client
+	shutdown  bool
+}
+
+// NewServer returns a server reading requests from in and writing
+// responses and notifications to out.
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		docs:      make(map[string]string),
+		indexes:   make(map[string]*index),
+		published: make(map[string]bool),
+	}
+}
+
+// Serve handles messages until the client sends exit or the input ends.
+func (s *Server) Serve() error {
+	for {
+		msg := &message{}
+		err := framing.Read(s.in, msg)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if msg.Method == "exit" {
+			// The protocol asks for a failing exit status without shutdown
+			if !s.shutdown {
+				return errors.New("exit before shutdown")
+			}
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle answers one request or applies one notification.
+func (s *Server) handle(msg *message) error {
+	var result any
+	var err error
+	switch msg.Method {
+	case "initialize":
+		result, err = s.initialize(msg.Params)
+	case "shutdown":
+		s.shutdown = true
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			s.docs[uriToPath(params.TextDocument.URI)] = params.TextDocument.Text
+			return s.refresh()
+		}
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil && len(params.ContentChanges) > 0 {
+			// Full sync: the last change holds the whole document
+			s.docs[uriToPath(params.TextDocument.URI)] = params.ContentChanges[len(params.ContentChanges)-1].Text
+			return s.refresh()
+		}
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			delete(s.docs, uriToPath(params.TextDocument.URI))
+			return s.refresh()
+		}
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.definition(uriToPath(params.TextDocument.URI), params.Position)
+		}
+	case "textDocument/references":
+		var params referenceParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.references(uriToPath(params.TextDocument.URI), params.Position, params.Context.IncludeDeclaration)
+		}
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.hover(uriToPath(params.TextDocument.URI), params.Position)
+		}
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.completion(uriToPath(params.TextDocument.URI))
+		}
+	default:
+		if msg.ID == nil {
+			return nil // notifications we do not handle are ignored
+		}
+		return s.respondError(msg, codeMethodNotFound, fmt.Sprintf("method %s is not supported", msg.Method))
+	}
+	if msg.ID == nil {
+		return nil
+	}
+	if err != nil {
+		return s.respondError(msg, codeInvalidParams, err.Error())
+	}
+	return framing.Write(s.out, response{JSONRPC: "2.0", ID: msg.ID, Result: result})
+}
+
+func (s *Server) respondError(msg *message, code int, text string) error {
+	return framing.Write(s.out, response{JSONRPC: "2.0", ID: msg.ID, Error: &responseError{Code: code, Message: text}})
+}
+
+func (s *Server) initialize(raw json.RawMessage) (any, error) {
+	var params initializeParams
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, err
+		}
+	}
+	root := params.RootPath
+	if params.RootURI != "" {
+		root = uriToPath(params.RootURI)
+	}
+	if root != "" {
+		s.workspace = findSources(root)
+	}
+	return map[string]any{
+		"capabilities": map[string]any{
+			"textDocumentSync":   1, // full
+			"definitionProvider": true,
+			"referencesProvider": true,
+			"hoverProvider":      true,
+			"completionProvider": map[string]any{},
+		},
+		"serverInfo": map[string]any{"name": "rmm"},
+	}, nil
+}
+
+// findSources lists the .rmm files under root, skipping hidden directories.
+func findSources(root string) []string {
+	var files []string
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && path != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".rmm" {
+			files = append(files, path)
+			if len(files) == maxWorkspaceFiles {
+				return filepath.SkipAll
+			}
+		}
+		return nil
+	})
+	return files
+}
+
+// readFile reads open documents from the editor and everything else from
+// disk.
+func (s *Server) readFile(name string) ([]byte, error) {
+	if text, ok := s.docs[name]; ok {
+		return []byte(text), nil
+	}
+	return os.ReadFile(name)
+}
+
+// refresh rebuilds the index of every workspace file and open document,
+// then republishes diagnostics.
+func (s *Server) refresh() error {
+	s.indexes = make(map[string]*index)
+	for _, path := range s.workspace {
+		s.indexes[path] = buildIndex(path, s.readFile)
+	}
+	for path := range s.docs {
+		if _, ok := s.indexes[path]; !ok {
+			s.indexes[path] = buildIndex(path, s.readFile)
+		}
+	}
+	return s.publishDiagnostics()
+}
+
+// topLevelRoots returns the roots that no other root imports.
+func (s *Server) topLevelRoots() []string {
+	var roots []string
+	for root := range s.indexes {
+		imported := false
+		for other, idx := range s.indexes {
+			if other != root && idx.files[root] {
+				imported = true
+				break
+			}
+		}
+		if !imported {
+			roots = append(roots, root)
+		}
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// publishDiagnostics compiles every top-level root that includes an open
+// document. A file that is only ever imported is checked as part of the
+// programs importing it, where its labels can resolve.
+func (s *Server) publishDiagnostics() error {
+	found := make(map[string][]diagnostic)
+	for _, root := range s.topLevelRoots() {
+		open := false
+		for path := range s.docs {
+			if s.indexes[root].files[path] {
+				open = true
+				break
+			}
+		}
+		if !open {
+			continue
+		}
+		_, err := rmm.CompileFileWith(root, s.readFile)
+		var compileErr *rmm.CompileError
+		if !errors.As(err, &compileErr) {
+			continue
+		}
+		path := compileErr.File
+		if path == "" || compileErr.Line == 0 {
+			path = root
+		}
+		d := diagnostic{Range: s.errorRange(path, compileErr), Severity: 1, Source: "rmm", Message: compileErr.Message}
+		found[path] = append(found[path], d)
+	}
+
+	var paths []string
+	for path := range found {
+		paths = append(paths, path)
+	}
+	for path := range s.published {
+		if _, ok := found[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		diagnostics := found[path]
+		if diagnostics == nil {
+			diagnostics = []diagnostic{}
+		}
+		params := publishDiagnosticsParams{URI: pathToURI(path), Diagnostics: diagnostics}
+		if err := framing.Write(s.out, notification{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics", Params: params}); err != nil {
+			return err
+		}
+		if len(found[path]) > 0 {
+			s.published[path] = true
+		} else {
+			delete(s.published, path)
+		}
+	}
+	return nil
+}
+
+// errorRange covers the word a compile error points at, or the start of
+// its line when the column is unknown.
+func (s *Server) errorRange(path string, e *rmm.CompileError) textRange {
+	line := max(e.Line-1, 0)
+	start := max(e.Column-1, 0)
+	text := s.line(path, line)
+	if start > len(text) {
+		start = 0
+	}
+	end := start
+	for end < len(text) && !unicode.IsSpace(rune(text[end])) {
+		end++
+	}
+	if end == start {
+		end = len(text)
+	}
+	return textRange{Start: position{Line: line, Character: start}, End: position{Line: line, Character: end}}
+}
+
+// line returns zero-based line n of path.
+func (s *Server) line(path string, n int) string {
+	data, err := s.readFile(path)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(string(data), "\n")
+	if n < 0 || n >= len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[n], "\r")
+}
+
+// indexesFor returns the indexes that include path, indexing it as a root
+// of its own if none does.
+func (s *Server) indexesFor(path string) []*index {
+	var found []*index
+	for _, root := range s.sortedRoots() {
+		if idx := s.indexes[root]; idx.files[path] {
+			found = append(found, idx)
+		}
+	}
+	if len(found) == 0 {
+		idx := buildIndex(path, s.readFile)
+		s.indexes[path] = idx
+		found = append(found, idx)
+	}
+	return found
+}
+
+func (s *Server) sortedRoots() []string {
+	roots := make([]string, 0, len(s.indexes))
+	for root := range s.indexes {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// isWordByte reports whether c can be part of a word, as in token.GetWord.
+func isWordByte(c byte) bool {
+	return unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)) || c == ':' || c == '_'
+}
+
+// wordAt returns the word under pos and its zero-based start column.
+func (s *Server) wordAt(path string, pos position) (string, int) {
+	text := s.line(path, pos.Line)
+	if pos.Character > len(text) {
+		return "", 0
+	}
+	start, end := pos.Character, pos.Character
+	for start > 0 && isWordByte(text[start-1]) {
+		start--
+	}
+	for end < len(text) && isWordByte(text[end]) {
+		end++
+	}
+	return text[start:end], start
+}
+
+// symbolAt names the label or macro under pos. Words ending in ':' are
+// label definitions and never macros, as in the lexer.
+func (s *Server) symbolAt(path string, pos position, indexes []*index) (name string, isMacro bool) {
+	word, _ := s.wordAt(path, pos)
+	if word == "" {
+		return "", false
+	}
+	if label, ok := strings.CutSuffix(word, ":"); ok {
+		return label, false
+	}
+	for _, idx := range indexes {
+		if _, ok := idx.macroDefs[word]; ok {
+			return word, true
+		}
+	}
+	return word, false
+}
+
+func (s *Server) definition(path string, pos position) []location {
+	indexes := s.indexesFor(path)
+	if strings.HasPrefix(strings.TrimSpace(s.line(path, pos.Line)), "@imp") {
+		var found []location
+		for _, idx := range indexes {
+			for _, imp := range idx.imports {
+				if imp.at.file == path && imp.at.line == pos.Line+1 {
+					found = append(found, location{URI: pathToURI(imp.path)})
+				}
+			}
+		}
+		return dedupe(found)
+	}
+	name, isMacro := s.symbolAt(path, pos, indexes)
+	var found []location
+	for _, idx := range indexes {
+		if isMacro {
+			if def, ok := idx.macroDefs[name]; ok {
+				found = append(found, def.location())
+			}
+			continue
+		}
+		for _, def := range idx.labelDefs[name] {
+			found = append(found, def.location())
+		}
+	}
+	return dedupe(found)
+}
+
+func (s *Server) references(path string, pos position, includeDeclaration bool) []location {
+	indexes := s.indexesFor(path)
+	name, isMacro := s.symbolAt(path, pos, indexes)
+	if name == "" {
+		return []location{}
+	}
+	var found []location
+	for _, idx := range indexes {
+		var defs, uses []span
+		if isMacro {
+			if def, ok := idx.macroDefs[name]; ok {
+				defs = append(defs, def)
+			}
+			uses = idx.macroUses[name]
+		} else {
+			defs, uses = idx.labelDefs[name], idx.labelRefs[name]
+		}
+		if includeDeclaration {
+			for _, def := range defs {
+				found = append(found, def.location())
+			}
+		}
+		for _, use := range uses {
+			found = append(found, use.location())
+		}
+	}
+	return dedupe(found)
+}
+
+func (s *Server) hover(path string, pos position) *hover {
+	word, start := s.wordAt(path, pos)
+	if word == "" {
+		return nil
+	}
+	fields := strings.Fields(s.line(path, pos.Line)[:start])
+	var text string
+	if len(fields) > 0 && fields[len(fields)-1] == "native" {
+		text, _ = nativeOperandDoc(word)
+	} else if word == "native" {
+		rest := strings.Fields(s.line(path, pos.Line)[start+len(word):])
+		if len(rest) > 0 {
+			text, _ = nativeOperandDoc(rest[0])
+		}
+	}
+	if text == "" {
+		text = s.symbolDoc(path, pos, word)
+	}
+	if text == "" {
+		return nil
+	}
+	r := textRange{Start: position{Line: pos.Line, Character: start}, End: position{Line: pos.Line, Character: start + len(word)}}
+	return &hover{Contents: markupContent{Kind: "markdown", Value: text}, Range: &r}
+}
+
+// symbolDoc describes a macro, mnemonic, register or label.
+func (s *Server) symbolDoc(path string, pos position, word string) string {
+	indexes := s.indexesFor(path)
+	name, isMacro := s.symbolAt(path, pos, indexes)
+	if isMacro {
+		for _, idx := range indexes {
+			value := idx.macroValues[name]
+			text := fmt.Sprintf("```rmm\n@def %s %s\n```", name, value)
+			if fields := strings.Fields(value); len(fields) == 2 && fields[0] == "native" {
+				if doc, ok := nativeOperandDoc(fields[1]); ok {
+					text += "\n\n" + doc
+				}
+			} else if len(fields) > 0 {
+				if doc, ok := instructionDoc(fields[0]); ok {
+					text += "\n\n" + doc
+				}
+			}
+			return text
+		}
+	}
+	if doc, ok := instructionDoc(word); ok {
+		return doc
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(word, "r")); err == nil && strings.HasPrefix(word, "r") && n >= 0 && n < rmm.MaxRegisters {
+		return fmt.Sprintf("**register** %s", word)
+	}
+	for _, idx := range indexes {
+		if defs := idx.labelDefs[name]; len(defs) > 0 {
+			return fmt.Sprintf("**label** %s (%s:%d)", name, filepath.Base(defs[0].file), defs[0].line)
+		}
+	}
+	return ""
+}
+
+// nativeOperandDoc describes the native whose ID is text.
+func nativeOperandDoc(text string) (string, bool) {
+	id, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	return nativeDoc(id)
+}
+
+func (s *Server) completion(path string) []completionItem {
+	macros := make(map[string]string)
+	labels := make(map[string]bool)
+	for _, idx := range s.indexesFor(path) {
+		for name, value := range idx.macroValues {
+			macros[name] = value
+		}
+		for name := range idx.labelDefs {
+			labels[name] = true
+		}
+	}
+	var items []completionItem
+	for _, item := range staticCompletions() {
+		// A macro of the same name (stddefs.rmm defines one per native)
+		// is what the lexer will expand, so offer that instead
+		if _, ok := macros[item.Label]; !ok {
+			items = append(items, item)
+		}
+	}
+	for name, value := range macros {
+		items = append(items, completionItem{Label: name, Kind: kindConstant, Detail: value})
+	}
+	for name := range labels {
+		items = append(items, completionItem{Label: name, Kind: kindFunction, Detail: "label"})
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+func (sp span) location() location {
+	start := position{Line: sp.line - 1, Character: max(sp.column-1, 0)}
+	end := position{Line: start.Line, Character: start.Character + sp.length}
+	return location{URI: pathToURI(sp.file), Range: textRange{Start: start, End: end}}
+}
+
+// dedupe drops repeated locations, which appear when several roots import
+// the same file.
+func dedupe(locations []location) []location {
+	seen := make(map[location]bool)
+	found := []location{}
+	for _, loc := range locations {
+		if !seen[loc] {
+			seen[loc] = true
+			found = append(found, loc)
+		}
+	}
+	return found
+}
@@ -0,0 +1,264 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"vm/internal/framing"
+)
+
+const libSource = `@def write native 1
+@def STDOUT 1
+
+helper:
+	push 1
+	add
+	ret
+`
+
+const mainSource = `@imp "lib.rmm"
+entrypoint main
+main:
+	push 41
+	call helper
+	pop
+	get_str 0
+	push STDOUT
+	write
+	halt
+`
+
+// client drives a Server through scripted LSP messages.
+type client struct {
+	t             *testing.T
+	w             io.Writer
+	messages      chan map[string]any
+	id            int
+	notifications []map[string]any // read while waiting for a response
+}
+
+func newClient(t *testing.T, root string) *client {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &client{t: t, w: clientOut, messages: make(chan map[string]any, 64)}
+	go func() {
+		NewServer(serverIn, serverOut).Serve()
+		serverOut.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(clientIn)
+		for {
+			var msg map[string]any
+			if err := framing.Read(r, &msg); err != nil {
+				close(c.messages)
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+	t.Cleanup(func() { clientOut.Close() })
+	c.request("initialize", map[string]any{"rootUri": pathToURI(root)})
+	c.notify("initialized", map[string]any{})
+	return c
+}
+
+func (c *client) next() map[string]any {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatalf("server closed the connection")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatalf("timed out waiting for a message")
+	}
+	return nil
+}
+
+func (c *client) notify(method string, params any) {
+	c.t.Helper()
+	if err := framing.Write(c.w, map[string]any{"jsonrpc": "2.0", "method": method, "params": params}); err != nil {
+		c.t.Fatalf("failed to send %s: %v", method, err)
+	}
+}
+
+// request sends a request and returns its raw result.
+func (c *client) request(method string, params any) json.RawMessage {
+	c.t.Helper()
+	c.id++
+	if err := framing.Write(c.w, map[string]any{"jsonrpc": "2.0", "id": c.id, "method": method, "params": params}); err != nil {
+		c.t.Fatalf("failed to send %s: %v", method, err)
+	}
+	for {
+		msg := c.next()
+		if msg["method"] != nil {
+			c.notifications = append(c.notifications, msg)
+			continue
+		}
+		if msg["error"] != nil {
+			c.t.Fatalf("%s failed: %v", method, msg["error"])
+		}
+		result, _ := json.Marshal(msg["result"])
+		return result
+	}
+}
+
+// diagnostics waits for the next diagnostics published for uri.
+func (c *client) diagnostics(uri string) []diagnostic {
+	c.t.Helper()
+	for {
+		var msg map[string]any
+		if len(c.notifications) > 0 {
+			msg, c.notifications = c.notifications[0], c.notifications[1:]
+		} else {
+			msg = c.next()
+		}
+		if msg["method"] != "textDocument/publishDiagnostics" {
+			continue
+		}
+		body, _ := json.Marshal(msg["params"])
+		var params publishDiagnosticsParams
+		json.Unmarshal(body, &params)
+		if params.URI == uri {
+			return params.Diagnostics
+		}
+	}
+}
+
+func at(uri string, line, character int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": line, "character": character},
+	}
+}
+
+func writeWorkspace(t *testing.T) (dir, mainURI, libURI string) {
+	dir = t.TempDir()
+	for name, src := range map[string]string{"main.rmm": mainSource, "lib.rmm": libSource} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return dir, pathToURI(filepath.Join(dir, "main.rmm")), pathToURI(filepath.Join(dir, "lib.rmm"))
+}
+
+func locations(t *testing.T, raw json.RawMessage) []location {
+	var found []location
+	if err := json.Unmarshal(raw, &found); err != nil {
+		t.Fatalf("bad locations %s: %v", raw, err)
+	}
+	return found
+}
+
+func TestNavigation(t *testing.T) {
+	dir, mainURI, libURI := writeWorkspace(t)
+	c := newClient(t, dir)
+	c.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": mainURI, "text": mainSource}})
+
+	found := locations(t, c.request("textDocument/definition", at(mainURI, 4, 8)))
+	if len(found) != 1 || found[0].URI != libURI || found[0].Range.Start != (position{Line: 3, Character: 0}) {
+		t.Errorf("definition of helper: %+v", found)
+	}
+	found = locations(t, c.request("textDocument/definition", at(mainURI, 8, 2)))
+	if len(found) != 1 || found[0].URI != libURI || found[0].Range.Start != (position{Line: 0, Character: 5}) {
+		t.Errorf("definition of write: %+v", found)
+	}
+	found = locations(t, c.request("textDocument/definition", at(mainURI, 0, 7)))
+	if len(found) != 1 || found[0].URI != libURI {
+		t.Errorf("definition of the import: %+v", found)
+	}
+
+	refs := map[string]any{"context": map[string]any{"includeDeclaration": true}}
+	for k, v := range at(libURI, 3, 2) {
+		refs[k] = v
+	}
+	found = locations(t, c.request("textDocument/references", refs))
+	if len(found) != 2 || found[1].URI != mainURI || found[1].Range.Start != (position{Line: 4, Character: 6}) {
+		t.Errorf("references to helper: %+v", found)
+	}
+	refs["position"] = map[string]any{"line": 1, "character": 6}
+	found = locations(t, c.request("textDocument/references", refs))
+	if len(found) != 2 || found[1].Range.Start != (position{Line: 7, Character: 6}) {
+		t.Errorf("references to STDOUT: %+v", found)
+	}
+}
+
+func TestHoverAndCompletion(t *testing.T) {
+	dir, mainURI, libURI := writeWorkspace(t)
+	c := newClient(t, dir)
+	c.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": mainURI, "text": mainSource}})
+
+	hovers := []struct {
+		uri       string
+		line, col int
+		want      []string
+	}{
+		{libURI, 5, 2, []string{"**add** ( a b -- a+b )"}},
+		{mainURI, 8, 2, []string{"@def write native 1", "( string fd -- length )"}},
+		{libURI, 0, 18, []string{"**native 1** `write`"}},
+		{mainURI, 4, 8, []string{"**label** helper (lib.rmm:4)"}},
+	}
+	for _, h := range hovers {
+		var result hover
+		json.Unmarshal(c.request("textDocument/hover", at(h.uri, h.line, h.col)), &result)
+		for _, want := range h.want {
+			if !strings.Contains(result.Contents.Value, want) {
+				t.Errorf("hover at %d:%d = %q, want %q", h.line, h.col, result.Contents.Value, want)
+			}
+		}
+	}
+
+	var items []completionItem
+	json.Unmarshal(c.request("textDocument/completion", at(mainURI, 5, 1)), &items)
+	byLabel := make(map[string]completionItem)
+	for _, item := range items {
+		byLabel[item.Label] = item
+	}
+	wants := []completionItem{
+		{Label: "dup", Kind: kindKeyword, Detail: "( a -- a a )"},
+		{Label: "r15", Kind: kindVariable, Detail: "register"},
+		{Label: "strlen", Kind: kindFunction, Detail: "native 94 ( string -- length )", InsertText: "native 94"},
+		{Label: "pow", Kind: kindFunction, Detail: "native 8 ( exponent base -- result )", InsertText: "native 8"},
+		{Label: "write", Kind: kindConstant, Detail: "native 1"},
+		{Label: "helper", Kind: kindFunction, Detail: "label"},
+	}
+	for _, want := range wants {
+		if got := byLabel[want.Label]; got != want {
+			t.Errorf("completion %s = %+v, want %+v", want.Label, got, want)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	dir, mainURI, libURI := writeWorkspace(t)
+	c := newClient(t, dir)
+	broken := strings.Replace(mainSource, "call helper", "call helpr", 1)
+	c.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": mainURI, "text": broken}})
+	diags := c.diagnostics(mainURI)
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, "undefined label reference found for label 'helpr'") ||
+		diags[0].Range != (textRange{Start: position{Line: 4, Character: 6}, End: position{Line: 4, Character: 11}}) {
+		t.Errorf("unexpected diagnostics %+v", diags)
+	}
+
+	// Errors in an open import are reported against the import
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": mainURI},
+		"contentChanges": []map[string]any{{"text": mainSource}},
+	})
+	if diags := c.diagnostics(mainURI); len(diags) != 0 {
+		t.Errorf("expected diagnostics to clear, got %+v", diags)
+	}
+	c.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": libURI, "text": libSource + "\tinswap\n"}})
+	diags = c.diagnostics(libURI)
+	if len(diags) != 1 || diags[0].Range.Start.Line != 7 || !strings.Contains(diags[0].Message, "expected integer value after 'inswap'") {
+		t.Errorf("unexpected diagnostics %+v", diags)
+	}
+	c.request("shutdown", nil)
+	c.notify("exit", nil)
+}
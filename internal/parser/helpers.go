@@ -8,12 +8,19 @@ import (
 )
 
 func Init(l *lexer.Lexer) *ParserList {
+	parserList, _ := InitWithLabels(l)
+	return parserList
+}
+
+// InitWithLabels parses the lexed tokens like Init and also returns the
+// label definitions, mapped to the instruction number they point at.
+func InitWithLabels(l *lexer.Lexer) (*ParserList, map[string]int64) {
+	labelMap := make(map[string]int64)
 	if l.Tokens == nil || len(l.Tokens) == 0 {
-		return nil
+		return nil, labelMap
 	}
-	labelMap := make(map[string]int64)
 	parserList := generateList(l.Tokens, labelMap)
-	return parserList
+	return parserList, labelMap
 }
 
 func generateList(tokens token.Tokens, labelMap map[string]int64) *ParserList {
@@ -35,31 +42,31 @@ func generateList(tokens token.Tokens, labelMap map[string]int64) *ParserList {
 	nextToken := tokens.PeekToken(1)
 	switch tokens[0].Type {
 	case token.TypeInt, token.TypeLabel:
-		panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(fmt.Sprintf("program cannot start with a %s reference", tokens[0].Type)))
+		panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, fmt.Sprintf("program cannot start with a %s reference", tokens[0].Type)))
 	case token.TypePush:
 		if len(tokens) < 2 || util.NotOneOf(nextToken.Type, token.TypeInt, token.TypeFloat, token.TypeChar, token.TypeString, token.TypeNull) {
-			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(fmt.Sprintf("expected integer, float, char, or string value after '%s' instruction, but found %s '%s'", tokens[0].Type, nextToken.Type, nextToken.Text)))
+			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected integer, float, char, or string value after '%s' instruction, but found %s '%s'", tokens[0].Type, nextToken.Type, nextToken.Text)))
 		}
 		current = current.AddNextNode(tokens[1])
 		instructionNumber++
 		startIndex++
 	case token.TypePushPtr:
 		if len(tokens) < 2 || util.NotOneOf(nextToken.Type, token.TypeInt, token.TypeNull) {
-			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(fmt.Sprintf("expected integer or NULL after 'push_ptr' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
+			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected integer or NULL after 'push_ptr' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
 		}
 		current = current.AddNextNode(tokens[1])
 		instructionNumber++
 		startIndex++
 	case token.TypePushStr:
 		if len(tokens) < 2 || nextToken.Type != token.TypeString {
-			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(fmt.Sprintf("expected string value after 'push_str' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
+			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected string value after 'push_str' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
 		}
 		current = current.AddNextNode(tokens[1])
 		// push_str does not increment instructionNumber as it's a data directive
 		startIndex++
 	case token.TypeGetStr:
 		if len(tokens) < 2 || util.NotOneOf(nextToken.Type, token.TypeInt) {
-			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(fmt.Sprintf("expected integer value (index) after 'get_str' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
+			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected integer value (index) after 'get_str' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
 		}
 		current = current.AddNextNode(tokens[1])
 		instructionNumber++
@@ -67,54 +74,54 @@ func generateList(tokens token.Tokens, labelMap map[string]int64) *ParserList {
 	case token.TypeInDup, token.TypeInSwap, token.TypeInDupStr,
 		token.TypeInSwapStr, token.TypeCastIntToFloat, token.TypeCastFloatToInt:
 		if len(tokens) < 2 || util.NotOneOf(nextToken.Type, token.TypeInt) {
-			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(fmt.Sprintf("expected integer value after '%s' instruction, but found %s '%s'", tokens[0].Type, nextToken.Type, nextToken.Text)))
+			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected integer value after '%s' instruction, but found %s '%s'", tokens[0].Type, nextToken.Type, nextToken.Text)))
 		}
 		current = current.AddNextNode(tokens[1])
 		instructionNumber++
 		startIndex++
 	case token.TypeNative:
 		if len(tokens) < 2 || nextToken.Type != token.TypeInt {
-			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error("expected integer value (function ID) after 'native' instruction"))
+			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, "expected integer value (function ID) after 'native' instruction"))
 		}
 		current = current.AddNextNode(tokens[1])
 		instructionNumber++
 		startIndex++
 	case token.TypeJmp, token.TypeZjmp, token.TypeNzjmp:
 		if len(tokens) < 2 {
-			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error("expected label or integer after jump instruction at the start of the program"))
+			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, "expected label or integer after jump instruction at the start of the program"))
 		}
 		if util.NotOneOf(nextToken.Type, token.TypeInt, token.TypeLabel) {
-			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error("expected label or integer after jump instruction at the start of the program"))
+			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, "expected label or integer after jump instruction at the start of the program"))
 		}
 		current = current.AddNextNode(tokens[1])
 		instructionNumber++
 		startIndex++
 	case token.TypeCall:
 		if len(tokens) < 2 {
-			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error("expected label or integer after call instruction"))
+			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, "expected label or integer after call instruction"))
 		}
 		if util.NotOneOf(nextToken.Type, token.TypeInt, token.TypeLabel) {
-			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error("expected label or integer after call instruction"))
+			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, "expected label or integer after call instruction"))
 		}
 		current = current.AddNextNode(tokens[1])
 		instructionNumber++
 		startIndex++
 	case token.TypeEntrypoint:
 		if len(tokens) < 2 {
-			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error("expected label or integer after entrypoint instruction"))
+			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, "expected label or integer after entrypoint instruction"))
 		}
 		if util.NotOneOf(nextToken.Type, token.TypeInt, token.TypeLabel) {
-			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error("expected label or integer after entrypoint instruction"))
+			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, "expected label or integer after entrypoint instruction"))
 		}
 		current = current.AddNextNode(tokens[1])
 		startIndex++
 	case token.TypeMov:
 		if nextToken.Type != token.TypeRegister {
-			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(fmt.Sprintf("expected register after 'mov' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
+			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected register after 'mov' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
 		}
 		valToken := tokens.PeekToken(2)
 		if util.NotOneOf(valToken.Type, token.TypeInt, token.TypeFloat, token.TypeChar, token.TypeTop) {
-			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(fmt.Sprintf("expected integer, float, char, or top value after register in 'mov' instruction, but found %s '%s'", valToken.Type, valToken.Text)))
+			panic(token.TokenContext{Line: tokens[0].Line, Character: tokens[0].Character, FileName: tokens[0].FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected integer, float, char, or top value after register in 'mov' instruction, but found %s '%s'", valToken.Type, valToken.Text)))
 		}
 		current = current.AddNextNode(tokens[1])
 		current = current.AddNextNode(valToken)
@@ -140,7 +147,7 @@ func generateList(tokens token.Tokens, labelMap map[string]int64) *ParserList {
 		switch curToken.Type {
 		case token.TypePush:
 			if util.NotOneOf(nextToken.Type, token.TypeInt, token.TypeFloat, token.TypeChar, token.TypeString, token.TypeNull, token.TypeRegister) {
-				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(fmt.Sprintf("expected integer, float, char, string, or register value after '%s' instruction, but found %s '%s'", curToken.Type, nextToken.Type, nextToken.Text)))
+				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected integer, float, char, string, or register value after '%s' instruction, but found %s '%s'", curToken.Type, nextToken.Type, nextToken.Text)))
 			}
 			current = current.AddNextNode(curToken)
 			current = current.AddNextNode(nextToken)
@@ -152,12 +159,12 @@ func generateList(tokens token.Tokens, labelMap map[string]int64) *ParserList {
 			i++
 		case token.TypeMov:
 			if nextToken.Type != token.TypeRegister {
-				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(fmt.Sprintf("expected register after 'mov' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
+				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected register after 'mov' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
 			}
 			// mov <reg> <val>
 			valToken := tokens.PeekToken(i + 2)
 			if util.NotOneOf(valToken.Type, token.TypeInt, token.TypeFloat, token.TypeChar, token.TypeTop) {
-				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(fmt.Sprintf("expected integer, float, char, or top value after register in 'mov' instruction, but found %s '%s'", valToken.Type, valToken.Text)))
+				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected integer, float, char, or top value after register in 'mov' instruction, but found %s '%s'", valToken.Type, valToken.Text)))
 			}
 			current = current.AddNextNode(curToken)
 			current = current.AddNextNode(nextToken)
@@ -166,7 +173,7 @@ func generateList(tokens token.Tokens, labelMap map[string]int64) *ParserList {
 			i += 2
 		case token.TypePushPtr:
 			if util.NotOneOf(nextToken.Type, token.TypeInt, token.TypeNull) {
-				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(fmt.Sprintf("expected integer or NULL after 'push_ptr' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
+				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected integer or NULL after 'push_ptr' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
 			}
 			current = current.AddNextNode(curToken)
 			current = current.AddNextNode(nextToken)
@@ -174,14 +181,14 @@ func generateList(tokens token.Tokens, labelMap map[string]int64) *ParserList {
 			i++
 		case token.TypePushStr:
 			if nextToken.Type != token.TypeString {
-				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(fmt.Sprintf("expected string value after 'push_str' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
+				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected string value after 'push_str' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
 			}
 			current = current.AddNextNode(curToken)
 			current = current.AddNextNode(nextToken)
 			i++
 		case token.TypeGetStr:
 			if util.NotOneOf(nextToken.Type, token.TypeInt) {
-				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(fmt.Sprintf("expected integer value (index) after 'get_str' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
+				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected integer value (index) after 'get_str' instruction, but found %s '%s'", nextToken.Type, nextToken.Text)))
 			}
 			current = current.AddNextNode(curToken)
 			current = current.AddNextNode(nextToken)
@@ -189,7 +196,7 @@ func generateList(tokens token.Tokens, labelMap map[string]int64) *ParserList {
 			i++
 		case token.TypeInDup, token.TypeInSwap, token.TypeInDupStr, token.TypeInSwapStr:
 			if util.NotOneOf(nextToken.Type, token.TypeInt) {
-				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(fmt.Sprintf("expected integer value after '%s' instruction, but found %s '%s'", curToken.Type, nextToken.Type, nextToken.Text)))
+				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected integer value after '%s' instruction, but found %s '%s'", curToken.Type, nextToken.Type, nextToken.Text)))
 			}
 			current = current.AddNextNode(curToken)
 			current = current.AddNextNode(nextToken)
@@ -197,7 +204,7 @@ func generateList(tokens token.Tokens, labelMap map[string]int64) *ParserList {
 			i++
 		case token.TypeNative:
 			if util.NotOneOf(nextToken.Type, token.TypeInt) {
-				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error("expected integer value (function ID) after 'native' instruction"))
+				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(token.ErrorSyntax, "expected integer value (function ID) after 'native' instruction"))
 			}
 			current = current.AddNextNode(curToken)
 			current = current.AddNextNode(nextToken)
@@ -205,7 +212,7 @@ func generateList(tokens token.Tokens, labelMap map[string]int64) *ParserList {
 			i++
 		case token.TypeJmp, token.TypeZjmp, token.TypeNzjmp:
 			if util.NotOneOf(nextToken.Type, token.TypeInt, token.TypeLabel) {
-				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(fmt.Sprintf("expected label after '%s' instruction, but found %s '%s'", curToken.Type, nextToken.Type, nextToken.Text)))
+				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected label after '%s' instruction, but found %s '%s'", curToken.Type, nextToken.Type, nextToken.Text)))
 			}
 			current = current.AddNextNode(curToken)
 			current = current.AddNextNode(nextToken)
@@ -217,7 +224,7 @@ func generateList(tokens token.Tokens, labelMap map[string]int64) *ParserList {
 			handleLabelDefination(curToken, labelMap, instructionNumber)
 		case token.TypeCall:
 			if util.NotOneOf(nextToken.Type, token.TypeInt, token.TypeLabel) {
-				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(fmt.Sprintf("expected label or integer after '%s' instruction, but found %s '%s'", curToken.Type, nextToken.Type, nextToken.Text)))
+				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected label or integer after '%s' instruction, but found %s '%s'", curToken.Type, nextToken.Type, nextToken.Text)))
 			}
 			current = current.AddNextNode(curToken)
 			current = current.AddNextNode(nextToken)
@@ -225,7 +232,7 @@ func generateList(tokens token.Tokens, labelMap map[string]int64) *ParserList {
 			i++
 		case token.TypeEntrypoint:
 			if util.NotOneOf(nextToken.Type, token.TypeInt, token.TypeLabel) {
-				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(fmt.Sprintf("expected label or integer after '%s' instruction, but found %s '%s'", curToken.Type, nextToken.Type, nextToken.Text)))
+				panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(token.ErrorSyntax, fmt.Sprintf("expected label or integer after '%s' instruction, but found %s '%s'", curToken.Type, nextToken.Type, nextToken.Text)))
 			}
 			current = current.AddNextNode(curToken)
 			current = current.AddNextNode(nextToken)
@@ -249,7 +256,7 @@ func generateList(tokens token.Tokens, labelMap map[string]int64) *ParserList {
 		case token.TypeIndex:
 			if nextToken.Type == token.TypeChar {
 				if len(nextToken.Text) == 0 {
-					panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error("empty character literal for index"))
+					panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(token.ErrorSyntax, "empty character literal for index"))
 				}
 				current = current.AddNextNode(curToken)
 				current = current.AddNextNode(nextToken)
@@ -261,7 +268,7 @@ func generateList(tokens token.Tokens, labelMap map[string]int64) *ParserList {
 				instructionNumber++
 			}
 		default:
-			panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error("unknown token type encountered during parsing"))
+			panic(token.TokenContext{Line: curToken.Line, Character: curToken.Character, FileName: curToken.FileName}.Error(token.ErrorSyntax, "unknown token type encountered during parsing"))
 		}
 	}
 
@@ -291,7 +298,7 @@ func (pl *ParserList) Print() {
 
 func handleLabelDefination(t token.Token, labelMap map[string]int64, instructionNum int64) {
 	if _, exists := labelMap[t.Text]; exists {
-		panic(token.TokenContext{Line: t.Line, Character: t.Character, FileName: t.FileName}.Error(fmt.Sprintf("duplicate label definition found for label '%s'", t.Text)))
+		panic(token.TokenContext{Line: t.Line, Character: t.Character, FileName: t.FileName}.Error(token.ErrorSymbol, fmt.Sprintf("duplicate label definition found for label '%s'", t.Text)))
 	}
 	labelMap[t.Text] = instructionNum
 }
@@ -303,7 +310,7 @@ func assertAndReplaceLabels(parserList *ParserList, labelMap map[string]int64) {
 			label := cur.Value.Text
 			lineNum, exists := labelMap[label]
 			if !exists {
-				panic(token.TokenContext{Line: cur.Value.Line, Character: cur.Value.Character, FileName: cur.Value.FileName}.Error(fmt.Sprintf("undefined label reference found for label '%s'", label)))
+				panic(token.TokenContext{Line: cur.Value.Line, Character: cur.Value.Character, FileName: cur.Value.FileName}.Error(token.ErrorSymbol, fmt.Sprintf("undefined label reference found for label '%s'", label)))
 			}
 			// Replace label token with integer token representing the instruction number
 			cur.Value.Type = token.TypeInt
@@ -0,0 +1,263 @@
+// Package repl implements `rmm repl`, which assembles and runs .rmm one
+// entry at a time against a machine whose stack, heap, registers, labels
+// and macros persist between entries.
+package repl
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"vm/rmm"
+)
+
+// sourceName is the file name entries are compiled and reported under.
+// Imports resolve relative to the working directory.
+const sourceName = "<repl>"
+
+// errQuit ends the session.
+var errQuit = errors.New("quit")
+
+// entry is source the user typed: a line to run, a block of definitions,
+// or the import a :load adds.
+type entry struct {
+	line int // REPL line the entry starts on, for error locations
+	text string
+}
+
+// REPL reads entries and meta-commands from one input and writes results
+// and the program's output to another. Programs read stdin from the same
+// input.
+type REPL struct {
+	in      *bufio.Reader
+	out     io.Writer
+	machine *rmm.Machine
+	program *rmm.Program // every entry so far; nil before the first
+	entries []entry
+	line    int // source lines read so far
+}
+
+// New starts a session with an empty machine.
+func New(in io.Reader, out io.Writer) *REPL {
+	r := &REPL{in: bufio.NewReader(in), out: out}
+	r.reset()
+	return r
+}
+
+func (r *REPL) reset() {
+	r.machine = rmm.NewMachine(rmm.Options{Stdin: r.in, Stdout: r.out, Stderr: r.out})
+	r.program = nil
+	r.entries = nil
+	r.line = 0
+}
+
+// Run reads entries until the input ends or the user quits. A program that
+// calls exit ends the session with its *rmm.ExitError.
+func (r *REPL) Run() error {
+	for {
+		text, ok := r.readLine("rmm> ")
+		if !ok {
+			return nil
+		}
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" {
+			continue
+		}
+		var err error
+		if strings.HasPrefix(trimmed, ":") {
+			err = r.command(strings.Fields(trimmed[1:]))
+		} else if isLabelDefinition(trimmed) {
+			err = r.block(text)
+		} else {
+			r.line++
+			err = r.submit(entry{line: r.line, text: text}, true)
+		}
+		var exitErr *rmm.ExitError
+		switch {
+		case errors.Is(err, errQuit):
+			return nil
+		case errors.As(err, &exitErr):
+			return err
+		case err != nil:
+			fmt.Fprintln(r.out, err)
+			var runtimeErr *rmm.RuntimeError
+			if errors.As(err, &runtimeErr) {
+				fmt.Fprint(r.out, runtimeErr.StackTrace())
+			}
+		}
+	}
+}
+
+// readLine prompts for and reads one line, without its newline.
+func (r *REPL) readLine(prompt string) (string, bool) {
+	fmt.Fprint(r.out, prompt)
+	text, err := r.in.ReadString('\n')
+	if err != nil && text == "" {
+		fmt.Fprintln(r.out)
+		return "", false
+	}
+	return strings.TrimRight(text, "\r\n"), true
+}
+
+// isLabelDefinition reports whether a line starts by defining a label.
+func isLabelDefinition(text string) bool {
+	first := strings.Fields(text)[0]
+	return len(first) > 1 && strings.HasSuffix(first, ":")
+}
+
+// block reads a definition that starts with a label, up to a blank line,
+// and adds it without running it. Its code runs when something jumps to or
+// calls it; falling off its end stops the entry, like halt.
+func (r *REPL) block(first string) error {
+	r.line++
+	e := entry{line: r.line, text: first}
+	for {
+		text, ok := r.readLine("...> ")
+		if !ok || strings.TrimSpace(text) == "" {
+			break
+		}
+		r.line++
+		e.text += "\n" + text
+	}
+	return r.submit(e, false)
+}
+
+// submit compiles every entry plus e and, if run is set, runs e's code.
+// An entry that does not compile is dropped; one that faults is kept,
+// since it may have defined labels, macros and strings.
+func (r *REPL) submit(e entry, run bool) error {
+	entries := append(r.entries, e)
+	program, err := rmm.CompileFileWith(sourceName, sessionFiles(entries))
+	if err != nil {
+		return err
+	}
+	start := 0
+	if r.program != nil {
+		start = r.program.Len()
+	}
+	if !run {
+		start = program.Len()
+	}
+	r.entries, r.program = entries, program
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return r.machine.RunFrom(ctx, program, start)
+}
+
+// sessionFiles serves the session's source as sourceName and every other
+// file from disk. Each entry is followed by a halt so that control never
+// falls through into an earlier entry, and @line keeps error locations on
+// the lines the user typed.
+func sessionFiles(entries []entry) func(string) ([]byte, error) {
+	var sb strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&sb, "@line %d \"%s\"\n%s\nhalt\n", e.line, sourceName, e.text)
+	}
+	return func(name string) ([]byte, error) {
+		if name == sourceName {
+			return []byte(sb.String()), nil
+		}
+		return os.ReadFile(name)
+	}
+}
+
+const help = `Enter .rmm source to assemble and run it against the live machine. A line
+that starts with a label begins a definition, which is added without running
+and ends at a blank line; call or jump to it from later entries.
+
+:stack            print the data stack, top first
+:strstack         print the string stack
+:regs             print the registers
+:heap <a> [b]     print heap cells a up to (not including) b
+:load <file>      add a file's labels and macros without running it
+:reset            start over with an empty machine
+:help             show this help
+:quit             leave the REPL
+`
+
+// command runs one meta-command.
+func (r *REPL) command(args []string) error {
+	if len(args) == 0 {
+		return errors.New("unknown command; try :help")
+	}
+	switch args[0] {
+	case "help", "h":
+		fmt.Fprint(r.out, help)
+	case "quit", "q":
+		return errQuit
+	case "stack":
+		stack := r.machine.Stack()
+		if len(stack) == 0 {
+			fmt.Fprintln(r.out, "stack is empty")
+		}
+		for i := len(stack) - 1; i >= 0; i-- {
+			fmt.Fprintf(r.out, "[%d] %s\n", i, stack[i])
+		}
+	case "strstack":
+		strStack := r.machine.StrStack()
+		if len(strStack) == 0 {
+			fmt.Fprintln(r.out, "string stack is empty")
+		}
+		for i := len(strStack) - 1; i >= 0; i-- {
+			fmt.Fprintf(r.out, "[%d] PTR %d %q\n", i, strStack[i], r.machine.HeapString(strStack[i]))
+		}
+	case "regs", "registers":
+		for i, value := range r.machine.Registers() {
+			fmt.Fprintf(r.out, "r%-3d %s\n", i, value)
+		}
+	case "heap":
+		return r.printHeap(args[1:])
+	case "load":
+		if len(args) != 2 {
+			return errors.New("usage: :load <file>")
+		}
+		path, err := filepath.Abs(args[1])
+		if err != nil {
+			return err
+		}
+		r.line++
+		if err := r.submit(entry{line: r.line, text: fmt.Sprintf("@imp \"%s\"", path)}, false); err != nil {
+			return err
+		}
+		fmt.Fprintf(r.out, "loaded %s\n", args[1])
+	case "reset":
+		r.reset()
+		fmt.Fprintln(r.out, "machine reset")
+	default:
+		return fmt.Errorf("unknown command :%s; try :help", args[0])
+	}
+	return nil
+}
+
+// printHeap prints cells from up to to, clipped to the heap's size.
+func (r *REPL) printHeap(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("usage: :heap <from> [to]")
+	}
+	heap := r.machine.Heap()
+	from, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid heap index %q", args[0])
+	}
+	to := from + 1
+	if len(args) == 2 {
+		if to, err = strconv.Atoi(args[1]); err != nil {
+			return fmt.Errorf("invalid heap index %q", args[1])
+		}
+	}
+	to = min(to, len(heap))
+	if from < 0 || from >= to {
+		return fmt.Errorf("heap range %d..%d out of bounds (size %d)", from, to, len(heap))
+	}
+	for i := from; i < to; i++ {
+		fmt.Fprintf(r.out, "[%d] %s\n", i, heap[i])
+	}
+	return nil
+}
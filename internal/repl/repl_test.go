@@ -0,0 +1,116 @@
+package repl
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"vm/internal/testutil"
+	"vm/rmm"
+)
+
+// session runs the REPL over a scripted input and returns everything it
+// and the program wrote.
+func session(t *testing.T, input string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := New(strings.NewReader(input), &out).Run()
+	return out.String(), err
+}
+
+func TestStatePersists(t *testing.T) {
+	out, err := session(t, `push 3
+push 4
+add
+:stack
+square:
+	dup
+	mul
+	ret
+
+call square
+:stack
+@def TEN 10
+mov r2 TEN
+push r2
+print
+:regs
+push_str "hi"
+:strstack
+:heap 0 10
+`)
+	if err != nil {
+		t.Fatalf("repl failed: %v", err)
+	}
+	testutil.ExpectInOrder(t, out,
+		"[0] INT 7\n",
+		"...> ",
+		"[0] INT 49\n",
+		"INT 10\n",
+		"r2   INT 10\n",
+		`[0] PTR 0 "hi"`,
+		"[0] CHAR h\n[1] CHAR i\n",
+	)
+}
+
+func TestErrorsKeepSession(t *testing.T) {
+	out, err := session(t, `push 1
+jmp nowhere
+pop
+pop
+push 2
+:stack
+`)
+	if err != nil {
+		t.Fatalf("repl failed: %v", err)
+	}
+	testutil.ExpectInOrder(t, out,
+		"ERROR (<repl>:2): undefined label reference found for label 'nowhere'",
+		"ERROR (<repl>:4): stack underflow",
+		"#0 ",
+		"[0] INT 2\n",
+	)
+	if strings.Contains(out, "[1]") {
+		t.Errorf("expected a single stack value, output:\n%s", out)
+	}
+}
+
+func TestLoadAndReset(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.rmm")
+	src := "@imp \"defs.rmm\"\ntwice:\n\tpush TWO\n\tmul\n\tret\n"
+	if err := os.WriteFile(lib, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "defs.rmm"), []byte("@def TWO 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := session(t, ":load "+lib+`
+push 21
+call twice
+:stack
+:reset
+:stack
+call twice
+`)
+	if err != nil {
+		t.Fatalf("repl failed: %v", err)
+	}
+	testutil.ExpectInOrder(t, out,
+		"loaded "+lib,
+		"[0] INT 42\n",
+		"machine reset",
+		"stack is empty",
+		"undefined label reference found for label 'twice'",
+	)
+}
+
+func TestExitEndsSession(t *testing.T) {
+	_, err := session(t, "push 3\nnative 60\npush 1\n")
+	var exitErr *rmm.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Fatalf("expected exit status 3, got %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+// Package testutil holds helpers shared by the tests of the interactive
+// tools.
+package testutil
+
+import (
+	"strings"
+	"testing"
+)
+
+// ExpectInOrder fails the test unless each of want appears in output, each
+// after the one before it.
+func ExpectInOrder(t testing.TB, output string, want ...string) {
+	t.Helper()
+	rest := output
+	for _, w := range want {
+		i := strings.Index(rest, w)
+		if i < 0 {
+			t.Fatalf("expected %q in order, output:\n%s", w, output)
+		}
+		rest = rest[i+len(w):]
+	}
+}
@@ -310,14 +310,14 @@ func GenerateChar(input string, currentIndex int, ctx TokenContext) (Token, int)
 	currentIndex++ // skip opening '
 
 	if currentIndex >= len(input) {
-		panic(fmt.Sprintf("ERROR: unterminated character literal at line %d", ctx.Line))
+		panic(ctx.Error(ErrorSyntax, "unterminated character literal"))
 	}
 
 	charValue := input[currentIndex]
 	if charValue == '\\' {
 		currentIndex++
 		if currentIndex >= len(input) {
-			panic(fmt.Sprintf("ERROR: unterminated character literal at line %d", ctx.Line))
+			panic(ctx.Error(ErrorSyntax, "unterminated character literal"))
 		}
 		escapeChar := input[currentIndex]
 		switch escapeChar {
@@ -334,13 +334,13 @@ func GenerateChar(input string, currentIndex int, ctx TokenContext) (Token, int)
 		case '0':
 			charValue = 0
 		default:
-			panic(fmt.Sprintf("ERROR: unknown escape character '\\%c' at line %d", escapeChar, ctx.Line))
+			panic(ctx.Error(ErrorSyntax, fmt.Sprintf("unknown escape character '\\%c'", escapeChar)))
 		}
 	}
 	currentIndex++ // skip the character (or the escape code)
 
 	if currentIndex >= len(input) || input[currentIndex] != '\'' {
-		panic(fmt.Sprintf("ERROR: unterminated character literal at line %d", ctx.Line))
+		panic(ctx.Error(ErrorSyntax, "unterminated character literal"))
 	}
 
 	currentIndex++ // skip closing '
@@ -351,7 +351,7 @@ func GenerateChar(input string, currentIndex int, ctx TokenContext) (Token, int)
 func GenerateString(input string, currentIndex int, ctx TokenContext) (Token, int) {
 	currentIndex++ // skip opening "
 	if currentIndex >= len(input) {
-		panic(ctx.Error("unterminated string literal"))
+		panic(ctx.Error(ErrorSyntax, "unterminated string literal"))
 	}
 
 	var strValue string
@@ -359,7 +359,7 @@ func GenerateString(input string, currentIndex int, ctx TokenContext) (Token, in
 		if input[currentIndex] == '\\' { // escape character
 			currentIndex++ // skip backslash
 			if currentIndex >= len(input) {
-				panic(ctx.Error("unterminated string literal"))
+				panic(ctx.Error(ErrorSyntax, "unterminated string literal"))
 			}
 			switch input[currentIndex] {
 			case 'n':
@@ -373,7 +373,7 @@ func GenerateString(input string, currentIndex int, ctx TokenContext) (Token, in
 			case '0':
 				strValue += "\000"
 			default:
-				panic(ctx.Error(fmt.Sprintf("unknown escape character: \\%c", input[currentIndex])))
+				panic(ctx.Error(ErrorSyntax, fmt.Sprintf("unknown escape character: \\%c", input[currentIndex])))
 			}
 		} else {
 			strValue += string(input[currentIndex])
@@ -382,7 +382,7 @@ func GenerateString(input string, currentIndex int, ctx TokenContext) (Token, in
 	}
 
 	if currentIndex >= len(input) {
-		panic(ctx.Error("unterminated string literal"))
+		panic(ctx.Error(ErrorSyntax, "unterminated string literal"))
 	}
 
 	currentIndex++ // skip closing "
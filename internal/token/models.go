@@ -9,9 +9,33 @@ type TokenContext struct {
 	FileName  string
 }
 
-// Error formats an error message with file and line context
-func (ctx TokenContext) Error(message string) string {
-	return fmt.Sprintf("ERROR (%s:%d): %s", ctx.FileName, ctx.Line, message)
+// ErrorKind classifies the errors raised while lexing and parsing.
+type ErrorKind uint8
+
+const (
+	ErrorSyntax ErrorKind = iota // malformed literal, directive or operand
+	ErrorSymbol                  // undefined or duplicate label or macro
+	ErrorImport                  // a source file could not be read
+)
+
+// Error is the value the lexer and parser panic with. The rmm package turns
+// it into a CompileError at the API boundary.
+type Error struct {
+	Kind    ErrorKind
+	Context TokenContext
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Context.Line == 0 {
+		return fmt.Sprintf("ERROR: %s", e.Message)
+	}
+	return fmt.Sprintf("ERROR (%s:%d): %s", e.Context.FileName, e.Context.Line, e.Message)
+}
+
+// Error builds an error of the given kind located at ctx
+func (ctx TokenContext) Error(kind ErrorKind, message string) *Error {
+	return &Error{Kind: kind, Context: ctx, Message: message}
 }
 
 type TokenType uint8
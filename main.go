@@ -1,43 +1,350 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"vm/cli"
+	"vm/internal/dap"
+	"vm/internal/debugger"
 	"vm/internal/lexer"
+	"vm/internal/lsp"
 	"vm/internal/parser"
+	"vm/internal/repl"
+	"vm/rmm"
 )
 
 func main() {
 	args := cli.GetArgs()
-	lex := lexer.Init(args.FileName).Lex()
+	switch args.Command {
+	case cli.CommandExec:
+		execProgram(args)
+		return
+	case cli.CommandDisasm:
+		disasmProgram(args)
+		return
+	case cli.CommandDebug:
+		debugProgram(args)
+		return
+	case cli.CommandVerify:
+		verifyProgram(args)
+		return
+	case cli.CommandCheck:
+		checkProgram(args)
+		return
+	case cli.CommandGraph:
+		graphProgram(args)
+		return
+	case cli.CommandDAP:
+		if err := dap.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+			fail(err)
+		}
+		return
+	case cli.CommandREPL:
+		err := repl.New(os.Stdin, os.Stdout).Run()
+		var exitErr *rmm.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
+		if err != nil {
+			fail(err)
+		}
+		return
+	case cli.CommandLSP:
+		if err := lsp.NewServer(os.Stdin, os.Stdout).Serve(); err != nil {
+			fail(err)
+		}
+		return
+	}
 	if args.DebugMode {
+		lex := lexer.Init(args.FileName).Lex()
 		lex.Print()
+		parser.Init(lex).Print()
+	}
+	program, err := rmm.CompileFile(args.FileName)
+	if err != nil {
+		fail(err)
 	}
-	parsedTokens := parser.Init(lex)
+	program = optimize(program, args)
+
+	// Write the program before running it so the binary captures the
+	// initial heap rather than whatever the program left behind.
+	if err := program.WriteFile("program.bin"); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(cli.ExitCantCreate)
+	}
+	runProgram(program, args)
+}
+
+// execProgram runs a program.bin produced by a previous run without going
+// through the lexer and parser.
+func execProgram(args cli.Args) {
+	program, err := rmm.LoadProgram(args.FileName)
+	if err != nil {
+		fail(err)
+	}
+	runProgram(optimize(program, args), args)
+}
+
+// limits returns the machine limits the flags set.
+func limits(args cli.Args) rmm.Limits {
+	return rmm.Limits{
+		MaxStack:       args.MaxStack,
+		MaxReturnStack: args.MaxReturnStack,
+		MaxStrStack:    args.MaxStrStack,
+		MaxHeap:        args.MaxHeap,
+		MaxOpenFiles:   args.MaxOpenFiles,
+		MaxSteps:       args.MaxSteps,
+		Timeout:        args.Timeout,
+	}
+}
+
+// optimize optimizes the program at the -O level for the limits the flags
+// set.
+func optimize(program *rmm.Program, args cli.Args) *rmm.Program {
+	return program.OptimizeFor(args.OptLevel, limits(args))
+}
+
+func runProgram(program *rmm.Program, args cli.Args) {
 	if args.DebugMode {
-		parsedTokens.Print()
+		program.Print()
+	}
+	opts := rmm.Options{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Debug:  args.DebugMode,
+		Limits: limits(args),
+	}
+	traceDone := func() error { return nil }
+	if args.TraceFile != "" {
+		trace, err := traceOptions(program, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(cli.ExitUsage)
+		}
+		file, err := os.Create(args.TraceFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(cli.ExitCantCreate)
+		}
+		out := bufio.NewWriter(file)
+		trace.Output = out
+		opts.Trace = trace
+		// Flushed explicitly, since the exits below skip deferred calls.
+		traceDone = func() error {
+			if err := out.Flush(); err != nil {
+				file.Close()
+				return err
+			}
+			return file.Close()
+		}
+	}
+	if args.ProfileFile != "" {
+		opts.Profiler = rmm.NewProfiler()
+	}
+	if args.CoverFile != "" {
+		opts.Coverage = rmm.NewCoverage()
+	}
+	if args.Memcheck {
+		opts.Memcheck = rmm.NewMemcheck()
+	}
+	if args.GC {
+		opts.GC = &rmm.GCOptions{Threshold: args.GCThreshold, Compact: args.GCCompact}
+	}
+	machine := rmm.NewMachine(opts)
+	err := machine.Run(context.Background(), program)
+	if traceErr := traceDone(); traceErr != nil && err == nil {
+		err = traceErr
+	}
+	if opts.Profiler != nil {
+		if profileErr := opts.Profiler.WriteProfileFile(args.ProfileFile); profileErr != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", profileErr)
+			os.Exit(cli.ExitCantCreate)
+		}
+	}
+	if opts.Coverage != nil {
+		opts.Coverage.WriteReport(os.Stderr)
+		if coverErr := opts.Coverage.WriteLCOVFile(args.CoverFile); coverErr != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", coverErr)
+			os.Exit(cli.ExitCantCreate)
+		}
+	}
+	if opts.Memcheck != nil {
+		opts.Memcheck.WriteReport(os.Stderr)
+	}
+	if args.HeapStats {
+		fmt.Fprintln(os.Stderr, machine.HeapStats())
 	}
-	instructions, entrypoint := generateInstructions(parsedTokens)
 	if args.DebugMode {
-		instructions.Print()
-	}
-	// preprocess strings into Heap
-	strStack, heap := populateStringTable(parsedTokens)
-	loadedMachine := &Machine{
-		stack:           []Literal{},
-		instructions:    instructions,
-		heap:            heap,
-		allocations:     make(map[int]int),
-		input:           os.Stdin,
-		output:          os.Stdout,
-		fileDescriptors: make(map[int64]*os.File),
-		strStack:        strStack,
-		entrypoint:      entrypoint,
-	}
-
-	loadedMachine = runInstructions(loadedMachine)
-	if debugMode {
-		printStack(loadedMachine)
-	}
-	writeProgram(loadedMachine, "program.bin")
+		machine.PrintStack()
+	}
+	var exitErr *rmm.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.Code)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+// traceOptions turns the --trace-* flags into TraceOptions, resolving the
+// ends of --trace-range against the program's labels.
+func traceOptions(program *rmm.Program, args cli.Args) (*rmm.TraceOptions, error) {
+	trace := &rmm.TraceOptions{Top: args.TraceTop, Ops: args.TraceOps}
+	if args.TraceRange == "" {
+		return trace, nil
+	}
+	from, to, _ := strings.Cut(args.TraceRange, ":")
+	var err error
+	if trace.From, err = traceBound(program, from, 0); err != nil {
+		return nil, err
+	}
+	if trace.To, err = traceBound(program, to, program.Len()); err != nil {
+		return nil, err
+	}
+	return trace, nil
+}
+
+// traceBound resolves one end of --trace-range: a label, an instruction
+// index, or empty for the default.
+func traceBound(program *rmm.Program, bound string, empty int) (int, error) {
+	if bound == "" {
+		return empty, nil
+	}
+	if index, ok := program.Symbols()[bound]; ok {
+		return index, nil
+	}
+	index, err := strconv.Atoi(bound)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("--trace-range: %q is neither a label nor an instruction index", bound)
+	}
+	return index, nil
+}
+
+// fail reports err and exits with the status for its class of error.
+func fail(err error) {
+	var compileErr *rmm.CompileError
+	var runtimeErr *rmm.RuntimeError
+	switch {
+	case errors.As(err, &compileErr):
+		fmt.Fprintln(os.Stderr, err)
+		if compileErr.Kind == rmm.KindImport {
+			os.Exit(cli.ExitNoInput)
+		}
+		os.Exit(cli.ExitCompileError)
+	case errors.As(err, &runtimeErr):
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprint(os.Stderr, runtimeErr.StackTrace())
+		if runtimeErr.Kind == rmm.KindBudgetExceeded {
+			os.Exit(cli.ExitBudgetExceeded)
+		}
+		os.Exit(cli.ExitRuntimeError)
+	case errors.Is(err, rmm.ErrInvalidProgram):
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(cli.ExitCompileError)
+	case errors.Is(err, fs.ErrNotExist):
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(cli.ExitNoInput)
+	default:
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(cli.ExitFailure)
+	}
+}
+
+// loadProgram compiles the source file args names, or loads it if it is a
+// program.bin.
+func loadProgram(args cli.Args) (*rmm.Program, error) {
+	if strings.HasSuffix(args.FileName, ".bin") {
+		return rmm.LoadProgram(args.FileName)
+	}
+	return rmm.CompileFile(args.FileName)
+}
+
+// debugProgram runs a source file or program.bin under the interactive
+// debugger.
+func debugProgram(args cli.Args) {
+	program, err := loadProgram(args)
+	if err != nil {
+		fail(err)
+	}
+	if err := debugger.New(program, os.Stdin, os.Stdout).Run(os.Stdout, os.Stderr); err != nil {
+		fail(err)
+	}
+}
+
+// verifyProgram checks the data stack of a source file or program.bin, as
+// optimized at the given level, and exits with ExitFailure if it found
+// errors.
+func verifyProgram(args cli.Args) {
+	program, err := loadProgram(args)
+	if err != nil {
+		fail(err)
+	}
+	report := optimize(program, args).VerifyStack()
+	report.WriteReport(os.Stdout)
+	if len(report.Errors) > 0 {
+		os.Exit(cli.ExitFailure)
+	}
+}
+
+// checkProgram checks the types of a source file or program.bin, as
+// optimized at the given level, and exits with ExitFailure if it found
+// errors.
+func checkProgram(args cli.Args) {
+	program, err := loadProgram(args)
+	if err != nil {
+		fail(err)
+	}
+	report := optimize(program, args).CheckTypes()
+	report.WriteReport(os.Stdout)
+	if len(report.Errors) > 0 {
+		os.Exit(cli.ExitFailure)
+	}
+}
+
+// graphProgram writes the control-flow graph or the call graph of a source
+// file or program.bin, as optimized at the given level, to stdout.
+func graphProgram(args cli.Args) {
+	program, err := loadProgram(args)
+	if err != nil {
+		fail(err)
+	}
+	graph, err := optimize(program, args).ControlFlowGraph()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: cannot graph %s: %v\n", args.FileName, err)
+		os.Exit(cli.ExitFailure)
+	}
+	write := graph.WriteDOT
+	switch {
+	case args.GraphCalls && args.GraphJSON:
+		write = graph.WriteCallGraphJSON
+	case args.GraphCalls:
+		write = graph.WriteCallGraphDOT
+	case args.GraphJSON:
+		write = graph.WriteJSON
+	}
+	if err := write(os.Stdout); err != nil {
+		fail(err)
+	}
+}
+
+// disasmProgram prints a program.bin as .rmm source.
+func disasmProgram(args cli.Args) {
+	program, err := rmm.LoadProgram(args.FileName)
+	if err != nil {
+		fail(err)
+	}
+	source, err := program.Disassemble(filepath.Base(args.FileName))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: cannot disassemble %s: %v\n", args.FileName, err)
+		os.Exit(cli.ExitFailure)
+	}
+	fmt.Print(source)
 }
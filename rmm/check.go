@@ -0,0 +1,643 @@
+package rmm
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+)
+
+// The type checker infers the types each stack slot and register can hold
+// at every instruction, without running the program, and reports the
+// operations that would fault on every type they can be given: an add of
+// an int and a float, a deref of something that is never a pointer, a
+// conditional jump on a char. Types flow along the control-flow graph from
+// the entrypoint and are merged where paths meet; a slot that can hold
+// several types is only reported when none of them would do. Calls flow
+// into the function and back to every place it is called from, using the
+// function's stack effect, as VerifyStack finds it, to line the callee's
+// results up with the caller's stack. Heap cells are not tracked, so
+// deref gives a value of any type.
+
+// typeSet is a set of LiteralTypes, one bit each.
+type typeSet uint8
+
+const (
+	typeNone    = typeSet(1 << LiteralNone)
+	typeInt     = typeSet(1 << LiteralInt)
+	typeFloat   = typeSet(1 << LiteralFloat)
+	typeChar    = typeSet(1 << LiteralChar)
+	typeString  = typeSet(1 << LiteralString)
+	typeNull    = typeSet(1 << LiteralNull)
+	typePointer = typeSet(1 << LiteralPointer)
+	typeAny     = typeNone | typeInt | typeFloat | typeChar | typeString | typeNull | typePointer
+)
+
+func typeOf(l Literal) typeSet {
+	return typeSet(1 << l.Type())
+}
+
+// typeNames are the types in the order messages list them.
+var typeNames = []struct {
+	types typeSet
+	name  string
+}{
+	{typeInt, "an int"},
+	{typeFloat, "a float"},
+	{typeChar, "a char"},
+	{typeString, "a string"},
+	{typePointer, "a pointer"},
+	{typeNull, "NULL"},
+	{typeNone, "an unset register"},
+}
+
+func (t typeSet) String() string {
+	if t == typeAny {
+		return "a value of any type"
+	}
+	var names []string
+	for _, n := range typeNames {
+		if t&n.types != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "nothing"
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// Where a slot's value came from, when it is not an instruction index.
+const (
+	fromUnknown = -1 // on entry, or below what the checker knows of the stack
+	fromSeveral = -2 // different instructions on different paths
+)
+
+// typeSlot is what the checker knows of a stack slot or register: the
+// types it can hold and the instruction that produced the value.
+type typeSlot struct {
+	types typeSet
+	from  int
+}
+
+func (s typeSlot) join(o typeSlot) typeSlot {
+	if s.from != o.from {
+		s.from = fromSeveral
+	}
+	s.types |= o.types
+	return s
+}
+
+var anySlot = typeSlot{types: typeAny, from: fromUnknown}
+
+// typeState is what the checker knows before an instruction runs. stack
+// holds the top of the stack, bottom first; when open is set there may be
+// values of any type below it, as in a function, which does not know its
+// callers' stacks, or where paths with different depths meet.
+type typeState struct {
+	stack     []typeSlot
+	open      bool
+	registers [MaxRegisters]typeSlot
+}
+
+func (s *typeState) clone() *typeState {
+	c := *s
+	c.stack = append([]typeSlot(nil), s.stack...)
+	return &c
+}
+
+func (s *typeState) push(slot typeSlot) {
+	s.stack = append(s.stack, slot)
+}
+
+// pop takes the top slot; past what the checker knows, it is any value.
+func (s *typeState) pop() typeSlot {
+	if len(s.stack) == 0 {
+		return anySlot
+	}
+	slot := s.stack[len(s.stack)-1]
+	s.stack = s.stack[:len(s.stack)-1]
+	return slot
+}
+
+// join merges o into s, lining the stacks up at the top, and reports
+// whether s changed.
+func (s *typeState) join(o *typeState) bool {
+	changed := false
+	if len(o.stack) != len(s.stack) {
+		n := min(len(s.stack), len(o.stack))
+		changed = len(s.stack) > n || !s.open
+		s.stack = s.stack[len(s.stack)-n:]
+		o = &typeState{stack: o.stack[len(o.stack)-n:], open: true, registers: o.registers}
+	}
+	if o.open && !s.open {
+		s.open = true
+		changed = true
+	}
+	for i := range s.stack {
+		if joined := s.stack[i].join(o.stack[i]); joined != s.stack[i] {
+			s.stack[i] = joined
+			changed = true
+		}
+	}
+	for i := range s.registers {
+		if joined := s.registers[i].join(o.registers[i]); joined != s.registers[i] {
+			s.registers[i] = joined
+			changed = true
+		}
+	}
+	return changed
+}
+
+// binaryRule is a pair of operand types an operator takes, b pushed first,
+// and the type it gives.
+type binaryRule struct {
+	b, a, result typeSet
+}
+
+var (
+	arithmeticRules = []binaryRule{{typeInt, typeInt, typeInt}, {typeFloat, typeFloat, typeFloat}}
+	pointerRules    = append(arithmeticRules, binaryRule{typePointer, typeInt, typePointer}, binaryRule{typeInt, typePointer, typePointer})
+	orderRules      = []binaryRule{{typeInt, typeInt, typeInt}, {typeFloat, typeFloat, typeInt}, {typePointer, typePointer, typeInt}}
+)
+
+// binaryOperators holds the operand types of the arithmetic and ordering
+// instructions, with how messages describe them.
+var binaryOperators = map[InstructionSet]struct {
+	rules []binaryRule
+	needs string
+}{
+	InstructionAdd:   {pointerRules, "two ints, two floats, or a pointer and an int"},
+	InstructionSub:   {pointerRules, "two ints, two floats, or a pointer and an int"},
+	InstructionMul:   {arithmeticRules, "two ints or two floats"},
+	InstructionDiv:   {arithmeticRules, "two ints or two floats"},
+	InstructionMod:   {arithmeticRules, "two ints or two floats"},
+	InstructionCmpg:  {orderRules, "two ints, two floats or two pointers"},
+	InstructionCmpl:  {orderRules, "two ints, two floats or two pointers"},
+	InstructionCmpge: {orderRules, "two ints, two floats or two pointers"},
+	InstructionCmple: {orderRules, "two ints, two floats or two pointers"},
+}
+
+// TypeReport is the result of CheckTypes.
+type TypeReport struct {
+	// Errors lists the type errors found, in program order.
+	Errors []*TypeError
+}
+
+// TypeError is an instruction that faults on every type its operands can
+// hold. The message names the types and where they came from.
+type TypeError struct {
+	File    string
+	Line    int
+	IP      int
+	Message string
+}
+
+func (e *TypeError) Error() string {
+	return errorText(e.File, e.Line, e.Message)
+}
+
+// WriteReport writes the errors, one per line.
+func (r *TypeReport) WriteReport(w io.Writer) error {
+	var sb strings.Builder
+	for _, err := range r.Errors {
+		fmt.Fprintf(&sb, "%s:%d: %s\n", err.File, err.Line, err.Message)
+	}
+	switch len(r.Errors) {
+	case 0:
+		sb.WriteString("types: ok\n")
+	case 1:
+		sb.WriteString("types: 1 error\n")
+	default:
+		fmt.Fprintf(&sb, "types: %d errors\n", len(r.Errors))
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// CheckTypes infers the types of the stack slots and registers at each
+// instruction and reports the instructions that would fault on all of
+// them. Stack underflows are left to VerifyStack.
+func (p *Program) CheckTypes() *TypeReport {
+	c := newTypeChecker(p)
+	c.infer()
+	// Run each instruction once more over what is known before it, only
+	// to report; passing the results on would change the states being
+	// read.
+	c.report = true
+	for ip, state := range c.states {
+		if state != nil {
+			c.apply(ip, p.instructions[ip], state.clone())
+		}
+	}
+	return &TypeReport{Errors: c.errors}
+}
+
+type typeChecker struct {
+	program *Program
+	stacks  *stackVerifier
+	// states holds what is known before each instruction, nil where no
+	// path reaches.
+	states []*typeState
+	// returns holds what is known at the rets of each function, merged.
+	returns map[int]*typeState
+	// callers lists the calls to each function; owners lists the
+	// functions each ret returns from.
+	callers map[int][]int
+	owners  map[int][]int
+	work    []int
+	queued  []bool
+	report  bool // whether step reports errors
+	errors  []*TypeError
+}
+
+func newTypeChecker(p *Program) *typeChecker {
+	c := &typeChecker{
+		program: p,
+		stacks:  newStackVerifier(p),
+		states:  make([]*typeState, len(p.instructions)),
+		returns: make(map[int]*typeState),
+		callers: make(map[int][]int),
+		owners:  make(map[int][]int),
+		queued:  make([]bool, len(p.instructions)),
+	}
+	for ip := range p.instructions {
+		if target, ok := c.stacks.target(ip); ok && p.instructions[ip].instructionType == InstructionCall {
+			c.callers[target] = append(c.callers[target], ip)
+		}
+	}
+	for _, entry := range c.stacks.entries {
+		for _, ret := range c.functionRets(entry) {
+			c.owners[ret] = append(c.owners[ret], entry)
+		}
+	}
+	return c
+}
+
+// functionRets returns the rets reachable from a function's entry without
+// going through another function.
+func (c *typeChecker) functionRets(entry int) []int {
+	var rets []int
+	for ip, reached := range functionBody(c.program, entry) {
+		if reached && c.program.instructions[ip].instructionType == InstructionRet {
+			rets = append(rets, ip)
+		}
+	}
+	return rets
+}
+
+// infer runs the instructions over types until what is known before each
+// one stops changing.
+func (c *typeChecker) infer() {
+	if c.program.entrypoint < 0 || c.program.entrypoint >= len(c.program.instructions) {
+		return
+	}
+	entry := &typeState{}
+	for i := range entry.registers {
+		entry.registers[i] = typeSlot{types: typeNone, from: fromUnknown}
+	}
+	c.flow(c.program.entrypoint, entry)
+	for len(c.work) > 0 {
+		ip := c.work[0]
+		c.work = c.work[1:]
+		c.queued[ip] = false
+		c.step(ip, c.states[ip].clone())
+	}
+}
+
+// flow merges state into what is known before the instruction at ip and
+// queues it if that changed.
+func (c *typeChecker) flow(ip int, state *typeState) {
+	if ip < 0 || ip >= len(c.states) {
+		return
+	}
+	if c.states[ip] == nil {
+		c.states[ip] = state.clone()
+	} else if !c.states[ip].join(state) {
+		return
+	}
+	c.enqueue(ip)
+}
+
+func (c *typeChecker) enqueue(ip int) {
+	if !c.queued[ip] {
+		c.queued[ip] = true
+		c.work = append(c.work, ip)
+	}
+}
+
+// step runs the instruction at ip over s and passes the result on.
+func (c *typeChecker) step(ip int, s *typeState) {
+	instr := c.program.instructions[ip]
+	switch instr.instructionType {
+	case InstructionCall:
+		c.call(ip, s)
+		return
+	case InstructionRet:
+		for _, entry := range c.owners[ip] {
+			if c.returns[entry] == nil {
+				c.returns[entry] = s.clone()
+			} else if !c.returns[entry].join(s) {
+				continue
+			}
+			for _, call := range c.callers[entry] {
+				if c.states[call] != nil {
+					c.enqueue(call)
+				}
+			}
+		}
+		return
+	}
+	if !c.apply(ip, instr, s) {
+		return
+	}
+	for _, next := range successors(c.program, ip) {
+		c.flow(next, s)
+	}
+}
+
+// call passes s into the function the call at ip goes to and, once the
+// function is known to return, what it returns to the next instruction.
+func (c *typeChecker) call(ip int, s *typeState) {
+	target, ok := c.stacks.target(ip)
+	if !ok {
+		return
+	}
+	c.flow(target, s)
+	ret := c.returns[target]
+	if ret == nil {
+		return
+	}
+	after := ret.clone()
+	summary := c.stacks.functions[target]
+	if summary.returns && !c.stacks.unsettled[target] && len(s.stack) >= summary.effect.Pops {
+		// The caller's stack below what the function takes, then what it
+		// leaves.
+		pushes := summary.effect.Pushes
+		results := make([]typeSlot, pushes)
+		for i := range results {
+			if k := len(ret.stack) - pushes + i; k >= 0 {
+				results[i] = ret.stack[k]
+			} else {
+				results[i] = anySlot
+			}
+		}
+		after.stack = append(append([]typeSlot(nil), s.stack[:len(s.stack)-summary.effect.Pops]...), results...)
+		after.open = s.open
+	} else {
+		after.open = true
+	}
+	c.flow(ip+1, after)
+}
+
+// apply runs the instruction over s in place and reports whether it can
+// complete; an instruction that faults on every type it gets does not.
+func (c *typeChecker) apply(ip int, instr Instruction, s *typeState) bool {
+	result := typeSlot{from: ip}
+	switch t := instr.instructionType; t {
+	case InstructionPush, InstructionGetStr:
+		result.types = typeOf(instr.value)
+		if t == InstructionGetStr {
+			result.types = typePointer
+		}
+		s.push(result)
+	case InstructionPushPtr:
+		result.types = typePointer
+		if instr.value.Type() == LiteralNull {
+			result.types = typeNull
+		}
+		s.push(result)
+	case InstructionPop, InstructionPrint:
+		s.pop()
+	case InstructionDup:
+		top := s.pop()
+		s.push(top)
+		s.push(top)
+	case InstructionSwap:
+		a, b := s.pop(), s.pop()
+		s.push(a)
+		s.push(b)
+	case InstructionInDup:
+		if slot, ok := s.at(instr.value.Int()); ok {
+			s.push(*slot)
+		} else {
+			s.push(anySlot)
+		}
+	case InstructionInSwap:
+		top := s.pop()
+		if slot, ok := s.at(instr.value.Int()); ok {
+			top, *slot = *slot, top
+		} else {
+			top = anySlot
+		}
+		s.push(top)
+	case InstructionAdd, InstructionSub, InstructionMul, InstructionDiv, InstructionMod,
+		InstructionCmpg, InstructionCmpl, InstructionCmpge, InstructionCmple:
+		a, b := s.pop(), s.pop()
+		op := binaryOperators[t]
+		for _, rule := range op.rules {
+			if b.types&rule.b != 0 && a.types&rule.a != 0 {
+				result.types |= rule.result
+			}
+		}
+		if result.types == 0 {
+			c.errorf(ip, instr, "%s needs %s, but gets %s and %s",
+				opText(instr), op.needs, describeSlot(c.program, b), describeSlot(c.program, a))
+			return false
+		}
+		s.push(result)
+	case InstructionCmpe, InstructionCmpne:
+		s.pop()
+		s.pop()
+		result.types = typeInt
+		s.push(result)
+	case InstructionZjmp, InstructionNzjmp:
+		if !c.need(ip, instr, s.pop(), typeInt, "an int condition") {
+			return false
+		}
+	case InstructionCmpeZjmp, InstructionCmpeNzjmp:
+		s.pop()
+		s.pop()
+	case InstructionCastIntToFloat:
+		if !c.need(ip, instr, s.pop(), typeInt, "an int") {
+			return false
+		}
+		result.types = typeFloat
+		s.push(result)
+	case InstructionCastFloatToInt:
+		if !c.need(ip, instr, s.pop(), typeFloat, "a float") {
+			return false
+		}
+		result.types = typeInt
+		s.push(result)
+	case InstructionRef:
+		s.pop()
+		result.types = typePointer
+		s.push(result)
+	case InstructionDeref:
+		if !c.need(ip, instr, s.pop(), typePointer, "a pointer") {
+			return false
+		}
+		result.types = typeAny
+		s.push(result)
+	case InstructionMovStr:
+		if !c.need(ip, instr, s.pop(), typeChar|typeInt|typePointer, "a char, an int or a pointer") {
+			return false
+		}
+	case InstructionIndex:
+		if instr.value.Type() != LiteralChar {
+			s.pop()
+		}
+		s.pop()
+		if !c.need(ip, instr, s.pop(), typePointer, "a pointer under the index") {
+			return false
+		}
+		result.types = typePointer
+		s.push(result)
+	case InstructionMov:
+		if instr.registerIndex >= 0 && instr.registerIndex < MaxRegisters {
+			s.registers[instr.registerIndex] = typeSlot{types: typeOf(instr.value), from: ip}
+		}
+	case InstructionMovTop:
+		value := s.pop()
+		if r := instr.value.Int(); r >= 0 && r < MaxRegisters {
+			s.registers[r] = value
+		}
+	case InstructionPushReg:
+		s.push(c.readRegister(ip, instr.registerIndex, s))
+	case InstructionNative:
+		native, ok := nativeSignatures[instr.value.Int()]
+		if !ok || instr.value.Type() != LiteralInt {
+			return false
+		}
+		for i := len(native.Params) - 1; i >= 0; i-- {
+			param := native.Params[i]
+			if !c.need(ip, instr, s.pop(), param.types, fmt.Sprintf("%s to be %s", param.Name, param.types)) {
+				return false
+			}
+		}
+		if native.result != 0 {
+			result.types = native.result
+			s.push(result)
+		}
+	case InstructionAddI:
+		parts := instr.parts()
+		b := s.pop()
+		result.types = 0
+		for _, rule := range pointerRules {
+			if b.types&rule.b != 0 && rule.a == typeInt {
+				result.types |= rule.result
+			}
+		}
+		if result.types == 0 {
+			c.errorf(ip, parts[1], "add needs %s, but gets %s and %s pushed at line %d",
+				binaryOperators[InstructionAdd].needs, describeSlot(c.program, b), typeInt, parts[0].line)
+			return false
+		}
+		s.push(result)
+	case InstructionPushRegDeref:
+		parts := instr.parts()
+		if instr.registerIndex < 0 || instr.registerIndex >= MaxRegisters {
+			c.errorf(ip, parts[0], "invalid register index r%d", instr.registerIndex)
+			return false
+		}
+		value := s.registers[instr.registerIndex]
+		if value.types&typePointer == 0 {
+			got := describeSlot(c.program, value)
+			if value.from == fromUnknown {
+				got = fmt.Sprintf("%s read from r%d at line %d", value.types, instr.registerIndex, parts[0].line)
+			}
+			c.errorf(ip, parts[1], "deref needs a pointer, but gets %s", got)
+			return false
+		}
+		result.types = typeAny
+		s.push(result)
+	}
+	return true
+}
+
+// at returns the slot at an index from the bottom of the stack, as indup
+// and inswap count, if the checker knows it.
+func (s *typeState) at(index int64) (*typeSlot, bool) {
+	if s.open || index < 0 || index >= int64(len(s.stack)) {
+		return nil, false
+	}
+	return &s.stack[index], true
+}
+
+// readRegister returns what register r holds, marking a value that was
+// never set as read at ip.
+func (c *typeChecker) readRegister(ip, r int, s *typeState) typeSlot {
+	if r < 0 || r >= MaxRegisters {
+		return anySlot
+	}
+	slot := s.registers[r]
+	if slot.from == fromUnknown {
+		slot.from = ip
+	}
+	return slot
+}
+
+// need checks that slot can hold one of types, describing what the
+// instruction needs in a message if not.
+func (c *typeChecker) need(ip int, at Instruction, slot typeSlot, types typeSet, needs string) bool {
+	if slot.types&types != 0 {
+		return true
+	}
+	c.errorf(ip, at, "%s needs %s, but gets %s", opText(at), needs, describeSlot(c.program, slot))
+	return false
+}
+
+func (c *typeChecker) errorf(ip int, at Instruction, format string, a ...any) {
+	if !c.report {
+		return
+	}
+	c.errors = append(c.errors, &TypeError{
+		File:    at.fileName,
+		Line:    at.line,
+		IP:      ip,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// opText names an instruction in messages.
+func opText(instr Instruction) string {
+	name := strings.ToLower(instr.instructionType.String())
+	if instr.instructionType == InstructionNative {
+		if native, ok := nativeSignatures[instr.value.Int()]; ok {
+			name += " " + native.Name
+		}
+	}
+	return name
+}
+
+// describeSlot describes the types a slot holds and where its value came
+// from.
+func describeSlot(p *Program, slot typeSlot) string {
+	switch slot.from {
+	case fromUnknown:
+		return slot.types.String()
+	case fromSeveral:
+		if bits.OnesCount8(uint8(slot.types)) > 1 {
+			return slot.types.String() + ", depending on the path"
+		}
+		return slot.types.String() + " from several places"
+	}
+	instr := p.instructions[slot.from]
+	if parts := instr.parts(); len(parts) > 1 {
+		// Only the last part of a superinstruction leaves a value.
+		instr = parts[1]
+	}
+	switch instr.instructionType {
+	case InstructionPush, InstructionPushPtr, InstructionGetStr:
+		return fmt.Sprintf("%s pushed at line %d", slot.types, instr.line)
+	case InstructionMov:
+		return fmt.Sprintf("%s stored in r%d at line %d", slot.types, instr.registerIndex, instr.line)
+	case InstructionPushReg:
+		return fmt.Sprintf("%s read from r%d at line %d", slot.types, instr.registerIndex, instr.line)
+	}
+	return fmt.Sprintf("%s from %s at line %d", slot.types, opText(instr), instr.line)
+}
@@ -0,0 +1,131 @@
+package rmm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCheckTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		errors []string // "line: message"
+	}{
+		{
+			name:   "ints",
+			source: "push 1\npush 2\nadd\nprint\n",
+		},
+		{
+			name:   "int and float",
+			source: "push 1\npush 2.5\nadd\nprint\n",
+			errors: []string{"3: add needs two ints, two floats, or a pointer and an int, but gets an int pushed at line 1 and a float pushed at line 2"},
+		},
+		{
+			name:   "pointer arithmetic",
+			source: "push 8\nnative 4\npush 1\nadd\nderef\nprint\n",
+		},
+		{
+			name:   "float condition",
+			source: "push 1.5\nzjmp end\nend:\n",
+			errors: []string{"2: zjmp needs an int condition, but gets a float pushed at line 1"},
+		},
+		{
+			name:   "unset register",
+			source: "mov r0 1\npush r1\nderef\n",
+			errors: []string{"3: deref needs a pointer, but gets an unset register read from r1 at line 2"},
+		},
+		{
+			name:   "register",
+			source: "mov r0 1.5\npush r0\nftoi\nitof\nftoi\nprint\n",
+		},
+		{
+			name:   "native arguments",
+			source: "push 1\npush 2\nnative 1\n",
+			errors: []string{"3: native write needs string to be a pointer, but gets an int pushed at line 1"},
+		},
+		{
+			name:   "types from either path",
+			source: "push 0\nzjmp float\npush 1\njmp done\nfloat:\npush 1.5\ndone:\npush 1\nadd\nprint\n",
+		},
+		{
+			name:   "wrong on every path",
+			source: "push 0\nzjmp float\npush 'a'\njmp done\nfloat:\npush 1.5\ndone:\nitof\n",
+			errors: []string{"8: itof needs an int, but gets a float or a char, depending on the path"},
+		},
+		{
+			name:   "three depths meet",
+			source: "push 0\nzjmp two\npush 0\nzjmp one\npush 0\npop\npush 0\npop\njmp meet\ntwo:\npush 1.5\npush 1.5\njmp meet\none:\npush 1.5\njmp meet\nmeet:\npush 1\npop\nitof\n",
+		},
+		{
+			name:   "call results",
+			source: "entrypoint main\nhalf:\nitof\npush 2.0\ndiv\nret\nmain:\npush 3\ncall half\npush 1\nadd\n",
+			errors: []string{"11: add needs two ints, two floats, or a pointer and an int, but gets a float from div at line 5 and an int pushed at line 10"},
+		},
+		{
+			name:   "below a call",
+			source: "entrypoint main\nf:\npop\nret\nmain:\npush 1.5\npush 2\ncall f\nftoi\nprint\n",
+		},
+		{
+			name:   "indup",
+			source: "push 1.5\npush 2\nindup 0\nitof\n",
+			errors: []string{"4: itof needs an int, but gets a float pushed at line 1"},
+		},
+		{
+			name:   "superinstructions",
+			source: "mov r0 1.5\npush r0\npush 1\nadd\nprint\n",
+			errors: []string{"4: add needs two ints, two floats, or a pointer and an int, but gets a float stored in r0 at line 1 and an int pushed at line 3"},
+		},
+	}
+	for _, tt := range tests {
+		program, err := Compile(tt.source)
+		if err != nil {
+			t.Fatalf("%s: failed to compile: %v", tt.name, err)
+		}
+		for _, level := range []int{0, 2} {
+			var got []string
+			for _, err := range program.Optimize(level).CheckTypes().Errors {
+				got = append(got, fmt.Sprintf("%d: %s", err.Line, err.Message))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.errors, "\n") {
+				t.Errorf("%s at -O%d: expected errors %q, got %q", tt.name, level, tt.errors, got)
+			}
+		}
+	}
+}
+
+func TestCheckBadRegister(t *testing.T) {
+	program, err := Compile("push 8\nnative 4\nmov r0 top\npush r0\nderef\nprint\n")
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	optimized := program.Optimize(2)
+	for i, instr := range optimized.instructions {
+		if instr.instructionType == InstructionPushRegDeref {
+			// A loaded program.bin can carry any register byte.
+			optimized.instructions[i].registerIndex = 32
+		}
+	}
+	var got []string
+	for _, err := range optimized.CheckTypes().Errors {
+		got = append(got, fmt.Sprintf("%d: %s", err.Line, err.Message))
+	}
+	if want := []string{"4: invalid register index r32"}; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected errors %q, got %q", want, got)
+	}
+}
+
+// TestNativeSignatures checks that every native has a signature, and
+// every signature a native.
+func TestNativeSignatures(t *testing.T) {
+	for id := range natives {
+		if _, ok := nativeSignatures[id]; !ok {
+			t.Errorf("native %d has no signature", id)
+		}
+	}
+	for id, native := range nativeSignatures {
+		if _, ok := natives[id]; !ok {
+			t.Errorf("native %d (%s) is not implemented", id, native.Name)
+		}
+	}
+}
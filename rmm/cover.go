@@ -0,0 +1,319 @@
+package rmm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Coverage records which instructions ran and which way each conditional
+// jump went. Attach one to a machine through Options.Coverage; it
+// accumulates over every run of that machine, so use one per program.
+type Coverage struct {
+	instructions []Instruction
+	symbols      map[string]int
+	entrypoint   int
+	runs         int64
+	counts       []int64    // executions per instruction
+	branches     [][2]int64 // per zjmp/nzjmp: times jumped, times fallen through
+}
+
+// NewCoverage returns an empty coverage record.
+func NewCoverage() *Coverage {
+	return &Coverage{}
+}
+
+// start begins recording a run of m's program.
+func (c *Coverage) start(m *Machine) {
+	c.instructions = m.instructions
+	c.symbols = m.symbols
+	c.entrypoint = m.entrypoint
+	c.runs++
+	if n := len(m.instructions); n > len(c.counts) {
+		c.counts = append(c.counts, make([]int64, n-len(c.counts))...)
+		c.branches = append(c.branches, make([][2]int64, n-len(c.branches))...)
+	}
+}
+
+// count records that the instruction at ip is about to run.
+func (c *Coverage) count(ip int) {
+	c.counts[ip]++
+}
+
+// branch records which way the conditional jump at ip went.
+func (c *Coverage) branch(ip int, jumped bool) {
+	if jumped {
+		c.branches[ip][0]++
+	} else {
+		c.branches[ip][1]++
+	}
+}
+
+func isConditionalJump(instructionType InstructionSet) bool {
+	switch instructionType {
+	case InstructionZjmp, InstructionNzjmp, InstructionCmpeZjmp, InstructionCmpeNzjmp:
+		return true
+	}
+	return false
+}
+
+// branchPart returns the conditional jump itself, which for a fused
+// compare-and-jump is its second part.
+func branchPart(instr Instruction) Instruction {
+	parts := instr.parts()
+	return parts[len(parts)-1]
+}
+
+// FileCoverage summarises the coverage of one source file. A line counts
+// as covered when any of its instructions ran, and a branch when the jump
+// went that way at least once; each conditional jump has two branches.
+type FileCoverage struct {
+	File                string
+	Lines, LinesHit     int
+	Instructions, Hit   int
+	Branches, BranchHit int
+	Missed              []int // lines none of whose instructions ran
+	// Partial lists the conditional jumps that only ever went one way.
+	Partial []BranchCoverage
+}
+
+// BranchCoverage is how often a conditional jump went each way.
+type BranchCoverage struct {
+	IP, Line      int
+	Op            string // "zjmp" or "nzjmp"
+	Jumped, Falls int64
+}
+
+// lineCount is the executions of a line's most executed instruction, as
+// LCOV reports it.
+type lineCount struct {
+	line  int
+	count int64
+}
+
+// Files returns the coverage of every source file the program has
+// instructions from, sorted by file name.
+func (c *Coverage) Files() []FileCoverage {
+	var files []FileCoverage
+	for _, file := range c.fileNames() {
+		fc := FileCoverage{File: file}
+		for _, lc := range c.lines(file) {
+			fc.Lines++
+			if lc.count > 0 {
+				fc.LinesHit++
+			} else {
+				fc.Missed = append(fc.Missed, lc.line)
+			}
+		}
+		for ip, instr := range c.instructions {
+			if instr.fileName != file {
+				continue
+			}
+			fc.Instructions++
+			if c.counts[ip] > 0 {
+				fc.Hit++
+			}
+			if !isConditionalJump(instr.instructionType) {
+				continue
+			}
+			taken := c.branches[ip]
+			fc.Branches += 2
+			for _, n := range taken {
+				if n > 0 {
+					fc.BranchHit++
+				}
+			}
+			if (taken[0] > 0) != (taken[1] > 0) {
+				jump := branchPart(instr)
+				fc.Partial = append(fc.Partial, BranchCoverage{
+					IP:     ip,
+					Line:   jump.line,
+					Op:     strings.ToLower(jump.instructionType.String()),
+					Jumped: taken[0],
+					Falls:  taken[1],
+				})
+			}
+		}
+		files = append(files, fc)
+	}
+	return files
+}
+
+func (c *Coverage) fileNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, instr := range c.instructions {
+		if !seen[instr.fileName] {
+			seen[instr.fileName] = true
+			names = append(names, instr.fileName)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lines returns the lines of file that have instructions, in order.
+func (c *Coverage) lines(file string) []lineCount {
+	counts := make(map[int]int64)
+	for ip, instr := range c.instructions {
+		for _, part := range instr.parts() {
+			if part.fileName == file {
+				counts[part.line] = max(counts[part.line], c.counts[ip])
+			}
+		}
+	}
+	lines := make([]lineCount, 0, len(counts))
+	for _, line := range sortedKeys(counts) {
+		lines = append(lines, lineCount{line, counts[line]})
+	}
+	return lines
+}
+
+// WriteReport writes a summary per file, followed by the lines that never
+// ran and the conditional jumps that only went one way.
+func (c *Coverage) WriteReport(w io.Writer) error {
+	var sb strings.Builder
+	for _, fc := range c.Files() {
+		fmt.Fprintf(&sb, "%s: %s of lines (%d/%d), %s of instructions (%d/%d), %s of branches (%d/%d)\n",
+			fc.File, percent(fc.LinesHit, fc.Lines), fc.LinesHit, fc.Lines,
+			percent(fc.Hit, fc.Instructions), fc.Hit, fc.Instructions,
+			percent(fc.BranchHit, fc.Branches), fc.BranchHit, fc.Branches)
+		if len(fc.Missed) > 0 {
+			fmt.Fprintf(&sb, "  not run: %s\n", lineRanges(fc.Missed))
+		}
+		for _, b := range fc.Partial {
+			way := "always jumped"
+			if b.Jumped == 0 {
+				way = "never jumped"
+			}
+			fmt.Fprintf(&sb, "  line %d: %s %s\n", b.Line, b.Op, way)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "100.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+// lineRanges formats sorted line numbers, collapsing runs: "3, 7-9".
+func lineRanges(lines []int) string {
+	var parts []string
+	for i := 0; i < len(lines); {
+		j := i
+		for j+1 < len(lines) && lines[j+1] == lines[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, fmt.Sprint(lines[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", lines[i], lines[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
+
+// WriteLCOV writes the coverage as an LCOV tracefile, one record per
+// source file. Functions are the targets of calls plus the entrypoint, and
+// are counted by how often they were called (or run, for the entrypoint).
+// Each conditional jump is a block of two branches, jumped and fell
+// through, numbered by its instruction index.
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	labels := labelsByIndex(c.symbols)
+	calls := c.functionCalls()
+	var sb strings.Builder
+	for _, file := range c.fileNames() {
+		sb.WriteString("TN:\n")
+		fmt.Fprintf(&sb, "SF:%s\n", file)
+
+		found, hit := 0, 0
+		for _, start := range sortedKeys(calls) {
+			if c.instructions[start].fileName != file {
+				continue
+			}
+			fmt.Fprintf(&sb, "FN:%d,%s\n", c.instructions[start].line, functionName(labels, start))
+		}
+		for _, start := range sortedKeys(calls) {
+			if c.instructions[start].fileName != file {
+				continue
+			}
+			fmt.Fprintf(&sb, "FNDA:%d,%s\n", calls[start], functionName(labels, start))
+			found++
+			if calls[start] > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(&sb, "FNF:%d\nFNH:%d\n", found, hit)
+
+		found, hit = 0, 0
+		for ip, instr := range c.instructions {
+			if instr.fileName != file || !isConditionalJump(instr.instructionType) {
+				continue
+			}
+			for way, n := range c.branches[ip] {
+				taken := "-"
+				if c.counts[ip] > 0 {
+					taken = fmt.Sprint(n)
+				}
+				fmt.Fprintf(&sb, "BRDA:%d,%d,%d,%s\n", branchPart(instr).line, ip, way, taken)
+				found++
+				if n > 0 {
+					hit++
+				}
+			}
+		}
+		fmt.Fprintf(&sb, "BRF:%d\nBRH:%d\n", found, hit)
+
+		found, hit = 0, 0
+		for _, lc := range c.lines(file) {
+			fmt.Fprintf(&sb, "DA:%d,%d\n", lc.line, lc.count)
+			found++
+			if lc.count > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(&sb, "LF:%d\nLH:%d\n", found, hit)
+		sb.WriteString("end_of_record\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// functionCalls returns how often each function was entered, by its first
+// instruction.
+func (c *Coverage) functionCalls() map[int]int64 {
+	calls := make(map[int]int64)
+	if c.entrypoint < len(c.instructions) {
+		calls[c.entrypoint] = c.runs
+	}
+	for ip, instr := range c.instructions {
+		if instr.instructionType != InstructionCall {
+			continue
+		}
+		target := int(instr.value.Int())
+		if target >= 0 && target < len(c.instructions) {
+			calls[target] += c.counts[ip]
+		}
+	}
+	return calls
+}
+
+// WriteLCOVFile writes the LCOV tracefile to filePath.
+func (c *Coverage) WriteLCOVFile(filePath string) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if err := c.WriteLCOV(f); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write coverage %s: %w", filePath, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,91 @@
+package rmm
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const coverSource = `entrypoint main
+abs:
+	dup
+	push 0
+	cmpl
+	zjmp done
+	push -1
+	mul
+done:
+	ret
+unused:
+	push 1
+	ret
+main:
+	push 5
+	call abs
+	push 7
+	call abs
+	add
+	print
+`
+
+func runCoverage(t *testing.T) *Coverage {
+	t.Helper()
+	program, err := Compile(coverSource)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	coverage := NewCoverage()
+	machine := NewMachine(Options{Coverage: coverage})
+	if err := machine.Run(context.Background(), program); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	return coverage
+}
+
+func TestCoverageFiles(t *testing.T) {
+	files := runCoverage(t).Files()
+	want := []FileCoverage{{
+		File:         "main.rmm",
+		Lines:        15,
+		LinesHit:     11,
+		Instructions: 15,
+		Hit:          11,
+		Branches:     2,
+		BranchHit:    1,
+		Missed:       []int{7, 8, 12, 13},
+		Partial:      []BranchCoverage{{IP: 3, Line: 6, Op: "zjmp", Jumped: 2}},
+	}}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("coverage %+v, want %+v", files, want)
+	}
+
+	var report bytes.Buffer
+	runCoverage(t).WriteReport(&report)
+	wantReport := `main.rmm: 73.3% of lines (11/15), 73.3% of instructions (11/15), 50.0% of branches (1/2)
+  not run: 7-8, 12-13
+  line 6: zjmp always jumped
+`
+	if report.String() != wantReport {
+		t.Errorf("report:\n%s\nwant:\n%s", report.String(), wantReport)
+	}
+}
+
+func TestCoverageLCOV(t *testing.T) {
+	var lcov bytes.Buffer
+	if err := runCoverage(t).WriteLCOV(&lcov); err != nil {
+		t.Fatalf("failed to write LCOV: %v", err)
+	}
+	out := lcov.String()
+	for _, want := range []string{
+		"TN:\nSF:main.rmm\nFN:3,abs\nFN:15,main\nFNDA:2,abs\nFNDA:1,main\nFNF:2\nFNH:2\n",
+		"BRDA:6,3,0,2\nBRDA:6,3,1,0\nBRF:2\nBRH:1\n",
+		"DA:6,2\nDA:7,0\n",
+		"LF:15\nLH:11\nend_of_record\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("LCOV lacks %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package rmm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Len returns the number of instructions in the program.
+func (p *Program) Len() int {
+	return len(p.instructions)
+}
+
+// Entrypoint returns the index of the first instruction to run.
+func (p *Program) Entrypoint() int {
+	return p.entrypoint
+}
+
+// Symbols returns a copy of the program's labels and the instruction index
+// each one names.
+func (p *Program) Symbols() map[string]int {
+	symbols := make(map[string]int, len(p.symbols))
+	for name, index := range p.symbols {
+		symbols[name] = index
+	}
+	return symbols
+}
+
+// Location returns the source file and line instruction ip came from.
+func (p *Program) Location(ip int) (string, int) {
+	if ip < 0 || ip >= len(p.instructions) {
+		return "", 0
+	}
+	return p.instructions[ip].fileName, p.instructions[ip].line
+}
+
+// InstructionText returns instruction ip in source form, naming jump and
+// call targets by their labels where the program has them.
+func (p *Program) InstructionText(ip int) string {
+	if ip < 0 || ip >= len(p.instructions) {
+		return ""
+	}
+	instr := p.instructions[ip]
+	labels := disasmLabels{definitions: make(map[int][]string)}
+	if isJumpInstruction(instr.instructionType) {
+		target := int(instr.value.Int())
+		labels.definitions[target] = []string{functionName(labelsByIndex(p.symbols), target)}
+	}
+	text, err := disassembleInstruction(instr, labels)
+	if err != nil {
+		return strings.ToLower(instr.instructionType.String())
+	}
+	if instr.instructionType == InstructionNative {
+		if native, ok := nativeSignatures[instr.value.Int()]; ok {
+			text += " ; " + native.Name
+		}
+	}
+	if _, ok := superinstructions[instr.instructionType]; ok {
+		text += " ; " + strings.ToLower(instr.instructionType.String())
+	}
+	return text
+}
+
+// IP returns the index of the instruction the machine is executing, or is
+// about to execute when called from a StepHook.
+func (m *Machine) IP() int {
+	return m.ip
+}
+
+// CallDepth returns the number of active calls.
+func (m *Machine) CallDepth() int {
+	return len(m.returnStack)
+}
+
+// Backtrace returns the active calls, innermost first, as in a
+// RuntimeError's Trace.
+func (m *Machine) Backtrace() []Frame {
+	return m.frames(m.ip, 0)
+}
+
+// HeapString returns the NUL-terminated string at heap index ptr, stopping
+// early at a cell that is not a character.
+func (m *Machine) HeapString(ptr int64) string {
+	var sb strings.Builder
+	for i := ptr; i >= 0 && i < int64(m.heap.len()); i++ {
+		c := m.heap.at(int(i))
+		if c.Type() != LiteralChar || c.Char() == 0 {
+			break
+		}
+		sb.WriteRune(c.Char())
+	}
+	return sb.String()
+}
+
+// SetRegister overwrites register r<index>.
+func (m *Machine) SetRegister(index int, value Literal) error {
+	if index < 0 || index >= MaxRegisters {
+		return fmt.Errorf("register index out of bounds: r%d", index)
+	}
+	m.registers[index] = value
+	return nil
+}
+
+// SetStack overwrites data stack slot index, counted from the bottom as by
+// indup and inswap.
+func (m *Machine) SetStack(index int, value Literal) error {
+	if index < 0 || index >= len(m.stack) {
+		return fmt.Errorf("stack index %d out of bounds (depth %d)", index, len(m.stack))
+	}
+	m.stack[index] = value
+	return nil
+}
+
+// SetHeap overwrites heap cell index.
+func (m *Machine) SetHeap(index int, value Literal) error {
+	if index < 0 || index >= m.heap.len() {
+		return fmt.Errorf("heap index %d out of bounds (size %d)", index, m.heap.len())
+	}
+	m.heap.set(index, value)
+	return nil
+}
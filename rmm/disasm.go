@@ -0,0 +1,320 @@
+package rmm
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Disassemble turns the program back into .rmm source. Jump and call
+// targets use the program's symbols where it has them and synthetic
+// L<index> labels otherwise, and @line directives carry the original line
+// table, so assembling the output reproduces the same program file. The
+// one exception is a target that was written as a raw address: its
+// synthetic label becomes a new symbol.
+func (p *Program) Disassemble(sourceName string) (string, error) {
+	labels, err := disassemblyLabels(p)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	// Starting with a comment also keeps the first token from being a label
+	// definition, which the parser numbers differently.
+	fmt.Fprintf(&out, "; disassembled from %s\n", sourceName)
+
+	strs, err := disassembleStrings(p)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range strs {
+		fmt.Fprintf(&out, "push_str %s\n", s)
+	}
+	if p.entrypoint != 0 {
+		fmt.Fprintf(&out, "entrypoint %s\n", labels.reference(p.entrypoint))
+	}
+
+	// Superinstructions are written out as the instructions they fuse,
+	// each at its own line.
+	type part struct {
+		index int // of the instruction the part belongs to
+		first bool
+		instr Instruction
+	}
+	var code []part
+	for i, instr := range p.instructions {
+		for k, pi := range instr.parts() {
+			code = append(code, part{i, k == 0, pi})
+		}
+	}
+
+	curFile, curLine := "", -1
+	for i := 0; i < len(code); {
+		// Instructions from the same source line share an output line
+		first := code[i].instr
+		if first.fileName != curFile || first.line != curLine {
+			fmt.Fprintf(&out, "@line %d \"%s\"\n", first.line, first.fileName)
+			curFile, curLine = first.fileName, first.line
+		}
+		parts := []string{}
+		natives := []string{}
+		for ; i < len(code); i++ {
+			instr := code[i].instr
+			if instr.fileName != curFile || instr.line != curLine {
+				break
+			}
+			if code[i].first {
+				for _, name := range labels.definitions[code[i].index] {
+					parts = append(parts, name+":")
+				}
+			}
+			text, err := disassembleInstruction(instr, labels)
+			if err != nil {
+				return "", fmt.Errorf("instruction %d: %w", code[i].index, err)
+			}
+			parts = append(parts, text)
+			if instr.instructionType == InstructionNative {
+				if native, ok := nativeSignatures[instr.value.Int()]; ok {
+					natives = append(natives, native.Name)
+				}
+			}
+		}
+		out.WriteString(strings.Join(parts, " "))
+		if len(natives) > 0 {
+			out.WriteString(" ; " + strings.Join(natives, ", "))
+		}
+		out.WriteString("\n")
+		curLine++
+	}
+	for _, name := range labels.definitions[len(p.instructions)] {
+		out.WriteString(name + ":\n")
+	}
+	return out.String(), nil
+}
+
+// disasmLabels holds the label names defined at each instruction index.
+type disasmLabels struct {
+	definitions map[int][]string
+}
+
+func (l disasmLabels) reference(target int) string {
+	return l.definitions[target][0]
+}
+
+func disassemblyLabels(program *Program) (disasmLabels, error) {
+	labels := disasmLabels{definitions: make(map[int][]string)}
+	for _, name := range sortedSymbols(program.symbols) {
+		target := program.symbols[name]
+		labels.definitions[target] = append(labels.definitions[target], name)
+	}
+
+	targets := []int{program.entrypoint}
+	for _, instr := range program.instructions {
+		if isJumpInstruction(instr.instructionType) {
+			targets = append(targets, int(instr.value.Int()))
+		}
+	}
+	sort.Ints(targets)
+	for _, target := range targets {
+		if target < 0 || target > len(program.instructions) {
+			return labels, fmt.Errorf("jump target %d is outside the program", target)
+		}
+		if len(labels.definitions[target]) > 0 {
+			continue
+		}
+		name := fmt.Sprintf("L%d", target)
+		for _, taken := program.symbols[name]; taken; _, taken = program.symbols[name] {
+			name += "_"
+		}
+		labels.definitions[target] = []string{name}
+	}
+	return labels, nil
+}
+
+func isJumpInstruction(instructionType InstructionSet) bool {
+	switch instructionType {
+	case InstructionJmp, InstructionZjmp, InstructionNzjmp, InstructionCall,
+		InstructionCmpeZjmp, InstructionCmpeNzjmp:
+		return true
+	}
+	return false
+}
+
+func disassembleInstruction(instr Instruction, labels disasmLabels) (string, error) {
+	if _, ok := superinstructions[instr.instructionType]; ok {
+		var texts []string
+		for _, part := range instr.parts() {
+			text, err := disassembleInstruction(part, labels)
+			if err != nil {
+				return "", err
+			}
+			texts = append(texts, text)
+		}
+		return strings.Join(texts, " "), nil
+	}
+	mnemonic := strings.ToLower(instr.instructionType.String())
+	switch instr.instructionType {
+	case InstructionPop, InstructionDup, InstructionSwap, InstructionAdd, InstructionSub,
+		InstructionMul, InstructionDiv, InstructionMod, InstructionCmpe, InstructionCmpne,
+		InstructionCmpg, InstructionCmpl, InstructionCmpge, InstructionCmple, InstructionPrint,
+		InstructionRet, InstructionHalt, InstructionPopStr, InstructionDupStr, InstructionSwapStr,
+		InstructionCastIntToFloat, InstructionCastFloatToInt, InstructionRef, InstructionDeref,
+		InstructionMovStr:
+		return mnemonic, nil
+	case InstructionPush:
+		operand, err := disassembleLiteral(instr.value)
+		if err != nil {
+			return "", err
+		}
+		return mnemonic + " " + operand, nil
+	case InstructionPushPtr:
+		if instr.value.Type() == LiteralNull {
+			return mnemonic + " NULL", nil
+		}
+		return mnemonic + " " + strconv.FormatInt(instr.value.Int(), 10), nil
+	case InstructionGetStr, InstructionInDup, InstructionInSwap, InstructionInDupStr,
+		InstructionInSwapStr, InstructionNative:
+		return mnemonic + " " + strconv.FormatInt(instr.value.Int(), 10), nil
+	case InstructionJmp, InstructionZjmp, InstructionNzjmp, InstructionCall:
+		return mnemonic + " " + labels.reference(int(instr.value.Int())), nil
+	case InstructionIndex:
+		if instr.value.Type() == LiteralChar {
+			operand, err := disassembleChar(instr.value.Char())
+			if err != nil {
+				return "", err
+			}
+			return mnemonic + " " + operand, nil
+		}
+		return mnemonic, nil
+	case InstructionPushReg:
+		register, err := disassembleRegister(int64(instr.registerIndex))
+		if err != nil {
+			return "", err
+		}
+		return "push " + register, nil
+	case InstructionMov:
+		register, err := disassembleRegister(int64(instr.registerIndex))
+		if err != nil {
+			return "", err
+		}
+		operand, err := disassembleLiteral(instr.value)
+		if err != nil {
+			return "", err
+		}
+		return "mov " + register + " " + operand, nil
+	case InstructionMovTop:
+		register, err := disassembleRegister(instr.value.Int())
+		if err != nil {
+			return "", err
+		}
+		return "mov " + register + " top", nil
+	default:
+		return "", fmt.Errorf("%s has no source form", instr.instructionType)
+	}
+}
+
+func disassembleLiteral(l Literal) (string, error) {
+	switch l.Type() {
+	case LiteralInt:
+		return strconv.FormatInt(l.Int(), 10), nil
+	case LiteralFloat:
+		if math.IsInf(l.Float(), 0) || math.IsNaN(l.Float()) {
+			return "", fmt.Errorf("float %v cannot be written as a literal", l.Float())
+		}
+		text := strconv.FormatFloat(l.Float(), 'f', -1, 64)
+		if !strings.Contains(text, ".") {
+			text += ".0"
+		}
+		return text, nil
+	case LiteralChar:
+		return disassembleChar(l.Char())
+	case LiteralNull:
+		return "NULL", nil
+	default:
+		return "", fmt.Errorf("%s cannot be written as a literal", l.String())
+	}
+}
+
+// disassembleRegister names register r, which the assembler only accepts
+// as r0 to r15.
+func disassembleRegister(r int64) (string, error) {
+	if r < 0 || r >= MaxRegisters {
+		return "", fmt.Errorf("register index %d is out of range", r)
+	}
+	return fmt.Sprintf("r%d", r), nil
+}
+
+// disassembleChar quotes a character the way the lexer reads it back. The
+// lexer reads literals byte by byte, so characters up to 0xFF are written
+// as that raw byte and anything above cannot come from source.
+func disassembleChar(c rune) (string, error) {
+	switch c {
+	case '\n':
+		return `'\n'`, nil
+	case '\t':
+		return `'\t'`, nil
+	case '\r':
+		return `'\r'`, nil
+	case '\\':
+		return `'\\'`, nil
+	case '\'':
+		return `'\''`, nil
+	case 0:
+		return `'\0'`, nil
+	}
+	if c < 0 || c > 0xFF {
+		return "", fmt.Errorf("character %U cannot be written as a literal", c)
+	}
+	return "'" + string([]byte{byte(c)}) + "'", nil
+}
+
+// disassembleStrings recovers the push_str directives that built the
+// initial heap: each string table entry points at its characters followed
+// by a terminating zero, laid out back to back.
+func disassembleStrings(program *Program) ([]string, error) {
+	strs := []string{}
+	next := int64(0)
+	for i, ptr := range program.strStack {
+		if ptr != next {
+			return nil, fmt.Errorf("string %d does not follow the previous string on the heap", i)
+		}
+		end := int64(len(program.heap)) - 1
+		if i+1 < len(program.strStack) {
+			end = program.strStack[i+1] - 1
+		}
+		if end < ptr || end >= int64(len(program.heap)) {
+			return nil, fmt.Errorf("string %d is outside the heap image", i)
+		}
+		var text []byte
+		for _, cell := range program.heap[ptr:end] {
+			if cell.Type() != LiteralChar || cell.Char() > 0xFF {
+				return nil, fmt.Errorf("string %d holds %s, which push_str cannot express", i, cell.String())
+			}
+			switch cell.Char() {
+			case '\n':
+				text = append(text, `\n`...)
+			case '\t':
+				text = append(text, `\t`...)
+			case '"':
+				text = append(text, `\"`...)
+			case '\\':
+				text = append(text, `\\`...)
+			case 0:
+				text = append(text, `\0`...)
+			default:
+				text = append(text, byte(cell.Char()))
+			}
+		}
+		if !program.heap[end].Equal(CharLiteral(0)) {
+			return nil, fmt.Errorf("string %d is not zero-terminated", i)
+		}
+		strs = append(strs, `"`+string(text)+`"`)
+		next = end + 1
+	}
+	if next != int64(len(program.heap)) {
+		return nil, fmt.Errorf("heap image holds data that push_str cannot express")
+	}
+	return strs, nil
+}
@@ -0,0 +1,132 @@
+package rmm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"defs.rmm": "@def write native 1\n@def STDOUT 1\n",
+		"main.rmm": `@imp "defs.rmm"
+entrypoint main
+push_str "hi \"there\"\n\0x"
+push_str ""
+
+loop:
+	push 'a' push '\n'
+	push "xy"
+	mov r3 -1.5
+	mov r2 'q'
+	mov r1 top
+	push r1
+	push_ptr NULL
+	NULL
+	push_ptr 3
+	index 'z'
+	index
+	get_str 1
+	itof ftoi ref deref mov_str
+	pop_str dup_str indup_str 0 swap_str inswap_str 1
+	indup 2 inswap 3
+	zjmp loop
+	nzjmp end
+
+main:
+	push 0.0
+	push -3.25
+	push STDOUT
+	write
+	call loop
+	ret
+	halt
+end:
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	program, err := CompileFile(filepath.Join(tmpDir, "main.rmm"))
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	original := encodeProgram(program)
+	loaded, err := decodeProgram(original)
+	if err != nil {
+		t.Fatalf("failed to decode program: %v", err)
+	}
+	source, err := loaded.Disassemble("program.bin")
+	if err != nil {
+		t.Fatalf("failed to disassemble: %v", err)
+	}
+
+	for _, want := range []string{"loop: push 'a' push '\\n'", "native 1 ; write", "zjmp loop", "mov r1 top", "push -3.25"} {
+		if !strings.Contains(source, want) {
+			t.Errorf("expected disassembly to contain %q, got:\n%s", want, source)
+		}
+	}
+
+	disasmFile := filepath.Join(tmpDir, "disasm.rmm")
+	if err := os.WriteFile(disasmFile, []byte(source), 0644); err != nil {
+		t.Fatalf("failed to write disassembly: %v", err)
+	}
+	reassembledProgram, err := CompileFile(disasmFile)
+	if err != nil {
+		t.Fatalf("failed to compile disassembly: %v", err)
+	}
+	reassembled := encodeProgram(reassembledProgram)
+	if !bytes.Equal(original, reassembled) {
+		t.Fatalf("reassembled program differs from original; disassembly:\n%s", source)
+	}
+}
+
+func TestDisassembleSyntheticLabels(t *testing.T) {
+	program := newProgram([]Instruction{
+		{instructionType: InstructionPush, value: IntLiteral(1), line: 1},
+		{instructionType: InstructionZjmp, value: IntLiteral(3), line: 2},
+		{instructionType: InstructionCall, value: IntLiteral(0), line: 3},
+		{instructionType: InstructionHalt, line: 4},
+	}, 0, []int64{}, []Literal{})
+
+	source, err := program.Disassemble("program.bin")
+	if err != nil {
+		t.Fatalf("failed to disassemble: %v", err)
+	}
+	for _, want := range []string{"L0: push 1", "zjmp L3", "call L0", "L3: halt"} {
+		if !strings.Contains(source, want) {
+			t.Errorf("expected disassembly to contain %q, got:\n%s", want, source)
+		}
+	}
+}
+
+func TestDisassembleRejectsUnrepresentableHeap(t *testing.T) {
+	program := newProgram([]Instruction{
+		{instructionType: InstructionHalt},
+	}, 0, []int64{0}, []Literal{IntLiteral(5), CharLiteral(0)})
+
+	if _, err := program.Disassemble("program.bin"); err == nil {
+		t.Fatalf("expected error for heap that push_str cannot express")
+	}
+}
+
+func TestDisassembleRejectsBadRegister(t *testing.T) {
+	for _, instr := range []Instruction{
+		{instructionType: InstructionPushReg, registerIndex: 32},
+		{instructionType: InstructionMov, registerIndex: -1, value: IntLiteral(1)},
+		{instructionType: InstructionMovTop, value: IntLiteral(16)},
+		{instructionType: InstructionPushRegDeref, registerIndex: 32},
+	} {
+		program := newProgram([]Instruction{instr, {instructionType: InstructionHalt}}, 0, nil, nil)
+		_, err := program.Disassemble("program.bin")
+		if err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("%v: expected a register out of range error, got %v", instr.instructionType, err)
+		}
+	}
+}
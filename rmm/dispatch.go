@@ -0,0 +1,623 @@
+package rmm
+
+import "fmt"
+
+// The interpreter does not run Instructions directly. When a program is
+// loaded, decode checks each instruction's operands once, the jump and call
+// targets, register indexes, native IDs and operand types, and turns the
+// instruction into an op: the handler for it and its operands in the form
+// the handler wants. The run loop then calls one handler per step with no
+// switch and without checking again what decode has proven. An instruction
+// that fails the checks decodes to a handler that raises the fault the
+// check found, so a bad operand still only faults if it is reached.
+
+// op is a decoded instruction.
+type op struct {
+	exec  opFunc
+	value Literal // the value pushed or stored
+	arg   int     // the jump target, register or stack index
+}
+
+// opFunc executes the op at ip and returns the index of the next
+// instruction to run.
+type opFunc func(ctx *RuntimeContext, o *op, ip int) int
+
+// natives maps native syscall IDs to their implementations;
+// nativeSignatures holds what each takes and pushes.
+var natives = map[int64]func(ctx *RuntimeContext){
+	0:   nativeOpen,
+	1:   nativeWrite,
+	2:   nativeRead,
+	3:   nativeClose,
+	4:   nativeMalloc,
+	5:   nativeRealloc,
+	6:   nativeFree,
+	7:   nativeScanf,
+	8:   nativePow,
+	10:  nativeTime,
+	60:  nativeExit,
+	90:  nativeStrcmp,
+	91:  nativeStrcpy,
+	92:  nativeMemcpy,
+	93:  nativeStrcat,
+	94:  nativeStrlen,
+	98:  nativeFloatToStr,
+	99:  nativeIntToStr,
+	100: nativeAssert,
+	101: nativeGC,
+}
+
+// decode verifies and decodes a program's instructions.
+func decode(instructions []Instruction) []op {
+	code := make([]op, len(instructions))
+	for i, instr := range instructions {
+		code[i] = decodeInstruction(instr, len(instructions))
+	}
+	return code
+}
+
+func decodeInstruction(instr Instruction, size int) op {
+	value := instr.value
+	intOperand := value.Type() == LiteralInt
+	inProgram := intOperand && value.Int() >= 0 && value.Int() < int64(size)
+	validRegister := instr.registerIndex >= 0 && instr.registerIndex < MaxRegisters
+	switch instr.instructionType {
+	case InstructionNoOp:
+		return op{exec: execNoOp}
+	case InstructionCall:
+		if !intOperand {
+			return faultOp(KindType, "call target must be an integer")
+		}
+		if !inProgram {
+			return faultOp(KindInvalidOperand, "call target out of bounds")
+		}
+		return op{exec: execCall, arg: int(value.Int())}
+	case InstructionRet:
+		return op{exec: execRet}
+	case InstructionJmp:
+		if !intOperand {
+			return faultOp(KindType, "jump target must be an integer")
+		}
+		if !inProgram {
+			return faultOp(KindInvalidOperand, "jump target out of bounds")
+		}
+		return op{exec: execJmp, arg: int(value.Int())}
+	case InstructionZjmp, InstructionNzjmp:
+		if !intOperand {
+			if instr.instructionType == InstructionZjmp {
+				// zjmp took its condition before checking its target.
+				return op{exec: func(ctx *RuntimeContext, o *op, ip int) int {
+					pop(ctx)
+					panic(ctx.Error(KindType, "jump target must be an integer"))
+				}}
+			}
+			return faultOp(KindType, "jump target must be an integer")
+		}
+		exec := execZjmp
+		if instr.instructionType == InstructionNzjmp {
+			exec = execNzjmp
+		}
+		if !inProgram {
+			// Out of bounds only faults when the jump is taken.
+			return op{exec: exec, arg: -1}
+		}
+		return op{exec: exec, arg: int(value.Int())}
+	case InstructionHalt:
+		return op{exec: execHalt}
+	case InstructionPush:
+		return op{exec: execPush, value: value}
+	case InstructionPushPtr:
+		switch value.Type() {
+		case LiteralNull:
+			return op{exec: execPush, value: value}
+		case LiteralInt:
+			return op{exec: execPush, value: PointerLiteral(value.Int())}
+		}
+		return faultOp(KindType, "push_ptr requires an integer or NULL value")
+	case InstructionPushStr:
+		if !intOperand {
+			return faultOp(KindType, "push_str value must be integer pointer")
+		}
+		return op{exec: execPushStr, value: value}
+	case InstructionGetStr:
+		return op{exec: execGetStr, arg: int(value.Int())}
+	case InstructionPop:
+		return op{exec: execPop}
+	case InstructionDup:
+		return op{exec: execDup}
+	case InstructionInDup:
+		if !intOperand {
+			return faultOp(KindType, "indup requires integer arguments")
+		}
+		return op{exec: execInDup, value: value}
+	case InstructionSwap:
+		return op{exec: execSwap}
+	case InstructionInSwap:
+		if !intOperand {
+			return faultOp(KindType, "inswap requires integer arguments")
+		}
+		return op{exec: execInSwap, value: value}
+	case InstructionPopStr:
+		return op{exec: execPopStr}
+	case InstructionDupStr:
+		return op{exec: execDupStr}
+	case InstructionInDupStr:
+		if !intOperand {
+			return faultOp(KindType, "indup_str requires integer arguments")
+		}
+		return op{exec: execInDupStr, value: value}
+	case InstructionSwapStr:
+		return op{exec: execSwapStr}
+	case InstructionInSwapStr:
+		if !intOperand {
+			return faultOp(KindType, "inswap_str requires integer arguments")
+		}
+		return op{exec: execInSwapStr, value: value}
+	case InstructionAdd:
+		return op{exec: execAdd}
+	case InstructionSub:
+		return op{exec: execSub}
+	case InstructionMul:
+		return op{exec: execMul}
+	case InstructionDiv:
+		return op{exec: execDiv}
+	case InstructionMod:
+		return op{exec: execMod}
+	case InstructionCmpe:
+		return op{exec: execCmpe}
+	case InstructionCmpne:
+		return op{exec: execCmpne}
+	case InstructionCmpg:
+		return op{exec: execCmpg}
+	case InstructionCmpl:
+		return op{exec: execCmpl}
+	case InstructionCmpge:
+		return op{exec: execCmpge}
+	case InstructionCmple:
+		return op{exec: execCmple}
+	case InstructionCastIntToFloat:
+		return op{exec: execItof}
+	case InstructionCastFloatToInt:
+		return op{exec: execFtoi}
+	case InstructionRef:
+		return op{exec: execRef}
+	case InstructionDeref:
+		return op{exec: execDeref}
+	case InstructionMovStr:
+		return op{exec: execMovStr}
+	case InstructionIndex:
+		if value.Type() == LiteralChar {
+			return op{exec: execIndexChar, value: value}
+		}
+		return op{exec: execIndex}
+	case InstructionMov:
+		if !validRegister {
+			return faultOp(KindInvalidOperand, "invalid register index")
+		}
+		return op{exec: execMov, value: value, arg: instr.registerIndex}
+	case InstructionPushReg:
+		if !validRegister {
+			return faultOp(KindInvalidOperand, "invalid register index")
+		}
+		return op{exec: execPushReg, arg: instr.registerIndex}
+	case InstructionMovTop:
+		if r := value.Int(); r < 0 || r >= MaxRegisters {
+			// mov_top took its value before checking the register.
+			return op{exec: func(ctx *RuntimeContext, o *op, ip int) int {
+				pop(ctx)
+				panic(ctx.Error(KindInvalidOperand, "invalid register index"))
+			}}
+		}
+		return op{exec: execMovTop, arg: int(value.Int())}
+	case InstructionPrint:
+		return op{exec: execPrint}
+	case InstructionNative:
+		if !intOperand {
+			return faultOp(KindType, "native syscall ID must be integer")
+		}
+		native, ok := natives[value.Int()]
+		if !ok {
+			return faultOp(KindInvalidOperand, fmt.Sprintf("unknown native syscall ID: %d", value.Int()))
+		}
+		return op{exec: func(ctx *RuntimeContext, o *op, ip int) int {
+			native(ctx)
+			return ip + 1
+		}}
+	case InstructionAddI:
+		if !intOperand {
+			return fusedFaultOp(0, KindType, "addi requires an integer operand")
+		}
+		return op{exec: execAddI, value: value}
+	case InstructionCmpeZjmp, InstructionCmpeNzjmp:
+		if !intOperand {
+			return fusedFaultOp(1, KindType, "jump target must be an integer")
+		}
+		exec := execCmpeZjmp
+		if instr.instructionType == InstructionCmpeNzjmp {
+			exec = execCmpeNzjmp
+		}
+		if !inProgram {
+			return op{exec: exec, arg: -1}
+		}
+		return op{exec: exec, arg: int(value.Int())}
+	case InstructionPushRegDeref:
+		if !validRegister {
+			return fusedFaultOp(0, KindInvalidOperand, "invalid register index")
+		}
+		return op{exec: execPushRegDeref, arg: instr.registerIndex}
+	}
+	return faultOp(KindInvalidOperand, fmt.Sprintf("unknown instruction type: %d", instr.instructionType))
+}
+
+// faultOp is an instruction that failed decoding: running it raises the
+// fault.
+func faultOp(kind ErrorKind, message string) op {
+	return op{exec: func(ctx *RuntimeContext, o *op, ip int) int {
+		panic(ctx.Error(kind, message))
+	}}
+}
+
+// jumpTaken reports whether the conditional jump at the top of a step
+// will jump, judging by the condition on the stack before it runs.
+func jumpTaken(ctx *RuntimeContext, instructionType InstructionSet) bool {
+	if instructionType == InstructionCmpeZjmp || instructionType == InstructionCmpeNzjmp {
+		n := len(ctx.stack)
+		return n >= 2 && ctx.stack[n-2].Equal(ctx.stack[n-1]) == (instructionType == InstructionCmpeNzjmp)
+	}
+	if len(ctx.stack) == 0 {
+		return false
+	}
+	cond := ctx.stack[len(ctx.stack)-1]
+	if cond.Type() != LiteralInt {
+		return false
+	}
+	return (cond.Int() == 0) == (instructionType == InstructionZjmp)
+}
+
+func execNoOp(ctx *RuntimeContext, o *op, ip int) int {
+	return ip + 1
+}
+
+func execCall(ctx *RuntimeContext, o *op, ip int) int {
+	if len(ctx.returnStack) >= ctx.limits.MaxReturnStack {
+		panic(ctx.Error(KindStackOverflow, "return stack overflow"))
+	}
+	ctx.returnStack = append(ctx.returnStack, ip+1)
+	ctx.callDepths = append(ctx.callDepths, len(ctx.stack))
+	return o.arg
+}
+
+func execRet(ctx *RuntimeContext, o *op, ip int) int {
+	if len(ctx.returnStack) == 0 {
+		panic(ctx.Error(KindStackUnderflow, "return stack underflow"))
+	}
+	retAddr := ctx.returnStack[len(ctx.returnStack)-1]
+	ctx.returnStack = ctx.returnStack[:len(ctx.returnStack)-1]
+	ctx.callDepths = ctx.callDepths[:len(ctx.callDepths)-1]
+	return retAddr
+}
+
+func execJmp(ctx *RuntimeContext, o *op, ip int) int {
+	return o.arg
+}
+
+func execZjmp(ctx *RuntimeContext, o *op, ip int) int {
+	value := pop(ctx)
+	if value.Type() != LiteralInt {
+		panic(ctx.Error(KindType, "zjmp condition value must be an integer"))
+	}
+	if value.Int() != 0 {
+		return ip + 1
+	}
+	return jumpTarget(ctx, o)
+}
+
+func execNzjmp(ctx *RuntimeContext, o *op, ip int) int {
+	value := pop(ctx)
+	if value.Type() != LiteralInt {
+		panic(ctx.Error(KindType, "nzjmp condition value must be an integer"))
+	}
+	if value.Int() == 0 {
+		return ip + 1
+	}
+	return jumpTarget(ctx, o)
+}
+
+// jumpTarget returns a taken conditional jump's target, which decode left
+// negative when it is outside the program.
+func jumpTarget(ctx *RuntimeContext, o *op) int {
+	if o.arg < 0 {
+		panic(ctx.Error(KindInvalidOperand, "jump target out of bounds"))
+	}
+	return o.arg
+}
+
+func execHalt(ctx *RuntimeContext, o *op, ip int) int {
+	return ctx.programSize()
+}
+
+func execPush(ctx *RuntimeContext, o *op, ip int) int {
+	push(ctx, o.value)
+	return ip + 1
+}
+
+func execPushStr(ctx *RuntimeContext, o *op, ip int) int {
+	pushStr(ctx, o.value.Int())
+	return ip + 1
+}
+
+func execGetStr(ctx *RuntimeContext, o *op, ip int) int {
+	if o.arg < 0 || o.arg >= len(ctx.strStack) {
+		panic(ctx.Error(KindInvalidOperand, "string index out of bounds"))
+	}
+	push(ctx, PointerLiteral(ctx.strStack[o.arg]))
+	return ip + 1
+}
+
+func execPop(ctx *RuntimeContext, o *op, ip int) int {
+	pop(ctx)
+	return ip + 1
+}
+
+func execDup(ctx *RuntimeContext, o *op, ip int) int {
+	if len(ctx.stack) == 0 {
+		panic(ctx.Error(KindStackUnderflow, "stack underflow"))
+	}
+	push(ctx, ctx.stack[len(ctx.stack)-1])
+	return ip + 1
+}
+
+func execInDup(ctx *RuntimeContext, o *op, ip int) int {
+	indexDup(ctx, o.value.Int())
+	return ip + 1
+}
+
+func execSwap(ctx *RuntimeContext, o *op, ip int) int {
+	if len(ctx.stack) < 2 {
+		panic(ctx.Error(KindStackUnderflow, "stack underflow"))
+	}
+	l := len(ctx.stack)
+	ctx.stack[l-1], ctx.stack[l-2] = ctx.stack[l-2], ctx.stack[l-1]
+	return ip + 1
+}
+
+func execInSwap(ctx *RuntimeContext, o *op, ip int) int {
+	indexSwap(ctx, o.value.Int())
+	return ip + 1
+}
+
+func execPopStr(ctx *RuntimeContext, o *op, ip int) int {
+	popStr(ctx)
+	return ip + 1
+}
+
+func execDupStr(ctx *RuntimeContext, o *op, ip int) int {
+	if len(ctx.strStack) == 0 {
+		panic(ctx.Error(KindStackUnderflow, "string stack underflow"))
+	}
+	pushStr(ctx, ctx.strStack[len(ctx.strStack)-1])
+	return ip + 1
+}
+
+func execInDupStr(ctx *RuntimeContext, o *op, ip int) int {
+	indexDupStr(ctx, o.value.Int())
+	return ip + 1
+}
+
+func execSwapStr(ctx *RuntimeContext, o *op, ip int) int {
+	if len(ctx.strStack) < 2 {
+		panic(ctx.Error(KindStackUnderflow, "string stack underflow"))
+	}
+	a := popStr(ctx)
+	b := popStr(ctx)
+	pushStr(ctx, a)
+	pushStr(ctx, b)
+	return ip + 1
+}
+
+func execInSwapStr(ctx *RuntimeContext, o *op, ip int) int {
+	indexSwapStr(ctx, o.value.Int())
+	return ip + 1
+}
+
+func execAdd(ctx *RuntimeContext, o *op, ip int) int {
+	a := pop(ctx)
+	b := pop(ctx)
+	push(ctx, a.Add(b))
+	return ip + 1
+}
+
+func execSub(ctx *RuntimeContext, o *op, ip int) int {
+	a := pop(ctx)
+	b := pop(ctx)
+	push(ctx, b.Sub(a))
+	return ip + 1
+}
+
+func execMul(ctx *RuntimeContext, o *op, ip int) int {
+	a := pop(ctx)
+	b := pop(ctx)
+	push(ctx, b.Mul(a))
+	return ip + 1
+}
+
+func execDiv(ctx *RuntimeContext, o *op, ip int) int {
+	a := pop(ctx)
+	b := pop(ctx)
+	push(ctx, b.Div(a))
+	return ip + 1
+}
+
+func execMod(ctx *RuntimeContext, o *op, ip int) int {
+	a := pop(ctx)
+	b := pop(ctx)
+	push(ctx, b.Mod(a))
+	return ip + 1
+}
+
+// popOperands pops the two operands of a comparison, the deeper one
+// first.
+func popOperands(ctx *RuntimeContext) (Literal, Literal) {
+	if len(ctx.stack) < 2 {
+		panic(ctx.Error(KindStackUnderflow, "stack underflow"))
+	}
+	a := pop(ctx)
+	b := pop(ctx)
+	return b, a
+}
+
+// pushBool pushes 1 for true and 0 for false.
+func pushBool(ctx *RuntimeContext, v bool) {
+	if v {
+		push(ctx, IntLiteral(1))
+	} else {
+		push(ctx, IntLiteral(0))
+	}
+}
+
+func execCmpe(ctx *RuntimeContext, o *op, ip int) int {
+	b, a := popOperands(ctx)
+	pushBool(ctx, b.Equal(a))
+	return ip + 1
+}
+
+func execCmpne(ctx *RuntimeContext, o *op, ip int) int {
+	b, a := popOperands(ctx)
+	pushBool(ctx, !b.Equal(a))
+	return ip + 1
+}
+
+func execCmpg(ctx *RuntimeContext, o *op, ip int) int {
+	b, a := popOperands(ctx)
+	pushBool(ctx, b.Greater(a))
+	return ip + 1
+}
+
+func execCmpl(ctx *RuntimeContext, o *op, ip int) int {
+	b, a := popOperands(ctx)
+	pushBool(ctx, b.Less(a))
+	return ip + 1
+}
+
+func execCmpge(ctx *RuntimeContext, o *op, ip int) int {
+	b, a := popOperands(ctx)
+	pushBool(ctx, b.GreaterOrEqual(a))
+	return ip + 1
+}
+
+func execCmple(ctx *RuntimeContext, o *op, ip int) int {
+	b, a := popOperands(ctx)
+	pushBool(ctx, b.LessOrEqual(a))
+	return ip + 1
+}
+
+func execItof(ctx *RuntimeContext, o *op, ip int) int {
+	val := pop(ctx)
+	if val.Type() != LiteralInt {
+		panic(ctx.Error(KindType, "itof requires an integer"))
+	}
+	push(ctx, FloatLiteral(float64(val.Int())))
+	return ip + 1
+}
+
+func execFtoi(ctx *RuntimeContext, o *op, ip int) int {
+	val := pop(ctx)
+	if val.Type() != LiteralFloat {
+		panic(ctx.Error(KindType, "ftoi requires a float"))
+	}
+	push(ctx, IntLiteral(int64(val.Float())))
+	return ip + 1
+}
+
+func execRef(ctx *RuntimeContext, o *op, ip int) int {
+	maybeCollect(ctx, 1)
+	val := pop(ctx)
+	push(ctx, PointerLiteral(int64(newCells(ctx, val))))
+	return ip + 1
+}
+
+func execDeref(ctx *RuntimeContext, o *op, ip int) int {
+	deref(ctx, pop(ctx))
+	return ip + 1
+}
+
+// deref pushes the heap cell ptrVal points at.
+func deref(ctx *RuntimeContext, ptrVal Literal) {
+	if ptrVal.Type() != LiteralPointer {
+		panic(ctx.Error(KindType, "deref requires a pointer"))
+	}
+	ptr := ptrVal.Pointer()
+	if ptr < 0 || int(ptr) >= ctx.heap.len() {
+		panic(ctx.Error(KindSegfault, "segmentation fault: invalid pointer"))
+	}
+	checkAccess(ctx, ptr, ptr, memRead)
+	push(ctx, ctx.heap.at(int(ptr)))
+}
+
+func execMovStr(ctx *RuntimeContext, o *op, ip int) int {
+	val := pop(ctx)
+	switch val.Type() {
+	case LiteralChar:
+		maybeCollect(ctx, 2)
+		pushStr(ctx, int64(newCells(ctx, val, CharLiteral(0))))
+	case LiteralInt:
+		pushStr(ctx, val.Int())
+	case LiteralPointer:
+		pushStr(ctx, val.Pointer())
+	default:
+		panic(ctx.Error(KindType, "mov_str requires char or int (pointer)"))
+	}
+	return ip + 1
+}
+
+// execIndexChar stores its operand char; execIndex stores a value popped
+// from the stack.
+func execIndexChar(ctx *RuntimeContext, o *op, ip int) int {
+	storeIndexed(ctx, o.value)
+	return ip + 1
+}
+
+func execIndex(ctx *RuntimeContext, o *op, ip int) int {
+	storeIndexed(ctx, pop(ctx))
+	return ip + 1
+}
+
+// storeIndexed pops an index and a pointer, stores val at the pointer plus
+// the index and pushes the pointer back.
+func storeIndexed(ctx *RuntimeContext, val Literal) {
+	idx := pop(ctx).Int()
+	ptrCtx := pop(ctx)
+	if ptrCtx.Type() != LiteralPointer {
+		panic(ctx.Error(KindType, "expected pointer for index"))
+	}
+	if idx < 0 {
+		panic(ctx.Error(KindSegfault, "index cannot be less than 0"))
+	}
+	targetAddr := ptrCtx.Pointer() + idx
+	if targetAddr < 0 || int(targetAddr) >= ctx.heap.len() {
+		panic(ctx.Error(KindSegfault, "segmentation fault: index out of bounds"))
+	}
+	checkAccess(ctx, ptrCtx.Pointer(), targetAddr, memWrite)
+	storeHeap(ctx, targetAddr, val)
+	push(ctx, ptrCtx)
+}
+
+func execMov(ctx *RuntimeContext, o *op, ip int) int {
+	storeRegister(ctx, o.arg, o.value)
+	return ip + 1
+}
+
+func execPushReg(ctx *RuntimeContext, o *op, ip int) int {
+	push(ctx, ctx.registers[o.arg])
+	return ip + 1
+}
+
+func execMovTop(ctx *RuntimeContext, o *op, ip int) int {
+	storeRegister(ctx, o.arg, pop(ctx))
+	return ip + 1
+}
+
+func execPrint(ctx *RuntimeContext, o *op, ip int) int {
+	fmt.Fprintln(ctx.output, pop(ctx))
+	return ip + 1
+}
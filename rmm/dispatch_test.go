@@ -0,0 +1,106 @@
+package rmm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDecodeFaultsOnlyWhenReached(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		kind    ErrorKind // KindUnknown for a clean run
+		message string
+	}{
+		{name: "jump not reached", source: "halt\njmp 99\n"},
+		{name: "jump reached", source: "jmp 99\n", kind: KindInvalidOperand, message: "jump target out of bounds"},
+		{name: "call reached", source: "call 99\n", kind: KindInvalidOperand, message: "call target out of bounds"},
+		{name: "branch not taken", source: "push 0\nnzjmp 99\n"},
+		{name: "branch taken", source: "push 1\nnzjmp 99\n", kind: KindInvalidOperand, message: "jump target out of bounds"},
+		{name: "zero branch taken", source: "push 0\nzjmp 99\n", kind: KindInvalidOperand, message: "jump target out of bounds"},
+		{name: "native not reached", source: "halt\nnative 55\n"},
+		{name: "native reached", source: "native 55\n", kind: KindInvalidOperand, message: "unknown native syscall ID: 55"},
+	}
+	for _, tt := range tests {
+		program, err := Compile(tt.source)
+		if err != nil {
+			t.Fatalf("%s: failed to compile: %v", tt.name, err)
+		}
+		err = NewMachine(Options{}).Run(context.Background(), program)
+		if tt.kind == KindUnknown {
+			if err != nil {
+				t.Errorf("%s: expected a clean run, got %v", tt.name, err)
+			}
+			continue
+		}
+		var rtErr *RuntimeError
+		if !errors.As(err, &rtErr) || rtErr.Kind != tt.kind || rtErr.Message != tt.message {
+			t.Errorf("%s: expected %v %q, got %v", tt.name, tt.kind, tt.message, err)
+			continue
+		}
+		if rtErr.Line != 1 && rtErr.Line != 2 {
+			t.Errorf("%s: expected the fault on the faulting line, got line %d", tt.name, rtErr.Line)
+		}
+	}
+}
+
+func TestSteps(t *testing.T) {
+	program, err := Compile("push 3\nloop:\npush 1\nsub\ndup\nnzjmp loop\n")
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	machine := NewMachine(Options{})
+	if err := machine.Run(context.Background(), program); err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	if got := machine.Steps(); got != 13 {
+		t.Errorf("expected 13 steps, got %d", got)
+	}
+}
+
+// dispatchKernels are loops that exercise one part of the interpreter
+// each, for BenchmarkDispatch.
+var dispatchKernels = []struct {
+	name   string
+	source string
+}{
+	{
+		name:   "stack",
+		source: "push 100000\nloop:\npush 1\nsub\ndup\nnzjmp loop\n",
+	},
+	{
+		name:   "registers",
+		source: "mov r0 0\nloop:\npush r0\npush 1\nadd\nmov r0 top\npush r0\npush 100000\ncmpl\nnzjmp loop\n",
+	},
+	{
+		name:   "calls",
+		source: "push 50000\nloop:\ncall f\npush 1\nsub\ndup\nnzjmp loop\nhalt\nf:\nret\n",
+	},
+	{
+		name:   "heap",
+		source: "push 1\nref\nmov r0 top\npush 50000\nloop:\npush r0\nderef\npop\npush 1\nsub\ndup\nnzjmp loop\n",
+	},
+}
+
+// BenchmarkDispatch measures the interpreter's raw speed in instructions
+// per second, which is the figure to compare across releases.
+func BenchmarkDispatch(b *testing.B) {
+	for _, k := range dispatchKernels {
+		b.Run(k.name, func(b *testing.B) {
+			program, err := Compile(k.source)
+			if err != nil {
+				b.Fatalf("failed to compile: %v", err)
+			}
+			machine := NewMachine(Options{})
+			var steps int64
+			for b.Loop() {
+				if err := machine.Run(context.Background(), program); err != nil {
+					b.Fatalf("failed to run: %v", err)
+				}
+				steps += machine.Steps()
+			}
+			b.ReportMetric(float64(steps)/b.Elapsed().Seconds(), "instr/s")
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package rmm
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"vm/internal/token"
+)
+
+// ErrorKind classifies a CompileError or RuntimeError.
+type ErrorKind uint8
+
+const (
+	KindUnknown ErrorKind = iota
+	// Compile errors
+	KindSyntax // malformed literal, directive or operand
+	KindSymbol // undefined or duplicate label or macro
+	KindImport // a source file could not be read
+	// Runtime errors
+	KindStackUnderflow // data, string or return stack underflow
+	KindStackOverflow  // data, string or return stack overflow
+	KindType           // operand of the wrong type
+	KindSegfault       // pointer or index outside the heap, bad free
+	KindDivideByZero
+	KindInvalidOperand // bad jump target, register, string index or syscall
+	KindIO             // a file operation or stdin read failed
+	KindAssertion      // assert native failed
+	KindCancelled      // the run's context was cancelled
+	KindBudgetExceeded // the step limit or timeout ran out
+	KindOutOfMemory    // the heap limit was reached
+	KindMemory         // memcheck found an invalid heap access
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindSyntax:
+		return "syntax"
+	case KindSymbol:
+		return "symbol"
+	case KindImport:
+		return "import"
+	case KindStackUnderflow:
+		return "stack underflow"
+	case KindStackOverflow:
+		return "stack overflow"
+	case KindType:
+		return "type"
+	case KindSegfault:
+		return "segmentation fault"
+	case KindDivideByZero:
+		return "division by zero"
+	case KindInvalidOperand:
+		return "invalid operand"
+	case KindIO:
+		return "i/o"
+	case KindAssertion:
+		return "assertion"
+	case KindCancelled:
+		return "cancelled"
+	case KindBudgetExceeded:
+		return "budget exceeded"
+	case KindOutOfMemory:
+		return "out of memory"
+	case KindMemory:
+		return "memory error"
+	default:
+		return "unknown"
+	}
+}
+
+// CompileError is returned by Compile and CompileFile when the source cannot
+// be assembled.
+type CompileError struct {
+	Kind    ErrorKind
+	File    string
+	Line    int // 0 when the error has no source position
+	Column  int // 1-based byte offset in the line, 0 if unknown
+	Message string
+}
+
+func (e *CompileError) Error() string {
+	return errorText(e.File, e.Line, e.Message)
+}
+
+// errorText formats message as every error in the package reads, located
+// by the base name of file and the line when the line is known.
+func errorText(file string, line int, message string) string {
+	if line == 0 {
+		return fmt.Sprintf("ERROR: %s", message)
+	}
+	return fmt.Sprintf("ERROR (%s:%d): %s", filepath.Base(file), line, message)
+}
+
+// RuntimeError is returned by Run when the program faults.
+type RuntimeError struct {
+	Kind    ErrorKind
+	File    string
+	Line    int
+	Column  int // 0 if unknown, e.g. for programs loaded from program.bin
+	IP      int // index of the faulting instruction
+	Message string
+	// Trace lists the active calls at the fault, innermost first.
+	Trace []Frame
+}
+
+func (e *RuntimeError) Error() string {
+	return errorText(e.File, e.Line, e.Message)
+}
+
+// fault builds a location-less RuntimeError; runInstructions fills in the
+// position of the instruction that raised it.
+func fault(kind ErrorKind, message string) *RuntimeError {
+	return &RuntimeError{Kind: kind, IP: -1, Message: message}
+}
+
+// Error builds a RuntimeError of the given kind located at the current
+// instruction.
+func (ctx *RuntimeContext) Error(kind ErrorKind, message string) *RuntimeError {
+	instr := ctx.instruction()
+	return &RuntimeError{
+		Kind:    kind,
+		File:    instr.fileName,
+		Line:    instr.line,
+		Column:  instr.column,
+		IP:      ctx.ip,
+		Message: message,
+	}
+}
+
+// Error builds a CompileError of the given kind located at ctx.
+func (ctx InstructionContext) Error(kind ErrorKind, message string) *CompileError {
+	return &CompileError{
+		Kind:    kind,
+		File:    ctx.FileName,
+		Line:    int(ctx.Line),
+		Column:  ctx.Character,
+		Message: message,
+	}
+}
+
+// compileError converts a value recovered while compiling into an error.
+func compileError(r any) error {
+	switch v := r.(type) {
+	case *token.Error:
+		kind := KindSyntax
+		switch v.Kind {
+		case token.ErrorSymbol:
+			kind = KindSymbol
+		case token.ErrorImport:
+			kind = KindImport
+		}
+		return &CompileError{
+			Kind:    kind,
+			File:    v.Context.FileName,
+			Line:    int(v.Context.Line),
+			Column:  v.Context.Character,
+			Message: v.Message,
+		}
+	case *CompileError:
+		return v
+	case error:
+		return &CompileError{Kind: KindUnknown, Message: v.Error()}
+	default:
+		return &CompileError{Kind: KindUnknown, Message: fmt.Sprint(v)}
+	}
+}
+
+// runtimeError converts a value recovered from the interpreter into an
+// error, locating faults raised without a position at instr. A Go runtime
+// error is a bug in the interpreter rather than a fault in the program, so
+// it is panicked again.
+func runtimeError(r any, ip int, instr Instruction) error {
+	switch v := r.(type) {
+	case exitRequest:
+		return nil
+	case runtime.Error:
+		panic(v)
+	case *RuntimeError:
+		if v.IP < 0 {
+			v.File, v.Line, v.Column, v.IP = instr.fileName, instr.line, instr.column, ip
+		}
+		return v
+	case error:
+		return &RuntimeError{Kind: KindUnknown, File: instr.fileName, Line: instr.line, Column: instr.column, IP: ip, Message: v.Error()}
+	default:
+		return &RuntimeError{Kind: KindUnknown, File: instr.fileName, Line: instr.line, Column: instr.column, IP: ip, Message: fmt.Sprint(v)}
+	}
+}
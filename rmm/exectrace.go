@@ -0,0 +1,162 @@
+package rmm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TraceOptions configures an execution trace: one JSON object per line for
+// every executed instruction that passes the filters. Records carry no
+// timing or addresses of Go values, so traces of the same program from two
+// VM versions can be diffed line by line.
+type TraceOptions struct {
+	Output io.Writer
+	// Top is how many data stack values each record shows; zero shows
+	// traceStackExcerpt.
+	Top int
+	// From and To bound the traced instructions to indexes From <= ip < To.
+	// A To of zero or less means the end of the program.
+	From, To int
+	// Ops, if not empty, limits the trace to these instruction kinds, named
+	// as in source ("push", "call", "native", ...).
+	Ops []string
+}
+
+// TraceRecord is one line of an execution trace. Depth and Top describe
+// the data stack after the instruction ran.
+type TraceRecord struct {
+	IP        int          `json:"ip"`
+	Op        string       `json:"op"`
+	Operand   string       `json:"operand,omitempty"`
+	File      string       `json:"file"`
+	Line      int          `json:"line"`
+	Depth     int          `json:"depth"`
+	Top       []string     `json:"top"`
+	Registers []TraceWrite `json:"regs,omitempty"`
+	Heap      []TraceWrite `json:"heap,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// TraceWrite is a register or heap cell an instruction wrote.
+type TraceWrite struct {
+	Index int64  `json:"index"`
+	Value string `json:"value"`
+}
+
+// tracer writes an execution trace for a machine.
+type tracer struct {
+	opts   TraceOptions
+	enc    *json.Encoder
+	ops    map[string]bool
+	labels disasmLabels
+	regs   []TraceWrite // writes by the current instruction
+	heap   []TraceWrite
+	err    error // first write error; tracing stops after it
+}
+
+func newTracer(opts TraceOptions) *tracer {
+	if opts.Top <= 0 {
+		opts.Top = traceStackExcerpt
+	}
+	t := &tracer{opts: opts, enc: json.NewEncoder(opts.Output)}
+	if len(opts.Ops) > 0 {
+		t.ops = make(map[string]bool, len(opts.Ops))
+		for _, op := range opts.Ops {
+			t.ops[strings.ToLower(op)] = true
+		}
+	}
+	return t
+}
+
+// start prepares to trace the machine's current program.
+func (t *tracer) start(m *Machine) {
+	labels, err := disassemblyLabels(&Program{instructions: m.instructions, entrypoint: m.entrypoint, symbols: m.symbols})
+	if err != nil {
+		labels = disasmLabels{definitions: make(map[int][]string)}
+	}
+	t.labels = labels
+}
+
+// begin clears the writes recorded for the previous instruction.
+func (t *tracer) begin() {
+	t.regs = t.regs[:0]
+	t.heap = t.heap[:0]
+}
+
+func (t *tracer) registerWrite(index int, value Literal) {
+	t.regs = append(t.regs, TraceWrite{Index: int64(index), Value: value.String()})
+}
+
+func (t *tracer) heapWrite(index int64, value Literal) {
+	t.heap = append(t.heap, TraceWrite{Index: index, Value: value.String()})
+}
+
+// traces reports whether the instruction at ip passes the filters.
+func (t *tracer) traces(ip int, instr Instruction) bool {
+	if ip < t.opts.From || (t.opts.To > 0 && ip >= t.opts.To) {
+		return false
+	}
+	return t.ops == nil || t.ops[strings.ToLower(instr.instructionType.String())]
+}
+
+// record writes the trace line for the instruction at ip, which stopped the
+// run with fault if it is not nil.
+func (t *tracer) record(m *Machine, ip int, instr Instruction, fault error) {
+	if t.err != nil || !t.traces(ip, instr) {
+		return
+	}
+	rec := TraceRecord{
+		IP:        ip,
+		Op:        strings.ToLower(instr.instructionType.String()),
+		Operand:   t.operand(instr),
+		File:      instr.fileName,
+		Line:      instr.line,
+		Depth:     len(m.stack),
+		Top:       []string{},
+		Registers: t.regs,
+		Heap:      t.heap,
+	}
+	for i := len(m.stack) - 1; i >= 0 && len(rec.Top) < t.opts.Top; i-- {
+		rec.Top = append(rec.Top, m.stack[i].String())
+	}
+	if fault != nil {
+		rec.Error = fault.Error()
+	}
+	if err := t.enc.Encode(rec); err != nil {
+		t.err = fmt.Errorf("failed to write trace: %w", err)
+	}
+}
+
+// operand returns an instruction's operand as it appears in source.
+func (t *tracer) operand(instr Instruction) string {
+	text, err := disassembleOperand(instr, t.labels)
+	if err != nil {
+		return instr.value.String()
+	}
+	return text
+}
+
+// disassembleOperand disassembles instr and drops its mnemonic. Jump
+// targets without a label, which disassembleInstruction cannot name, are
+// shown as instruction indexes.
+func disassembleOperand(instr Instruction, labels disasmLabels) (text string, err error) {
+	if parts := instr.parts(); len(parts) > 1 {
+		// A superinstruction's operand is that of the part taking one.
+		if isJumpInstruction(parts[1].instructionType) {
+			instr = parts[1]
+		} else {
+			instr = parts[0]
+		}
+	}
+	if isJumpInstruction(instr.instructionType) && len(labels.definitions[int(instr.value.Int())]) == 0 {
+		return fmt.Sprint(instr.value.Int()), nil
+	}
+	text, err = disassembleInstruction(instr, labels)
+	if err != nil {
+		return "", err
+	}
+	_, operand, _ := strings.Cut(text, " ")
+	return operand, nil
+}
@@ -0,0 +1,90 @@
+package rmm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const traceSource = `
+entrypoint main
+store:
+	mov r3 top
+	push 'x'
+	ref
+	pop
+	ret
+main:
+	push 1
+	call store
+	push 2
+	push 0
+	div
+`
+
+func runTrace(t *testing.T, opts TraceOptions) []TraceRecord {
+	t.Helper()
+	program, err := Compile(traceSource)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	var out bytes.Buffer
+	opts.Output = &out
+	machine := NewMachine(Options{Trace: &opts})
+	if err := machine.Run(context.Background(), program); err == nil {
+		t.Fatalf("expected the division by zero to fault")
+	}
+	var records []TraceRecord
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var rec TraceRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("bad trace line %q: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestTrace(t *testing.T) {
+	records := runTrace(t, TraceOptions{Top: 2})
+	var ops []string
+	for _, rec := range records {
+		ops = append(ops, rec.Op)
+	}
+	want := []string{"push", "call", "mov_top", "push", "ref", "pop", "ret", "push", "push", "div"}
+	if !reflect.DeepEqual(ops, want) {
+		t.Fatalf("traced %v, want %v", ops, want)
+	}
+
+	call := records[1]
+	if call.IP != 6 || call.Operand != "store" || call.Line != 11 || call.Depth != 1 {
+		t.Errorf("unexpected call record %+v", call)
+	}
+	if mov := records[2]; !reflect.DeepEqual(mov.Registers, []TraceWrite{{Index: 3, Value: "INT 1"}}) || mov.Depth != 0 {
+		t.Errorf("unexpected mov_top record %+v", mov)
+	}
+	if ref := records[4]; !reflect.DeepEqual(ref.Heap, []TraceWrite{{Index: 0, Value: "CHAR x"}}) ||
+		!reflect.DeepEqual(ref.Top, []string{"PTR 0"}) {
+		t.Errorf("unexpected ref record %+v", ref)
+	}
+	if push := records[8]; !reflect.DeepEqual(push.Top, []string{"INT 0", "INT 2"}) || push.Operand != "0" {
+		t.Errorf("unexpected push record %+v", push)
+	}
+	if div := records[9]; !strings.Contains(div.Error, "division by zero") {
+		t.Errorf("expected the fault in the last record, got %+v", div)
+	}
+}
+
+func TestTraceFilters(t *testing.T) {
+	records := runTrace(t, TraceOptions{From: 0, To: 5})
+	if len(records) != 5 || records[0].Op != "mov_top" || records[4].Op != "ret" {
+		t.Errorf("range 0:5 traced %+v", records)
+	}
+	records = runTrace(t, TraceOptions{Ops: []string{"push", "CALL"}})
+	if len(records) != 5 || records[1].Op != "call" {
+		t.Errorf("ops push,call traced %+v", records)
+	}
+}
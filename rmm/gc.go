@@ -0,0 +1,256 @@
+package rmm
+
+import "sort"
+
+// The garbage collector frees the blocks the program can no longer reach.
+// It marks from the roots, the pointers on the data stack, in registers and
+// on the string stack, through the pointers stored in reachable blocks and
+// in the cells outside the allocator, such as the program's strings, and
+// frees every block left unmarked. A pointer anywhere inside a block keeps
+// it alive. With the collector on, ref and the natives that build strings
+// allocate blocks too, so their cells are reclaimed as well.
+
+// defaultGCThreshold is the cells allocated between collections unless
+// GCOptions.Threshold says otherwise.
+const defaultGCThreshold = 1 << 16
+
+// GCOptions turns on the garbage collector.
+type GCOptions struct {
+	// Threshold is how many cells may be allocated between collections;
+	// zero uses 65536. A collection also runs before an allocation that
+	// would otherwise fail for lack of room.
+	Threshold int
+	// Compact moves live blocks down into free space after each
+	// collection, updates every pointer to them and shrinks the heap.
+	Compact bool
+}
+
+// maybeCollect collects before an allocation of size cells once enough has
+// been allocated since the last collection, or when the allocation would
+// not fit otherwise. pins are pointers the caller holds outside the roots;
+// they keep their blocks alive and compaction updates them.
+func maybeCollect(ctx *RuntimeContext, size int, pins ...*int) {
+	if ctx.gc == nil {
+		return
+	}
+	if ctx.sinceGC+size <= ctx.gc.Threshold && (size <= ctx.limits.MaxHeap-ctx.heap.len() || fitsFree(ctx, size)) {
+		return
+	}
+	collect(ctx, pins...)
+}
+
+func fitsFree(ctx *RuntimeContext, size int) bool {
+	for _, b := range ctx.freeBlocks {
+		if b.size >= size {
+			return true
+		}
+	}
+	return false
+}
+
+// collect frees every unreachable block and returns the cells reclaimed.
+func collect(ctx *RuntimeContext, pins ...*int) int {
+	starts := sortedKeys(ctx.allocations)
+	marked := make(map[int]bool, len(starts))
+	var work []int
+	mark := func(p int) {
+		if start, ok := blockAt(ctx, starts, p); ok && !marked[start] {
+			marked[start] = true
+			work = append(work, start)
+		}
+	}
+	markValue := func(v Literal) {
+		if v.Type() == LiteralPointer {
+			mark(int(v.Pointer()))
+		}
+	}
+	for _, v := range ctx.stack {
+		markValue(v)
+	}
+	for _, v := range ctx.registers {
+		markValue(v)
+	}
+	for _, p := range ctx.strStack {
+		mark(int(p))
+	}
+	for _, p := range pins {
+		mark(*p)
+	}
+	eachStaticCell(ctx, starts, func(i int) {
+		markValue(ctx.heap.at(i))
+	})
+	for len(work) > 0 {
+		start := work[len(work)-1]
+		work = work[:len(work)-1]
+		for i := start; i < start+ctx.allocations[start]; i++ {
+			markValue(ctx.heap.at(i))
+		}
+	}
+
+	reclaimed := 0
+	for _, start := range starts {
+		if !marked[start] {
+			reclaimed += footprint(ctx.allocations[start])
+			freeAllocation(ctx, start)
+		}
+	}
+	ctx.sinceGC = 0
+	ctx.collections++
+	ctx.collected += reclaimed
+	if ctx.gc.Compact {
+		compact(ctx, pins)
+	}
+	return reclaimed
+}
+
+// blockAt returns the start of the block p points into, given the sorted
+// starts of the blocks.
+func blockAt(ctx *RuntimeContext, starts []int, p int) (int, bool) {
+	i := sort.Search(len(starts), func(i int) bool { return starts[i] > p }) - 1
+	if i < 0 {
+		return 0, false
+	}
+	start := starts[i]
+	return start, p == start || p < start+ctx.allocations[start]
+}
+
+// eachStaticCell calls fn for every cell outside both the blocks, whose
+// sorted starts are given, and the free list.
+func eachStaticCell(ctx *RuntimeContext, starts []int, fn func(i int)) {
+	spans := make([]heapBlock, 0, len(starts)+len(ctx.freeBlocks))
+	for _, start := range starts {
+		spans = append(spans, heapBlock{start, footprint(ctx.allocations[start])})
+	}
+	spans = append(spans, ctx.freeBlocks...)
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	next := 0
+	for _, b := range spans {
+		for i := next; i < b.start; i++ {
+			fn(i)
+		}
+		next = max(next, b.end())
+	}
+	for i := next; i < ctx.heap.len(); i++ {
+		fn(i)
+	}
+}
+
+// relocation is a block compaction moved.
+type relocation struct {
+	from, to, size int
+}
+
+// compact slides each block down over the free cells just below it,
+// updates the pointers into moved blocks, and gives the free cells at the
+// end of the heap back. Cells outside the allocator stay put, so a block
+// only slides as far as the nearest of them.
+func compact(ctx *RuntimeContext, pins []*int) {
+	var moves []relocation
+	for _, start := range sortedKeys(ctx.allocations) {
+		size := ctx.allocations[start]
+		blocks := ctx.freeBlocks
+		i := sort.Search(len(blocks), func(i int) bool { return blocks[i].start >= start }) - 1
+		if size == 0 || i < 0 || blocks[i].end() != start {
+			continue
+		}
+		gap := blocks[i]
+		ctx.freeBlocks = append(blocks[:i], blocks[i+1:]...)
+		for k := 0; k < size; k++ {
+			storeHeap(ctx, gap.start+k, ctx.heap.at(start+k))
+		}
+		delete(ctx.allocations, start)
+		ctx.allocations[gap.start] = size
+		release(ctx, gap.start+size, gap.size)
+		if ctx.memcheck != nil {
+			ctx.memcheck.relocate(start, gap.start)
+		}
+		moves = append(moves, relocation{start, gap.start, size})
+	}
+	if len(moves) > 0 {
+		updatePointers(ctx, moves, pins)
+	}
+	if n := len(ctx.freeBlocks); n > 0 && ctx.freeBlocks[n-1].end() == ctx.heap.len() {
+		ctx.heap.truncate(ctx.freeBlocks[n-1].start)
+		ctx.freeBlocks = ctx.freeBlocks[:n-1]
+		if ctx.memcheck != nil {
+			ctx.memcheck.truncate(ctx.heap.len())
+		}
+	}
+	ctx.heap.narrow()
+}
+
+// updatePointers points every root, pin and pointer in a cell that is not
+// free at the new place of the block it pointed into. moves are sorted by
+// their old start.
+func updatePointers(ctx *RuntimeContext, moves []relocation, pins []*int) {
+	moved := func(p int) (int, bool) {
+		i := sort.Search(len(moves), func(i int) bool { return moves[i].from > p }) - 1
+		if i < 0 || p >= moves[i].from+moves[i].size {
+			return p, false
+		}
+		return moves[i].to + p - moves[i].from, true
+	}
+	movedValue := func(v Literal) (Literal, bool) {
+		if v.Type() != LiteralPointer {
+			return v, false
+		}
+		p, ok := moved(int(v.Pointer()))
+		return PointerLiteral(int64(p)), ok
+	}
+	for i, v := range ctx.stack {
+		ctx.stack[i], _ = movedValue(v)
+	}
+	for i, v := range ctx.registers {
+		if v, ok := movedValue(v); ok {
+			storeRegister(ctx, i, v)
+		}
+	}
+	for i, p := range ctx.strStack {
+		q, _ := moved(int(p))
+		ctx.strStack[i] = int64(q)
+	}
+	for _, p := range pins {
+		*p, _ = moved(*p)
+	}
+	next := 0
+	update := func(from, to int) {
+		for i := from; i < to; i++ {
+			if v, ok := movedValue(ctx.heap.at(i)); ok {
+				storeHeap(ctx, i, v)
+			}
+		}
+	}
+	for _, b := range ctx.freeBlocks {
+		update(next, b.start)
+		next = b.end()
+	}
+	update(next, ctx.heap.len())
+}
+
+// newCells stores values in fresh cells and returns the first: a block
+// when the collector is on, so it can be reclaimed, and otherwise cells
+// appended to the heap that are never freed.
+func newCells(ctx *RuntimeContext, values ...Literal) int {
+	if ctx.gc == nil {
+		ptr := ctx.heap.len()
+		for _, v := range values {
+			appendHeap(ctx, v)
+		}
+		return ptr
+	}
+	ptr := allocate(ctx, len(values))
+	for i, v := range values {
+		storeHeap(ctx, ptr+i, v)
+	}
+	return ptr
+}
+
+// newString stores s as a NUL-terminated string in fresh cells.
+func newString(ctx *RuntimeContext, s string) int {
+	values := make([]Literal, 0, len(s)+1)
+	for _, char := range s {
+		values = append(values, CharLiteral(char))
+	}
+	maybeCollect(ctx, len(values)+1)
+	return newCells(ctx, append(values, CharLiteral(0))...)
+}
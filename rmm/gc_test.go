@@ -0,0 +1,136 @@
+package rmm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGarbageCollector(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		reclaimed int64 // what native 101 pushed last
+		live      int
+	}{
+		{"unreachable", "push 4\nnative 4\npop\nnative 101\n", 4, 0},
+		{"on the stack", "push 4\nnative 4\nnative 101\n", 0, 4},
+		{"in a register", "push 2\nnative 4\nmov r0 top\nnative 101\n", 0, 2},
+		{"through a block", "push 1\nnative 4\npush 0\npush 1\nnative 4\nindex\nnative 101\n", 0, 2},
+		{"chain dropped", "push 1\nnative 4\npush 0\npush 1\nnative 4\nindex\npop\nnative 101\n", 2, 0},
+		{"ref", "push 5\nref\npop\nnative 101\n", 1, 0},
+		{"int_to_str", "push 123\nnative 99\npop\nnative 101\n", 4, 0},
+		{"string stack", "push 'a'\nmov_str\nnative 101\n", 0, 2},
+	}
+	for _, tt := range tests {
+		program, err := Compile(tt.source)
+		if err != nil {
+			t.Fatalf("%s: failed to compile: %v", tt.name, err)
+		}
+		machine := NewMachine(Options{GC: &GCOptions{}})
+		if err := machine.Run(context.Background(), program); err != nil {
+			t.Fatalf("%s: failed to run: %v", tt.name, err)
+		}
+		stack := machine.Stack()
+		if got := stack[len(stack)-1]; got.Type() != LiteralInt || got.Int() != tt.reclaimed {
+			t.Errorf("%s: expected %d cells reclaimed, got %v", tt.name, tt.reclaimed, got)
+		}
+		if stats := machine.HeapStats(); stats.Live != tt.live || stats.Collections != 1 {
+			t.Errorf("%s: expected %d live cells after one collection, got %+v", tt.name, tt.live, stats)
+		}
+	}
+}
+
+func TestGarbageCollectorOff(t *testing.T) {
+	program, err := Compile("push 4\nnative 4\npop\nnative 101\n")
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	machine := NewMachine(Options{})
+	if err := machine.Run(context.Background(), program); err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	if got := machine.Stack()[0]; got.Int() != 0 {
+		t.Errorf("expected gc to reclaim nothing with the collector off, got %v", got)
+	}
+	if stats := machine.HeapStats(); stats.Live != 4 || stats.Collections != 0 {
+		t.Errorf("expected the block to stay allocated, got %+v", stats)
+	}
+}
+
+func TestGarbageCollectorCompacts(t *testing.T) {
+	// The second block moves down over the first, unreachable one; the
+	// pointers on the stack and in r1 follow it.
+	source := "push 2\nnative 4\npop\npush 3\nnative 4\npush 1\npush 'z'\nindex\ndup\nmov r1 top\nnative 101\n"
+	program, err := Compile(source)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	machine := NewMachine(Options{GC: &GCOptions{Compact: true}})
+	if err := machine.Run(context.Background(), program); err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	if ptr := machine.Stack()[0]; ptr.Type() != LiteralPointer || ptr.Pointer() != 0 {
+		t.Errorf("expected the stack pointer to move to 0, got %v", ptr)
+	}
+	if ptr := machine.Registers()[1]; ptr.Type() != LiteralPointer || ptr.Pointer() != 0 {
+		t.Errorf("expected r1 to move to 0, got %v", ptr)
+	}
+	if heap := machine.Heap(); len(heap) != 3 || heap[1].Char() != 'z' {
+		t.Errorf("expected the block's 3 cells alone on the heap, got %v", heap)
+	}
+	if stats := machine.HeapStats(); stats.Free != 0 || stats.Live != 3 {
+		t.Errorf("expected no free cells after compaction, got %+v", stats)
+	}
+}
+
+func TestGarbageCollectorTriggers(t *testing.T) {
+	source := strings.Repeat("push 10\nnative 4\npop\n", 20)
+	program, err := Compile(source)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	limits := Limits{MaxHeap: 50}
+	err = NewMachine(Options{Limits: limits}).Run(context.Background(), program)
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.Kind != KindOutOfMemory {
+		t.Fatalf("expected to run out of memory without the collector, got %v", err)
+	}
+
+	// Running out of room triggers a collection before the threshold does.
+	machine := NewMachine(Options{Limits: limits, GC: &GCOptions{}})
+	if err := machine.Run(context.Background(), program); err != nil {
+		t.Fatalf("failed to run with the collector: %v", err)
+	}
+	if stats := machine.HeapStats(); stats.Cells > 50 || stats.Collections == 0 {
+		t.Errorf("expected collections to keep the heap within 50 cells, got %+v", stats)
+	}
+
+	// The threshold triggers one every 30 cells allocated.
+	machine = NewMachine(Options{GC: &GCOptions{Threshold: 30}})
+	if err := machine.Run(context.Background(), program); err != nil {
+		t.Fatalf("failed to run with a threshold: %v", err)
+	}
+	if stats := machine.HeapStats(); stats.Collections != 6 || stats.Cells != 30 {
+		t.Errorf("expected 6 collections and a 30-cell heap, got %+v", stats)
+	}
+}
+
+func TestGarbageCollectorRealloc(t *testing.T) {
+	// The block being resized is off the stack while realloc runs; the
+	// collection realloc triggers must keep it, and compaction must carry
+	// its contents along.
+	source := "push 4\nnative 4\npop\npush 1\nnative 4\npush 0\npush 'q'\nindex\npush 8\nnative 5\nderef\n"
+	program, err := Compile(source)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	machine := NewMachine(Options{GC: &GCOptions{Threshold: 1, Compact: true}})
+	if err := machine.Run(context.Background(), program); err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	if got := machine.Stack()[0]; got.Char() != 'q' {
+		t.Errorf("expected realloc to keep the block's contents, got %v", got)
+	}
+}
@@ -0,0 +1,403 @@
+package rmm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ControlFlowGraph is a program split into basic blocks, runs of
+// instructions entered only at the top and left only at the bottom, with
+// the functions they make up and the calls between them. A block starts
+// at a label or at the target of a jump or call, and ends at a jump, call,
+// ret or halt.
+type ControlFlowGraph struct {
+	// Blocks lists the basic blocks in program order.
+	Blocks []*Block
+	// Functions lists the entrypoint and then the targets of call in
+	// program order.
+	Functions []*GraphFunction
+	// Calls lists the calls by where they are, in program order.
+	Calls []*CallEdge
+}
+
+// Block is a basic block.
+type Block struct {
+	ID int `json:"id"`
+	// Start is the index of the first instruction and End that of the one
+	// after the last.
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Label string `json:"label,omitempty"`
+	// Function is the function the block belongs to, empty if no path
+	// from the entrypoint or a call reaches it.
+	Function string       `json:"function,omitempty"`
+	Lines    []BlockLine  `json:"lines"`
+	Edges    []*BlockEdge `json:"edges,omitempty"`
+}
+
+// BlockLine is an instruction of a block as written in the source; the
+// two parts of a superinstruction each have their own.
+type BlockLine struct {
+	IP   int    `json:"ip"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// BlockEdge is a way out of a block: "jump" for jmp, "taken" and
+// "fallthrough" for a conditional jump, "return" from a call to the
+// instruction after it, and "fallthrough" into a label.
+type BlockEdge struct {
+	To   int    `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// GraphFunction is the entrypoint or a target of call, with the blocks it
+// reaches without going through another call.
+type GraphFunction struct {
+	Name   string `json:"name"`
+	IP     int    `json:"ip"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Blocks []int  `json:"blocks"`
+}
+
+// CallEdge is a call from one function to another.
+type CallEdge struct {
+	Caller string `json:"caller"`
+	Callee string `json:"callee"`
+	IP     int    `json:"ip"`
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+}
+
+// staticTarget returns the target of the jump or call at ip, if it has a
+// valid one.
+func staticTarget(p *Program, ip int) (int, bool) {
+	instr := p.instructions[ip]
+	if !isJumpInstruction(instr.instructionType) || instr.value.Type() != LiteralInt {
+		return 0, false
+	}
+	target := instr.value.Int()
+	if target < 0 || target >= int64(len(p.instructions)) {
+		return 0, false
+	}
+	return int(target), true
+}
+
+// successors returns the instructions that can run after the one at ip
+// within its function, taking a call to return.
+func successors(p *Program, ip int) []int {
+	instr := p.instructions[ip]
+	switch instr.instructionType {
+	case InstructionRet, InstructionHalt:
+		return nil
+	case InstructionJmp:
+		if target, ok := staticTarget(p, ip); ok {
+			return []int{target}
+		}
+		return nil
+	case InstructionZjmp, InstructionNzjmp, InstructionCmpeZjmp, InstructionCmpeNzjmp:
+		if target, ok := staticTarget(p, ip); ok {
+			return []int{target, ip + 1}
+		}
+	case InstructionNative:
+		if instr.value.Int() == nativeExitID {
+			return nil
+		}
+	}
+	return []int{ip + 1}
+}
+
+// functionBody marks the instructions reachable from a function's entry
+// without going through another function.
+func functionBody(p *Program, entry int) []bool {
+	reached := make([]bool, len(p.instructions))
+	reached[entry] = true
+	work := []int{entry}
+	for len(work) > 0 {
+		ip := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, next := range successors(p, ip) {
+			if next < len(reached) && !reached[next] {
+				reached[next] = true
+				work = append(work, next)
+			}
+		}
+	}
+	return reached
+}
+
+// endsBlock reports whether a block ends after an instruction of the type.
+func endsBlock(t InstructionSet) bool {
+	return isJumpInstruction(t) || t == InstructionRet || t == InstructionHalt
+}
+
+// ControlFlowGraph splits the program into basic blocks. It fails if a
+// jump goes outside the program.
+func (p *Program) ControlFlowGraph() (*ControlFlowGraph, error) {
+	labels, err := disassemblyLabels(p)
+	if err != nil {
+		return nil, err
+	}
+	g := &ControlFlowGraph{}
+	n := len(p.instructions)
+
+	leaders := make([]bool, n+1)
+	leaders[0], leaders[n] = true, true
+	for target := range labels.definitions {
+		if target >= 0 && target <= n {
+			leaders[target] = true
+		}
+	}
+	for ip, instr := range p.instructions {
+		if endsBlock(instr.instructionType) {
+			leaders[ip+1] = true
+		}
+	}
+	blockAt := make([]int, n)
+	for start := 0; start < n; {
+		end := start + 1
+		for !leaders[end] {
+			end++
+		}
+		block := &Block{ID: len(g.Blocks), Start: start, End: end}
+		if names := labels.definitions[start]; len(names) > 0 {
+			block.Label = names[0]
+		}
+		for ip := start; ip < end; ip++ {
+			blockAt[ip] = block.ID
+			for _, part := range p.instructions[ip].parts() {
+				block.Lines = append(block.Lines, BlockLine{IP: ip, File: part.fileName, Line: part.line, Text: lineText(part, labels)})
+			}
+		}
+		g.Blocks = append(g.Blocks, block)
+		start = end
+	}
+
+	// Functions, and the blocks each reaches. A block reached from more
+	// than one belongs to the first.
+	stacks := newStackVerifier(p)
+	entries := stacks.entries
+	if n > 0 && p.entrypoint >= 0 && p.entrypoint < n {
+		entries = append([]int{p.entrypoint}, entries...)
+	}
+	seen := make(map[int]bool)
+	for _, entry := range entries {
+		if seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		instr := p.instructions[entry]
+		fn := &GraphFunction{Name: labels.reference(entry), IP: entry, File: instr.fileName, Line: instr.line}
+		body := functionBody(p, entry)
+		for _, block := range g.Blocks {
+			if body[block.Start] {
+				fn.Blocks = append(fn.Blocks, block.ID)
+				if block.Function == "" {
+					block.Function = fn.Name
+				}
+			}
+		}
+		g.Functions = append(g.Functions, fn)
+	}
+
+	for _, block := range g.Blocks {
+		last := block.End - 1
+		instr := p.instructions[last]
+		next := successors(p, last)
+		switch instr.instructionType {
+		case InstructionCall:
+			target, ok := staticTarget(p, last)
+			if !ok {
+				break
+			}
+			if block.Function != "" {
+				part := instr.parts()[len(instr.parts())-1]
+				g.Calls = append(g.Calls, &CallEdge{
+					Caller: block.Function,
+					Callee: labels.reference(target),
+					IP:     last,
+					File:   part.fileName,
+					Line:   part.line,
+				})
+			}
+			if stacks.functions[target].returns && block.End < n {
+				block.Edges = append(block.Edges, &BlockEdge{To: blockAt[block.End], Kind: "return"})
+			}
+		case InstructionJmp:
+			for _, to := range next {
+				block.Edges = append(block.Edges, &BlockEdge{To: blockAt[to], Kind: "jump"})
+			}
+		case InstructionZjmp, InstructionNzjmp, InstructionCmpeZjmp, InstructionCmpeNzjmp:
+			if len(next) == 2 {
+				block.Edges = append(block.Edges, &BlockEdge{To: blockAt[next[0]], Kind: "taken"})
+			}
+			if block.End < n {
+				block.Edges = append(block.Edges, &BlockEdge{To: blockAt[block.End], Kind: "fallthrough"})
+			}
+		default:
+			if len(next) > 0 && block.End < n {
+				block.Edges = append(block.Edges, &BlockEdge{To: blockAt[block.End], Kind: "fallthrough"})
+			}
+		}
+	}
+	return g, nil
+}
+
+// lineText is the source text of an instruction, with a native's name.
+func lineText(instr Instruction, labels disasmLabels) string {
+	text, err := disassembleInstruction(instr, labels)
+	if err != nil {
+		return strings.ToLower(instr.instructionType.String())
+	}
+	if instr.instructionType == InstructionNative {
+		if native, ok := nativeSignatures[instr.value.Int()]; ok {
+			text += " ; " + native.Name
+		}
+	}
+	return text
+}
+
+// WriteJSON writes the blocks and the functions they make up.
+func (g *ControlFlowGraph) WriteJSON(w io.Writer) error {
+	return writeGraphJSON(w, struct {
+		Functions []*GraphFunction `json:"functions"`
+		Blocks    []*Block         `json:"blocks"`
+	}{nonNil(g.Functions), nonNil(g.Blocks)})
+}
+
+// WriteCallGraphJSON writes the functions and the calls between them.
+func (g *ControlFlowGraph) WriteCallGraphJSON(w io.Writer) error {
+	type function struct {
+		Name string `json:"name"`
+		IP   int    `json:"ip"`
+		File string `json:"file"`
+		Line int    `json:"line"`
+	}
+	functions := []function{}
+	for _, fn := range g.Functions {
+		functions = append(functions, function{fn.Name, fn.IP, fn.File, fn.Line})
+	}
+	return writeGraphJSON(w, struct {
+		Functions []function  `json:"functions"`
+		Calls     []*CallEdge `json:"calls"`
+	}{functions, nonNil(g.Calls)})
+}
+
+func writeGraphJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// nonNil makes an empty list encode as [] rather than null.
+func nonNil[T any](list []T) []T {
+	if list == nil {
+		return []T{}
+	}
+	return list
+}
+
+// WriteDOT writes the blocks as a Graphviz digraph, one cluster per
+// function, each block listing its source lines.
+func (g *ControlFlowGraph) WriteDOT(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph cfg {\n\tnode [shape=box, fontname=\"monospace\"];\n")
+	clustered := make(map[int]bool)
+	for i, fn := range g.Functions {
+		fmt.Fprintf(&sb, "\tsubgraph cluster_%d {\n\t\tlabel=%s;\n", i, dotQuote(fmt.Sprintf("%s (%s:%d)", fn.Name, fn.File, fn.Line)))
+		for _, id := range fn.Blocks {
+			if g.Blocks[id].Function == fn.Name {
+				fmt.Fprintf(&sb, "\t\t%s;\n", blockNode(g.Blocks[id]))
+				clustered[id] = true
+			}
+		}
+		sb.WriteString("\t}\n")
+	}
+	for _, block := range g.Blocks {
+		if !clustered[block.ID] {
+			// No path reaches it.
+			fmt.Fprintf(&sb, "\t%s [style=dashed];\n", blockNode(block))
+		}
+	}
+	for _, block := range g.Blocks {
+		for _, edge := range block.Edges {
+			fmt.Fprintf(&sb, "\tb%d -> b%d", block.ID, edge.To)
+			switch edge.Kind {
+			case "taken", "return":
+				fmt.Fprintf(&sb, " [label=%q]", edge.Kind)
+			}
+			sb.WriteString(";\n")
+		}
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// blockNode is a block's node statement, labelled with its source lines.
+func blockNode(block *Block) string {
+	var label strings.Builder
+	if block.Label != "" {
+		label.WriteString(block.Label + ":\n")
+	}
+	file := ""
+	for _, line := range block.Lines {
+		if line.File != file {
+			// The first line, and any after a macro or import switches
+			// file, names it.
+			file = line.File
+			fmt.Fprintf(&label, "%s:%d  %s\n", line.File, line.Line, line.Text)
+			continue
+		}
+		fmt.Fprintf(&label, "%d  %s\n", line.Line, line.Text)
+	}
+	return fmt.Sprintf("b%d [label=%s]", block.ID, dotQuote(label.String()))
+}
+
+// WriteCallGraphDOT writes the functions and the calls between them as a
+// Graphviz digraph, each call labelled with the lines it is made from.
+func (g *ControlFlowGraph) WriteCallGraphDOT(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph calls {\n\tnode [shape=box];\n")
+	ids := make(map[string]int)
+	for i, fn := range g.Functions {
+		ids[fn.Name] = i
+		fmt.Fprintf(&sb, "\tf%d [label=%s];\n", i, dotQuote(fmt.Sprintf("%s\n%s:%d", fn.Name, fn.File, fn.Line)))
+	}
+	type pair struct{ caller, callee int }
+	var pairs []pair
+	sites := make(map[pair][]string)
+	for _, call := range g.Calls {
+		key := pair{ids[call.Caller], ids[call.Callee]}
+		if _, ok := sites[key]; !ok {
+			pairs = append(pairs, key)
+		}
+		sites[key] = append(sites[key], fmt.Sprintf("%s:%d", call.File, call.Line))
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].caller != pairs[j].caller {
+			return pairs[i].caller < pairs[j].caller
+		}
+		return pairs[i].callee < pairs[j].callee
+	})
+	for _, key := range pairs {
+		fmt.Fprintf(&sb, "\tf%d -> f%d [label=%s];\n", key.caller, key.callee, dotQuote(strings.Join(sites[key], "\n")))
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// dotQuote quotes a DOT label, left-aligning each line.
+func dotQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+	if !strings.HasSuffix(s, "\n") {
+		return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+	}
+	return `"` + strings.ReplaceAll(s, "\n", `\l`) + `"`
+}
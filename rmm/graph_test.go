@@ -0,0 +1,131 @@
+package rmm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const graphSource = `entrypoint main
+square:
+	dup
+	mul
+	ret
+main:
+	push 3
+	call square
+	dup
+	push 10
+	cmpl
+	zjmp big
+	push 1
+	add
+big:
+	print
+	halt
+`
+
+func TestControlFlowGraph(t *testing.T) {
+	program, err := Compile(graphSource)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	g, err := program.ControlFlowGraph()
+	if err != nil {
+		t.Fatalf("failed to build the graph: %v", err)
+	}
+	var blocks []string
+	for _, block := range g.Blocks {
+		var edges []string
+		for _, edge := range block.Edges {
+			edges = append(edges, fmt.Sprintf("%s b%d", edge.Kind, edge.To))
+		}
+		blocks = append(blocks, fmt.Sprintf("b%d %d-%d %s in %s -> %s", block.ID, block.Start, block.End, block.Label, block.Function, strings.Join(edges, ", ")))
+	}
+	want := []string{
+		"b0 0-3 square in square -> ",
+		"b1 3-5 main in main -> return b2",
+		"b2 5-9  in main -> taken b4, fallthrough b3",
+		"b3 9-11  in main -> fallthrough b4",
+		"b4 11-13 big in main -> ",
+	}
+	if strings.Join(blocks, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected blocks\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(blocks, "\n"))
+	}
+	if len(g.Functions) != 2 || g.Functions[0].Name != "main" || g.Functions[1].Name != "square" || g.Functions[1].Line != 3 {
+		t.Errorf("expected main and square, got %+v", g.Functions)
+	}
+	if len(g.Calls) != 1 || *g.Calls[0] != (CallEdge{Caller: "main", Callee: "square", IP: 4, File: "main.rmm", Line: 8}) {
+		t.Errorf("expected main to call square on line 8, got %+v", g.Calls)
+	}
+	if line := g.Blocks[2].Lines[3]; line.Line != 12 || line.Text != "zjmp big" {
+		t.Errorf("expected zjmp big on line 12, got %+v", line)
+	}
+}
+
+func TestControlFlowGraphFused(t *testing.T) {
+	program, err := Compile(graphSource)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	g, err := program.Optimize(2).ControlFlowGraph()
+	if err != nil {
+		t.Fatalf("failed to build the graph: %v", err)
+	}
+	// push 1; add is one addi, listed as both lines.
+	block := g.Blocks[3]
+	if block.End-block.Start != 1 || len(block.Lines) != 2 || block.Lines[0].Line != 13 || block.Lines[1].Text != "add" {
+		t.Errorf("expected one addi on lines 13 and 14, got %+v", block)
+	}
+}
+
+func TestWriteGraph(t *testing.T) {
+	program, err := Compile(graphSource)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	g, err := program.ControlFlowGraph()
+	if err != nil {
+		t.Fatalf("failed to build the graph: %v", err)
+	}
+
+	var dot bytes.Buffer
+	if err := g.WriteDOT(&dot); err != nil {
+		t.Fatalf("failed to write DOT: %v", err)
+	}
+	for _, line := range []string{
+		"\tsubgraph cluster_1 {\n\t\tlabel=\"square (main.rmm:3)\";\n",
+		"\t\tb0 [label=\"square:\\lmain.rmm:3  dup\\l4  mul\\l5  ret\\l\"];\n",
+		"\tb2 -> b4 [label=\"taken\"];\n",
+		"\tb2 -> b3;\n",
+	} {
+		if !strings.Contains(dot.String(), line) {
+			t.Errorf("expected %q in\n%s", line, dot.String())
+		}
+	}
+
+	var calls bytes.Buffer
+	if err := g.WriteCallGraphDOT(&calls); err != nil {
+		t.Fatalf("failed to write the call graph: %v", err)
+	}
+	if !strings.Contains(calls.String(), "\tf0 -> f1 [label=\"main.rmm:8\"];\n") {
+		t.Errorf("expected the call from main to square, got\n%s", calls.String())
+	}
+
+	var cfg struct {
+		Functions []GraphFunction `json:"functions"`
+		Blocks    []Block         `json:"blocks"`
+	}
+	var out bytes.Buffer
+	if err := g.WriteJSON(&out); err != nil {
+		t.Fatalf("failed to write JSON: %v", err)
+	}
+	if err := json.Unmarshal(out.Bytes(), &cfg); err != nil {
+		t.Fatalf("failed to decode %s: %v", out.String(), err)
+	}
+	if len(cfg.Blocks) != 5 || len(cfg.Functions) != 2 || cfg.Blocks[1].Edges[0].Kind != "return" {
+		t.Errorf("expected 5 blocks in 2 functions, got %s", out.String())
+	}
+}
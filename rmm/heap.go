@@ -0,0 +1,246 @@
+package rmm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The allocator behind malloc, realloc and free. Allocations are runs of
+// heap cells addressed by the index of their first cell, as before;
+// allocations maps each one's start to its size. Freed runs go on a free
+// list, sorted by address with neighbouring runs merged, and are reused
+// first-fit before the heap grows. A zero-size allocation still takes a
+// cell, so that each allocation has its own address. Cells ref and the
+// program's strings take are outside the allocator and never freed.
+
+// heapBlock is a run of free cells.
+type heapBlock struct {
+	start, size int
+}
+
+func (b heapBlock) end() int {
+	return b.start + b.size
+}
+
+// HeapStats describes the heap and the allocator's use of it, in cells.
+type HeapStats struct {
+	Cells       int // the heap's size
+	Live        int // cells in live allocations
+	Allocations int // live allocations
+	Peak        int // most cells live at once
+	Free        int // cells on the free list
+	FreeBlocks  int
+	LargestFree int // size of the largest free block
+	Collections int // garbage collections run
+	Collected   int // cells the collections reclaimed
+}
+
+// Fragmentation is the share of free cells outside the largest free block:
+// 0 when free space is one block, approaching 1 as it splinters.
+func (s HeapStats) Fragmentation() float64 {
+	if s.Free == 0 {
+		return 0
+	}
+	return 1 - float64(s.LargestFree)/float64(s.Free)
+}
+
+func (s HeapStats) String() string {
+	str := fmt.Sprintf("heap: %d cells, %d live in %d allocations (peak %d), %d free in %d blocks, %.1f%% fragmented",
+		s.Cells, s.Live, s.Allocations, s.Peak, s.Free, s.FreeBlocks, 100*s.Fragmentation())
+	if s.Collections > 0 {
+		str += fmt.Sprintf(", %d collections reclaimed %d cells", s.Collections, s.Collected)
+	}
+	return str
+}
+
+// HeapStats returns the allocator's statistics.
+func (m *Machine) HeapStats() HeapStats {
+	stats := HeapStats{
+		Cells:       m.heap.len(),
+		Live:        m.liveCells,
+		Allocations: len(m.allocations),
+		Peak:        m.peakCells,
+		FreeBlocks:  len(m.freeBlocks),
+		Collections: m.collections,
+		Collected:   m.collected,
+	}
+	for _, b := range m.freeBlocks {
+		stats.Free += b.size
+		stats.LargestFree = max(stats.LargestFree, b.size)
+	}
+	return stats
+}
+
+// footprint is the number of cells an allocation of size cells takes.
+func footprint(size int) int {
+	return max(size, 1)
+}
+
+// allocate returns the start of size zeroed cells.
+func allocate(ctx *RuntimeContext, size int) int {
+	size = max(size, 0)
+	cells := footprint(size)
+	ptr, ok := takeFree(ctx, cells)
+	if !ok {
+		ptr = growHeap(ctx, cells)
+	}
+	ctx.allocations[ptr] = size
+	ctx.liveCells += cells
+	ctx.peakCells = max(ctx.peakCells, ctx.liveCells)
+	ctx.sinceGC += cells
+	if ctx.memcheck != nil {
+		ctx.memcheck.alloc(ptr, size, ctx.ip)
+	}
+	return ptr
+}
+
+// takeFree claims size cells from the first free block large enough.
+func takeFree(ctx *RuntimeContext, size int) (int, bool) {
+	for i, b := range ctx.freeBlocks {
+		if b.size < size {
+			continue
+		}
+		if b.size == size {
+			ctx.freeBlocks = append(ctx.freeBlocks[:i], ctx.freeBlocks[i+1:]...)
+		} else {
+			ctx.freeBlocks[i] = heapBlock{b.start + size, b.size - size}
+		}
+		zeroCells(ctx, b.start, b.start+size)
+		return b.start, true
+	}
+	return 0, false
+}
+
+// growHeap claims size cells at the end of the heap, starting inside the
+// free block that ends it, if there is one.
+func growHeap(ctx *RuntimeContext, size int) int {
+	ptr := ctx.heap.len()
+	n := len(ctx.freeBlocks)
+	if n > 0 && ctx.freeBlocks[n-1].end() == ptr {
+		ptr = ctx.freeBlocks[n-1].start
+	}
+	checkHeapRoom(ctx, ptr+size-ctx.heap.len())
+	if ptr < ctx.heap.len() {
+		ctx.freeBlocks = ctx.freeBlocks[:n-1]
+		zeroCells(ctx, ptr, ctx.heap.len())
+	}
+	for ctx.heap.len() < ptr+size {
+		appendHeap(ctx, CharLiteral(0))
+	}
+	return ptr
+}
+
+// zeroCells clears reused cells, as malloc hands out zeroed memory.
+func zeroCells(ctx *RuntimeContext, from, to int) {
+	for i := from; i < to; i++ {
+		storeHeap(ctx, i, CharLiteral(0))
+	}
+}
+
+// release puts cells on the free list, merging them with free neighbours.
+func release(ctx *RuntimeContext, start, size int) {
+	if size <= 0 {
+		return
+	}
+	blocks := ctx.freeBlocks
+	i := sort.Search(len(blocks), func(i int) bool { return blocks[i].start >= start })
+	b := heapBlock{start, size}
+	if i < len(blocks) && b.end() == blocks[i].start {
+		b.size += blocks[i].size
+		blocks = append(blocks[:i], blocks[i+1:]...)
+	}
+	if i > 0 && blocks[i-1].end() == b.start {
+		blocks[i-1].size += b.size
+	} else {
+		blocks = append(blocks, heapBlock{})
+		copy(blocks[i+1:], blocks[i:])
+		blocks[i] = b
+	}
+	ctx.freeBlocks = blocks
+}
+
+// freeAllocation frees the allocation starting at ptr.
+func freeAllocation(ctx *RuntimeContext, ptr int) {
+	size, ok := ctx.allocations[ptr]
+	if !ok {
+		message := "double free or invalid heap pointer"
+		if ctx.memcheck != nil {
+			if b := ctx.memcheck.freed(ptr); b != nil {
+				message = fmt.Sprintf("double free of the block at %d allocated at %s and freed at %s",
+					ptr, ctx.memcheck.site(b.allocIP), ctx.memcheck.site(b.freeIP))
+			}
+		}
+		panic(ctx.Error(KindSegfault, message))
+	}
+	delete(ctx.allocations, ptr)
+	ctx.liveCells -= footprint(size)
+	release(ctx, ptr, footprint(size))
+	if ctx.memcheck != nil {
+		ctx.memcheck.free(ptr, ctx.ip)
+	}
+}
+
+// reallocate resizes the allocation at ptr, in place when the cells after
+// it are free or it ends the heap, and returns its start.
+func reallocate(ctx *RuntimeContext, ptr, size int) int {
+	oldSize, ok := ctx.allocations[ptr]
+	if !ok {
+		panic(ctx.Error(KindSegfault, "realloc: invalid heap pointer"))
+	}
+	size = max(size, 0)
+	if size > oldSize {
+		maybeCollect(ctx, size, &ptr)
+	}
+	oldCells, cells := footprint(oldSize), footprint(size)
+	end := ptr + oldCells
+	switch extra := cells - oldCells; {
+	case extra <= 0:
+		release(ctx, ptr+cells, -extra)
+	case growInPlace(ctx, end, extra):
+	default:
+		newPtr := allocate(ctx, size)
+		for i := 0; i < oldSize; i++ {
+			storeHeap(ctx, newPtr+i, ctx.heap.at(ptr+i))
+		}
+		if ctx.memcheck != nil {
+			ctx.memcheck.move(ptr, newPtr, oldSize)
+		}
+		freeAllocation(ctx, ptr)
+		return newPtr
+	}
+	ctx.allocations[ptr] = size
+	ctx.liveCells += cells - oldCells
+	ctx.peakCells = max(ctx.peakCells, ctx.liveCells)
+	if ctx.memcheck != nil {
+		ctx.memcheck.resize(ptr, size)
+	}
+	return ptr
+}
+
+// growInPlace claims extra zeroed cells starting at end, from a free block
+// that starts there and by growing the heap if that block ends it.
+func growInPlace(ctx *RuntimeContext, end, extra int) bool {
+	blocks := ctx.freeBlocks
+	i := sort.Search(len(blocks), func(i int) bool { return blocks[i].start >= end })
+	avail := 0
+	if i < len(blocks) && blocks[i].start == end {
+		avail = blocks[i].size
+	}
+	if avail < extra && end+avail != ctx.heap.len() {
+		return false
+	}
+	if avail < extra {
+		checkHeapRoom(ctx, extra-avail)
+	}
+	switch {
+	case avail > extra:
+		blocks[i] = heapBlock{end + extra, avail - extra}
+	case avail > 0:
+		ctx.freeBlocks = append(blocks[:i], blocks[i+1:]...)
+	}
+	zeroCells(ctx, end, end+min(avail, extra))
+	for ctx.heap.len() < end+extra {
+		appendHeap(ctx, CharLiteral(0))
+	}
+	return true
+}
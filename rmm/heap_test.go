@@ -0,0 +1,146 @@
+package rmm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestHeapAllocator(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		stack  []int64 // pointers left on the stack
+		stats  HeapStats
+	}{
+		{
+			name:   "reuse after free",
+			source: "push 4\nnative 4\nnative 6\npush 4\nnative 4\n",
+			stack:  []int64{0},
+			stats:  HeapStats{Cells: 4, Live: 4, Allocations: 1, Peak: 4},
+		},
+		{
+			name:   "coalesce neighbours",
+			source: "push 2\nnative 4\npush 2\nnative 4\npush 2\nnative 4\nswap\nnative 6\nswap\nnative 6\npush 4\nnative 4\n",
+			stack:  []int64{4, 0},
+			stats:  HeapStats{Cells: 6, Live: 6, Allocations: 2, Peak: 6},
+		},
+		{
+			name:   "fragmented",
+			source: "push 2\nnative 4\npush 2\nnative 4\npush 2\nnative 4\nnative 6\nswap\nnative 6\n",
+			stack:  []int64{2},
+			stats:  HeapStats{Cells: 6, Live: 2, Allocations: 1, Peak: 6, Free: 4, FreeBlocks: 2, LargestFree: 2},
+		},
+		{
+			name:   "grow into free tail",
+			source: "push 2\nnative 4\nnative 6\npush 5\nnative 4\n",
+			stack:  []int64{0},
+			stats:  HeapStats{Cells: 5, Live: 5, Allocations: 1, Peak: 5},
+		},
+		{
+			name:   "realloc at heap end",
+			source: "push 2\nnative 4\npush 5\nnative 5\n",
+			stack:  []int64{0},
+			stats:  HeapStats{Cells: 5, Live: 5, Allocations: 1, Peak: 5},
+		},
+		{
+			name:   "realloc into free neighbour",
+			source: "push 2\nnative 4\npush 2\nnative 4\nnative 6\npush 3\nnative 5\n",
+			stack:  []int64{0},
+			stats:  HeapStats{Cells: 4, Live: 3, Allocations: 1, Peak: 4, Free: 1, FreeBlocks: 1, LargestFree: 1},
+		},
+		{
+			name:   "realloc moves",
+			source: "push 2\nnative 4\npush 2\nnative 4\nswap\npush 4\nnative 5\n",
+			stack:  []int64{2, 4},
+			stats:  HeapStats{Cells: 8, Live: 6, Allocations: 2, Peak: 8, Free: 2, FreeBlocks: 1, LargestFree: 2},
+		},
+		{
+			name:   "realloc shrinks",
+			source: "push 4\nnative 4\npush 1\nnative 5\n",
+			stack:  []int64{0},
+			stats:  HeapStats{Cells: 4, Live: 1, Allocations: 1, Peak: 4, Free: 3, FreeBlocks: 1, LargestFree: 3},
+		},
+		{
+			name:   "zero size",
+			source: "push 0\nnative 4\npush 4\nnative 4\nswap\nnative 6\n",
+			stack:  []int64{1},
+			stats:  HeapStats{Cells: 5, Live: 4, Allocations: 1, Peak: 5, Free: 1, FreeBlocks: 1, LargestFree: 1},
+		},
+		{
+			name:   "realloc to zero",
+			source: "push 4\nnative 4\npush 0\nnative 5\npush 2\nnative 4\n",
+			stack:  []int64{0, 1},
+			stats:  HeapStats{Cells: 4, Live: 3, Allocations: 2, Peak: 4, Free: 1, FreeBlocks: 1, LargestFree: 1},
+		},
+	}
+	for _, tt := range tests {
+		program, err := Compile(tt.source)
+		if err != nil {
+			t.Fatalf("%s: failed to compile: %v", tt.name, err)
+		}
+		machine := NewMachine(Options{})
+		if err := machine.Run(context.Background(), program); err != nil {
+			t.Fatalf("%s: failed to run: %v", tt.name, err)
+		}
+		stack := machine.Stack()
+		if len(stack) != len(tt.stack) {
+			t.Fatalf("%s: expected %d pointers on the stack, got %v", tt.name, len(tt.stack), stack)
+		}
+		for i, ptr := range tt.stack {
+			if stack[i].Type() != LiteralPointer || stack[i].Pointer() != ptr {
+				t.Errorf("%s: expected pointer %d at stack[%d], got %v", tt.name, ptr, i, stack[i])
+			}
+		}
+		if stats := machine.HeapStats(); stats != tt.stats {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.stats, stats)
+		}
+	}
+}
+
+func TestHeapReuseIsZeroed(t *testing.T) {
+	// The freed cells hold a copy of the string; malloc must clear them.
+	source := "push_str \"ab\"\npush 3\nnative 4\nget_str 0\nnative 91\nnative 6\npush 3\nnative 4\n"
+	program, err := Compile(source)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	machine := NewMachine(Options{})
+	if err := machine.Run(context.Background(), program); err != nil {
+		t.Fatalf("failed to run: %v", err)
+	}
+	ptr := int(machine.Stack()[0].Pointer())
+	heap := machine.Heap()
+	for i := ptr; i < ptr+3; i++ {
+		if heap[i].Char() != 0 {
+			t.Errorf("expected cell %d to be zeroed, got %v", i, heap[i])
+		}
+	}
+}
+
+func TestHeapFragmentation(t *testing.T) {
+	stats := HeapStats{Free: 4, LargestFree: 1}
+	if got := stats.Fragmentation(); got != 0.75 {
+		t.Errorf("expected fragmentation 0.75, got %v", got)
+	}
+	if got := (HeapStats{}).Fragmentation(); got != 0 {
+		t.Errorf("expected no fragmentation without free cells, got %v", got)
+	}
+}
+
+func TestHeapInvalidFree(t *testing.T) {
+	for _, source := range []string{
+		"push 1\nnative 4\ndup\nnative 6\nnative 6\n",
+		"push 1\nnative 4\ndup\nnative 6\npush 2\nnative 5\n",
+	} {
+		program, err := Compile(source)
+		if err != nil {
+			t.Fatalf("failed to compile: %v", err)
+		}
+		err = NewMachine(Options{}).Run(context.Background(), program)
+		var rtErr *RuntimeError
+		if !errors.As(err, &rtErr) || rtErr.Kind != KindSegfault {
+			t.Errorf("%q: expected a segfault, got %v", source, err)
+		}
+	}
+}
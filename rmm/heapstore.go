@@ -0,0 +1,151 @@
+package rmm
+
+// heapStore holds the machine's heap cells in pages of heapPageCells. A page
+// that holds only characters below 256 keeps a byte per cell, so string data
+// costs a byte a character rather than a 16-byte Literal; the first store of
+// anything else widens the page to Literal cells. Every address still names
+// one cell whichever kind of page it is in, so pointers and pointer
+// arithmetic are the same over strings and numbers.
+type heapStore struct {
+	pages []heapPage
+	size  int
+}
+
+// heapPage is a page of cells: chars while it holds only characters, cells
+// once it has been widened.
+type heapPage struct {
+	chars []byte
+	cells []Literal
+}
+
+const (
+	heapPageShift = 8
+	heapPageCells = 1 << heapPageShift
+	heapPageMask  = heapPageCells - 1
+)
+
+// newHeapStore returns a heap holding a copy of cells.
+func newHeapStore(cells []Literal) heapStore {
+	var s heapStore
+	for _, v := range cells {
+		s.push(v)
+	}
+	return s
+}
+
+// isByteChar reports whether v fits a byte page.
+func isByteChar(v Literal) bool {
+	return v.valueType == LiteralChar && v.bits < heapPageCells
+}
+
+func (s *heapStore) len() int {
+	return s.size
+}
+
+// at returns cell i, which must be below len.
+func (s *heapStore) at(i int) Literal {
+	p := &s.pages[i>>heapPageShift]
+	if p.cells != nil {
+		return p.cells[i&heapPageMask]
+	}
+	return Literal{bits: uint64(p.chars[i&heapPageMask]), valueType: LiteralChar}
+}
+
+// set writes cell i, which must be below len, widening its page if v is not
+// a byte character.
+func (s *heapStore) set(i int, v Literal) {
+	p := &s.pages[i>>heapPageShift]
+	if p.cells != nil {
+		p.cells[i&heapPageMask] = v
+		return
+	}
+	if isByteChar(v) {
+		p.chars[i&heapPageMask] = byte(v.bits)
+		return
+	}
+	p.widen()
+	p.cells[i&heapPageMask] = v
+}
+
+// push adds a cell to the end of the heap.
+func (s *heapStore) push(v Literal) {
+	if s.size == len(s.pages)<<heapPageShift {
+		s.pages = append(s.pages, heapPage{chars: make([]byte, heapPageCells)})
+	}
+	s.size++
+	s.set(s.size-1, v)
+}
+
+// truncate shrinks the heap to n cells, dropping the pages past the end.
+func (s *heapStore) truncate(n int) {
+	pages := (n + heapPageMask) >> heapPageShift
+	clear(s.pages[pages:])
+	s.pages = s.pages[:pages]
+	s.size = n
+}
+
+// chars returns the run of byte cells from i to the end of i's page or of
+// the heap, or nil if i's page has been widened.
+func (s *heapStore) chars(i int) []byte {
+	p := &s.pages[i>>heapPageShift]
+	if p.chars == nil {
+		return nil
+	}
+	end := heapPageCells
+	if last := s.size - i&^heapPageMask; last < end {
+		end = last
+	}
+	return p.chars[i&heapPageMask : end]
+}
+
+// narrow turns widened pages that hold only byte characters back into byte
+// pages. The collector runs it after compacting.
+func (s *heapStore) narrow() {
+	for i := range s.pages {
+		p := &s.pages[i]
+		if p.cells == nil {
+			continue
+		}
+		n := min(heapPageCells, s.size-i<<heapPageShift)
+		chars := make([]byte, heapPageCells)
+		ok := true
+		for k, v := range p.cells[:n] {
+			if !isByteChar(v) {
+				ok = false
+				break
+			}
+			chars[k] = byte(v.bits)
+		}
+		if ok {
+			*p = heapPage{chars: chars}
+		}
+	}
+}
+
+// literals returns a copy of the heap as Literal cells.
+func (s *heapStore) literals() []Literal {
+	cells := make([]Literal, s.size)
+	for i := range cells {
+		cells[i] = s.at(i)
+	}
+	return cells
+}
+
+// byteCells is the number of cells held in byte pages.
+func (s *heapStore) byteCells() int {
+	n := 0
+	for i, p := range s.pages {
+		if p.chars != nil {
+			n += min(heapPageCells, s.size-i<<heapPageShift)
+		}
+	}
+	return n
+}
+
+func (p *heapPage) widen() {
+	p.cells = make([]Literal, heapPageCells)
+	for k, c := range p.chars {
+		p.cells[k] = CharLiteral(rune(c))
+	}
+	p.chars = nil
+}
@@ -0,0 +1,81 @@
+package rmm
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHeapStore(t *testing.T) {
+	var s heapStore
+	for i := 0; i < 300; i++ {
+		s.push(CharLiteral('a' + rune(i%26)))
+	}
+	if s.len() != 300 || s.byteCells() != 300 {
+		t.Fatalf("expected 300 cells in byte pages, got %d of %d", s.byteCells(), s.len())
+	}
+	if run := s.chars(250); len(run) != 6 || run[0] != 'a'+250%26 {
+		t.Errorf("expected the 6 cells left in the first page, got %q", run)
+	}
+	if run := s.chars(290); len(run) != 10 {
+		t.Errorf("expected the run to stop at the end of the heap, got %q", run)
+	}
+
+	s.set(10, IntLiteral(5))
+	if got := s.at(10); got != IntLiteral(5) {
+		t.Errorf("expected the int back, got %v", got)
+	}
+	if got := s.at(11); got != CharLiteral('a'+11) {
+		t.Errorf("expected widening to keep the other cells, got %v", got)
+	}
+	if s.chars(0) != nil || s.byteCells() != 44 {
+		t.Errorf("expected only the second page to stay bytes, got %d byte cells", s.byteCells())
+	}
+
+	s.set(10, CharLiteral('k'))
+	s.narrow()
+	if s.byteCells() != 300 {
+		t.Errorf("expected narrow to turn the page back into bytes, got %d byte cells", s.byteCells())
+	}
+
+	s.truncate(100)
+	s.push(CharLiteral('€'))
+	want := newHeapStore(s.literals())
+	if s.len() != 101 || s.at(100) != CharLiteral('€') || !reflect.DeepEqual(s.literals(), want.literals()) {
+		t.Errorf("unexpected heap after truncate and push: %v", s.literals())
+	}
+	if s.byteCells() != 0 {
+		t.Errorf("expected a wide character to widen its page, got %d byte cells", s.byteCells())
+	}
+}
+
+func TestHeapStoreStrings(t *testing.T) {
+	// The string crosses the first page boundary, and strcpy copies it
+	// over an int, so the natives read both byte and widened pages.
+	long := strings.Repeat("x", 300) + "y"
+	source := "push_str \"" + long + "\"\n" +
+		"get_str 0\npush 1\nnative 1\npop\n" +
+		"push 400\nnative 4\npush 0\npush 7\nindex\n" +
+		"get_str 0\nnative 91\n" +
+		"dup\npush 1\nnative 1\npop\n" +
+		"native 94\n"
+	program, err := Compile(source)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	for _, mc := range []*Memcheck{nil, NewMemcheck()} {
+		var out bytes.Buffer
+		machine := NewMachine(Options{Stdout: &out, Memcheck: mc})
+		if err := machine.Run(context.Background(), program); err != nil {
+			t.Fatalf("memcheck %v: failed to run: %v", mc != nil, err)
+		}
+		if out.String() != long+long {
+			t.Errorf("memcheck %v: expected the string twice, got %q", mc != nil, out.String())
+		}
+		if stack := machine.Stack(); len(stack) != 1 || stack[0] != IntLiteral(int64(len(long))) {
+			t.Errorf("memcheck %v: expected strlen %d, got %v", mc != nil, len(long), stack)
+		}
+	}
+}
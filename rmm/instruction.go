@@ -0,0 +1,870 @@
+package rmm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+	"vm/internal/parser"
+	"vm/internal/token"
+)
+
+type InstructionSet uint8
+
+const (
+	InstructionNoOp InstructionSet = iota
+	InstructionPush
+	InstructionPushPtr
+	InstructionGetStr
+	InstructionPop
+	InstructionDup
+	InstructionInDup
+	InstructionSwap
+	InstructionInSwap
+	InstructionAdd
+	InstructionSub
+	InstructionMul
+	InstructionDiv
+	InstructionMod
+	InstructionCmpe
+	InstructionCmpne
+	InstructionCmpg
+	InstructionCmpl
+	InstructionCmpge
+	InstructionCmple
+	InstructionZjmp
+	InstructionNzjmp
+	InstructionJmp
+	InstructionPrint
+	InstructionNative
+	InstructionCall
+	InstructionRet
+	InstructionPushStr
+	InstructionPopStr
+	InstructionDupStr
+	InstructionInDupStr
+	InstructionSwapStr
+	InstructionInSwapStr
+	InstructionCastIntToFloat
+	InstructionCastFloatToInt
+	InstructionRef
+	InstructionDeref
+	InstructionMovStr
+	InstructionIndex
+	InstructionMov
+	InstructionPushReg
+	InstructionMovTop
+	InstructionHalt
+	// Superinstructions, which only the optimizer emits.
+	InstructionAddI
+	InstructionCmpeZjmp
+	InstructionCmpeNzjmp
+	InstructionPushRegDeref
+)
+
+func populateStringTable(parsedTokens *parser.ParserList) ([]int64, []Literal) {
+	strStack := []int64{}
+	heap := []Literal{}
+
+	cur := parsedTokens
+	for cur != nil {
+		if cur.Value.Type == token.TypePushStr {
+			if cur.Next == nil || cur.Next.Value.Type != token.TypeString {
+				ctx := InstructionContext{Line: int(cur.Value.Line), FileName: cur.Value.FileName, Character: cur.Value.Character}
+				panic(ctx.Error(KindSyntax, "expected string after push_str")) // Should be caught by parser
+			}
+			strVal := cur.Next.Value.Text
+
+			ptr := int64(len(heap))
+
+			for _, char := range strVal {
+				heap = append(heap, CharLiteral(char))
+			}
+			heap = append(heap, CharLiteral(0))
+
+			strStack = append(strStack, ptr)
+		}
+		cur = cur.Next
+	}
+	return strStack, heap
+}
+
+func (i InstructionSet) String() string {
+	switch i {
+	case InstructionNoOp:
+		return "NOOP"
+	case InstructionPush:
+		return "PUSH"
+	case InstructionPushPtr:
+		return "PUSH_PTR"
+	case InstructionGetStr:
+		return "GET_STR"
+	case InstructionPop:
+		return "POP"
+	case InstructionAdd:
+		return "ADD"
+	case InstructionSub:
+		return "SUB"
+	case InstructionMul:
+		return "MUL"
+	case InstructionDiv:
+		return "DIV"
+	case InstructionPrint:
+		return "PRINT"
+	case InstructionNative:
+		return "NATIVE"
+	case InstructionDup:
+		return "DUP"
+	case InstructionInDup:
+		return "INDUP"
+	case InstructionSwap:
+		return "SWAP"
+	case InstructionInSwap:
+		return "INSWAP"
+	case InstructionCmpe:
+		return "CMPE"
+	case InstructionCmpne:
+		return "CMPNE"
+	case InstructionCmpg:
+		return "CMPG"
+	case InstructionCmpl:
+		return "CMPL"
+	case InstructionCmpge:
+		return "CMPGE"
+	case InstructionCmple:
+		return "CMPLE"
+	case InstructionJmp:
+		return "JMP"
+	case InstructionZjmp:
+		return "ZJMP"
+	case InstructionNzjmp:
+		return "NZJMP"
+	case InstructionMod:
+		return "MOD"
+	case InstructionHalt:
+		return "HALT"
+	case InstructionCall:
+		return "CALL"
+	case InstructionRet:
+		return "RET"
+	case InstructionPushStr:
+		return "PUSH_STR"
+	case InstructionPopStr:
+		return "POP_STR"
+	case InstructionDupStr:
+		return "DUP_STR"
+	case InstructionInDupStr:
+		return "INDUP_STR"
+	case InstructionSwapStr:
+		return "SWAP_STR"
+	case InstructionInSwapStr:
+		return "INSWAP_STR"
+	case InstructionCastIntToFloat:
+		return "ITOF"
+	case InstructionCastFloatToInt:
+		return "FTOI"
+	case InstructionRef:
+		return "REF"
+	case InstructionDeref:
+		return "DEREF"
+	case InstructionMovStr:
+		return "MOV_STR"
+	case InstructionIndex:
+		return "INDEX"
+	case InstructionMov:
+		return "MOV"
+	case InstructionPushReg:
+		return "PUSH_REG"
+	case InstructionMovTop:
+		return "MOV_TOP"
+	case InstructionAddI:
+		return "ADDI"
+	case InstructionCmpeZjmp:
+		return "CMPE_ZJMP"
+	case InstructionCmpeNzjmp:
+		return "CMPE_NZJMP"
+	case InstructionPushRegDeref:
+		return "PUSH_REG_DEREF"
+	default:
+		return fmt.Sprintf("UNKNOWN(%d)", i)
+	}
+}
+
+// cancelCheckInterval is how many instructions run between checks of the
+// run's context, keeping the check off the per-instruction path.
+const cancelCheckInterval = 1024
+
+// runInstructions runs the machine's program and returns the fault that
+// stopped it, if any.
+func runInstructions(runCtx context.Context, machine *Machine) (err error) {
+	ctx := &RuntimeContext{
+		Machine: machine,
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = runtimeError(r, ctx.ip, ctx.instruction())
+			if rtErr, ok := err.(*RuntimeError); ok {
+				rtErr.Trace = machine.frames(rtErr.IP, ctx.part)
+			}
+			if machine.tracer != nil {
+				machine.tracer.record(machine, ctx.ip, ctx.instruction(), err)
+			}
+		}
+		if err == nil && machine.tracer != nil {
+			err = machine.tracer.err
+		}
+		if machine.profiler != nil {
+			machine.profiler.finish()
+		}
+	}()
+	if machine.tracer != nil {
+		machine.tracer.start(machine)
+	}
+	if machine.profiler != nil {
+		machine.profiler.start(machine)
+	}
+	if machine.coverage != nil {
+		machine.coverage.start(machine)
+	}
+	if machine.memcheck != nil {
+		machine.memcheck.start(machine)
+	}
+	code := machine.code
+	observed := machine.debug || machine.hook != nil || machine.tracer != nil ||
+		machine.profiler != nil || machine.coverage != nil
+	maxSteps := machine.limits.MaxSteps
+	if maxSteps <= 0 {
+		maxSteps = math.MaxInt64
+	}
+
+	// Jump to entrypoint
+	insPtr := machine.entrypoint
+	for insPtr < len(code) {
+		machine.ip = insPtr
+		if machine.steps == maxSteps {
+			panic(ctx.Error(KindBudgetExceeded, fmt.Sprintf("execution budget exceeded: step limit of %d instructions reached", maxSteps)))
+		}
+		machine.steps++
+		if machine.steps%cancelCheckInterval == 0 {
+			checkCancelled(runCtx, ctx)
+		}
+		o := &code[insPtr]
+		if !observed {
+			insPtr = o.exec(ctx, o, insPtr)
+			continue
+		}
+
+		instr := machine.instructions[insPtr]
+		if machine.profiler != nil {
+			machine.profiler.step(machine)
+		}
+		if machine.coverage != nil {
+			machine.coverage.count(insPtr)
+		}
+		if machine.hook != nil {
+			if err := machine.hook(machine); err != nil {
+				return err
+			}
+		}
+		if machine.debug {
+			fmt.Fprintf(machine.errOutput, "Line %d: %v, Stack: %+v\n", instr.line, instr.instructionType, ctx.stack)
+		}
+		branch := isConditionalJump(instr.instructionType)
+		jumped := branch && jumpTaken(ctx, instr.instructionType)
+		insPtr = o.exec(ctx, o, insPtr)
+		if machine.tracer != nil {
+			machine.tracer.record(machine, machine.ip, instr, nil)
+		}
+		if machine.coverage != nil && branch {
+			machine.coverage.branch(machine.ip, jumped)
+		}
+	}
+	return nil
+}
+
+// checkCancelled stops the run once its context is done.
+func checkCancelled(runCtx context.Context, ctx *RuntimeContext) {
+	err := runCtx.Err()
+	if err == nil {
+		return
+	}
+	cause := context.Cause(runCtx)
+	var limit timeLimitError
+	if errors.As(cause, &limit) {
+		panic(ctx.Error(KindBudgetExceeded, fmt.Sprintf("execution budget exceeded: %v", cause)))
+	}
+	panic(ctx.Error(KindCancelled, fmt.Sprintf("execution cancelled: %v", err)))
+}
+
+// Native function ID 99: int_to_str
+// Stack inputs: [int]
+// Stack output: [ptr] (pointer to new string)
+func nativeIntToStr(ctx *RuntimeContext) {
+	value := pop(ctx)
+	if value.Type() != LiteralInt {
+		panic(ctx.Error(KindType, "int_to_str expects an integer"))
+	}
+	s := fmt.Sprintf("%d", value.Int())
+	push(ctx, PointerLiteral(int64(newString(ctx, s))))
+}
+
+// Native function ID 101: gc
+// Stack inputs: []
+// Stack output: [int] (cells reclaimed, 0 when the collector is off)
+func nativeGC(ctx *RuntimeContext) {
+	reclaimed := 0
+	if ctx.gc != nil {
+		reclaimed = collect(ctx)
+	}
+	push(ctx, IntLiteral(int64(reclaimed)))
+}
+
+// Open a file
+func nativeOpen(ctx *RuntimeContext) {
+	// Pop flags
+	flagsVal := pop(ctx)
+	if flagsVal.Type() != LiteralInt {
+		panic(ctx.Error(KindType, "open flags must be integer"))
+	}
+	flags := int(flagsVal.Int())
+
+	// Translate VM flags to OS flags
+	// VM: RONLY=0, WONLY=1, RDWR=2, CREAT=64, EXCL=128
+	osFlags := 0
+	if flags&0x3 == 0 {
+		osFlags |= os.O_RDONLY
+	} else if flags&0x3 == 1 {
+		osFlags |= os.O_WRONLY
+	} else if flags&0x3 == 2 {
+		osFlags |= os.O_RDWR
+	}
+
+	if flags&64 != 0 {
+		osFlags |= os.O_CREATE
+	}
+	if flags&128 != 0 {
+		osFlags |= os.O_EXCL
+	}
+
+	// Pop filename length
+	lenVal := pop(ctx)
+	if lenVal.Type() != LiteralInt {
+		panic(ctx.Error(KindType, "open filename length must be integer"))
+	}
+	length := int(lenVal.Int())
+
+	// Pop filename pointer
+	ptrVal := pop(ctx)
+	if ptrVal.Type() != LiteralPointer {
+		panic(ctx.Error(KindType, "open filename pointer must be pointer"))
+	}
+
+	// Read filename from heap
+	ptr := int(ptrVal.Pointer())
+	if ptr < 0 || ptr+length > ctx.heap.len() {
+		panic(ctx.Error(KindSegfault, "segmentation fault: invalid heap pointer for filename"))
+	}
+
+	filename := ""
+	for i := 0; i < length; i++ {
+		checkAccess(ctx, ptr, ptr+i, memRead)
+		charLit := ctx.heap.at(ptr + i)
+		if charLit.Type() != LiteralChar {
+			panic(ctx.Error(KindType, "filename must be a string of characters"))
+		}
+		filename += string(charLit.Char())
+	}
+
+	if len(ctx.fileDescriptors) >= ctx.limits.MaxOpenFiles {
+		panic(ctx.Error(KindIO, fmt.Sprintf("failed to open file %s: too many open files (limit %d)", filename, ctx.limits.MaxOpenFiles)))
+	}
+
+	// Open the file
+	file, err := os.OpenFile(filename, osFlags, 0644)
+	if err != nil {
+		panic(ctx.Error(KindIO, fmt.Sprintf("failed to open file %s: %v", filename, err)))
+	}
+
+	// 0: Standard Input (stdin)
+	// 1: Standard Output (stdout)
+	// 2: Standard Error (stderr)
+
+	// Find lowest available file descriptor
+	fd := int64(3)
+	for {
+		if _, ok := ctx.fileDescriptors[fd]; !ok {
+			break
+		}
+		fd++
+	}
+
+	ctx.fileDescriptors[fd] = file
+	push(ctx, IntLiteral(fd))
+}
+
+// Write to a file descriptor
+func nativeWrite(ctx *RuntimeContext) {
+	fd := pop(ctx) // FD
+	if fd.Type() != LiteralInt {
+		panic(ctx.Error(KindType, "write fd must be integer"))
+	}
+
+	ptr := pop(ctx) // Ptr
+	if ptr.Type() != LiteralPointer {
+		panic(ctx.Error(KindType, "write string pointer must be pointer"))
+	}
+
+	var writer io.Writer
+	if fd.Int() == 1 {
+		writer = ctx.output
+	} else if fd.Int() == 2 {
+		writer = ctx.errOutput
+	} else {
+		if file, ok := ctx.fileDescriptors[int64(fd.Int())]; ok {
+			writer = file
+		} else {
+			panic(ctx.Error(KindIO, fmt.Sprintf("unknown file descriptor %d", fd.Int())))
+		}
+	}
+
+	ptrIdx := int(ptr.Pointer())
+	if ptrIdx < 0 || ptrIdx >= ctx.heap.len() {
+		panic(ctx.Error(KindSegfault, "segmentation fault: invalid heap pointer"))
+	}
+
+	var sb strings.Builder
+	for i := ptrIdx; i < ctx.heap.len(); {
+		if run, nul, ok := charRun(ctx, i); ok {
+			writeChars(&sb, run)
+			if nul {
+				break
+			}
+			i += len(run)
+			continue
+		}
+		checkAccess(ctx, ptrIdx, i, memRead)
+		charLit := ctx.heap.at(i)
+		i++
+		if charLit.Type() == LiteralInt {
+			sb.WriteRune(rune(charLit.Int()))
+			continue
+		}
+		if charLit.Type() != LiteralChar {
+			continue
+		}
+		if charLit.Char() == 0 {
+			break
+		}
+		sb.WriteRune(charLit.Char())
+	}
+	s := sb.String()
+	fmt.Fprint(writer, s)
+	push(ctx, IntLiteral(int64(len(s))))
+}
+
+// Read from a file descriptor into a buffer
+func nativeRead(ctx *RuntimeContext) {
+	// Arguments: [ptr, len, fd] (Top is fd)
+	fdVal := pop(ctx)
+	if fdVal.Type() != LiteralInt {
+		panic(ctx.Error(KindType, "read fd must be integer"))
+	}
+
+	lenVal := pop(ctx)
+	if lenVal.Type() != LiteralInt {
+		panic(ctx.Error(KindType, "read length must be integer"))
+	}
+	length := int(lenVal.Int())
+
+	ptrVal := pop(ctx)
+	if ptrVal.Type() != LiteralPointer {
+		panic(ctx.Error(KindType, "read buffer pointer must be pointer"))
+	}
+	fd := int64(fdVal.Int())
+
+	var reader io.Reader
+	if fd == 0 {
+		reader = ctx.input
+	} else {
+		if file, ok := ctx.fileDescriptors[fd]; ok {
+			reader = file
+		} else {
+			panic(ctx.Error(KindIO, fmt.Sprintf("read error: invalid file descriptor %d", fd)))
+		}
+	}
+
+	// Validate Heap Pointer and Size
+	ptr := int(ptrVal.Pointer())
+
+	// Safety check against allocation size if tracked
+	if allocSize, ok := ctx.allocations[ptr]; ok {
+		if length > allocSize {
+			panic(ctx.Error(KindSegfault, "buffer overflow: read length exceeds allocated size"))
+		}
+	} else {
+		// Fallback strictly to heap bounds
+		if ptr < 0 || ptr+length > ctx.heap.len() {
+			panic(ctx.Error(KindSegfault, "segmentation fault: invalid heap pointer or length"))
+		}
+	}
+
+	for i := 0; i < length; i++ {
+		checkAccess(ctx, ptr, ptr+i, memWrite)
+	}
+
+	// Read from Input
+	buf := make([]byte, length)
+	_, err := reader.Read(buf)
+	if err != nil && err != io.EOF {
+		panic(ctx.Error(KindIO, fmt.Sprintf("read error: %v", err)))
+	}
+
+	// Store in Heap
+	for i, b := range buf {
+		storeHeap(ctx, ptr+i, CharLiteral(rune(b)))
+	}
+}
+
+// Close a file descriptor
+func nativeClose(ctx *RuntimeContext) {
+	// Pop file descriptor ID
+	fdVal := pop(ctx)
+	if fdVal.Type() != LiteralInt {
+		panic(ctx.Error(KindType, "close file descriptor must be integer"))
+	}
+	fd := int64(fdVal.Int())
+
+	// Check if it's a valid custom file descriptor
+	if fd < 3 { // 0, 1, 2 are stdin, stdout, stderr - cannot close
+		panic(ctx.Error(KindIO, fmt.Sprintf("cannot close standard file descriptor %d", fd)))
+	}
+
+	file, ok := ctx.fileDescriptors[fd]
+	if !ok {
+		panic(ctx.Error(KindIO, fmt.Sprintf("invalid file descriptor %d", fd)))
+	}
+
+	err := file.Close()
+	if err != nil {
+		panic(ctx.Error(KindIO, fmt.Sprintf("failed to close file %d: %v", fd, err)))
+	}
+
+	delete(ctx.fileDescriptors, fd)
+}
+
+// Free a heap pointer
+func nativeFree(ctx *RuntimeContext) {
+	// Pop ptr
+	ptrVal := pop(ctx)
+	if ptrVal.Type() == LiteralNull {
+		return
+	}
+	if ptrVal.Type() != LiteralPointer {
+		panic(ctx.Error(KindType, "free pointer must be pointer"))
+	}
+	freeAllocation(ctx, int(ptrVal.Pointer()))
+}
+
+func nativeScanf(ctx *RuntimeContext) {
+	ptrVal := pop(ctx)
+	if ptrVal.Type() != LiteralPointer {
+		panic(ctx.Error(KindType, "scanf buffer pointer must be pointer"))
+	}
+	ptr := int(ptrVal.Pointer())
+
+	var input string
+	_, err := fmt.Fscan(ctx.input, &input)
+	if err != nil {
+		if err == io.EOF {
+		} else {
+			panic(ctx.Error(KindIO, fmt.Sprintf("scanf error: %v", err)))
+		}
+	}
+
+	if ptr < 0 || ptr+len(input)+1 > ctx.heap.len() {
+		panic(ctx.Error(KindSegfault, "segmentation fault: scanf overflow heap bounds"))
+	}
+	for i := 0; i <= len(input); i++ {
+		checkAccess(ctx, ptr, ptr+i, memWrite)
+	}
+
+	for i, char := range input {
+		storeHeap(ctx, ptr+i, CharLiteral(char))
+	}
+	storeHeap(ctx, ptr+len(input), CharLiteral(0))
+
+	push(ctx, ptrVal)
+}
+
+func nativeMalloc(ctx *RuntimeContext) {
+	// Pop size
+	sizeVal := pop(ctx)
+	if sizeVal.Type() != LiteralInt {
+		panic(ctx.Error(KindType, "malloc size must be integer"))
+	}
+	size := int(sizeVal.Int())
+
+	// Allocate and push pointer
+	maybeCollect(ctx, size)
+	push(ctx, PointerLiteral(int64(allocate(ctx, size))))
+}
+
+func nativeExit(ctx *RuntimeContext) {
+	// Pop exit code
+	codeVal := pop(ctx)
+	if codeVal.Type() != LiteralInt {
+		panic(ctx.Error(KindType, "exit code must be integer"))
+	}
+	ctx.exitCode = int(codeVal.Int())
+	panic(exitRequest{code: ctx.exitCode})
+}
+
+func nativePow(ctx *RuntimeContext) {
+	powerVal := pop(ctx)
+	numVal := pop(ctx)
+	if powerVal.Type() != LiteralInt || numVal.Type() != LiteralInt {
+		panic(ctx.Error(KindType, "pow requires integer arguments"))
+	}
+	// Patch logic: pow(power, num). pop() gives power first.
+	// usage: push 8; push 10; native 8 -> 10^8
+	// power=10, num=8. pow(10, 8).
+	res := math.Pow(float64(powerVal.Int()), float64(numVal.Int()))
+	push(ctx, IntLiteral(int64(res)))
+}
+
+func nativeFloatToStr(ctx *RuntimeContext) {
+	val := pop(ctx)
+	if val.Type() != LiteralFloat {
+		panic(ctx.Error(KindType, "float_to_str requires float argument"))
+	}
+	s := fmt.Sprintf("%.8f", val.Float())
+	// Allocate on heap
+	push(ctx, PointerLiteral(int64(newString(ctx, s))))
+}
+
+func nativeStrcmp(ctx *RuntimeContext) {
+	ptr2Val := pop(ctx)
+	ptr1Val := pop(ctx)
+
+	if ptr1Val.Type() != LiteralPointer || ptr2Val.Type() != LiteralPointer {
+		panic(ctx.Error(KindType, "strcmp pointers must be pointer"))
+	}
+
+	ptr1 := ptr1Val.Pointer()
+	ptr2 := ptr2Val.Pointer()
+
+	s1 := getStringFromHeap(ctx, ptr1)
+	s2 := getStringFromHeap(ctx, ptr2)
+
+	if s1 == s2 {
+		push(ctx, IntLiteral(1))
+	} else {
+		push(ctx, IntLiteral(0))
+	}
+}
+
+// Helper to get string from heap
+func getStringFromHeap(ctx *RuntimeContext, ptr int64) string {
+	if int(ptr) < 0 || int(ptr) >= ctx.heap.len() {
+		panic(ctx.Error(KindSegfault, "segmentation fault: invalid heap pointer"))
+	}
+	var sb strings.Builder
+	for i := int(ptr); i < ctx.heap.len(); {
+		if run, nul, ok := charRun(ctx, i); ok {
+			writeChars(&sb, run)
+			if nul {
+				break
+			}
+			i += len(run)
+			continue
+		}
+		checkAccess(ctx, int(ptr), i, memRead)
+		charLit := ctx.heap.at(i)
+		i++
+		if charLit.Type() != LiteralChar {
+			continue
+		}
+		if charLit.Char() == 0 {
+			break
+		}
+		sb.WriteRune(charLit.Char())
+	}
+	return sb.String()
+}
+
+func nativeStrcpy(ctx *RuntimeContext) {
+	srcPtrVal := pop(ctx)
+	destPtrVal := pop(ctx)
+
+	if srcPtrVal.Type() != LiteralPointer || destPtrVal.Type() != LiteralPointer {
+		panic(ctx.Error(KindType, "strcpy pointers must be pointer"))
+	}
+
+	srcPtr := int(srcPtrVal.Pointer())
+	destPtr := int(destPtrVal.Pointer())
+
+	if srcPtr < 0 || srcPtr >= ctx.heap.len() {
+		panic(ctx.Error(KindSegfault, "segmentation fault: invalid source pointer"))
+	}
+	if destPtr < 0 || destPtr >= ctx.heap.len() {
+		panic(ctx.Error(KindSegfault, "segmentation fault: invalid destination pointer"))
+	}
+
+	for i := 0; srcPtr+i < ctx.heap.len(); i++ {
+		checkAccess(ctx, srcPtr, srcPtr+i, memRead)
+		charLit := ctx.heap.at(srcPtr + i)
+		checkAccess(ctx, destPtr, destPtr+i, memWrite)
+		if destPtr+i >= ctx.heap.len() {
+			appendHeap(ctx, CharLiteral(0))
+		}
+		storeHeap(ctx, destPtr+i, charLit)
+		if charLit.Type() == LiteralChar && charLit.Char() == 0 {
+			break
+		}
+	}
+	push(ctx, destPtrVal)
+}
+
+// Native function ID 92: memcpy
+// Stack inputs: [dest, src, size] (top is size)
+// Stack output: [dest]
+func nativeMemcpy(ctx *RuntimeContext) {
+	sizeVal := pop(ctx)
+	srcPtrVal := pop(ctx)
+	destPtrVal := pop(ctx)
+
+	if sizeVal.Type() != LiteralInt || srcPtrVal.Type() != LiteralPointer || destPtrVal.Type() != LiteralPointer {
+		panic(ctx.Error(KindType, "memcpy arguments must be valid (src/dest: ptr, size: int)"))
+	}
+
+	size := int(sizeVal.Int())
+	srcPtr := int(srcPtrVal.Pointer())
+	destPtr := int(destPtrVal.Pointer())
+
+	if srcPtr < 0 || srcPtr+size > ctx.heap.len() { // Strict bound check for src
+		panic(ctx.Error(KindSegfault, "segmentation fault: invalid source range"))
+	}
+
+	for i := 0; i < size; i++ {
+		checkAccess(ctx, srcPtr, srcPtr+i, memCopy)
+		checkAccess(ctx, destPtr, destPtr+i, memWrite)
+	}
+
+	// Extend dest if needed
+	if destPtr+size > ctx.heap.len() {
+		required := (destPtr + size) - ctx.heap.len()
+		for k := 0; k < required; k++ {
+			appendHeap(ctx, CharLiteral(0))
+		}
+	}
+
+	// Copy
+	for i := 0; i < size; i++ {
+		storeHeap(ctx, destPtr+i, ctx.heap.at(srcPtr+i))
+	}
+	if ctx.memcheck != nil {
+		ctx.memcheck.move(srcPtr, destPtr, size)
+	}
+
+	push(ctx, destPtrVal)
+}
+
+func nativeRealloc(ctx *RuntimeContext) {
+	sizeVal := pop(ctx)
+	ptrVal := pop(ctx)
+
+	if sizeVal.Type() != LiteralInt {
+		panic(ctx.Error(KindType, "realloc size must be integer"))
+	}
+	if ptrVal.Type() != LiteralPointer && ptrVal.Type() != LiteralNull {
+		panic(ctx.Error(KindType, "realloc pointer must be pointer or NULL"))
+	}
+
+	size := int(sizeVal.Int())
+
+	// Check for NULL (Strictly LiteralNull)
+	isNull := ptrVal.Type() == LiteralNull
+
+	if isNull { // NULL check -> behaves like malloc
+		maybeCollect(ctx, size)
+		push(ctx, PointerLiteral(int64(allocate(ctx, size))))
+		return
+	}
+
+	// Resize in place if possible, otherwise move
+	ptr := reallocate(ctx, int(ptrVal.Pointer()), size)
+	push(ctx, PointerLiteral(int64(ptr)))
+}
+
+func nativeTime(ctx *RuntimeContext) {
+	now := time.Now().Unix()
+	push(ctx, IntLiteral(now))
+}
+
+func nativeStrcat(ctx *RuntimeContext) {
+	srcPtrVal := pop(ctx)
+	destPtrVal := pop(ctx)
+
+	if srcPtrVal.Type() != LiteralPointer || destPtrVal.Type() != LiteralPointer {
+		panic(ctx.Error(KindType, "strcat pointers must be pointer"))
+	}
+
+	srcPtr := int(srcPtrVal.Pointer())
+	destPtr := int(destPtrVal.Pointer())
+
+	// Get length of dest
+	sDest := getStringFromHeap(ctx, int64(destPtr))
+	destLen := len(sDest)
+
+	// Append pointer
+	appendPtr := destPtr + destLen
+
+	// Copy src to dest end
+	// Note: We need to ensure heap is large enough or extend it.
+	// getStringFromHeap reads until null.
+
+	sSrc := getStringFromHeap(ctx, int64(srcPtr))
+
+	for i, char := range sSrc {
+		// Check bounds/grow
+		target := appendPtr + i
+		checkAccess(ctx, destPtr, target, memWrite)
+		if target >= ctx.heap.len() {
+			appendHeap(ctx, CharLiteral(char))
+		} else {
+			storeHeap(ctx, target, CharLiteral(char))
+		}
+	}
+	// Null terminate
+	target := appendPtr + len(sSrc)
+	checkAccess(ctx, destPtr, target, memWrite)
+	if target >= ctx.heap.len() {
+		appendHeap(ctx, CharLiteral(0))
+	} else {
+		storeHeap(ctx, target, CharLiteral(0))
+	}
+
+	push(ctx, destPtrVal)
+}
+
+func nativeStrlen(ctx *RuntimeContext) {
+	ptrVal := pop(ctx)
+	if ptrVal.Type() != LiteralPointer {
+		panic(ctx.Error(KindType, "strlen pointer must be pointer"))
+	}
+	s := getStringFromHeap(ctx, ptrVal.Pointer())
+	push(ctx, IntLiteral(int64(len(s))))
+}
+
+func nativeAssert(ctx *RuntimeContext) {
+	val := pop(ctx)
+	if val.Type() == LiteralInt {
+		if val.Int() == 0 {
+			panic(ctx.Error(KindAssertion, "assertion failed"))
+		}
+	}
+}
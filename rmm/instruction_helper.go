@@ -1,8 +1,10 @@
-package main
+package rmm
 
 import (
+	"bytes"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"vm/internal/parser"
 	"vm/internal/token"
@@ -14,60 +16,46 @@ type InstructionList []Instruction
 type InstructionContext struct {
 	Line      int
 	FileName  string
-	Character int // Reserved for future use
-}
-
-// Error formats an error message with file and line context
-func (ctx InstructionContext) Error(message string) string {
-	return fmt.Sprintf("ERROR (%s:%d): %s", ctx.FileName, ctx.Line, message)
+	Character int
 }
 
 // ---- Stack helper functions ----
 
 func push(ctx *RuntimeContext, value Literal) {
-	if len(ctx.stack) >= maxStackSize {
-		panic(ctx.CurrentInstruction.Error("stack overflow"))
+	if len(ctx.stack) >= ctx.limits.MaxStack {
+		panic(ctx.Error(KindStackOverflow, "stack overflow"))
 	}
-	if value.Type() == LiteralInt {
-		ctx.stack = append(ctx.stack, value)
-	} else if value.Type() == LiteralFloat {
-		ctx.stack = append(ctx.stack, value)
-	} else if value.Type() == LiteralChar {
-		ctx.stack = append(ctx.stack, value)
-	} else if value.Type() == LiteralString {
-		ctx.stack = append(ctx.stack, value)
-	} else if value.Type() == LiteralNull {
-		ctx.stack = append(ctx.stack, value)
-	} else if value.Type() == LiteralPointer {
+	// A value of no type, as an empty operand decodes to, is not pushed.
+	if value.Type() != LiteralNone {
 		ctx.stack = append(ctx.stack, value)
 	}
 }
 
 func pop(ctx *RuntimeContext) Literal {
 	if len(ctx.stack) == 0 {
-		panic(ctx.CurrentInstruction.Error("stack underflow"))
+		panic(ctx.Error(KindStackUnderflow, "stack underflow"))
 	}
 	value := ctx.stack[len(ctx.stack)-1]
 	ctx.stack = ctx.stack[:len(ctx.stack)-1]
 	return value
 }
 
-func getRegisterIndex(name string) int {
+func getRegisterIndex(ctx InstructionContext, name string) int {
 	if len(name) < 2 || name[0] != 'r' {
-		panic("invalid register name: " + name)
+		panic(ctx.Error(KindSyntax, "invalid register name: "+name))
 	}
 	idx, err := strconv.Atoi(name[1:])
 	if err != nil {
-		panic("invalid register index: " + name)
+		panic(ctx.Error(KindSyntax, "invalid register index: "+name))
 	}
 	if idx < 0 || idx >= MaxRegisters {
-		panic("register index out of bounds: " + name)
+		panic(ctx.Error(KindSyntax, "register index out of bounds: "+name))
 	}
 	return idx
 }
 func indexSwap(ctx *RuntimeContext, index int64) {
 	if index < 0 || int(index) >= len(ctx.stack) {
-		panic(ctx.CurrentInstruction.Error("index out of bounds for swap"))
+		panic(ctx.Error(KindStackUnderflow, "index out of bounds for swap"))
 	}
 	targetIdx := int(index)
 	topIdx := len(ctx.stack) - 1
@@ -79,7 +67,7 @@ func indexSwap(ctx *RuntimeContext, index int64) {
 
 func indexDup(ctx *RuntimeContext, index int64) {
 	if index < 0 || int(index) >= len(ctx.stack) {
-		panic(ctx.CurrentInstruction.Error("index out of bounds for dup"))
+		panic(ctx.Error(KindStackUnderflow, "index out of bounds for dup"))
 	}
 	targetIdx := int(index)
 	push(ctx, ctx.stack[targetIdx])
@@ -89,16 +77,84 @@ func (machine *Machine) programSize() int {
 	return len(machine.instructions)
 }
 
+// storeHeap writes heap cell index, which the caller has bounds-checked.
+// Heap writes go through storeHeap and appendHeap so that tracing sees them.
+func storeHeap[I int | int64](ctx *RuntimeContext, index I, value Literal) {
+	ctx.heap.set(int(index), value)
+	if ctx.tracer != nil {
+		ctx.tracer.heapWrite(int64(index), value)
+	}
+	if ctx.memcheck != nil {
+		ctx.memcheck.write(int(index))
+	}
+}
+
+// appendHeap adds a cell to the end of the heap.
+func appendHeap(ctx *RuntimeContext, value Literal) {
+	checkHeapRoom(ctx, 1)
+	ctx.heap.push(value)
+	if ctx.tracer != nil {
+		ctx.tracer.heapWrite(int64(ctx.heap.len()-1), value)
+	}
+	if ctx.memcheck != nil {
+		ctx.memcheck.write(ctx.heap.len() - 1)
+	}
+}
+
+// charRun returns the byte cells from heap index i up to the first NUL or
+// the end of i's page, and whether it stopped at a NUL. ok is false when
+// i's page holds wider cells or memcheck has to see each read, and the
+// caller reads cell by cell instead.
+func charRun(ctx *RuntimeContext, i int) (run []byte, nul, ok bool) {
+	if ctx.memcheck != nil {
+		return nil, false, false
+	}
+	run = ctx.heap.chars(i)
+	if run == nil {
+		return nil, false, false
+	}
+	if n := bytes.IndexByte(run, 0); n >= 0 {
+		return run[:n], true, true
+	}
+	return run, false, true
+}
+
+// writeChars appends byte cells to sb as the characters they hold.
+func writeChars(sb *strings.Builder, run []byte) {
+	for _, c := range run {
+		if c < 0x80 {
+			sb.WriteByte(c)
+		} else {
+			sb.WriteRune(rune(c))
+		}
+	}
+}
+
+// checkHeapRoom faults unless n more cells fit under the heap limit.
+func checkHeapRoom(ctx *RuntimeContext, n int) {
+	if n > ctx.limits.MaxHeap-ctx.heap.len() {
+		panic(ctx.Error(KindOutOfMemory, fmt.Sprintf("out of memory: heap limit of %d cells reached", ctx.limits.MaxHeap)))
+	}
+}
+
+// storeRegister writes register index, which the caller has checked.
+func storeRegister(ctx *RuntimeContext, index int, value Literal) {
+	ctx.registers[index] = value
+	if ctx.tracer != nil {
+		ctx.tracer.registerWrite(index, value)
+	}
+}
+
 func pushStr(ctx *RuntimeContext, val int64) {
-	if len(ctx.strStack) >= maxStrStackSize {
-		panic(ctx.CurrentInstruction.Error("string stack overflow"))
+	if len(ctx.strStack) >= ctx.limits.MaxStrStack {
+		panic(ctx.Error(KindStackOverflow, "string stack overflow"))
 	}
 	ctx.strStack = append(ctx.strStack, val)
 }
 
 func popStr(ctx *RuntimeContext) int64 {
 	if len(ctx.strStack) == 0 {
-		panic(ctx.CurrentInstruction.Error("string stack underflow"))
+		panic(ctx.Error(KindStackUnderflow, "string stack underflow"))
 	}
 	val := ctx.strStack[len(ctx.strStack)-1]
 	ctx.strStack = ctx.strStack[:len(ctx.strStack)-1]
@@ -107,7 +163,7 @@ func popStr(ctx *RuntimeContext) int64 {
 
 func indexDupStr(ctx *RuntimeContext, index int64) {
 	if index < 0 || int(index) >= len(ctx.strStack) {
-		panic(ctx.CurrentInstruction.Error("index out of bounds for indup_str"))
+		panic(ctx.Error(KindStackUnderflow, "index out of bounds for indup_str"))
 	}
 	targetIdx := int(index)
 	pushStr(ctx, ctx.strStack[targetIdx])
@@ -115,7 +171,7 @@ func indexDupStr(ctx *RuntimeContext, index int64) {
 
 func indexSwapStr(ctx *RuntimeContext, index int64) {
 	if index < 0 || int(index) >= len(ctx.strStack) {
-		panic(ctx.CurrentInstruction.Error("index out of bounds for inswap_str"))
+		panic(ctx.Error(KindStackUnderflow, "index out of bounds for inswap_str"))
 	}
 	targetIdx := int(index)
 	topIdx := len(ctx.strStack) - 1
@@ -128,111 +184,111 @@ func indexSwapStr(ctx *RuntimeContext, index int64) {
 // ---- Instruction helper functions ----
 
 func pushIntIns(value int64, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionPush, value: IntLiteral(value), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionPush, value: IntLiteral(value), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func pushPtrIns(value int64, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionPushPtr, value: IntLiteral(value), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionPushPtr, value: IntLiteral(value), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func pushNullIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionPushPtr, value: NullLiteral(), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionPushPtr, value: NullLiteral(), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func pushFloatIns(value float64, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionPush, value: FloatLiteral(value), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionPush, value: FloatLiteral(value), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func pushCharIns(value rune, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionPush, value: CharLiteral(value), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionPush, value: CharLiteral(value), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func popIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionPop, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionPop, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func dupIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionDup, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionDup, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func inDupIns(index int64, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionInDup, value: IntLiteral(index), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionInDup, value: IntLiteral(index), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func swapIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionSwap, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionSwap, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func inSwapIns(index int64, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionInSwap, value: IntLiteral(index), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionInSwap, value: IntLiteral(index), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func getStrIns(index int64, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionGetStr, value: IntLiteral(index), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionGetStr, value: IntLiteral(index), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func addIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionAdd, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionAdd, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func subIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionSub, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionSub, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func mulIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionMul, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionMul, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func divIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionDiv, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionDiv, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func printIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionPrint, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionPrint, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func cmpeIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionCmpe, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionCmpe, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func cmpneIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionCmpne, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionCmpne, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func cmpgIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionCmpg, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionCmpg, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func cmplIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionCmpl, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionCmpl, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func cmpgeIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionCmpge, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionCmpge, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func cmpleIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionCmple, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionCmple, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func modIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionMod, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionMod, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func jmpIns(target int64, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionJmp, value: IntLiteral(target), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionJmp, value: IntLiteral(target), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func zjmpIns(target int64, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionZjmp, value: IntLiteral(target), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionZjmp, value: IntLiteral(target), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func nzjmpIns(target int64, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionNzjmp, value: IntLiteral(target), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionNzjmp, value: IntLiteral(target), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func haltIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionHalt, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionHalt, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func nativeIns(id int64, ctx InstructionContext) Instruction {
@@ -240,72 +296,73 @@ func nativeIns(id int64, ctx InstructionContext) Instruction {
 		instructionType: InstructionNative,
 		value:           IntLiteral(id),
 		line:            ctx.Line,
+		column:          ctx.Character,
 		fileName:        ctx.FileName,
 	}
 }
 
 func callIns(label int64, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionCall, value: IntLiteral(label), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionCall, value: IntLiteral(label), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func retIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionRet, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionRet, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func popStrIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionPopStr, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionPopStr, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func dupStrIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionDupStr, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionDupStr, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func inDupStrIns(index int64, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionInDupStr, value: IntLiteral(index), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionInDupStr, value: IntLiteral(index), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func swapStrIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionSwapStr, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionSwapStr, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func inSwapStrIns(index int64, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionInSwapStr, value: IntLiteral(index), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionInSwapStr, value: IntLiteral(index), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func castIntToFloatIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionCastIntToFloat, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionCastIntToFloat, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func castFloatToIntIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionCastFloatToInt, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionCastFloatToInt, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func refIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionRef, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionRef, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func derefIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionDeref, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionDeref, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func movStrIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionMovStr, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionMovStr, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func indexIns(val rune, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionIndex, value: CharLiteral(val), line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionIndex, value: CharLiteral(val), line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func indexStackIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionIndex, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionIndex, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func pushRegIns(idx int, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionPushReg, registerIndex: idx, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionPushReg, registerIndex: idx, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func movIns(idx int, val Literal, ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionMov, registerIndex: idx, value: val, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionMov, registerIndex: idx, value: val, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
 func movTopIns(idx int, ctx InstructionContext) Instruction {
@@ -313,20 +370,22 @@ func movTopIns(idx int, ctx InstructionContext) Instruction {
 		instructionType: InstructionMovTop,
 		value:           IntLiteral(int64(idx)),
 		line:            ctx.Line,
+		column:          ctx.Character,
 		fileName:        ctx.FileName,
 	}
 }
 
 func noopIns(ctx InstructionContext) Instruction {
-	return Instruction{instructionType: InstructionNoOp, line: ctx.Line, fileName: ctx.FileName}
+	return Instruction{instructionType: InstructionNoOp, line: ctx.Line, column: ctx.Character, fileName: ctx.FileName}
 }
 
-func printStack(machine *Machine) {
-	fmt.Println("------ STACK")
+// PrintStack writes the data stack to the machine's stdout.
+func (machine *Machine) PrintStack() {
+	fmt.Fprintln(machine.output, "------ STACK")
 	for i := 0; i < len(machine.stack); i++ {
-		fmt.Printf("[%d]: %s\n", i, machine.stack[i].String())
+		fmt.Fprintf(machine.output, "[%d]: %s\n", i, machine.stack[i].String())
 	}
-	fmt.Println("------ END OF STACK")
+	fmt.Fprintln(machine.output, "------ END OF STACK")
 }
 
 func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int) {
@@ -336,21 +395,22 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 
 	for cur != nil {
 		ctx := InstructionContext{
-			Line:     int(cur.Value.Line),
-			FileName: cur.Value.FileName,
+			Line:      int(cur.Value.Line),
+			FileName:  cur.Value.FileName,
+			Character: cur.Value.Character,
 		}
 
 		switch cur.Value.Type {
 		case token.TypeInvalid:
-			panic(ctx.Error("invalid token encountered during instruction generation"))
+			panic(ctx.Error(KindSyntax, "invalid token encountered during instruction generation"))
 		case token.TypeNoOp:
 		case token.TypeCall:
 			if cur.Next.Value.Type != token.TypeInt {
-				panic(ctx.Error("expected integer (label address) after call"))
+				panic(ctx.Error(KindSyntax, "expected integer (label address) after call"))
 			}
 			value, err := strconv.ParseInt(cur.Next.Value.Text, 10, 64)
 			if err != nil {
-				panic(ctx.Error("invalid integer value for call instruction"))
+				panic(ctx.Error(KindSyntax, "invalid integer value for call instruction"))
 			}
 			cur = cur.Next
 			instructions = append(instructions, callIns(value, ctx))
@@ -358,14 +418,14 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 			instructions = append(instructions, retIns(ctx))
 		case token.TypeEntrypoint:
 			if cur.Next.Value.Type != token.TypeInt {
-				panic(ctx.Error("expected integer (label address) after entrypoint"))
+				panic(ctx.Error(KindSyntax, "expected integer (label address) after entrypoint"))
 			}
 			if entrypointIndex != -1 {
-				panic(ctx.Error("cannot define entrypoint more than once"))
+				panic(ctx.Error(KindSymbol, "cannot define entrypoint more than once"))
 			}
 			val, err := strconv.ParseInt(cur.Next.Value.Text, 10, 64)
 			if err != nil {
-				panic(ctx.Error("invalid integer value for entrypoint"))
+				panic(ctx.Error(KindSyntax, "invalid integer value for entrypoint"))
 			}
 			entrypointIndex = int(val)
 			cur = cur.Next
@@ -373,18 +433,18 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 			if cur.Next.Value.Type == token.TypeInt {
 				value, err := strconv.ParseInt(cur.Next.Value.Text, 10, 64)
 				if err != nil {
-					panic(ctx.Error("invalid integer value for push instruction"))
+					panic(ctx.Error(KindSyntax, "invalid integer value for push instruction"))
 				}
 				instructions = append(instructions, pushIntIns(value, ctx))
 			} else if cur.Next.Value.Type == token.TypeFloat {
 				value, err := strconv.ParseFloat(cur.Next.Value.Text, 64)
 				if err != nil {
-					panic(ctx.Error("invalid float value for push instruction"))
+					panic(ctx.Error(KindSyntax, "invalid float value for push instruction"))
 				}
 				instructions = append(instructions, pushFloatIns(value, ctx))
 			} else if cur.Next.Value.Type == token.TypeChar {
 				if len(cur.Next.Value.Text) == 0 {
-					panic(ctx.Error("empty character literal"))
+					panic(ctx.Error(KindSyntax, "empty character literal"))
 				}
 				charValue := rune(cur.Next.Value.Text[0])
 				instructions = append(instructions, pushCharIns(charValue, ctx))
@@ -394,7 +454,7 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 					instructions = append(instructions, pushCharIns(char, ctx))
 				}
 			} else if cur.Next.Value.Type == token.TypeRegister {
-				regIdx := getRegisterIndex(cur.Next.Value.Text)
+				regIdx := getRegisterIndex(ctx, cur.Next.Value.Text)
 				instructions = append(instructions, pushRegIns(regIdx, ctx))
 			} else if cur.Next.Value.Type == token.TypeNull {
 				instructions = append(instructions, pushNullIns(ctx))
@@ -404,7 +464,7 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 			if cur.Next.Value.Type == token.TypeInt {
 				value, err := strconv.ParseInt(cur.Next.Value.Text, 10, 64)
 				if err != nil {
-					panic(ctx.Error("invalid integer value for push_ptr instruction"))
+					panic(ctx.Error(KindSyntax, "invalid integer value for push_ptr instruction"))
 				}
 				instructions = append(instructions, pushPtrIns(value, ctx))
 			} else if cur.Next.Value.Type == token.TypeNull {
@@ -418,7 +478,7 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 		case token.TypeInDup:
 			value, err := strconv.ParseInt(cur.Next.Value.Text, 10, 64)
 			if err != nil {
-				panic(ctx.Error("invalid integer value for indup instruction"))
+				panic(ctx.Error(KindSyntax, "invalid integer value for indup instruction"))
 			}
 			cur = cur.Next
 			instructions = append(instructions, inDupIns(value, ctx))
@@ -427,7 +487,7 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 		case token.TypeInSwap:
 			value, err := strconv.ParseInt(cur.Next.Value.Text, 10, 64)
 			if err != nil {
-				panic(ctx.Error("invalid integer value for inswap instruction"))
+				panic(ctx.Error(KindSyntax, "invalid integer value for inswap instruction"))
 			}
 			cur = cur.Next
 			instructions = append(instructions, inSwapIns(value, ctx))
@@ -456,28 +516,28 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 		case token.TypeJmp:
 			value, err := strconv.ParseInt(cur.Next.Value.Text, 10, 64)
 			if err != nil {
-				panic(ctx.Error("invalid integer value for jmp instruction"))
+				panic(ctx.Error(KindSyntax, "invalid integer value for jmp instruction"))
 			}
 			cur = cur.Next
 			instructions = append(instructions, jmpIns(value, ctx))
 		case token.TypeZjmp:
 			value, err := strconv.ParseInt(cur.Next.Value.Text, 10, 64)
 			if err != nil {
-				panic(ctx.Error("invalid integer value for zjmp instruction"))
+				panic(ctx.Error(KindSyntax, "invalid integer value for zjmp instruction"))
 			}
 			cur = cur.Next
 			instructions = append(instructions, zjmpIns(value, ctx))
 		case token.TypeNzjmp:
 			value, err := strconv.ParseInt(cur.Next.Value.Text, 10, 64)
 			if err != nil {
-				panic(ctx.Error("invalid integer value for nzjmp instruction"))
+				panic(ctx.Error(KindSyntax, "invalid integer value for nzjmp instruction"))
 			}
 			cur = cur.Next
 			instructions = append(instructions, nzjmpIns(value, ctx))
 		case token.TypeNative:
 			id, err := strconv.ParseInt(cur.Next.Value.Text, 10, 64)
 			if err != nil {
-				panic(ctx.Error("invalid integer value for native function ID"))
+				panic(ctx.Error(KindSyntax, "invalid integer value for native function ID"))
 			}
 			cur = cur.Next
 			instructions = append(instructions, nativeIns(id, ctx))
@@ -488,16 +548,16 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 		case token.TypeGetStr:
 			value, err := strconv.ParseInt(cur.Next.Value.Text, 10, 64)
 			if err != nil {
-				panic(ctx.Error("invalid integer value for get_str instruction"))
+				panic(ctx.Error(KindSyntax, "invalid integer value for get_str instruction"))
 			}
 			cur = cur.Next
 			instructions = append(instructions, getStrIns(value, ctx))
 		case token.TypeInt:
-			panic(ctx.Error("unexpected standalone integer token encountered during instruction generation"))
+			panic(ctx.Error(KindSyntax, "unexpected standalone integer token encountered during instruction generation"))
 		case token.TypeLabelDefinition:
-			panic(ctx.Error("unexpected label definition token encountered during instruction generation"))
+			panic(ctx.Error(KindSyntax, "unexpected label definition token encountered during instruction generation"))
 		case token.TypeLabel:
-			panic(ctx.Error("unexpected label token encountered during instruction generation"))
+			panic(ctx.Error(KindSyntax, "unexpected label token encountered during instruction generation"))
 		case token.TypeNull:
 			instructions = append(instructions, pushNullIns(ctx))
 		case token.TypeHalt:
@@ -509,7 +569,7 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 		case token.TypeInDupStr:
 			value, err := strconv.ParseInt(cur.Next.Value.Text, 10, 64)
 			if err != nil {
-				panic(ctx.Error("invalid integer value for indup_str instruction"))
+				panic(ctx.Error(KindSyntax, "invalid integer value for indup_str instruction"))
 			}
 			cur = cur.Next
 			instructions = append(instructions, inDupStrIns(value, ctx))
@@ -518,7 +578,7 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 		case token.TypeInSwapStr:
 			value, err := strconv.ParseInt(cur.Next.Value.Text, 10, 64)
 			if err != nil {
-				panic(ctx.Error("invalid integer value for inswap_str instruction"))
+				panic(ctx.Error(KindSyntax, "invalid integer value for inswap_str instruction"))
 			}
 			cur = cur.Next
 			instructions = append(instructions, inSwapStrIns(value, ctx))
@@ -534,13 +594,13 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 			instructions = append(instructions, movStrIns(ctx))
 		case token.TypeMov:
 			if cur.Next == nil || cur.Next.Value.Type != token.TypeRegister {
-				panic(ctx.Error("expected register after mov"))
+				panic(ctx.Error(KindSyntax, "expected register after mov"))
 			}
-			regIdx := getRegisterIndex(cur.Next.Value.Text)
+			regIdx := getRegisterIndex(ctx, cur.Next.Value.Text)
 			cur = cur.Next
 
 			if cur.Next == nil {
-				panic(ctx.Error("expected immediate value after register in mov"))
+				panic(ctx.Error(KindSyntax, "expected immediate value after register in mov"))
 			}
 
 			if cur.Next.Value.Type == token.TypeTop {
@@ -557,11 +617,11 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 					val = FloatLiteral(v)
 				case token.TypeChar:
 					if len(cur.Next.Value.Text) == 0 {
-						panic(ctx.Error("empty char literal"))
+						panic(ctx.Error(KindSyntax, "empty char literal"))
 					}
 					val = CharLiteral(rune(cur.Next.Value.Text[0]))
 				default:
-					panic(ctx.Error("mov only supports immediate values (int, float, char)"))
+					panic(ctx.Error(KindSyntax, "mov only supports immediate values (int, float, char)"))
 				}
 				instructions = append(instructions, movIns(regIdx, val, ctx))
 				cur = cur.Next
@@ -569,7 +629,7 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 		case token.TypeIndex:
 			if cur.Next != nil && cur.Next.Value.Type == token.TypeChar {
 				if len(cur.Next.Value.Text) == 0 {
-					panic(ctx.Error("empty character literal for index"))
+					panic(ctx.Error(KindSyntax, "empty character literal for index"))
 				}
 				charValue := rune(cur.Next.Value.Text[0])
 				instructions = append(instructions, indexIns(charValue, ctx))
@@ -578,7 +638,7 @@ func generateInstructions(parsedTokens *parser.ParserList) (InstructionList, int
 				instructions = append(instructions, indexStackIns(ctx))
 			}
 		default:
-			panic(ctx.Error("unknown token type encountered during instruction generation"))
+			panic(ctx.Error(KindSyntax, "unknown token type encountered during instruction generation"))
 		}
 		cur = cur.Next
 	}
@@ -592,13 +652,13 @@ func (il InstructionList) Print() {
 	for i, instr := range il {
 		fmt.Printf("[%d]: Type=%s", i, instr.instructionType.String())
 		if instr.value.Type() == LiteralInt {
-			fmt.Printf(", ValueInt=%d", instr.value.valueInt)
+			fmt.Printf(", ValueInt=%d", instr.value.Int())
 		}
 		if instr.value.Type() == LiteralFloat {
-			fmt.Printf(", ValueFloat=%f", instr.value.valueFloat)
+			fmt.Printf(", ValueFloat=%f", instr.value.Float())
 		}
 		if instr.value.Type() == LiteralChar {
-			fmt.Printf(", ValueChar=%c", instr.value.valueChar)
+			fmt.Printf(", ValueChar=%c", instr.value.Char())
 		}
 		fmt.Println()
 	}
@@ -0,0 +1,461 @@
+package rmm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"sort"
+)
+
+// Program file layout (v2), all values little-endian:
+//
+//	header (16 bytes):
+//	  0:4   magic "RMMB"
+//	  4:6   format version
+//	  6:8   section count
+//	  8:16  entrypoint
+//	section table, 12 bytes per section: id, offset, length (4 bytes each)
+//	section payloads
+//	CRC-32 (IEEE) of everything before it (4 bytes)
+//
+// Sections:
+//
+//	code:    4-byte count, then 8 bytes per instruction: instruction type,
+//	         register index, 2 reserved bytes, 4-byte constant pool index
+//	pool:    4-byte constant count, then 9 bytes (type + value) per
+//	         constant; 4-byte string count, then per string a 4-byte length
+//	         and the bytes. Strings hold file and symbol names.
+//	heap:    4-byte cell count, then 9 bytes (type + value) per cell;
+//	         4-byte string table count, then 8-byte heap pointer per entry
+//	symbols: 4-byte count, then per label a 4-byte name (pool string
+//	         index) and 4-byte instruction index
+//	lines:   4-byte count, then per instruction a 4-byte file name (pool
+//	         string index) and 4-byte line
+//	fused:   4-byte count, then per superinstruction its 4-byte index, and
+//	         the 4-byte file name (pool string index) and 4-byte line of
+//	         its second part
+//
+// Unknown sections are skipped so newer writers can add sections without
+// breaking older readers. Files without the magic are read with the legacy
+// reader.
+
+var programMagic = []byte("RMMB")
+
+const programVersion = 2
+const programHeaderSize = 16
+const sectionEntrySize = 12
+
+const (
+	sectionCode uint32 = iota + 1
+	sectionPool
+	sectionHeap
+	sectionSymbols
+	sectionLines
+	sectionFused
+)
+
+// WriteFile writes the program (instructions, entrypoint, string table,
+// initial heap, symbols and line info) to filePath.
+func (p *Program) WriteFile(filePath string) error {
+	if err := os.WriteFile(filePath, encodeProgram(p), 0644); err != nil {
+		return fmt.Errorf("could not write program file %s: %w", filePath, err)
+	}
+	return nil
+}
+
+// ErrInvalidProgram is wrapped by the errors LoadProgram returns for files
+// that are not well-formed programs.
+var ErrInvalidProgram = errors.New("invalid program file")
+
+// LoadProgram reads a program file written by WriteFile, or by older
+// versions of the VM.
+func LoadProgram(filePath string) (*Program, error) {
+	payload, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read program file %s: %w", filePath, err)
+	}
+	program, err := decodeProgram(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %w", ErrInvalidProgram, filePath, err)
+	}
+	return program, nil
+}
+
+// programPool interns constants and strings while encoding.
+type programPool struct {
+	constants     []Literal
+	constantIndex map[literalKey]uint32
+	strings       []string
+	stringIndex   map[string]uint32
+}
+
+// literalKey identifies a constant by its exact bits, so 0.0 and -0.0 stay
+// distinct.
+type literalKey struct {
+	valueType LiteralType
+	bits      uint64
+}
+
+func (p *programPool) constant(l Literal) uint32 {
+	key := literalKey{l.valueType, literalBits(l)}
+	if idx, ok := p.constantIndex[key]; ok {
+		return idx
+	}
+	idx := uint32(len(p.constants))
+	p.constants = append(p.constants, l)
+	p.constantIndex[key] = idx
+	return idx
+}
+
+func (p *programPool) string(s string) uint32 {
+	if idx, ok := p.stringIndex[s]; ok {
+		return idx
+	}
+	idx := uint32(len(p.strings))
+	p.strings = append(p.strings, s)
+	p.stringIndex[s] = idx
+	return idx
+}
+
+func encodeProgram(program *Program) []byte {
+	pool := &programPool{
+		constantIndex: make(map[literalKey]uint32),
+		stringIndex:   make(map[string]uint32),
+	}
+
+	code := binary.LittleEndian.AppendUint32(nil, uint32(len(program.instructions)))
+	for _, instr := range program.instructions {
+		code = append(code, uint8(instr.instructionType), uint8(instr.registerIndex), 0, 0)
+		code = binary.LittleEndian.AppendUint32(code, pool.constant(instr.value))
+	}
+
+	heap := binary.LittleEndian.AppendUint32(nil, uint32(len(program.heap)))
+	for _, cell := range program.heap {
+		heap = appendLiteral(heap, cell)
+	}
+	heap = binary.LittleEndian.AppendUint32(heap, uint32(len(program.strStack)))
+	for _, ptr := range program.strStack {
+		heap = binary.LittleEndian.AppendUint64(heap, uint64(ptr))
+	}
+
+	symbols := binary.LittleEndian.AppendUint32(nil, uint32(len(program.symbols)))
+	for _, name := range sortedSymbols(program.symbols) {
+		symbols = binary.LittleEndian.AppendUint32(symbols, pool.string(name))
+		symbols = binary.LittleEndian.AppendUint32(symbols, uint32(program.symbols[name]))
+	}
+
+	lines := binary.LittleEndian.AppendUint32(nil, uint32(len(program.instructions)))
+	for _, instr := range program.instructions {
+		lines = binary.LittleEndian.AppendUint32(lines, pool.string(instr.fileName))
+		lines = binary.LittleEndian.AppendUint32(lines, uint32(instr.line))
+	}
+
+	var fused []byte
+	count := 0
+	for i, instr := range program.instructions {
+		if _, ok := superinstructions[instr.instructionType]; !ok {
+			continue
+		}
+		fused = binary.LittleEndian.AppendUint32(fused, uint32(i))
+		fused = binary.LittleEndian.AppendUint32(fused, pool.string(instr.second.fileName))
+		fused = binary.LittleEndian.AppendUint32(fused, uint32(instr.second.line))
+		count++
+	}
+	fused = append(binary.LittleEndian.AppendUint32(nil, uint32(count)), fused...)
+
+	// The pool is encoded last since the other sections intern into it.
+	poolData := binary.LittleEndian.AppendUint32(nil, uint32(len(pool.constants)))
+	for _, constant := range pool.constants {
+		poolData = appendLiteral(poolData, constant)
+	}
+	poolData = binary.LittleEndian.AppendUint32(poolData, uint32(len(pool.strings)))
+	for _, s := range pool.strings {
+		poolData = binary.LittleEndian.AppendUint32(poolData, uint32(len(s)))
+		poolData = append(poolData, s...)
+	}
+
+	sections := []struct {
+		id   uint32
+		data []byte
+	}{
+		{sectionCode, code},
+		{sectionPool, poolData},
+		{sectionHeap, heap},
+		{sectionSymbols, symbols},
+		{sectionLines, lines},
+	}
+	if count > 0 {
+		sections = append(sections, struct {
+			id   uint32
+			data []byte
+		}{sectionFused, fused})
+	}
+
+	buf := append([]byte{}, programMagic...)
+	buf = binary.LittleEndian.AppendUint16(buf, programVersion)
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(sections)))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(program.entrypoint))
+
+	offset := programHeaderSize + sectionEntrySize*len(sections)
+	for _, section := range sections {
+		buf = binary.LittleEndian.AppendUint32(buf, section.id)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(offset))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(section.data)))
+		offset += len(section.data)
+	}
+	for _, section := range sections {
+		buf = append(buf, section.data...)
+	}
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+func decodeProgram(payload []byte) (*Program, error) {
+	if !bytes.HasPrefix(payload, programMagic) {
+		return decodeLegacyProgram(payload)
+	}
+	if len(payload) < programHeaderSize+4 {
+		return nil, fmt.Errorf("truncated header")
+	}
+	body := payload[:len(payload)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(payload[len(payload)-4:]) {
+		return nil, fmt.Errorf("checksum mismatch, file is corrupt")
+	}
+	if version := binary.LittleEndian.Uint16(body[4:6]); version != programVersion {
+		return nil, fmt.Errorf("unsupported format version %d", version)
+	}
+	sectionCount := int(binary.LittleEndian.Uint16(body[6:8]))
+	entrypoint := int(binary.LittleEndian.Uint64(body[8:16]))
+
+	sections := make(map[uint32][]byte)
+	for i := 0; i < sectionCount; i++ {
+		entry := programHeaderSize + i*sectionEntrySize
+		if entry+sectionEntrySize > len(body) {
+			return nil, fmt.Errorf("truncated section table")
+		}
+		id := binary.LittleEndian.Uint32(body[entry : entry+4])
+		offset := int(binary.LittleEndian.Uint32(body[entry+4 : entry+8]))
+		length := int(binary.LittleEndian.Uint32(body[entry+8 : entry+12]))
+		if offset > len(body) || length > len(body)-offset {
+			return nil, fmt.Errorf("section %d out of bounds", id)
+		}
+		sections[id] = body[offset : offset+length]
+	}
+	if _, ok := sections[sectionCode]; !ok {
+		return nil, fmt.Errorf("missing code section")
+	}
+
+	program := newProgram(nil, entrypoint, []int64{}, []Literal{})
+
+	// Pool
+	constants := []Literal{}
+	strs := []string{}
+	if data, ok := sections[sectionPool]; ok {
+		r := &programReader{payload: data}
+		count := r.uint32()
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			constants = append(constants, r.literal())
+		}
+		count = r.uint32()
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			strs = append(strs, string(r.bytes(int(r.uint32()))))
+		}
+		if r.err != nil {
+			return nil, fmt.Errorf("pool section: %w", r.err)
+		}
+	}
+	poolString := func(idx uint32) (string, error) {
+		if int(idx) >= len(strs) {
+			return "", fmt.Errorf("string pool index %d out of range", idx)
+		}
+		return strs[idx], nil
+	}
+
+	// Code
+	r := &programReader{payload: sections[sectionCode]}
+	count := r.uint32()
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		record := r.bytes(4)
+		constIdx := r.uint32()
+		if r.err != nil {
+			break
+		}
+		if int(constIdx) >= len(constants) {
+			return nil, fmt.Errorf("instruction %d references unknown constant %d", i, constIdx)
+		}
+		program.instructions = append(program.instructions, Instruction{
+			instructionType: InstructionSet(record[0]),
+			registerIndex:   int(record[1]),
+			value:           constants[constIdx],
+		})
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("code section: %w", r.err)
+	}
+
+	// Heap
+	if data, ok := sections[sectionHeap]; ok {
+		r := &programReader{payload: data}
+		count := r.uint32()
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			program.heap = append(program.heap, r.literal())
+		}
+		count = r.uint32()
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			program.strStack = append(program.strStack, int64(r.uint64()))
+		}
+		if r.err != nil {
+			return nil, fmt.Errorf("heap section: %w", r.err)
+		}
+	}
+
+	// Symbols
+	if data, ok := sections[sectionSymbols]; ok {
+		r := &programReader{payload: data}
+		count := r.uint32()
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			nameIdx := r.uint32()
+			target := r.uint32()
+			if r.err != nil {
+				break
+			}
+			name, err := poolString(nameIdx)
+			if err != nil {
+				return nil, fmt.Errorf("symbols section: %w", err)
+			}
+			program.symbols[name] = int(target)
+		}
+		if r.err != nil {
+			return nil, fmt.Errorf("symbols section: %w", r.err)
+		}
+	}
+
+	// Lines
+	if data, ok := sections[sectionLines]; ok {
+		r := &programReader{payload: data}
+		count := int(r.uint32())
+		if r.err == nil && count != len(program.instructions) {
+			return nil, fmt.Errorf("line table has %d entries for %d instructions", count, len(program.instructions))
+		}
+		for i := 0; i < count && r.err == nil; i++ {
+			fileIdx := r.uint32()
+			line := r.uint32()
+			if r.err != nil {
+				break
+			}
+			fileName, err := poolString(fileIdx)
+			if err != nil {
+				return nil, fmt.Errorf("lines section: %w", err)
+			}
+			program.instructions[i].fileName = fileName
+			program.instructions[i].line = int(line)
+		}
+		if r.err != nil {
+			return nil, fmt.Errorf("lines section: %w", r.err)
+		}
+	}
+
+	// Fused
+	if data, ok := sections[sectionFused]; ok {
+		r := &programReader{payload: data}
+		count := r.uint32()
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			index := r.uint32()
+			fileIdx := r.uint32()
+			line := r.uint32()
+			if r.err != nil {
+				break
+			}
+			if int(index) >= len(program.instructions) {
+				return nil, fmt.Errorf("fused section: unknown instruction %d", index)
+			}
+			fileName, err := poolString(fileIdx)
+			if err != nil {
+				return nil, fmt.Errorf("fused section: %w", err)
+			}
+			program.instructions[index].second = position{fileName: fileName, line: int(line)}
+		}
+		if r.err != nil {
+			return nil, fmt.Errorf("fused section: %w", r.err)
+		}
+	}
+	return program, nil
+}
+
+// sortedSymbols returns label names ordered by instruction index, then name,
+// so encoding is deterministic.
+func sortedSymbols(symbols map[string]int) []string {
+	names := make([]string, 0, len(symbols))
+	for name := range symbols {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if symbols[names[i]] != symbols[names[j]] {
+			return symbols[names[i]] < symbols[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func appendLiteral(buf []byte, l Literal) []byte {
+	buf = append(buf, uint8(l.valueType))
+	return binary.LittleEndian.AppendUint64(buf, literalBits(l))
+}
+
+// programReader reads sequential little-endian fields, remembering the
+// first out-of-bounds read so callers only need to check once.
+type programReader struct {
+	payload []byte
+	off     int
+	err     error
+}
+
+func (r *programReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || len(r.payload)-r.off < n {
+		if r.err == nil {
+			r.err = fmt.Errorf("unexpected end of file at offset %d", r.off)
+		}
+		return make([]byte, max(n, 1))
+	}
+	b := r.payload[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *programReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.bytes(4))
+}
+
+func (r *programReader) uint64() uint64 {
+	return binary.LittleEndian.Uint64(r.bytes(8))
+}
+
+func (r *programReader) literal() Literal {
+	valueType := LiteralType(r.bytes(1)[0])
+	return literalFromBits(valueType, r.uint64())
+}
+
+// literalBits returns the 8-byte payload stored for a literal.
+func literalBits(l Literal) uint64 {
+	return l.bits
+}
+
+// literalFromBits rebuilds a literal from its type tag and 8-byte payload.
+func literalFromBits(valueType LiteralType, bits uint64) Literal {
+	switch valueType {
+	case LiteralInt:
+		return IntLiteral(int64(bits))
+	case LiteralFloat:
+		return FloatLiteral(math.Float64frombits(bits))
+	case LiteralChar:
+		return CharLiteral(rune(bits))
+	case LiteralPointer:
+		return PointerLiteral(int64(bits))
+	default:
+		return Literal{valueType: valueType}
+	}
+}
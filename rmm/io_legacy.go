@@ -0,0 +1,97 @@
+package rmm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Legacy (unversioned) program file layout, all values little-endian:
+//
+//	[0:8]   entrypoint
+//	[8:...] 16 bytes per instruction:
+//	          0:4  instruction type
+//	          4    literal type
+//	          5    register index
+//	          8:16 literal value
+//	        an end-of-code record (instruction type endOfCodeMarker)
+//	        string table: 8-byte count, then 8-byte heap pointer per entry
+//	        heap image:   8-byte count, then 9 bytes (type + value) per cell
+//	        line table:   8-byte file count, then per file a 4-byte length
+//	                      and the name; then per instruction a 4-byte file
+//	                      index and a 4-byte line
+//
+// The oldest files have no end-of-code record and are just the entrypoint
+// followed by instruction records; they still load, without strings, heap
+// or line information. New files are always written in the v2 format.
+
+const instructionRecordSize = 16
+const endOfCodeMarker = math.MaxUint32
+
+// decodeLegacyProgram reads a program written before the v2 container
+// format was introduced.
+func decodeLegacyProgram(payload []byte) (*Program, error) {
+	if len(payload) < 8 {
+		return nil, fmt.Errorf("missing entrypoint")
+	}
+	program := newProgram(nil, int(binary.LittleEndian.Uint64(payload[0:8])), []int64{}, []Literal{})
+
+	off := 8
+	hasTables := false
+	for off < len(payload) {
+		if len(payload)-off < instructionRecordSize {
+			return nil, fmt.Errorf("truncated instruction record at offset %d", off)
+		}
+		record := payload[off : off+instructionRecordSize]
+		off += instructionRecordSize
+		if binary.LittleEndian.Uint32(record[0:4]) == endOfCodeMarker {
+			hasTables = true
+			break
+		}
+		program.instructions = append(program.instructions, Instruction{
+			instructionType: InstructionSet(binary.LittleEndian.Uint32(record[0:4])),
+			value:           literalFromBits(LiteralType(record[4]), binary.LittleEndian.Uint64(record[8:16])),
+			registerIndex:   int(record[5]),
+		})
+	}
+	if !hasTables {
+		return program, nil
+	}
+
+	r := &programReader{payload: payload, off: off}
+
+	// String table
+	count := r.uint64()
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		program.strStack = append(program.strStack, int64(r.uint64()))
+	}
+
+	// Heap image
+	count = r.uint64()
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		program.heap = append(program.heap, r.literal())
+	}
+
+	// Line table
+	count = r.uint64()
+	files := []string{}
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		files = append(files, string(r.bytes(int(r.uint32()))))
+	}
+	for i := range program.instructions {
+		fileIdx := int(r.uint32())
+		line := int(r.uint32())
+		if r.err != nil {
+			break
+		}
+		if fileIdx >= len(files) {
+			return nil, fmt.Errorf("instruction %d references unknown file %d", i, fileIdx)
+		}
+		program.instructions[i].fileName = files[fileIdx]
+		program.instructions[i].line = line
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return program, nil
+}
@@ -0,0 +1,231 @@
+package rmm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteProgramSerialization(t *testing.T) {
+	// Setup a sample program with entrypoint and instructions
+	instr1 := Instruction{instructionType: InstructionPush, value: IntLiteral(42)}
+	instr2 := Instruction{instructionType: InstructionHalt}
+	program := &Program{
+		entrypoint:   12345,
+		instructions: []Instruction{instr1, instr2},
+	}
+
+	// Create temp file
+	tmpFile, err := os.CreateTemp("", "test_serialization_*.bin")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	// Write program
+	if err := program.WriteFile(tmpFile.Name()); err != nil {
+		t.Fatalf("failed to write program: %v", err)
+	}
+
+	// Read back raw bytes
+	payload, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("failed to read back file: %v", err)
+	}
+
+	if !bytes.Equal(payload[0:4], programMagic) {
+		t.Fatalf("expected magic %q, got %q", programMagic, payload[0:4])
+	}
+	if version := binary.LittleEndian.Uint16(payload[4:6]); version != programVersion {
+		t.Errorf("expected version %d, got %d", programVersion, version)
+	}
+
+	// Verify Entrypoint
+	readEntrypoint := binary.LittleEndian.Uint64(payload[8:16])
+	if int(readEntrypoint) != program.entrypoint {
+		t.Errorf("expected entrypoint %d, got %d", program.entrypoint, int(readEntrypoint))
+	}
+
+	// The first section is code: count, then (type, register, reserved, constant)
+	if id := binary.LittleEndian.Uint32(payload[16:20]); id != sectionCode {
+		t.Fatalf("expected first section to be code, got %d", id)
+	}
+	codeOffset := binary.LittleEndian.Uint32(payload[20:24])
+	code := payload[codeOffset:]
+	if count := binary.LittleEndian.Uint32(code[0:4]); count != 2 {
+		t.Errorf("expected 2 instructions, got %d", count)
+	}
+	if got := InstructionSet(code[4]); got != InstructionPush {
+		t.Errorf("expected first instruction %s, got %s", InstructionPush, got)
+	}
+	if got := InstructionSet(code[12]); got != InstructionHalt {
+		t.Errorf("expected second instruction %s, got %s", InstructionHalt, got)
+	}
+
+	// Verify the trailing checksum
+	body := payload[:len(payload)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(payload[len(payload)-4:]) {
+		t.Errorf("checksum does not match file contents")
+	}
+}
+
+func TestReadProgramRoundTrip(t *testing.T) {
+	program := newProgram([]Instruction{
+		{instructionType: InstructionMov, registerIndex: 7, value: FloatLiteral(2.5), line: 1, fileName: "main.rmm"},
+		{instructionType: InstructionPushReg, registerIndex: 7, line: 2, fileName: "main.rmm"},
+		{instructionType: InstructionGetStr, value: IntLiteral(0), line: 3, fileName: "lib.rmm"},
+		{instructionType: InstructionPush, value: CharLiteral('x'), line: 4, fileName: "main.rmm"},
+		{instructionType: InstructionPushPtr, value: NullLiteral(), line: 5, fileName: "main.rmm"},
+		{instructionType: InstructionAddI, value: IntLiteral(1), line: 6, fileName: "main.rmm", second: position{fileName: "lib.rmm", line: 9}},
+		{instructionType: InstructionHalt, line: 7, fileName: "main.rmm"},
+	}, 1, []int64{0, 3}, []Literal{
+		CharLiteral('h'), CharLiteral('i'), CharLiteral(0),
+		CharLiteral('!'), CharLiteral(0),
+	})
+	program.symbols["main"] = 1
+	program.symbols["end"] = 6
+
+	tmpFile, err := os.CreateTemp("", "test_roundtrip_*.bin")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	if err := program.WriteFile(tmpFile.Name()); err != nil {
+		t.Fatalf("failed to write program: %v", err)
+	}
+	loaded, err := LoadProgram(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("failed to read program: %v", err)
+	}
+
+	if loaded.entrypoint != program.entrypoint {
+		t.Errorf("expected entrypoint %d, got %d", program.entrypoint, loaded.entrypoint)
+	}
+	if !reflect.DeepEqual(loaded.instructions, program.instructions) {
+		t.Errorf("instructions mismatch:\nwant %+v\ngot  %+v", program.instructions, loaded.instructions)
+	}
+	if !reflect.DeepEqual(loaded.strStack, program.strStack) {
+		t.Errorf("string table mismatch: want %v, got %v", program.strStack, loaded.strStack)
+	}
+	if !reflect.DeepEqual(loaded.heap, program.heap) {
+		t.Errorf("heap mismatch: want %v, got %v", program.heap, loaded.heap)
+	}
+	if !reflect.DeepEqual(loaded.symbols, program.symbols) {
+		t.Errorf("symbols mismatch: want %v, got %v", program.symbols, loaded.symbols)
+	}
+}
+
+func TestEncodeProgramKeepsNegativeZero(t *testing.T) {
+	program := newProgram([]Instruction{
+		{instructionType: InstructionPush, value: FloatLiteral(0)},
+		{instructionType: InstructionPush, value: FloatLiteral(math.Copysign(0, -1))},
+	}, 0, []int64{}, []Literal{})
+
+	loaded, err := decodeProgram(encodeProgram(program))
+	if err != nil {
+		t.Fatalf("failed to decode program: %v", err)
+	}
+	if math.Signbit(loaded.instructions[0].value.Float()) || !math.Signbit(loaded.instructions[1].value.Float()) {
+		t.Errorf("expected 0.0 and -0.0 to survive pooling, got %v", loaded.instructions)
+	}
+}
+
+func TestReadProgramDetectsCorruption(t *testing.T) {
+	program := newProgram([]Instruction{
+		{instructionType: InstructionPush, value: IntLiteral(1), line: 1, fileName: "main.rmm"},
+		{instructionType: InstructionHalt, line: 2, fileName: "main.rmm"},
+	}, 0, []int64{}, []Literal{})
+	payload := encodeProgram(program)
+
+	corrupt := append([]byte{}, payload...)
+	corrupt[len(corrupt)/2] ^= 0xFF
+	if _, err := decodeProgram(corrupt); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("expected checksum error, got %v", err)
+	}
+
+	future := append([]byte{}, payload...)
+	binary.LittleEndian.PutUint16(future[4:6], programVersion+1)
+	binary.LittleEndian.PutUint32(future[len(future)-4:], crc32.ChecksumIEEE(future[:len(future)-4]))
+	if _, err := decodeProgram(future); err == nil || !strings.Contains(err.Error(), "unsupported format version") {
+		t.Errorf("expected version error, got %v", err)
+	}
+}
+
+func TestReadProgramLegacyLayout(t *testing.T) {
+	// Entrypoint followed by bare instruction records, no end-of-code record
+	payload := make([]byte, 8+2*instructionRecordSize)
+	binary.LittleEndian.PutUint64(payload[0:8], 1)
+	binary.LittleEndian.PutUint32(payload[8:12], uint32(InstructionPush))
+	payload[12] = uint8(LiteralInt)
+	binary.LittleEndian.PutUint64(payload[16:24], 7)
+	binary.LittleEndian.PutUint32(payload[24:28], uint32(InstructionHalt))
+
+	loaded, err := decodeProgram(payload)
+	if err != nil {
+		t.Fatalf("failed to decode legacy program: %v", err)
+	}
+	want := []Instruction{
+		{instructionType: InstructionPush, value: IntLiteral(7)},
+		{instructionType: InstructionHalt},
+	}
+	if !reflect.DeepEqual(loaded.instructions, want) {
+		t.Errorf("instructions mismatch:\nwant %+v\ngot  %+v", want, loaded.instructions)
+	}
+	if loaded.entrypoint != 1 {
+		t.Errorf("expected entrypoint 1, got %d", loaded.entrypoint)
+	}
+}
+
+func TestReadProgramLegacyLayoutWithTables(t *testing.T) {
+	// Entrypoint, one record, end-of-code record, then string table, heap
+	// image and line table
+	payload := make([]byte, 8+2*instructionRecordSize)
+	binary.LittleEndian.PutUint32(payload[8:12], uint32(InstructionMov))
+	payload[12] = uint8(LiteralInt)
+	payload[13] = 3
+	binary.LittleEndian.PutUint64(payload[16:24], 9)
+	binary.LittleEndian.PutUint32(payload[24:28], endOfCodeMarker)
+	payload = binary.LittleEndian.AppendUint64(payload, 1)
+	payload = binary.LittleEndian.AppendUint64(payload, 0)
+	payload = binary.LittleEndian.AppendUint64(payload, 1)
+	payload = append(payload, uint8(LiteralChar))
+	payload = binary.LittleEndian.AppendUint64(payload, 0)
+	payload = binary.LittleEndian.AppendUint64(payload, 1)
+	payload = binary.LittleEndian.AppendUint32(payload, 8)
+	payload = append(payload, "main.rmm"...)
+	payload = binary.LittleEndian.AppendUint32(payload, 0)
+	payload = binary.LittleEndian.AppendUint32(payload, 4)
+
+	loaded, err := decodeProgram(payload)
+	if err != nil {
+		t.Fatalf("failed to decode legacy program: %v", err)
+	}
+	want := []Instruction{
+		{instructionType: InstructionMov, registerIndex: 3, value: IntLiteral(9), line: 4, fileName: "main.rmm"},
+	}
+	if !reflect.DeepEqual(loaded.instructions, want) {
+		t.Errorf("instructions mismatch:\nwant %+v\ngot  %+v", want, loaded.instructions)
+	}
+	if !reflect.DeepEqual(loaded.strStack, []int64{0}) || !reflect.DeepEqual(loaded.heap, []Literal{CharLiteral(0)}) {
+		t.Errorf("unexpected string table %v or heap %v", loaded.strStack, loaded.heap)
+	}
+}
+
+func TestReadProgramTruncated(t *testing.T) {
+	program := newProgram([]Instruction{
+		{instructionType: InstructionHalt, line: 1, fileName: "main.rmm"},
+	}, 0, []int64{0}, []Literal{CharLiteral(0)})
+	payload := encodeProgram(program)
+
+	if _, err := decodeProgram(payload[:len(payload)-3]); err == nil {
+		t.Fatalf("expected error for truncated program")
+	}
+}
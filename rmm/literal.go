@@ -0,0 +1,332 @@
+package rmm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type LiteralType uint8
+
+const (
+	LiteralNone LiteralType = iota
+	LiteralInt
+	LiteralFloat
+	LiteralChar
+	LiteralString
+	LiteralNull
+	LiteralPointer
+)
+
+// Literal is a value on the stack, in a register or in a heap cell: a type
+// tag and a 64-bit payload holding the int, the float's bits, the char or
+// the heap index, 16 bytes in all.
+type Literal struct {
+	bits      uint64
+	valueType LiteralType
+}
+
+func (l Literal) Type() LiteralType {
+	return l.valueType
+}
+
+// Int returns the value of an integer literal, or 0 for other types.
+func (l Literal) Int() int64 {
+	if l.valueType != LiteralInt {
+		return 0
+	}
+	return l.int()
+}
+
+// Float returns the value of a float literal, or 0 for other types.
+func (l Literal) Float() float64 {
+	if l.valueType != LiteralFloat {
+		return 0
+	}
+	return l.float()
+}
+
+// Char returns the value of a character literal, or 0 for other types.
+func (l Literal) Char() rune {
+	if l.valueType != LiteralChar {
+		return 0
+	}
+	return l.char()
+}
+
+// Pointer returns the heap index held by a pointer literal, or 0 for other
+// types.
+func (l Literal) Pointer() int64 {
+	if l.valueType != LiteralPointer {
+		return 0
+	}
+	return l.ptr()
+}
+
+// int, float, char and ptr read the payload as the caller has checked it
+// to be.
+func (l Literal) int() int64     { return int64(l.bits) }
+func (l Literal) float() float64 { return math.Float64frombits(l.bits) }
+func (l Literal) char() rune     { return rune(l.bits) }
+func (l Literal) ptr() int64     { return int64(l.bits) }
+
+func IntLiteral(value int64) Literal {
+	return Literal{bits: uint64(value), valueType: LiteralInt}
+}
+
+func FloatLiteral(value float64) Literal {
+	return Literal{bits: math.Float64bits(value), valueType: LiteralFloat}
+}
+
+func NullLiteral() Literal {
+	return Literal{valueType: LiteralNull}
+}
+
+func CharLiteral(value rune) Literal {
+	return Literal{bits: uint64(value), valueType: LiteralChar}
+}
+
+func PointerLiteral(value int64) Literal {
+	return Literal{bits: uint64(value), valueType: LiteralPointer}
+}
+
+// ParseLiteral parses a value written as in source (42, -1.5, 'c', '\n',
+// NULL) or a pointer written as PTR 3.
+func ParseLiteral(text string) (Literal, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "NULL":
+		return NullLiteral(), nil
+	case strings.HasPrefix(text, "PTR "):
+		ptr, err := strconv.ParseInt(strings.TrimSpace(text[len("PTR "):]), 10, 64)
+		if err != nil {
+			return Literal{}, fmt.Errorf("invalid pointer %q", text)
+		}
+		return PointerLiteral(ptr), nil
+	case len(text) >= 3 && text[0] == '\'' && text[len(text)-1] == '\'':
+		body := text[1 : len(text)-1]
+		if len(body) == 1 {
+			return CharLiteral(rune(body[0])), nil
+		}
+		escapes := map[string]rune{`\n`: '\n', `\t`: '\t', `\r`: '\r', `\\`: '\\', `\'`: '\'', `\0`: 0}
+		if c, ok := escapes[body]; ok {
+			return CharLiteral(c), nil
+		}
+		return Literal{}, fmt.Errorf("invalid character literal %s", text)
+	}
+	if value, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return IntLiteral(value), nil
+	}
+	if strings.Contains(text, ".") {
+		if value, err := strconv.ParseFloat(text, 64); err == nil {
+			return FloatLiteral(value), nil
+		}
+	}
+	return Literal{}, fmt.Errorf("invalid value %q", text)
+}
+
+func (l Literal) String() string {
+	if l.Type() == LiteralInt {
+		return fmt.Sprintf("INT %d", l.int())
+	} else if l.Type() == LiteralFloat {
+		return fmt.Sprintf("FLOAT %f", l.float())
+	} else if l.Type() == LiteralChar {
+		return fmt.Sprintf("CHAR %c", l.char())
+	} else if l.Type() == LiteralNull {
+		return "NULL"
+	} else if l.Type() == LiteralPointer {
+		return fmt.Sprintf("PTR %d", l.ptr())
+	}
+	return "NONE"
+}
+
+func (l Literal) Print() {
+	fmt.Println(l.String())
+}
+
+func (l Literal) Equal(other Literal) bool {
+	if l.Type() != other.Type() {
+		return false
+	}
+	switch l.Type() {
+	case LiteralInt:
+		return l.int() == other.int()
+	case LiteralFloat:
+		return l.float() == other.float()
+	case LiteralChar:
+		return l.char() == other.char()
+	case LiteralNull:
+		return true
+	case LiteralPointer:
+		return l.ptr() == other.ptr()
+	default:
+		return false
+	}
+}
+
+func (l Literal) Greater(other Literal) bool {
+	if l.Type() != other.Type() {
+		panic(fault(KindType, "\"greater\" comparison requires operands of same type"))
+	}
+	switch l.Type() {
+	case LiteralInt:
+		return l.int() > other.int()
+	case LiteralFloat:
+		return l.float() > other.float()
+	case LiteralPointer:
+		return l.ptr() > other.ptr()
+	default:
+		panic(fault(KindType, "\"greater\" comparison not supported for this type"))
+	}
+}
+
+func (l Literal) Less(other Literal) bool {
+	if l.Type() != other.Type() {
+		panic(fault(KindType, "\"less\" comparison requires operands of same type"))
+	}
+	switch l.Type() {
+	case LiteralInt:
+		return l.int() < other.int()
+	case LiteralFloat:
+		return l.float() < other.float()
+	case LiteralPointer:
+		return l.ptr() < other.ptr()
+	default:
+		panic(fault(KindType, "\"less\" comparison not supported for this type"))
+	}
+}
+
+func (l Literal) GreaterOrEqual(other Literal) bool {
+	if l.Type() != other.Type() {
+		panic(fault(KindType, "\"greater or equal\" comparison requires operands of same type"))
+	}
+	switch l.Type() {
+	case LiteralInt:
+		return l.int() >= other.int()
+	case LiteralFloat:
+		return l.float() >= other.float()
+	case LiteralPointer:
+		return l.ptr() >= other.ptr()
+	default:
+		panic(fault(KindType, "\"greater or equal\" comparison not supported for this type"))
+	}
+}
+
+func (l Literal) LessOrEqual(other Literal) bool {
+	if l.Type() != other.Type() {
+		panic(fault(KindType, "\"less or equal\" comparison requires operands of same type"))
+	}
+	switch l.Type() {
+	case LiteralInt:
+		return l.int() <= other.int()
+	case LiteralFloat:
+		return l.float() <= other.float()
+	case LiteralPointer:
+		return l.ptr() <= other.ptr()
+	default:
+		panic(fault(KindType, "\"less or equal\" comparison not supported for this type"))
+	}
+}
+
+func (l Literal) Add(other Literal) Literal {
+	if l.Type() == LiteralPointer && other.Type() == LiteralInt {
+	} else if l.Type() == LiteralInt && other.Type() == LiteralPointer {
+	} else if l.Type() != other.Type() {
+		panic(fault(KindType, "\"add\" requires operands of same type"))
+	}
+	switch l.Type() {
+	case LiteralInt:
+		if other.Type() == LiteralPointer {
+			return PointerLiteral(l.int() + other.ptr())
+		}
+		return IntLiteral(l.int() + other.int())
+	case LiteralFloat:
+		return FloatLiteral(l.float() + other.float())
+	case LiteralPointer:
+		if other.Type() == LiteralInt {
+			return PointerLiteral(l.ptr() + other.int())
+		}
+		panic(fault(KindType, "\"add\" with pointer requires integer operand"))
+	default:
+		panic(fault(KindType, "\"add\" not supported for this type"))
+	}
+}
+
+func (l Literal) Sub(other Literal) Literal {
+	if l.Type() == LiteralPointer && other.Type() == LiteralInt {
+	} else if l.Type() == LiteralInt && other.Type() == LiteralPointer {
+	} else if l.Type() != other.Type() {
+		panic(fault(KindType, "\"sub\" requires operands of same type"))
+	}
+	switch l.Type() {
+	case LiteralInt:
+		if other.Type() == LiteralPointer {
+			return PointerLiteral(l.int() - other.ptr())
+		}
+		return IntLiteral(l.int() - other.int())
+	case LiteralFloat:
+		return FloatLiteral(l.float() - other.float())
+	case LiteralPointer:
+		if other.Type() == LiteralInt {
+			return PointerLiteral(l.ptr() - other.int())
+		}
+		panic(fault(KindType, "\"sub\" with pointer requires integer operand"))
+	default:
+		panic(fault(KindType, "\"sub\" not supported for this type"))
+	}
+}
+
+func (l Literal) Mul(other Literal) Literal {
+	if l.Type() != other.Type() {
+		panic(fault(KindType, "\"mul\" requires operands of same type"))
+	}
+	switch l.Type() {
+	case LiteralInt:
+		return IntLiteral(l.int() * other.int())
+	case LiteralFloat:
+		return FloatLiteral(l.float() * other.float())
+	default:
+		panic(fault(KindType, "\"mul\" not supported for this type"))
+	}
+}
+
+func (l Literal) Div(other Literal) Literal {
+	if l.Type() != other.Type() {
+		panic(fault(KindType, "\"div\" requires operands of same type"))
+	}
+	switch l.Type() {
+	case LiteralInt:
+		if other.int() == 0 {
+			panic(fault(KindDivideByZero, "division by zero"))
+		}
+		return IntLiteral(l.int() / other.int())
+	case LiteralFloat:
+		if other.float() == 0.0 {
+			panic(fault(KindDivideByZero, "division by zero"))
+		}
+		return FloatLiteral(l.float() / other.float())
+	default:
+		panic(fault(KindType, "\"div\" not supported for this type"))
+	}
+}
+
+func (l Literal) Mod(other Literal) Literal {
+	if l.Type() != other.Type() {
+		panic(fault(KindType, "\"mod\" requires operands of same type"))
+	}
+	switch l.Type() {
+	case LiteralInt:
+		if other.int() == 0 {
+			panic(fault(KindDivideByZero, "modulo by zero"))
+		}
+		return IntLiteral(l.int() % other.int())
+	case LiteralFloat:
+		if other.float() == 0.0 {
+			panic(fault(KindDivideByZero, "modulo by zero"))
+		}
+		return FloatLiteral(math.Mod(l.float(), other.float()))
+	default:
+		panic(fault(KindType, "\"mod\" not supported for this type"))
+	}
+}
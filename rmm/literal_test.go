@@ -0,0 +1,43 @@
+package rmm
+
+import (
+	"math"
+	"testing"
+	"unsafe"
+)
+
+func TestLiteralSize(t *testing.T) {
+	if size := unsafe.Sizeof(Literal{}); size != 16 {
+		t.Errorf("expected a 16-byte Literal, got %d bytes", size)
+	}
+}
+
+func TestLiteralAccessors(t *testing.T) {
+	tests := []struct {
+		l     Literal
+		typ   LiteralType
+		int   int64
+		float float64
+		char  rune
+		ptr   int64
+	}{
+		{IntLiteral(-7), LiteralInt, -7, 0, 0, 0},
+		{FloatLiteral(-0.5), LiteralFloat, 0, -0.5, 0, 0},
+		{CharLiteral('é'), LiteralChar, 0, 0, 'é', 0},
+		{CharLiteral(-1), LiteralChar, 0, 0, -1, 0},
+		{PointerLiteral(42), LiteralPointer, 0, 0, 0, 42},
+		{NullLiteral(), LiteralNull, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		if tt.l.Type() != tt.typ || tt.l.Int() != tt.int || tt.l.Float() != tt.float || tt.l.Char() != tt.char || tt.l.Pointer() != tt.ptr {
+			t.Errorf("%v: got type %v, int %d, float %v, char %d, pointer %d",
+				tt.l, tt.l.Type(), tt.l.Int(), tt.l.Float(), tt.l.Char(), tt.l.Pointer())
+		}
+	}
+	if FloatLiteral(0).Equal(FloatLiteral(math.Copysign(0, -1))) != true {
+		t.Error("expected 0.0 and -0.0 to compare equal")
+	}
+	if IntLiteral(1).Equal(PointerLiteral(1)) {
+		t.Error("expected values of different types to differ")
+	}
+}
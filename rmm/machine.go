@@ -0,0 +1,251 @@
+package rmm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"vm/internal/lexer"
+	"vm/internal/parser"
+)
+
+// Compile assembles .rmm source text. Imports are resolved relative to the
+// working directory.
+func Compile(src string) (*Program, error) {
+	return compile(func() *lexer.Lexer {
+		return lexer.Init("main.rmm").LexSource(src)
+	})
+}
+
+// CompileFile assembles the .rmm file at fileName, following its imports.
+// Both compile functions report bad source as a *CompileError.
+func CompileFile(fileName string) (*Program, error) {
+	return compile(func() *lexer.Lexer {
+		return lexer.Init(fileName).Lex()
+	})
+}
+
+// CompileFileWith is CompileFile reading the file and its imports through
+// readFile, so editors can compile buffers that are not saved yet.
+func CompileFileWith(fileName string, readFile func(name string) ([]byte, error)) (*Program, error) {
+	return compile(func() *lexer.Lexer {
+		l := lexer.Init(fileName)
+		l.ReadFile = readFile
+		return l.Lex()
+	})
+}
+
+func compile(lex func() *lexer.Lexer) (program *Program, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			program, err = nil, compileError(r)
+		}
+	}()
+	parsedTokens, labels := parser.InitWithLabels(lex())
+	instructions, entrypoint := generateInstructions(parsedTokens)
+	// preprocess strings into Heap
+	strStack, heap := populateStringTable(parsedTokens)
+	program = newProgram(instructions, entrypoint, strStack, heap)
+	for name, target := range labels {
+		program.symbols[name] = int(target)
+	}
+	return program, nil
+}
+
+func newProgram(instructions []Instruction, entrypoint int, strStack []int64, heap []Literal) *Program {
+	return &Program{
+		instructions: instructions,
+		entrypoint:   entrypoint,
+		strStack:     strStack,
+		heap:         heap,
+		symbols:      make(map[string]int),
+	}
+}
+
+// Print writes the program's instruction list to stdout.
+func (p *Program) Print() {
+	InstructionList(p.instructions).Print()
+}
+
+// NewMachine creates a machine configured by opts.
+func NewMachine(opts Options) *Machine {
+	machine := &Machine{
+		stack:           []Literal{},
+		allocations:     make(map[int]int),
+		input:           opts.Stdin,
+		output:          opts.Stdout,
+		errOutput:       opts.Stderr,
+		fileDescriptors: make(map[int64]*os.File),
+		strStack:        []int64{},
+		symbols:         make(map[string]int),
+		limits:          opts.Limits,
+		debug:           opts.Debug,
+		hook:            opts.Hook,
+		profiler:        opts.Profiler,
+		coverage:        opts.Coverage,
+		memcheck:        opts.Memcheck,
+	}
+	if machine.input == nil {
+		machine.input = strings.NewReader("")
+	}
+	if machine.output == nil {
+		machine.output = io.Discard
+	}
+	if machine.errOutput == nil {
+		machine.errOutput = io.Discard
+	}
+	if opts.Trace != nil {
+		machine.tracer = newTracer(*opts.Trace)
+	}
+	if opts.GC != nil {
+		gc := *opts.GC
+		if gc.Threshold <= 0 {
+			gc.Threshold = defaultGCThreshold
+		}
+		machine.gc = &gc
+	}
+	if machine.limits.MaxStack <= 0 {
+		machine.limits.MaxStack = maxStackSize
+	}
+	if machine.limits.MaxReturnStack <= 0 {
+		machine.limits.MaxReturnStack = maxReturnStackSize
+	}
+	if machine.limits.MaxStrStack <= 0 {
+		machine.limits.MaxStrStack = maxStrStackSize
+	}
+	if machine.limits.MaxHeap <= 0 {
+		machine.limits.MaxHeap = maxHeapSize
+	}
+	if machine.limits.MaxOpenFiles <= 0 {
+		machine.limits.MaxOpenFiles = maxOpenFiles
+	}
+	return machine
+}
+
+// Run executes program from its entrypoint until it halts, runs off the
+// end, exits or fails. The machine's previous state is discarded first.
+// A non-zero exit is reported as an *ExitError and a fault as a
+// *RuntimeError.
+func (m *Machine) Run(runCtx context.Context, program *Program) error {
+	m.load(program)
+	return m.run(runCtx)
+}
+
+// RunFrom runs program from instruction start against the machine's
+// current stack, heap, registers and string stack instead of resetting
+// them. Strings the program's table has beyond those loaded by earlier
+// runs are copied onto the heap and string stack first. A REPL uses it to
+// run just the newest entry of a program that grows entry by entry.
+func (m *Machine) RunFrom(runCtx context.Context, program *Program, start int) error {
+	if start < 0 || start > len(program.instructions) {
+		return fmt.Errorf("start %d is outside the program (%d instructions)", start, len(program.instructions))
+	}
+	m.instructions = program.instructions
+	m.code = decode(program.instructions)
+	m.entrypoint = start
+	m.symbols = program.symbols
+	for _, ptr := range program.strStack[min(m.loadedStrings, len(program.strStack)):] {
+		m.strStack = append(m.strStack, int64(m.heap.len()))
+		for i := ptr; i < int64(len(program.heap)); i++ {
+			m.heap.push(program.heap[i])
+			if program.heap[i].Type() == LiteralChar && program.heap[i].Char() == 0 {
+				break
+			}
+		}
+	}
+	m.loadedStrings = len(program.strStack)
+	m.returnStack = m.returnStack[:0]
+	m.callDepths = m.callDepths[:0]
+	m.exitCode = 0
+	m.steps = 0
+	m.ip = start
+	return m.run(runCtx)
+}
+
+func (m *Machine) run(runCtx context.Context) error {
+	if m.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeoutCause(runCtx, m.limits.Timeout, timeLimitError{m.limits.Timeout})
+		defer cancel()
+	}
+	if err := runInstructions(runCtx, m); err != nil {
+		return err
+	}
+	if m.exitCode != 0 {
+		return &ExitError{Code: m.exitCode}
+	}
+	return nil
+}
+
+// load resets the machine and copies in program, leaving the program's own
+// heap image untouched by the run.
+func (m *Machine) load(program *Program) {
+	m.instructions = program.instructions
+	m.code = decode(program.instructions)
+	m.entrypoint = program.entrypoint
+	m.symbols = program.symbols
+	m.heap = newHeapStore(program.heap)
+	m.strStack = append([]int64{}, program.strStack...)
+	m.loadedStrings = len(program.strStack)
+	m.stack = []Literal{}
+	m.allocations = make(map[int]int)
+	m.freeBlocks = nil
+	m.liveCells, m.peakCells = 0, 0
+	m.sinceGC, m.collections, m.collected = 0, 0, 0
+	if m.memcheck != nil {
+		m.memcheck.reset()
+	}
+	m.returnStack = make([]int, 0, m.limits.MaxReturnStack)
+	m.callDepths = make([]int, 0, m.limits.MaxReturnStack)
+	m.registers = [MaxRegisters]Literal{}
+	m.exitCode = 0
+	m.steps = 0
+	m.ip = program.entrypoint
+}
+
+// Stack returns a copy of the data stack, bottom first.
+func (m *Machine) Stack() []Literal {
+	return append([]Literal{}, m.stack...)
+}
+
+// Registers returns the values of r0-r15.
+func (m *Machine) Registers() [MaxRegisters]Literal {
+	return m.registers
+}
+
+// Heap returns a copy of the heap.
+func (m *Machine) Heap() []Literal {
+	return m.heap.literals()
+}
+
+// StrStack returns a copy of the string stack (heap pointers), bottom first.
+func (m *Machine) StrStack() []int64 {
+	return append([]int64{}, m.strStack...)
+}
+
+// Steps returns the number of instructions the last run executed.
+func (m *Machine) Steps() int64 {
+	return m.steps
+}
+
+// ExitCode returns the status passed to exit by the last run, or 0.
+func (m *Machine) ExitCode() int {
+	return m.exitCode
+}
+
+// timeLimitError is the cause of a run's context ending when
+// Limits.Timeout runs out, telling it apart from the caller's own deadline.
+type timeLimitError struct {
+	timeout time.Duration
+}
+
+func (e timeLimitError) Error() string {
+	return fmt.Sprintf("time limit of %v exceeded", e.timeout)
+}
+
+// exitRequest unwinds the interpreter loop when the program calls exit.
+type exitRequest struct {
+	code int
+}
@@ -0,0 +1,406 @@
+package rmm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileAndRun(t *testing.T) {
+	program, err := Compile(`
+	push_str "hi\n"
+	mov r2 7
+	get_str 0
+	push 1
+	native 1
+	pop
+	get_str 0
+	push 2
+	native 1
+	pop
+	push 'z'
+	ref
+	push r2
+	push 5
+	add
+	print
+	push 3
+	`)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	machine := NewMachine(Options{Stdout: &stdout, Stderr: &stderr})
+	if err := machine.Run(context.Background(), program); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	if got := stdout.String(); got != "hi\nINT 12\n" {
+		t.Errorf("unexpected stdout %q", got)
+	}
+	if got := stderr.String(); got != "hi\n" {
+		t.Errorf("unexpected stderr %q", got)
+	}
+
+	stack := machine.Stack()
+	if len(stack) != 2 || stack[0].Type() != LiteralPointer || stack[1].Int() != 3 {
+		t.Fatalf("unexpected final stack %v", stack)
+	}
+	if got := machine.Registers()[2]; got.Int() != 7 {
+		t.Errorf("expected r2 = 7, got %v", got)
+	}
+	heap := machine.Heap()
+	if ptr := stack[0].Pointer(); heap[ptr].Char() != 'z' {
+		t.Errorf("expected heap[%d] = 'z', got %v", ptr, heap[ptr])
+	}
+}
+
+func TestRunIsRepeatable(t *testing.T) {
+	program, err := Compile(`
+	push_str "ab"
+	get_str 0
+	push 0
+	index 'X'
+	pop
+	`)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	machine := NewMachine(Options{})
+	for i := 0; i < 2; i++ {
+		if err := machine.Run(context.Background(), program); err != nil {
+			t.Fatalf("run %d failed: %v", i, err)
+		}
+		if len(machine.Stack()) != 0 {
+			t.Fatalf("run %d left stack %v", i, machine.Stack())
+		}
+		if heap := machine.Heap(); heap[0].Char() != 'X' || heap[1].Char() != 'b' {
+			t.Fatalf("run %d: unexpected heap %v", i, heap)
+		}
+	}
+	if program.heap[0].Char() != 'a' {
+		t.Errorf("running modified the program's heap image: %v", program.heap)
+	}
+}
+
+func TestRunReadsStdin(t *testing.T) {
+	program, err := Compile(`
+	push 8
+	native 4
+	native 7
+	push 1
+	native 1
+	`)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	var stdout bytes.Buffer
+	machine := NewMachine(Options{Stdin: strings.NewReader("hello\n"), Stdout: &stdout})
+	if err := machine.Run(context.Background(), program); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got := stdout.String(); got != "hello" {
+		t.Errorf("unexpected stdout %q", got)
+	}
+}
+
+func TestRunExitCode(t *testing.T) {
+	program, err := Compile(`
+	push 3
+	native 60
+	push 1
+	print
+	`)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	var stdout bytes.Buffer
+	machine := NewMachine(Options{Stdout: &stdout})
+	err = machine.Run(context.Background(), program)
+
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Fatalf("expected exit status 3, got %v", err)
+	}
+	if machine.ExitCode() != 3 {
+		t.Errorf("expected ExitCode 3, got %d", machine.ExitCode())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected no output after exit, got %q", stdout.String())
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	if _, err := Compile("jmp nowhere"); err == nil || !strings.Contains(err.Error(), "undefined label") {
+		t.Errorf("expected undefined label error, got %v", err)
+	}
+
+	program, err := Compile(`
+	push 1
+	pop
+	pop
+	`)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	err = NewMachine(Options{}).Run(context.Background(), program)
+	if err == nil || !strings.Contains(err.Error(), "main.rmm:4): stack underflow") {
+		t.Errorf("expected stack underflow, got %v", err)
+	}
+}
+
+// TestRuntimeErrorWritesNothing checks that a fault is only returned, not
+// also written to stderr.
+func TestRuntimeErrorWritesNothing(t *testing.T) {
+	program, err := Compile("push 3.14\nderef\n")
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	var stderr bytes.Buffer
+	err = NewMachine(Options{Stderr: &stderr}).Run(context.Background(), program)
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Kind != KindType {
+		t.Errorf("expected a type error, got %v", err)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("expected nothing on stderr, got %q", stderr.String())
+	}
+}
+
+func TestInterpreterBugsPanic(t *testing.T) {
+	bug := func() (r any) {
+		defer func() { r = recover() }()
+		var cells []Literal
+		i := 1
+		_ = cells[i]
+		return nil
+	}()
+	defer func() {
+		if r := recover(); r != bug {
+			t.Errorf("expected the runtime error to be panicked again, got %v", r)
+		}
+	}()
+	runtimeError(bug, 0, Instruction{})
+	t.Errorf("expected a panic")
+}
+
+func TestPanickedErrorsAreFaults(t *testing.T) {
+	err := runtimeError(errors.New("bad"), 3, Instruction{line: 4, fileName: "main.rmm"})
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Kind != KindUnknown || runtimeErr.IP != 3 || runtimeErr.Line != 4 {
+		t.Errorf("expected an unknown fault at ip 3 line 4, got %v", err)
+	}
+}
+
+func TestCompileErrorDetails(t *testing.T) {
+	tests := []struct {
+		src    string
+		kind   ErrorKind
+		line   int
+		column int
+	}{
+		{"push 1\n  jmp nowhere\n", KindSymbol, 2, 7},
+		{"push 1\npush 'ab'\n", KindSyntax, 2, 6},
+		{"@imp \"missing.rmm\"\n", KindImport, 1, 1},
+		{"mov r16 1\n", KindSyntax, 1, 1},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.src)
+		var compileErr *CompileError
+		if !errors.As(err, &compileErr) {
+			t.Errorf("%q: expected *CompileError, got %T (%v)", tt.src, err, err)
+			continue
+		}
+		if compileErr.Kind != tt.kind || compileErr.File != "main.rmm" || compileErr.Line != tt.line || compileErr.Column != tt.column {
+			t.Errorf("%q: got %v at %s:%d:%d, want %v at main.rmm:%d:%d", tt.src, compileErr.Kind,
+				compileErr.File, compileErr.Line, compileErr.Column, tt.kind, tt.line, tt.column)
+		}
+	}
+}
+
+func TestErrorFileNames(t *testing.T) {
+	file := filepath.Join("src", "main.rmm")
+	want := "ERROR (main.rmm:2): bad"
+	for _, err := range []error{
+		&CompileError{File: file, Line: 2, Message: "bad"},
+		&RuntimeError{File: file, Line: 2, Message: "bad"},
+		&StackError{File: file, Line: 2, Message: "bad"},
+		&TypeError{File: file, Line: 2, Message: "bad"},
+	} {
+		if err.Error() != want {
+			t.Errorf("%T: expected %q, got %q", err, want, err.Error())
+		}
+	}
+}
+
+func TestRuntimeErrorDetails(t *testing.T) {
+	tests := []struct {
+		src    string
+		kind   ErrorKind
+		ip     int
+		line   int
+		column int
+	}{
+		{"push 1\npush 0\n  div\n", KindDivideByZero, 2, 3, 3},
+		{"push 1\npush 2.0\nadd\n", KindType, 2, 3, 1},
+		{"pop\n", KindStackUnderflow, 0, 1, 1},
+		{"push_ptr 99\nderef\n", KindSegfault, 1, 2, 1},
+		{"push 0\nnative 100\n", KindAssertion, 1, 2, 1},
+		{"ret\n", KindStackUnderflow, 0, 1, 1},
+	}
+	for _, tt := range tests {
+		program, err := Compile(tt.src)
+		if err != nil {
+			t.Fatalf("%q: failed to compile: %v", tt.src, err)
+		}
+		err = NewMachine(Options{}).Run(context.Background(), program)
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Errorf("%q: expected *RuntimeError, got %T (%v)", tt.src, err, err)
+			continue
+		}
+		if runtimeErr.Kind != tt.kind || runtimeErr.IP != tt.ip || runtimeErr.Line != tt.line || runtimeErr.Column != tt.column {
+			t.Errorf("%q: got %v at ip %d line %d col %d, want %v at ip %d line %d col %d", tt.src,
+				runtimeErr.Kind, runtimeErr.IP, runtimeErr.Line, runtimeErr.Column, tt.kind, tt.ip, tt.line, tt.column)
+		}
+		if !strings.HasPrefix(err.Error(), "ERROR (main.rmm:") {
+			t.Errorf("%q: unexpected message %q", tt.src, err)
+		}
+	}
+}
+
+func TestRunLimits(t *testing.T) {
+	program, err := Compile(`
+	push 1
+	push 2
+	push 3
+	`)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	err = NewMachine(Options{Limits: Limits{MaxStack: 2}}).Run(context.Background(), program)
+	if err == nil || !strings.Contains(err.Error(), "stack overflow") {
+		t.Errorf("expected stack overflow, got %v", err)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	program, err := Compile(`
+	loop:
+		jmp loop
+	`)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	runCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = NewMachine(Options{}).Run(runCtx, program)
+	if err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Errorf("expected cancellation error, got %v", err)
+	}
+}
+
+func TestRunBudget(t *testing.T) {
+	program, err := Compile(`
+	push 0
+	loop:
+		push 1
+		add
+		jmp loop
+	`)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+
+	machine := NewMachine(Options{Limits: Limits{MaxSteps: 10}})
+	err = machine.Run(context.Background(), program)
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.Kind != KindBudgetExceeded || rtErr.IP != 1 || rtErr.Line != 4 ||
+		!strings.Contains(err.Error(), "step limit of 10 instructions") {
+		t.Fatalf("expected the step limit at ip 1, got %v", err)
+	}
+	// push 0, then three rounds of the loop
+	if stack := machine.Stack(); len(stack) != 1 || stack[0].Int() != 3 {
+		t.Errorf("unexpected stack after 10 steps: %v", stack)
+	}
+
+	err = NewMachine(Options{Limits: Limits{Timeout: 10 * time.Millisecond}}).Run(context.Background(), program)
+	if !errors.As(err, &rtErr) || rtErr.Kind != KindBudgetExceeded || rtErr.Line < 3 ||
+		!strings.Contains(err.Error(), "time limit of 10ms exceeded") {
+		t.Errorf("expected the time limit, got %v", err)
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	openTwice := fmt.Sprintf(`
+	push_str %q
+	get_str 0
+	push %d
+	push 66
+	native 0
+	get_str 0
+	push %d
+	push 66
+	native 0
+	`, path, len(path), len(path))
+	tests := []struct {
+		name    string
+		source  string
+		limits  Limits
+		kind    ErrorKind
+		message string
+	}{
+		{"heap", "push 10\nnative 4\npush 10\nnative 4\n", Limits{MaxHeap: 16}, KindOutOfMemory, "heap limit of 16 cells"},
+		{"heap cell", "push 1\nref\npush 2\nref\n", Limits{MaxHeap: 1}, KindOutOfMemory, "heap limit of 1 cells"},
+		{"huge malloc", "push 1000000000000\nnative 4\n", Limits{}, KindOutOfMemory, "heap limit of 4194304 cells"},
+		{"return stack", "f:\ncall f\n", Limits{MaxReturnStack: 8}, KindStackOverflow, "return stack overflow"},
+		{"string stack", "push_str \"a\"\ndup_str\ndup_str\n", Limits{MaxStrStack: 2}, KindStackOverflow, "string stack overflow"},
+		{"open files", openTwice, Limits{MaxOpenFiles: 1}, KindIO, "too many open files (limit 1)"},
+	}
+	for _, tt := range tests {
+		program, err := Compile(tt.source)
+		if err != nil {
+			t.Fatalf("%s: failed to compile: %v", tt.name, err)
+		}
+		err = NewMachine(Options{Limits: tt.limits}).Run(context.Background(), program)
+		var rtErr *RuntimeError
+		if !errors.As(err, &rtErr) || rtErr.Kind != tt.kind || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("%s: expected a %s error containing %q, got %v", tt.name, tt.kind, tt.message, err)
+		}
+	}
+}
+
+func TestRunFromKeepsState(t *testing.T) {
+	first, err := Compile("push_str \"ab\"\npush 5\nref\n")
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	second, err := Compile("push_str \"ab\"\npush 5\nref\npush_str \"cd\"\nget_str 1\n")
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	machine := NewMachine(Options{})
+	if err := machine.Run(context.Background(), first); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if err := machine.RunFrom(context.Background(), second, first.Len()); err != nil {
+		t.Fatalf("RunFrom failed: %v", err)
+	}
+	stack := machine.Stack()
+	if len(stack) != 2 || stack[0].Pointer() != 3 || stack[1].Pointer() != 4 {
+		t.Fatalf("unexpected stack %v", stack)
+	}
+	if got := machine.HeapString(stack[1].Pointer()); got != "cd" {
+		t.Errorf("expected the new string after the heap cell, got %q", got)
+	}
+	if err := machine.RunFrom(context.Background(), second, second.Len()+1); err == nil {
+		t.Errorf("expected an error for a start past the end")
+	}
+}
@@ -0,0 +1,260 @@
+package rmm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Memcheck checks heap accesses against the blocks malloc and realloc hand
+// out. Attach one to a machine through Options.Memcheck. It shadows every
+// heap cell with the block that owns it and whether it has been written,
+// and faults with KindMemory on a use after free, an access that runs out
+// of the block its pointer points into, or a read of a cell never written.
+// Cells outside any block, such as the program's strings, are only checked
+// for running into a block. Each run starts afresh.
+type Memcheck struct {
+	instructions []Instruction
+	cells        []memCell
+	blocks       map[int]*memBlock // live blocks by start
+	allocations  int
+	frees        int
+}
+
+type memCell struct {
+	block   *memBlock // nil outside the allocator
+	written bool
+}
+
+// memBlock is one allocation. A freed block keeps owning its cells until
+// an allocation reuses them.
+type memBlock struct {
+	start, size int
+	allocIP     int // instruction that allocated it
+	freeIP      int // instruction that freed it, -1 while live
+}
+
+type memAccess uint8
+
+const (
+	memRead memAccess = iota
+	memWrite
+	memCopy // a read that may copy unwritten cells, as memcpy does
+)
+
+// NewMemcheck returns a memcheck with nothing recorded.
+func NewMemcheck() *Memcheck {
+	return &Memcheck{blocks: make(map[int]*memBlock)}
+}
+
+// reset forgets the previous run, as load replaces the heap.
+func (mc *Memcheck) reset() {
+	mc.cells = mc.cells[:0]
+	mc.blocks = make(map[int]*memBlock)
+	mc.allocations, mc.frees = 0, 0
+}
+
+// start begins checking a run of m's program; the cells the program loaded
+// count as written.
+func (mc *Memcheck) start(m *Machine) {
+	mc.instructions = m.instructions
+	for len(mc.cells) < m.heap.len() {
+		mc.cells = append(mc.cells, memCell{written: true})
+	}
+}
+
+// write records that cell index was written, growing the shadow with the
+// heap.
+func (mc *Memcheck) write(index int) {
+	for len(mc.cells) <= index {
+		mc.cells = append(mc.cells, memCell{})
+	}
+	mc.cells[index].written = true
+}
+
+// alloc records a new block, whose cells are not yet written.
+func (mc *Memcheck) alloc(start, size, ip int) {
+	b := &memBlock{start: start, size: size, allocIP: ip, freeIP: -1}
+	mc.blocks[start] = b
+	mc.allocations++
+	mc.claim(b, start, start+size)
+}
+
+func (mc *Memcheck) claim(b *memBlock, from, to int) {
+	for i := from; i < to; i++ {
+		mc.cells[i] = memCell{block: b}
+	}
+}
+
+// resize records that the block at start now has size cells, all but the
+// new ones keeping their state.
+func (mc *Memcheck) resize(start, size int) {
+	b := mc.blocks[start]
+	if size > b.size {
+		mc.claim(b, b.start+b.size, b.start+size)
+	}
+	b.size = size
+}
+
+// move copies the written state of n cells along with their values, as
+// memcpy and a moving realloc do.
+func (mc *Memcheck) move(from, to, n int) {
+	for i := 0; i < n; i++ {
+		mc.cells[to+i].written = mc.cells[from+i].written
+	}
+}
+
+// relocate records that compaction slid the block at from down to to.
+func (mc *Memcheck) relocate(from, to int) {
+	b := mc.blocks[from]
+	delete(mc.blocks, from)
+	b.start = to
+	mc.blocks[to] = b
+	for i := 0; i < b.size; i++ {
+		mc.cells[to+i] = memCell{block: b, written: mc.cells[from+i].written}
+	}
+	for i := max(from, to+b.size); i < from+b.size; i++ {
+		mc.cells[i] = memCell{}
+	}
+}
+
+// truncate records that compaction shrank the heap to n cells.
+func (mc *Memcheck) truncate(n int) {
+	mc.cells = mc.cells[:n]
+}
+
+// free records that the block at start was freed.
+func (mc *Memcheck) free(start, ip int) {
+	b := mc.blocks[start]
+	delete(mc.blocks, start)
+	b.freeIP = ip
+	mc.frees++
+}
+
+// freed returns the freed block starting at start, if its cells have not
+// been reused.
+func (mc *Memcheck) freed(start int) *memBlock {
+	if start < 0 || start >= len(mc.cells) {
+		return nil
+	}
+	b := mc.cells[start].block
+	if b == nil || b.freeIP < 0 || b.start != start {
+		return nil
+	}
+	return b
+}
+
+// access checks an access to cell index through a pointer to cell base.
+// Accesses outside the heap are left to the caller's bounds checks.
+func (mc *Memcheck) access(ctx *RuntimeContext, base, index int, kind memAccess) {
+	if base < 0 || base >= len(mc.cells) || index < 0 {
+		return
+	}
+	b := mc.cells[base].block
+	var owner *memBlock
+	if index < len(mc.cells) {
+		owner = mc.cells[index].block
+	}
+	if b == nil {
+		if owner != nil {
+			panic(ctx.Error(KindMemory, fmt.Sprintf("memcheck: overflow: cell %d through pointer %d is in the %d-cell block at %d allocated at %s",
+				index, base, owner.size, owner.start, mc.site(owner.allocIP))))
+		}
+		return
+	}
+	if b.freeIP >= 0 {
+		panic(ctx.Error(KindMemory, fmt.Sprintf("memcheck: use after free: cell %d of the %d-cell block at %d allocated at %s and freed at %s",
+			index, b.size, b.start, mc.site(b.allocIP), mc.site(b.freeIP))))
+	}
+	if owner != b || index < b.start || index >= b.start+b.size {
+		panic(ctx.Error(KindMemory, fmt.Sprintf("memcheck: overflow: cell %d is outside the %d-cell block at %d allocated at %s",
+			index, b.size, b.start, mc.site(b.allocIP))))
+	}
+	if kind == memRead && !mc.cells[index].written {
+		panic(ctx.Error(KindMemory, fmt.Sprintf("memcheck: read of uninitialized cell %d of the %d-cell block at %d allocated at %s",
+			index, b.size, b.start, mc.site(b.allocIP))))
+	}
+}
+
+// site names the source line of the instruction at ip.
+func (mc *Memcheck) site(ip int) string {
+	if ip < 0 || ip >= len(mc.instructions) {
+		return fmt.Sprintf("ip %d", ip)
+	}
+	instr := mc.instructions[ip]
+	return fmt.Sprintf("%s:%d", instr.fileName, instr.line)
+}
+
+// checkAccess checks an access to cell index through a pointer to cell
+// base when memcheck is on.
+func checkAccess[I int | int64](ctx *RuntimeContext, base, index I, kind memAccess) {
+	if ctx.memcheck != nil {
+		ctx.memcheck.access(ctx, int(base), int(index), kind)
+	}
+}
+
+// Leak is the blocks allocated at one source line and never freed.
+type Leak struct {
+	File        string
+	Line        int
+	Allocations int
+	Cells       int
+}
+
+// Leaks returns the blocks still allocated, grouped by the line that
+// allocated them, largest first.
+func (mc *Memcheck) Leaks() []Leak {
+	bySite := make(map[string]*Leak)
+	for _, b := range mc.blocks {
+		site := mc.site(b.allocIP)
+		leak := bySite[site]
+		if leak == nil {
+			leak = &Leak{}
+			if b.allocIP >= 0 && b.allocIP < len(mc.instructions) {
+				leak.File, leak.Line = mc.instructions[b.allocIP].fileName, mc.instructions[b.allocIP].line
+			}
+			bySite[site] = leak
+		}
+		leak.Allocations++
+		leak.Cells += b.size
+	}
+	leaks := make([]Leak, 0, len(bySite))
+	for _, leak := range bySite {
+		leaks = append(leaks, *leak)
+	}
+	sort.Slice(leaks, func(i, j int) bool {
+		a, b := leaks[i], leaks[j]
+		if a.Cells != b.Cells {
+			return a.Cells > b.Cells
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return leaks
+}
+
+// WriteReport writes the leak report: the blocks never freed by the line
+// that allocated them.
+func (mc *Memcheck) WriteReport(w io.Writer) error {
+	leaks := mc.Leaks()
+	var sb strings.Builder
+	if len(leaks) == 0 {
+		fmt.Fprintf(&sb, "memcheck: no leaks, %d allocations and %d frees\n", mc.allocations, mc.frees)
+	} else {
+		blocks, cells := 0, 0
+		for _, leak := range leaks {
+			blocks += leak.Allocations
+			cells += leak.Cells
+		}
+		fmt.Fprintf(&sb, "memcheck: %d cells leaked in %d allocations, of %d allocations and %d frees\n",
+			cells, blocks, mc.allocations, mc.frees)
+		for _, leak := range leaks {
+			fmt.Fprintf(&sb, "  %s:%d: %d cells in %d allocations\n", leak.File, leak.Line, leak.Cells, leak.Allocations)
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,93 @@
+package rmm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMemcheckErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		message string // empty when the run must succeed
+	}{
+		{"use after free", "push 2\nnative 4\ndup\nnative 6\nderef\n", "use after free: cell 0 of the 2-cell block at 0 allocated at main.rmm:2 and freed at main.rmm:4"},
+		{"overflow into neighbour", "push 2\nnative 4\npush 2\nnative 4\nswap\npush 2\npush 'x'\nindex\n", "overflow: cell 2 is outside the 2-cell block at 0 allocated at main.rmm:2"},
+		{"uninitialized read", "push 2\nnative 4\nderef\n", "read of uninitialized cell 0 of the 2-cell block at 0"},
+		{"written read", "push 2\nnative 4\npush 0\npush 'x'\nindex\nderef\n", ""},
+		{"shrunk block", "push 4\nnative 4\npush 1\nnative 5\npush 2\npush 'x'\nindex\n", "overflow: cell 2 is outside the 1-cell block at 0"},
+		{"string into block", "push_str \"ab\"\npush 2\nnative 4\nget_str 0\npush 3\npush 'x'\nindex\n", "overflow: cell 3 through pointer 0 is in the 2-cell block at 3"},
+		{"strcpy overflow", "push_str \"abc\"\npush 2\nnative 4\nget_str 0\nnative 91\n", "overflow: cell 6 is outside the 2-cell block at 4"},
+		{"memcpy unwritten", "push 2\nnative 4\npush 2\nnative 4\nswap\npush 2\nnative 92\n", ""},
+		{"memcpy keeps state", "push 2\nnative 4\npush 2\nnative 4\nswap\npush 2\nnative 92\nderef\n", "read of uninitialized cell 2"},
+		{"realloc keeps state", "push 1\nnative 4\npush 0\npush 'x'\nindex\npush 1\nnative 4\nswap\npush 4\nnative 5\nderef\n", ""},
+		{"double free", "push 1\nnative 4\ndup\nnative 6\nnative 6\n", "double free of the block at 0 allocated at main.rmm:2 and freed at main.rmm:4"},
+	}
+	for _, tt := range tests {
+		program, err := CompileFileWith("main.rmm", func(string) ([]byte, error) { return []byte(tt.source), nil })
+		if err != nil {
+			t.Fatalf("%s: failed to compile: %v", tt.name, err)
+		}
+		err = NewMachine(Options{Memcheck: NewMemcheck()}).Run(context.Background(), program)
+		if tt.message == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.name, err)
+			}
+			continue
+		}
+		var rtErr *RuntimeError
+		if !errors.As(err, &rtErr) || !strings.Contains(rtErr.Message, tt.message) {
+			t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.message, err)
+		}
+	}
+}
+
+func TestMemcheckOff(t *testing.T) {
+	program, err := Compile("push 2\nnative 4\ndup\nnative 6\nderef\n")
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	if err := NewMachine(Options{}).Run(context.Background(), program); err != nil {
+		t.Errorf("expected a use after free to go unchecked without memcheck, got %v", err)
+	}
+}
+
+func TestMemcheckLeaks(t *testing.T) {
+	source := "push 2\nnative 4\nnative 6\nloop:\npush 3\nnative 4\npop\npush 1\nnative 4\npop\njmp loop\n"
+	program, err := CompileFileWith("main.rmm", func(string) ([]byte, error) { return []byte(source), nil })
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	mc := NewMemcheck()
+	err = NewMachine(Options{Memcheck: mc, Limits: Limits{MaxSteps: 3 + 2*7}}).Run(context.Background(), program)
+	var rtErr *RuntimeError
+	if !errors.As(err, &rtErr) || rtErr.Kind != KindBudgetExceeded {
+		t.Fatalf("expected the step limit, got %v", err)
+	}
+	want := []Leak{
+		{File: "main.rmm", Line: 6, Allocations: 2, Cells: 6},
+		{File: "main.rmm", Line: 9, Allocations: 2, Cells: 2},
+	}
+	leaks := mc.Leaks()
+	if len(leaks) != len(want) {
+		t.Fatalf("expected %v, got %v", want, leaks)
+	}
+	for i := range want {
+		if leaks[i] != want[i] {
+			t.Errorf("leak %d: expected %+v, got %+v", i, want[i], leaks[i])
+		}
+	}
+	var report bytes.Buffer
+	if err := mc.WriteReport(&report); err != nil {
+		t.Fatalf("failed to write report: %v", err)
+	}
+	wantReport := "memcheck: 8 cells leaked in 4 allocations, of 5 allocations and 1 frees\n" +
+		"  main.rmm:6: 6 cells in 2 allocations\n" +
+		"  main.rmm:9: 2 cells in 2 allocations\n"
+	if report.String() != wantReport {
+		t.Errorf("expected report:\n%sgot:\n%s", wantReport, report.String())
+	}
+}
@@ -0,0 +1,160 @@
+package rmm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Machine executes programs. Create one with NewMachine; its stack, heap
+// and registers stay inspectable after Run returns.
+type Machine struct {
+	stack           []Literal
+	instructions    []Instruction
+	code            []op // instructions decoded for the run loop
+	heap            heapStore
+	allocations     map[int]int // ptr -> size, for safety checks
+	freeBlocks      []heapBlock // free cells, sorted by address
+	liveCells       int         // cells in live allocations
+	peakCells       int
+	gc              *GCOptions // nil unless the collector is on
+	sinceGC         int        // cells allocated since the last collection
+	collections     int
+	collected       int
+	input           io.Reader
+	output          io.Writer
+	errOutput       io.Writer
+	fileDescriptors map[int64]*os.File
+	stringTable     []int64
+	entrypoint      int
+	strStack        []int64        // Stack of pointers to heap
+	loadedStrings   int            // program strings copied in by load or RunFrom
+	symbols         map[string]int // label -> instruction index
+	ip              int            // index of the instruction being executed
+	steps           int64          // instructions executed by the last run
+	returnStack     []int
+	callDepths      []int // data stack depth at each active call
+	// Registers (r0-r15)
+	registers [MaxRegisters]Literal
+	limits    Limits
+	debug     bool
+	exitCode  int
+	hook      StepHook
+	tracer    *tracer
+	profiler  *Profiler
+	coverage  *Coverage
+	memcheck  *Memcheck
+}
+
+type RuntimeContext struct {
+	*Machine
+	part int // the part of a superinstruction being executed
+}
+
+// instruction returns the instruction being executed, or for a
+// superinstruction the part of it being executed.
+func (ctx *RuntimeContext) instruction() Instruction {
+	return ctx.instructionPart(ctx.ip, ctx.part)
+}
+
+// instructionPart returns the instruction at ip, or for a superinstruction
+// the given part of it.
+func (m *Machine) instructionPart(ip, part int) Instruction {
+	if ip < 0 || ip >= len(m.instructions) {
+		return Instruction{}
+	}
+	instr := m.instructions[ip]
+	if _, ok := superinstructions[instr.instructionType]; ok {
+		return instr.parts()[part]
+	}
+	return instr
+}
+
+// Program is an assembled program. It is not modified by running it, so
+// one Program can be run any number of times.
+type Program struct {
+	instructions []Instruction
+	entrypoint   int
+	strStack     []int64        // initial string table (pointers into heap)
+	heap         []Literal      // initial heap image
+	symbols      map[string]int // label -> instruction index
+}
+
+// Options configures a Machine. Nil readers and writers behave like an
+// empty input and a discarded output.
+type Options struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	Limits Limits
+	// Debug writes every executed instruction and the stack to Stderr.
+	Debug bool
+	// Hook, if set, is called before every instruction.
+	Hook StepHook
+	// Trace, if set, writes a record of every executed instruction.
+	Trace *TraceOptions
+	// Profiler, if set, counts and times every executed instruction.
+	Profiler *Profiler
+	// Coverage, if set, records which instructions and branches ran.
+	Coverage *Coverage
+	// Memcheck, if set, checks heap accesses against allocations.
+	Memcheck *Memcheck
+	// GC, if set, turns on the garbage collector.
+	GC *GCOptions
+}
+
+// StepHook is called with the machine stopped before the instruction at
+// m.IP(). It may inspect and modify the machine; a non-nil error stops the
+// run and is returned by Run unchanged.
+type StepHook func(m *Machine) error
+
+// Limits bounds a machine's resources. Zero fields use the defaults.
+type Limits struct {
+	MaxStack       int
+	MaxReturnStack int
+	MaxStrStack    int
+	MaxHeap        int // heap cells, including the program's strings
+	MaxOpenFiles   int // files open at once through the open native
+	// MaxSteps bounds the instructions one run executes, and Timeout its
+	// wall-clock time. Zero means no bound. Exceeding either is a fault of
+	// kind KindBudgetExceeded; the run's own context, deadline or not,
+	// still ends it with KindCancelled.
+	MaxSteps int64
+	Timeout  time.Duration
+}
+
+// ExitError is returned by Run when the program calls exit with a non-zero
+// status.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+type Instruction struct {
+	operator        uint8
+	instructionType InstructionSet
+	value           Literal
+	registerIndex   int
+	length          int
+	line            int
+	column          int // not stored in program.bin
+	fileName        string
+	second          position // of the second part of a superinstruction
+}
+
+// position is where in the source an instruction came from.
+type position struct {
+	fileName     string
+	line, column int
+}
+
+const maxStackSize = 1024
+const maxReturnStackSize = 1024
+const maxStrStackSize = 1024
+const maxHeapSize = 1 << 22
+const maxOpenFiles = 256
+const MaxRegisters = 16
@@ -0,0 +1,93 @@
+package rmm
+
+// Native is the signature of a native syscall. The stack verifier takes its
+// stack effect from it, the type checker its argument types, and the
+// language server its documentation.
+type Native struct {
+	Name   string        // the name stddefs.rmm gives it
+	Params []NativeParam // in the order they are pushed, so the last is on top
+	Result string        // what it pushes, or "" if it pushes nothing
+	Doc    string
+
+	result typeSet
+}
+
+// NativeParam is an argument of a native syscall.
+type NativeParam struct {
+	Name  string
+	types typeSet
+}
+
+// Effect is the native's stack effect.
+func (n Native) Effect() StackEffect {
+	if n.Result == "" {
+		return StackEffect{len(n.Params), 0}
+	}
+	return StackEffect{len(n.Params), 1}
+}
+
+// nativeSignatures holds the signature of each native syscall by ID.
+var nativeSignatures = map[int64]Native{
+	0: {"open", []NativeParam{{"name", typePointer}, {"length", typeInt}, {"flags", typeInt}}, "fd",
+		"Open the file named by the length characters at name.", typeInt},
+	1: {"write", []NativeParam{{"string", typePointer}, {"fd", typeInt}}, "length",
+		"Write the NUL-terminated string to fd.", typeInt},
+	2: {"read", []NativeParam{{"buffer", typePointer}, {"length", typeInt}, {"fd", typeInt}}, "",
+		"Read up to length bytes from fd into the buffer.", 0},
+	3: {"close", []NativeParam{{"fd", typeInt}}, "",
+		"Close a file descriptor.", 0},
+	4: {"malloc", []NativeParam{{"size", typeInt}}, "block",
+		"Allocate size heap cells.", typePointer},
+	5: {"realloc", []NativeParam{{"block", typePointer | typeNull}, {"size", typeInt}}, "block",
+		"Resize an allocation, moving it if needed.", typePointer},
+	6: {"free", []NativeParam{{"block", typePointer | typeNull}}, "",
+		"Release an allocation.", 0},
+	7: {"scanf", []NativeParam{{"buffer", typePointer}}, "buffer",
+		"Read a word from stdin into the buffer.", typePointer},
+	8: {"pow", []NativeParam{{"exponent", typeInt}, {"base", typeInt}}, "result",
+		"Raise base (top) to exponent.", typeInt},
+	10: {"time", nil, "seconds",
+		"Push the Unix time.", typeInt},
+	60: {"exit", []NativeParam{{"code", typeInt}}, "",
+		"Exit the program with code.", 0},
+	90: {"strcmp", []NativeParam{{"string", typePointer}, {"string", typePointer}}, "equal",
+		"Push 1 if the strings are equal, else 0.", typeInt},
+	91: {"strcpy", []NativeParam{{"destination", typePointer}, {"source", typePointer}}, "destination",
+		"Copy the string at source to destination.", typePointer},
+	92: {"memcpy", []NativeParam{{"destination", typePointer}, {"source", typePointer}, {"size", typeInt}}, "destination",
+		"Copy size cells from source to destination.", typePointer},
+	93: {"strcat", []NativeParam{{"destination", typePointer}, {"source", typePointer}}, "destination",
+		"Append the string at source to the one at destination.", typePointer},
+	94: {"strlen", []NativeParam{{"string", typePointer}}, "length",
+		"Push the length of the string.", typeInt},
+	98: {"float_to_str", []NativeParam{{"value", typeFloat}}, "string",
+		"Format a float as a new heap string.", typePointer},
+	99: {"int_to_str", []NativeParam{{"value", typeInt}}, "string",
+		"Format an integer as a new heap string.", typePointer},
+	100: {"assert", []NativeParam{{"condition", typeAny}}, "",
+		"Fault with an assertion error if condition is 0.", 0},
+	101: {"gc", nil, "cells",
+		"Run the garbage collector; push the cells reclaimed.", typeInt},
+}
+
+// nativeExit's ID: it ends the program, so nothing follows it.
+const nativeExitID = 60
+
+// Natives returns the signature of each native syscall by ID.
+func Natives() map[int64]Native {
+	signatures := make(map[int64]Native, len(nativeSignatures))
+	for id, native := range nativeSignatures {
+		signatures[id] = native
+	}
+	return signatures
+}
+
+// NativeNames returns the native syscall IDs and the names stddefs.rmm
+// gives them.
+func NativeNames() map[int64]string {
+	names := make(map[int64]string, len(nativeSignatures))
+	for id, native := range nativeSignatures {
+		names[id] = native.Name
+	}
+	return names
+}
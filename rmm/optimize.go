@@ -0,0 +1,422 @@
+package rmm
+
+// The optimizer rewrites a program's instructions without changing what it
+// does. Rewrites replace instructions with noops in place, so that jump
+// targets stay valid while they run; compact then drops the noops and
+// remaps the targets, the entrypoint and the labels. The rewrites repeat
+// until none applies. Kept instructions keep their file and line, and an
+// instruction that replaces several takes the position of the first, so
+// errors still point at the source.
+
+// MaxOptLevel is the highest level Optimize knows.
+const MaxOptLevel = 2
+
+// Optimize returns the program rewritten at the given level for the
+// default limits. Level 0 returns p itself; level 1 drops noops, folds
+// constant arithmetic and comparisons, removes a push that is popped
+// straight away, threads jumps to jumps and removes code that nothing can
+// reach; level 2 then fuses pairs of instructions into superinstructions.
+func (p *Program) Optimize(level int) *Program {
+	return p.OptimizeFor(level, Limits{})
+}
+
+// OptimizeFor is Optimize for a machine with the given limits. Folding
+// takes pushes away, so it only folds where the data stack is known to
+// stay within limits.MaxStack through the instructions it replaces; where
+// they would overflow the stack, the optimized program still does.
+func (p *Program) OptimizeFor(level int, limits Limits) *Program {
+	if level <= 0 {
+		return p
+	}
+	o := &optimizer{
+		instructions: append([]Instruction{}, p.instructions...),
+		entrypoint:   p.entrypoint,
+		symbols:      make(map[string]int, len(p.symbols)),
+		maxStack:     limits.MaxStack,
+	}
+	if o.maxStack <= 0 {
+		o.maxStack = maxStackSize
+	}
+	for name, index := range p.symbols {
+		o.symbols[name] = index
+	}
+	for changed := true; changed; {
+		o.markLeaders()
+		changed = o.threadJumps()
+		changed = o.removeUnreachable() || changed
+		changed = o.fold() || changed
+		changed = o.compact() || changed
+	}
+	if level >= 2 {
+		o.markLeaders()
+		o.fuse()
+		o.compact()
+	}
+	program := newProgram(o.instructions, o.entrypoint, p.strStack, p.heap)
+	program.symbols = o.symbols
+	return program
+}
+
+type optimizer struct {
+	instructions []Instruction
+	entrypoint   int
+	symbols      map[string]int
+	// leaders are the instructions run other than by falling through from
+	// the one before: jump and call targets, return addresses, labels and
+	// the entrypoint. A rewrite only spans several instructions when all
+	// but the first are not leaders.
+	leaders []bool
+	// maxStack is the data stack limit of the machine the program is for.
+	maxStack int
+}
+
+func (o *optimizer) markLeaders() {
+	o.leaders = make([]bool, len(o.instructions)+1)
+	mark := func(i int) {
+		if i >= 0 && i < len(o.leaders) {
+			o.leaders[i] = true
+		}
+	}
+	mark(o.entrypoint)
+	for _, index := range o.symbols {
+		mark(index)
+	}
+	for i, instr := range o.instructions {
+		if target, ok := o.target(i); ok {
+			mark(target)
+		}
+		if instr.instructionType == InstructionCall {
+			mark(i + 1)
+		}
+	}
+}
+
+// target returns the target of the jump or call at i, if it has a valid
+// one.
+func (o *optimizer) target(i int) (int, bool) {
+	instr := o.instructions[i]
+	if !isJumpInstruction(instr.instructionType) || instr.value.Type() != LiteralInt {
+		return 0, false
+	}
+	target := instr.value.Int()
+	if target < 0 || target >= int64(len(o.instructions)) {
+		return 0, false
+	}
+	return int(target), true
+}
+
+func (o *optimizer) setTarget(i, target int) {
+	o.instructions[i].value = IntLiteral(int64(target))
+}
+
+// threadJumps points jumps and calls whose target is an unconditional jump
+// at where that jump chain ends, and drops jumps to the next instruction.
+func (o *optimizer) threadJumps() bool {
+	changed := false
+	for i := range o.instructions {
+		target, ok := o.target(i)
+		if !ok {
+			continue
+		}
+		end, seen := target, map[int]bool{target: true}
+		for o.instructions[end].instructionType == InstructionJmp {
+			next, ok := o.target(end)
+			if !ok || seen[next] {
+				break
+			}
+			end = next
+			seen[end] = true
+		}
+		if o.instructions[end].instructionType == InstructionJmp {
+			// The chain loops or ends in a bad target; leave it to fault.
+			continue
+		}
+		if end != target {
+			o.setTarget(i, end)
+			changed = true
+		}
+		if o.instructions[i].instructionType == InstructionJmp && end == i+1 {
+			o.noop(i)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// removeUnreachable drops the instructions that cannot run: those neither
+// reached from the entrypoint or a label nor from an instruction that is.
+func (o *optimizer) removeUnreachable() bool {
+	reached := make([]bool, len(o.instructions))
+	var work []int
+	visit := func(i int) {
+		if i >= 0 && i < len(reached) && !reached[i] {
+			reached[i] = true
+			work = append(work, i)
+		}
+	}
+	visit(o.entrypoint)
+	for _, index := range o.symbols {
+		visit(index)
+	}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		if target, ok := o.target(i); ok {
+			visit(target)
+		}
+		switch o.instructions[i].instructionType {
+		case InstructionJmp, InstructionRet, InstructionHalt:
+		default:
+			visit(i + 1)
+		}
+	}
+	changed := false
+	for i, instr := range o.instructions {
+		if !reached[i] && instr.instructionType != InstructionNoOp {
+			o.noop(i)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// fold replaces a constant pushed and popped straight away with nothing,
+// and arithmetic, comparisons and casts of constants with their result.
+// The first two take away pushes that can overflow the stack, so they
+// only apply where the stack is known to have room for them.
+func (o *optimizer) fold() bool {
+	changed := false
+	depths := stackDepths(&Program{instructions: o.instructions, entrypoint: o.entrypoint, symbols: o.symbols})
+	room := func(i, pushes int) bool {
+		return depths[i] >= 0 && depths[i]+pushes <= o.maxStack
+	}
+	for i := 0; i < len(o.instructions); i++ {
+		switch {
+		case o.constant(i) && o.follows(i, InstructionPop) && room(i, 1):
+			o.noop(i)
+			o.noop(i + 1)
+			changed = true
+			i++
+		case o.constant(i) && o.constant(i+1) && !o.leaders[i+1] && i+2 < len(o.instructions) && !o.leaders[i+2] && room(i, 2):
+			if result, ok := foldBinary(o.instructions[i+2].instructionType, o.instructions[i].value, o.instructions[i+1].value); ok {
+				o.instructions[i].value = result
+				o.noop(i + 1)
+				o.noop(i + 2)
+				changed = true
+				i += 2
+			}
+		case o.constant(i) && i+1 < len(o.instructions) && !o.leaders[i+1]:
+			if result, ok := foldUnary(o.instructions[i+1].instructionType, o.instructions[i].value); ok {
+				o.instructions[i].value = result
+				o.noop(i + 1)
+				changed = true
+				i++
+			}
+		}
+	}
+	return changed
+}
+
+// constant reports whether instruction i pushes a constant.
+func (o *optimizer) constant(i int) bool {
+	return i < len(o.instructions) && o.instructions[i].instructionType == InstructionPush &&
+		o.instructions[i].value.Type() != LiteralNone
+}
+
+// follows reports whether instruction i+1 is of the given type and only
+// runs after instruction i.
+func (o *optimizer) follows(i int, instructionType InstructionSet) bool {
+	return i+1 < len(o.instructions) && !o.leaders[i+1] && o.instructions[i+1].instructionType == instructionType
+}
+
+// foldBinary computes the operator on b, pushed first, and a, as the
+// machine would, and reports false for an operator it does not fold or
+// operands the machine would fault on. Those are left for the machine,
+// which raises the fault at the operator's line as in the unoptimized
+// program.
+func foldBinary(operator InstructionSet, b, a Literal) (result Literal, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isFault := r.(*RuntimeError); !isFault {
+				panic(r)
+			}
+			result, ok = Literal{}, false
+		}
+	}()
+	boolean := func(v bool) Literal {
+		if v {
+			return IntLiteral(1)
+		}
+		return IntLiteral(0)
+	}
+	switch operator {
+	case InstructionAdd:
+		return a.Add(b), true
+	case InstructionSub:
+		return b.Sub(a), true
+	case InstructionMul:
+		return b.Mul(a), true
+	case InstructionDiv:
+		return b.Div(a), true
+	case InstructionMod:
+		return b.Mod(a), true
+	case InstructionCmpe:
+		return boolean(b.Equal(a)), true
+	case InstructionCmpne:
+		return boolean(!b.Equal(a)), true
+	case InstructionCmpg:
+		return boolean(b.Greater(a)), true
+	case InstructionCmpl:
+		return boolean(b.Less(a)), true
+	case InstructionCmpge:
+		return boolean(b.GreaterOrEqual(a)), true
+	case InstructionCmple:
+		return boolean(b.LessOrEqual(a)), true
+	}
+	return Literal{}, false
+}
+
+// foldUnary computes a cast of a constant.
+func foldUnary(operator InstructionSet, v Literal) (Literal, bool) {
+	switch {
+	case operator == InstructionCastIntToFloat && v.Type() == LiteralInt:
+		return FloatLiteral(float64(v.Int())), true
+	case operator == InstructionCastFloatToInt && v.Type() == LiteralFloat:
+		return IntLiteral(int64(v.Float())), true
+	}
+	return Literal{}, false
+}
+
+// noop turns instruction i into a noop at the same position.
+func (o *optimizer) noop(i int) {
+	instr := o.instructions[i]
+	o.instructions[i] = Instruction{
+		instructionType: InstructionNoOp,
+		line:            instr.line,
+		column:          instr.column,
+		fileName:        instr.fileName,
+	}
+}
+
+// compact drops the noops and remaps jump and call targets, the entrypoint
+// and the labels. A target whose instructions were all dropped moves to
+// the next kept instruction; when that is past the end, a halt is kept in
+// its place so the jump still ends the program instead of faulting.
+func (o *optimizer) compact() bool {
+	index := make([]int, len(o.instructions)+1)
+	kept := make([]Instruction, 0, len(o.instructions))
+	for i, instr := range o.instructions {
+		index[i] = len(kept)
+		if instr.instructionType != InstructionNoOp {
+			kept = append(kept, instr)
+		}
+	}
+	index[len(o.instructions)] = len(kept)
+	if len(kept) == len(o.instructions) {
+		return false
+	}
+	needsHalt := false
+	for i := range o.instructions {
+		if target, ok := o.target(i); ok && index[target] == len(kept) {
+			needsHalt = true
+		}
+	}
+	for i := range kept {
+		instr := &kept[i]
+		if !isJumpInstruction(instr.instructionType) || instr.value.Type() != LiteralInt {
+			continue
+		}
+		// A target just past the end, where a label can be, stays there.
+		if target := instr.value.Int(); target >= 0 && target <= int64(len(o.instructions)) {
+			instr.value = IntLiteral(int64(index[target]))
+		}
+	}
+	if needsHalt {
+		last := o.instructions[len(o.instructions)-1]
+		kept = append(kept, Instruction{instructionType: InstructionHalt, line: last.line, column: last.column, fileName: last.fileName})
+	}
+	if o.entrypoint >= 0 && o.entrypoint <= len(o.instructions) {
+		o.entrypoint = index[o.entrypoint]
+	}
+	for name, target := range o.symbols {
+		if target >= 0 && target <= len(o.instructions) {
+			o.symbols[name] = index[target]
+		}
+	}
+	o.instructions = kept
+	return true
+}
+
+// stackDepths returns the most values the data stack can hold before each
+// instruction runs, or -1 where that is not known: where no walk from the
+// entrypoint reaches, where the stack verifier finds errors, and in
+// recursive functions.
+func stackDepths(p *Program) []int {
+	depths := make([]int, len(p.instructions))
+	for i := range depths {
+		depths[i] = -1
+	}
+	v := newStackVerifier(p)
+	top := v.walk(p.entrypoint, false)
+	if len(top.errors) > 0 {
+		return depths
+	}
+	walks := make(map[int]*stackWalk, len(v.entries))
+	for _, entry := range v.entries {
+		walks[entry] = v.walk(entry, true)
+	}
+
+	// A function's depths are relative to the stack at its calls, so the
+	// most it runs at is the deepest of its calls plus its own depth.
+	const visiting = -2
+	bases := make(map[int]int)
+	var depthAt func(ip int) (depth int, reached bool)
+	base := func(entry int) int {
+		if b, ok := bases[entry]; ok {
+			return max(b, -1)
+		}
+		bases[entry] = visiting
+		b := 0
+		if len(walks[entry].errors) > 0 || v.unsettled[entry] {
+			b = -1
+		}
+		for ip, instr := range p.instructions {
+			if b < 0 {
+				break
+			}
+			if target, ok := v.target(ip); !ok || target != entry || instr.instructionType != InstructionCall {
+				continue
+			}
+			if d, reached := depthAt(ip); reached {
+				b = max(b, d)
+				if d < 0 {
+					b = -1
+				}
+			}
+		}
+		bases[entry] = b
+		return b
+	}
+	depthAt = func(ip int) (int, bool) {
+		depth, reached := -1, false
+		if top.reached[ip] {
+			depth, reached = top.depth[ip], true
+		}
+		for _, entry := range v.entries {
+			w := walks[entry]
+			if !w.reached[ip] {
+				continue
+			}
+			b := base(entry)
+			if b < 0 {
+				return -1, true
+			}
+			depth, reached = max(depth, b+w.depth[ip]), true
+		}
+		return depth, reached
+	}
+	for ip := range depths {
+		depths[ip], _ = depthAt(ip)
+	}
+	return depths
+}
@@ -0,0 +1,271 @@
+package rmm
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// instructionTexts lists a program's instructions in source form.
+func instructionTexts(p *Program) string {
+	texts := make([]string, p.Len())
+	for i := range texts {
+		texts[i] = p.InstructionText(i)
+	}
+	return strings.Join(texts, "; ")
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name:     "fold arithmetic",
+			source:   "push 2\npush 3\nadd\npush 4\nmul\nprint\n",
+			expected: "push 20; print",
+		},
+		{
+			name:     "fold comparison and cast",
+			source:   "push 2\npush 3\ncmpl\nitof\nprint\n",
+			expected: "push 1.0; print",
+		},
+		{
+			name:     "keep faulting fold",
+			source:   "push 1\npush 0\ndiv\n",
+			expected: "push 1; push 0; div",
+		},
+		{
+			name:     "keep mixed types",
+			source:   "push 1\npush 1.5\nadd\n",
+			expected: "push 1; push 1.5; add",
+		},
+		{
+			name:     "push then pop",
+			source:   "push 1\npush 'a'\npop\nprint\n",
+			expected: "push 1; print",
+		},
+		{
+			name:     "no fold across a label",
+			source:   "push 1\nloop:\npush 2\nadd\ndup\nnzjmp loop\n",
+			expected: "push 1; push 2; add; dup; nzjmp loop",
+		},
+		{
+			name:     "fold in a function",
+			source:   "entrypoint main\nf:\npush 2\npush 3\nadd\nret\nmain:\ncall f\nprint\n",
+			expected: "push 5; ret; call f; print",
+		},
+		{
+			name:     "no fold at an unknown depth",
+			source:   "entrypoint main\nf:\ndup\nzjmp done\npush 1\nsub\ncall f\npush 2\npush 3\nadd\npop\ndone:\nret\nmain:\npush 3\ncall f\n",
+			expected: "dup; zjmp done; push 1; sub; call f; push 2; push 3; add; pop; ret; push 3; call f",
+		},
+		{
+			name:     "thread jumps",
+			source:   "push 0\nzjmp a\nhalt\na:\njmp b\nc:\nprint\nb:\npush 1\nprint\n",
+			expected: "push 0; zjmp b; halt; jmp b; print; push 1; print",
+		},
+		{
+			name:     "jump to next",
+			source:   "jmp next\nnext:\npush 1\nprint\n",
+			expected: "push 1; print",
+		},
+		{
+			name:     "unreachable after halt",
+			source:   "push 1\nprint\nhalt\npush 2\nprint\nf:\nret\n",
+			expected: "push 1; print; halt; ret",
+		},
+		{
+			name:     "jump past removed end",
+			source:   "push 0\nzjmp end\npush 1\nprint\nend:\npush 5\npop\n",
+			expected: "push 0; zjmp end; push 1; print; halt",
+		},
+	}
+	for _, tt := range tests {
+		program, err := Compile(tt.source)
+		if err != nil {
+			t.Fatalf("%s: failed to compile: %v", tt.name, err)
+		}
+		if got := instructionTexts(program.Optimize(1)); got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, got)
+		}
+	}
+}
+
+// TestOptimizeKeepsStackOverflow checks that folding does not take away a
+// push that overflows the stack.
+func TestOptimizeKeepsStackOverflow(t *testing.T) {
+	limits := Limits{MaxStack: 3}
+	for _, source := range []string{
+		"push 1\npush 2\npush 3\npush 4\npop\n",
+		"push 1\npush 2\npush 3\npush 4\nadd\n",
+	} {
+		program, err := Compile(source)
+		if err != nil {
+			t.Fatalf("%q: failed to compile: %v", source, err)
+		}
+		optimized := program.OptimizeFor(1, limits)
+		err = NewMachine(Options{Limits: limits}).Run(context.Background(), optimized)
+		rtErr, ok := err.(*RuntimeError)
+		if !ok || rtErr.Kind != KindStackOverflow || rtErr.Line != 4 {
+			t.Errorf("%q: expected a stack overflow on line 4, got %v", source, err)
+		}
+		// With room on the stack, the same code folds.
+		if got := program.Optimize(1).Len(); got >= program.Len() {
+			t.Errorf("%q: expected a fold with the default limits, got %s", source, instructionTexts(program.Optimize(1)))
+		}
+	}
+}
+
+func TestOptimizeLevelZero(t *testing.T) {
+	program, err := Compile("push 2\npush 3\nadd\n")
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	if program.Optimize(0) != program {
+		t.Errorf("expected -O0 to leave the program alone")
+	}
+}
+
+func TestOptimizeKeepsLines(t *testing.T) {
+	source := "push 2\npush 3\n\nadd\n; comment\npush 0\ndiv\n"
+	program, err := Compile(source)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	for _, p := range []*Program{program, program.Optimize(1)} {
+		var stdout bytes.Buffer
+		err := NewMachine(Options{Stdout: &stdout}).Run(context.Background(), p)
+		rtErr, ok := err.(*RuntimeError)
+		if !ok || rtErr.Kind != KindDivideByZero || rtErr.Line != 7 {
+			t.Errorf("expected a division by zero on line 7, got %v", err)
+		}
+	}
+	optimized := program.Optimize(1)
+	if file, line := optimized.Location(0); file != "main.rmm" || line != 1 {
+		t.Errorf("expected the folded push at main.rmm:1, got %s:%d", file, line)
+	}
+}
+
+func TestOptimizeFuses(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name:     "add immediate",
+			source:   "mov r0 4\npush r0\npush 1\nadd\nprint\n",
+			expected: "mov r0 4; push r0; push 1 add ; addi; print",
+		},
+		{
+			name:     "compare and jump",
+			source:   "loop:\npush r0\npush 3\ncmpe\nzjmp loop\ncmpe\nnzjmp loop\n",
+			expected: "push r0; push 3; cmpe zjmp loop ; cmpe_zjmp; cmpe nzjmp loop ; cmpe_nzjmp",
+		},
+		{
+			name:     "register dereference",
+			source:   "push 1\nref\nmov r0 top\npush r0\nderef\nprint\n",
+			expected: "push 1; ref; mov r0 top; push r0 deref ; push_reg_deref; print",
+		},
+		{
+			name:     "no fusion across a label",
+			source:   "mov r0 0\npush r0\npush 1\nl:\nadd\ncmpe\nm:\nzjmp l\njmp m\n",
+			expected: "mov r0 0; push r0; push 1; add; cmpe; zjmp l; jmp m",
+		},
+	}
+	for _, tt := range tests {
+		program, err := Compile(tt.source)
+		if err != nil {
+			t.Fatalf("%s: failed to compile: %v", tt.name, err)
+		}
+		if got := instructionTexts(program.Optimize(2)); got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestFusedFaultLines(t *testing.T) {
+	tests := []struct {
+		source string
+		kind   ErrorKind
+		ip     int
+		line   int
+	}{
+		{"push 1.5\npush 1\nadd\n", KindType, 1, 3},
+		{"cmpe\nzjmp 0\n", KindStackUnderflow, 0, 1},
+		{"push 0\npush 1\ncmpe\n\nnzjmp 0\n", KindUnknown, 0, 0},
+		{"mov r0 99\npush r0\n\nderef\n", KindType, 1, 4},
+	}
+	for _, tt := range tests {
+		program, err := Compile(tt.source)
+		if err != nil {
+			t.Fatalf("%q: failed to compile: %v", tt.source, err)
+		}
+		err = NewMachine(Options{}).Run(context.Background(), program.Optimize(2))
+		if tt.kind == KindUnknown {
+			if err != nil {
+				t.Errorf("%q: expected a clean run, got %v", tt.source, err)
+			}
+			continue
+		}
+		rtErr, ok := err.(*RuntimeError)
+		if !ok || rtErr.Kind != tt.kind || rtErr.IP != tt.ip || rtErr.Line != tt.line {
+			t.Errorf("%q: expected %v at ip %d line %d, got %v", tt.source, tt.kind, tt.ip, tt.line, err)
+			continue
+		}
+		if frame := rtErr.Trace[0]; frame.Line != tt.line {
+			t.Errorf("%q: expected the backtrace at line %d, got %d", tt.source, tt.line, frame.Line)
+		}
+	}
+}
+
+// TestFusedJumpFaultTrace checks that a fault in the jump half of a
+// cmpe_zjmp is placed on the jump's line in the error and the backtrace.
+func TestFusedJumpFaultTrace(t *testing.T) {
+	program, err := Compile("mov r0 1\npush r0\npush 2\ncmpe\n\nzjmp end\npush 3\nprint\nend:\nhalt\n")
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	optimized := program.Optimize(2)
+	if optimized.instructions[3].instructionType != InstructionCmpeZjmp {
+		t.Fatalf("expected cmpe_zjmp at 3, got %v", optimized.instructions[3].instructionType)
+	}
+	// The fuser only fuses jumps that stay in the program; send this one
+	// out of it, so the jump half faults when it is taken.
+	optimized.instructions[3].value = IntLiteral(99)
+	err = NewMachine(Options{}).Run(context.Background(), optimized)
+	rtErr, ok := err.(*RuntimeError)
+	if !ok || rtErr.Line != 6 {
+		t.Fatalf("expected a fault on line 6, got %v", err)
+	}
+	if frame := rtErr.Trace[0]; frame.Line != 6 {
+		t.Errorf("expected the backtrace at line 6, got %d", frame.Line)
+	}
+}
+
+func TestDisassembleFused(t *testing.T) {
+	source := "mov r0 0\npush r0\npush 1\nadd\npush 1\ncmpe\nzjmp end\nprint\nend:\n"
+	program, err := Compile(source)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	text, err := program.Optimize(2).Disassemble("main.rmm")
+	if err != nil {
+		t.Fatalf("failed to disassemble: %v", err)
+	}
+	for _, want := range []string{"push 1\n", "add\n", "cmpe\n", "zjmp end\n"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in the disassembly:\n%s", want, text)
+		}
+	}
+	again, err := Compile(text)
+	if err != nil {
+		t.Fatalf("disassembly does not compile: %v\n%s", err, text)
+	}
+	if got, want := instructionTexts(again), instructionTexts(program.Optimize(1)); got != want {
+		t.Errorf("expected the unfused program %q, got %q", want, got)
+	}
+}
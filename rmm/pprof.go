@@ -0,0 +1,279 @@
+package rmm
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The profile is written in the pprof format: a gzipped protocol buffer
+// following github.com/google/pprof/proto/profile.proto. It is encoded by
+// hand, so only the fields used here are defined.
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileMapping       = 3
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	mappingID             = 1
+	mappingMemoryLimit    = 3
+	mappingFilename       = 5
+	mappingHasFunctions   = 7
+	mappingHasFilenames   = 8
+	mappingHasLineNumbers = 9
+
+	locationID        = 1
+	locationMappingID = 2
+	locationAddress   = 3
+	locationLine      = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	funcID         = 1
+	funcName       = 2
+	funcSystemName = 3
+	funcFilename   = 4
+	funcStartLine  = 5
+)
+
+// protoBuffer appends protocol buffer fields.
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *protoBuffer) uint64(field int, v uint64) {
+	b.varint(uint64(field) << 3)
+	b.varint(v)
+}
+
+func (b *protoBuffer) int64(field int, v int64) {
+	b.uint64(field, uint64(v))
+}
+
+func (b *protoBuffer) bytes(field int, v []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *protoBuffer) packed(field int, values []uint64) {
+	size := 0
+	for _, v := range values {
+		size += varintSize(v)
+	}
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(size))
+	for _, v := range values {
+		b.varint(v)
+	}
+}
+
+// message appends a nested message built by fill. The message is built in
+// place and shifted up to make room for its length prefix, which saves an
+// allocation per message.
+func (b *protoBuffer) message(field int, fill func(m *protoBuffer)) {
+	start := len(*b)
+	fill(b)
+	size := len(*b) - start
+	var header protoBuffer
+	var scratch [2 * binary.MaxVarintLen64]byte
+	header = scratch[:0]
+	header.varint(uint64(field)<<3 | 2)
+	header.varint(uint64(size))
+	*b = append(*b, header...)
+	copy((*b)[start+len(header):], (*b)[start:start+size])
+	copy((*b)[start:], header)
+}
+
+func varintSize(v uint64) int {
+	size := 1
+	for ; v >= 0x80; v >>= 7 {
+		size++
+	}
+	return size
+}
+
+// profileEncoder interns the strings, functions and locations of a profile.
+type profileEncoder struct {
+	p         *Profiler
+	labels    map[int]string
+	buf       protoBuffer
+	strings   map[string]int64
+	functions map[int]uint64    // by function start -> function id
+	locations map[[2]int]uint64 // by (ip, function start) -> location id
+}
+
+// WriteProfile writes the profile in the pprof format, for `go tool pprof`.
+// Each executed instruction is a location whose address is its index, in
+// a function named by the label of its call target (or the entrypoint).
+// Samples carry the instruction count and wall time per call stack.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	e := &profileEncoder{
+		p:         p,
+		labels:    labelsByIndex(p.symbols),
+		strings:   map[string]int64{"": 0},
+		functions: make(map[int]uint64),
+		locations: make(map[[2]int]uint64),
+	}
+	// The string table fills up while the other messages are encoded, and
+	// its first entry must be "".
+	var table protoBuffer
+	table.bytes(profileStringTable, nil)
+
+	e.valueType(profileSampleType, "instructions", "count")
+	e.valueType(profileSampleType, "wall", "nanoseconds")
+	// One mapping covers the program, so pprof has a "binary" to name; the
+	// entry file stands in for its file name.
+	var entryFile string
+	if len(p.instructions) > 0 {
+		entryFile = p.instructions[0].fileName
+	}
+	e.buf.message(profileMapping, func(m *protoBuffer) {
+		m.uint64(mappingID, 1)
+		m.uint64(mappingMemoryLimit, uint64(len(p.instructions)))
+		m.int64(mappingFilename, e.str(entryFile))
+		m.uint64(mappingHasFunctions, 1)
+		m.uint64(mappingHasFilenames, 1)
+		m.uint64(mappingHasLineNumbers, 1)
+	})
+	// Callers are the same for every sample of a node, so each node's
+	// caller locations are looked up once, as the tree is walked.
+	var stack []uint64
+	var visit func(n *profileNode, callers []uint64)
+	visit = func(n *profileNode, callers []uint64) {
+		for _, ip := range sortedKeys(n.stats) {
+			stat := n.stats[ip]
+			stack = append(append(stack[:0], e.location(ip, n.function)), callers...)
+			e.buf.message(profileSample, func(m *protoBuffer) {
+				m.packed(sampleLocationID, stack)
+				m.packed(sampleValue, []uint64{uint64(stat.count), uint64(stat.wall.Nanoseconds())})
+			})
+		}
+		for _, retAddr := range sortedKeys(n.children) {
+			child := n.children[retAddr]
+			visit(child, append([]uint64{e.location(child.callSite, n.function)}, callers...))
+		}
+	}
+	for _, entry := range sortedKeys(p.roots) {
+		visit(p.roots[entry], nil)
+	}
+	if !p.started.IsZero() {
+		e.buf.int64(profileTimeNanos, p.started.UnixNano())
+	}
+	e.buf.int64(profileDurationNanos, p.duration.Nanoseconds())
+	e.valueType(profilePeriodType, "instructions", "count")
+	e.buf.int64(profilePeriod, 1)
+
+	strs := make([]string, len(e.strings))
+	for s, i := range e.strings {
+		strs[i] = s
+	}
+	for _, s := range strs[1:] {
+		table.bytes(profileStringTable, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(table); err != nil {
+		return err
+	}
+	if _, err := zw.Write(e.buf); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// WriteProfileFile writes the profile to filePath.
+func (p *Profiler) WriteProfileFile(filePath string) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if err := p.WriteProfile(f); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write profile %s: %w", filePath, err)
+	}
+	return f.Close()
+}
+
+func (e *profileEncoder) str(s string) int64 {
+	i, ok := e.strings[s]
+	if !ok {
+		i = int64(len(e.strings))
+		e.strings[s] = i
+	}
+	return i
+}
+
+func (e *profileEncoder) valueType(field int, typ, unit string) {
+	e.buf.message(field, func(m *protoBuffer) {
+		m.int64(valueTypeType, e.str(typ))
+		m.int64(valueTypeUnit, e.str(unit))
+	})
+}
+
+func (e *profileEncoder) function(start int) uint64 {
+	if id, ok := e.functions[start]; ok {
+		return id
+	}
+	id := uint64(len(e.functions) + 1)
+	e.functions[start] = id
+	name := functionName(e.labels, start)
+	var file string
+	var line int
+	if start >= 0 && start < len(e.p.instructions) {
+		file, line = e.p.instructions[start].fileName, e.p.instructions[start].line
+	}
+	e.buf.message(profileFunction, func(m *protoBuffer) {
+		m.uint64(funcID, id)
+		m.int64(funcName, e.str(name))
+		m.int64(funcSystemName, e.str(name))
+		m.int64(funcFilename, e.str(file))
+		m.int64(funcStartLine, int64(line))
+	})
+	return id
+}
+
+func (e *profileEncoder) location(ip, function int) uint64 {
+	key := [2]int{ip, function}
+	if id, ok := e.locations[key]; ok {
+		return id
+	}
+	id := uint64(len(e.locations) + 1)
+	e.locations[key] = id
+	fnID := e.function(function)
+	var line int
+	if ip >= 0 && ip < len(e.p.instructions) {
+		line = e.p.instructions[ip].line
+	}
+	e.buf.message(profileLocation, func(m *protoBuffer) {
+		m.uint64(locationID, id)
+		m.uint64(locationMappingID, 1)
+		m.uint64(locationAddress, uint64(ip))
+		m.message(locationLine, func(l *protoBuffer) {
+			l.uint64(lineFunctionID, fnID)
+			l.int64(lineLine, int64(line))
+		})
+	})
+	return id
+}
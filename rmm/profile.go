@@ -0,0 +1,240 @@
+package rmm
+
+import (
+	"sort"
+	"time"
+)
+
+// Profiler counts executed instructions and the wall time spent on each,
+// by call stack. Attach one to a machine through Options.Profiler; it
+// accumulates over every run of that machine, so use one per program.
+//
+// The wall time of an instruction runs from the moment it starts to the
+// moment the next one starts, so it includes the cost of the profiler
+// itself and of any StepHook.
+type Profiler struct {
+	instructions []Instruction
+	symbols      map[string]int
+	roots        map[int]*profileNode // by entrypoint
+	current      *profileNode         // node of the last instruction
+	last         *profileStat         // stat of the running instruction
+	lastIP       int                  // -1 before the first step of a run
+	pairs        map[int]int64        // by the first ip of two that ran in a row
+	lastStart    time.Time
+	runStart     time.Time
+	started      time.Time // start of the first run
+	duration     time.Duration
+}
+
+// profileNode is one distinct call stack: the function it runs and the
+// call that entered it from its parent.
+type profileNode struct {
+	function int // instruction index the function starts at
+	callSite int // index of the call in the parent; -1 for a root
+	retAddr  int // return address the call pushed
+	depth    int // length of the return stack
+	parent   *profileNode
+	children map[int]*profileNode // by return address
+	stats    map[int]*profileStat // by instruction index
+}
+
+type profileStat struct {
+	count int64
+	wall  time.Duration
+}
+
+// InstructionProfile is the count and wall time of one instruction over
+// every call stack it ran in.
+type InstructionProfile struct {
+	IP    int
+	File  string
+	Line  int
+	Count int64
+	Wall  time.Duration
+}
+
+// NewProfiler returns an empty profiler.
+func NewProfiler() *Profiler {
+	return &Profiler{roots: make(map[int]*profileNode), pairs: make(map[int]int64)}
+}
+
+// start begins profiling a run of m's program.
+func (p *Profiler) start(m *Machine) {
+	p.instructions = m.instructions
+	p.symbols = m.symbols
+	p.current = nil
+	p.lastIP = -1
+	p.runStart = time.Now()
+	if p.started.IsZero() {
+		p.started = p.runStart
+	}
+}
+
+// step counts the instruction at m.ip, which is about to run, and charges
+// the time since the previous step to the previous instruction.
+func (p *Profiler) step(m *Machine) {
+	now := time.Now()
+	if p.last != nil {
+		p.last.wall += now.Sub(p.lastStart)
+	}
+	node := p.node(m)
+	stat := node.stats[m.ip]
+	if stat == nil {
+		stat = &profileStat{}
+		node.stats[m.ip] = stat
+	}
+	stat.count++
+	if p.lastIP >= 0 && m.ip == p.lastIP+1 {
+		p.pairs[p.lastIP]++
+	}
+	p.last, p.lastStart, p.lastIP = stat, now, m.ip
+}
+
+// finish ends a run, charging the time since the last step to the last
+// instruction.
+func (p *Profiler) finish() {
+	now := time.Now()
+	if p.last != nil {
+		p.last.wall += now.Sub(p.lastStart)
+		p.last = nil
+	}
+	p.duration += now.Sub(p.runStart)
+}
+
+// node returns the node for m's return stack. Between two steps the
+// return stack changes by at most one call or return, so the node is
+// usually found next to the previous one.
+func (p *Profiler) node(m *Machine) *profileNode {
+	depth := len(m.returnStack)
+	n := p.current
+	if n != nil {
+		switch {
+		case depth == n.depth+1:
+			n = p.child(n, m.returnStack[depth-1])
+		case depth == n.depth-1:
+			n = n.parent
+		}
+	}
+	if n == nil || n.depth != depth || (depth > 0 && n.retAddr != m.returnStack[depth-1]) {
+		n = p.root(m.entrypoint)
+		for _, retAddr := range m.returnStack {
+			n = p.child(n, retAddr)
+		}
+	}
+	p.current = n
+	return n
+}
+
+func (p *Profiler) root(entrypoint int) *profileNode {
+	n := p.roots[entrypoint]
+	if n == nil {
+		n = newProfileNode(entrypoint, -1, -1, nil)
+		p.roots[entrypoint] = n
+	}
+	return n
+}
+
+func (p *Profiler) child(n *profileNode, retAddr int) *profileNode {
+	c := n.children[retAddr]
+	if c == nil {
+		function := int(p.instructions[retAddr-1].value.Int())
+		c = newProfileNode(function, retAddr-1, retAddr, n)
+		n.children[retAddr] = c
+	}
+	return c
+}
+
+func newProfileNode(function, callSite, retAddr int, parent *profileNode) *profileNode {
+	n := &profileNode{
+		function: function,
+		callSite: callSite,
+		retAddr:  retAddr,
+		parent:   parent,
+		children: make(map[int]*profileNode),
+		stats:    make(map[int]*profileStat),
+	}
+	if parent != nil {
+		n.depth = parent.depth + 1
+	}
+	return n
+}
+
+// walk calls fn for every node, parents before children.
+func (p *Profiler) walk(fn func(n *profileNode)) {
+	var visit func(n *profileNode)
+	visit = func(n *profileNode) {
+		fn(n)
+		for _, retAddr := range sortedKeys(n.children) {
+			visit(n.children[retAddr])
+		}
+	}
+	for _, entry := range sortedKeys(p.roots) {
+		visit(p.roots[entry])
+	}
+}
+
+// Instructions returns the count and wall time of every instruction that
+// ran, in program order.
+func (p *Profiler) Instructions() []InstructionProfile {
+	totals := make(map[int]*InstructionProfile)
+	p.walk(func(n *profileNode) {
+		for ip, stat := range n.stats {
+			total := totals[ip]
+			if total == nil {
+				total = &InstructionProfile{IP: ip}
+				if ip < len(p.instructions) {
+					total.File, total.Line = p.instructions[ip].fileName, p.instructions[ip].line
+				}
+				totals[ip] = total
+			}
+			total.Count += stat.count
+			total.Wall += stat.wall
+		}
+	})
+	profile := make([]InstructionProfile, 0, len(totals))
+	for _, ip := range sortedKeys(totals) {
+		profile = append(profile, *totals[ip])
+	}
+	return profile
+}
+
+// PairProfile counts how often an instruction of type First ran and was
+// followed straight away by the next instruction in the program, of type
+// Second. Frequent pairs are the candidates for superinstructions.
+type PairProfile struct {
+	First, Second InstructionSet
+	Count         int64
+}
+
+// Pairs returns the pairs of instruction types that ran one after the
+// other, most frequent first.
+func (p *Profiler) Pairs() []PairProfile {
+	counts := make(map[[2]InstructionSet]int64)
+	for ip, n := range p.pairs {
+		counts[[2]InstructionSet{p.instructions[ip].instructionType, p.instructions[ip+1].instructionType}] += n
+	}
+	pairs := make([]PairProfile, 0, len(counts))
+	for pair, n := range counts {
+		pairs = append(pairs, PairProfile{First: pair[0], Second: pair[1], Count: n})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i], pairs[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.First != b.First {
+			return a.First < b.First
+		}
+		return a.Second < b.Second
+	})
+	return pairs
+}
+
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,104 @@
+package rmm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+)
+
+const profileSource = `
+entrypoint main
+square:
+	dup
+	mul
+	ret
+main:
+	push 3
+	call square
+	call square
+	print
+`
+
+func TestProfiler(t *testing.T) {
+	program, err := Compile(profileSource)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	profiler := NewProfiler()
+	machine := NewMachine(Options{Profiler: profiler})
+	for range 2 {
+		if err := machine.Run(context.Background(), program); err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+	}
+
+	counts := make(map[int]int64)
+	for _, ins := range profiler.Instructions() {
+		counts[ins.IP] = ins.Count
+		if ins.Wall <= 0 {
+			t.Errorf("instruction %d has no wall time", ins.IP)
+		}
+	}
+	want := map[int]int64{0: 4, 1: 4, 2: 4, 3: 2, 4: 2, 5: 2, 6: 2}
+	if len(counts) != len(want) {
+		t.Errorf("unexpected profile %v", counts)
+	}
+	for ip, n := range want {
+		if counts[ip] != n {
+			t.Errorf("instruction %d ran %d times, want %d", ip, counts[ip], n)
+		}
+	}
+	if ins := profiler.Instructions()[0]; ins.Line != 4 || ins.File != "main.rmm" {
+		t.Errorf("unexpected location %s:%d", ins.File, ins.Line)
+	}
+
+	pairs := make(map[[2]InstructionSet]int64)
+	for _, pair := range profiler.Pairs() {
+		pairs[[2]InstructionSet{pair.First, pair.Second}] = pair.Count
+	}
+	wantPairs := map[[2]InstructionSet]int64{
+		{InstructionDup, InstructionMul}:   4,
+		{InstructionMul, InstructionRet}:   4,
+		{InstructionPush, InstructionCall}: 2,
+	}
+	if len(pairs) != len(wantPairs) {
+		t.Errorf("unexpected pairs %v", pairs)
+	}
+	for pair, n := range wantPairs {
+		if pairs[pair] != n {
+			t.Errorf("%v then %v ran %d times, want %d", pair[0], pair[1], pairs[pair], n)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := profiler.WriteProfile(&out); err != nil {
+		t.Fatalf("failed to write profile: %v", err)
+	}
+	zr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("profile is not gzipped: %v", err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("failed to read profile: %v", err)
+	}
+	for _, s := range []string{"instructions", "wall", "nanoseconds", "square", "main", "main.rmm"} {
+		if !bytes.Contains(raw, []byte(s)) {
+			t.Errorf("profile has no string %q", s)
+		}
+	}
+}
+
+func TestProtoBuffer(t *testing.T) {
+	var b protoBuffer
+	b.message(2, func(m *protoBuffer) {
+		m.packed(1, []uint64{1, 300})
+		m.int64(2, 150)
+	})
+	want := []byte{0x12, 0x08, 0x0a, 0x03, 0x01, 0xac, 0x02, 0x10, 0x96, 0x01}
+	if !bytes.Equal(b, want) {
+		t.Errorf("encoded % x, want % x", []byte(b), want)
+	}
+}
@@ -0,0 +1,134 @@
+package rmm
+
+// A superinstruction does the work of a pair of instructions that often
+// run one after the other in a single step. The pairs were picked from the
+// profiler's pair counts (see Profiler.Pairs) over the test programs:
+// cmpe followed by a conditional jump, a constant pushed and added, and a
+// register pushed and dereferenced. The optimizer fuses them at -O2. A
+// superinstruction keeps where both of its parts came from: faults are
+// reported at the line of the part that raised them, and disassembly
+// writes the parts back out.
+
+// superinstructions maps each superinstruction to the instructions it
+// fuses, in order.
+var superinstructions = map[InstructionSet][2]InstructionSet{
+	InstructionAddI:         {InstructionPush, InstructionAdd},
+	InstructionCmpeZjmp:     {InstructionCmpe, InstructionZjmp},
+	InstructionCmpeNzjmp:    {InstructionCmpe, InstructionNzjmp},
+	InstructionPushRegDeref: {InstructionPushReg, InstructionDeref},
+}
+
+// parts returns the instructions a superinstruction fuses, each at its own
+// source position and with the operand on the part that takes it, or just
+// instr for any other instruction.
+func (instr Instruction) parts() []Instruction {
+	pair, ok := superinstructions[instr.instructionType]
+	if !ok {
+		return []Instruction{instr}
+	}
+	first := instr
+	first.instructionType = pair[0]
+	first.second = position{}
+	second := Instruction{
+		instructionType: pair[1],
+		fileName:        instr.second.fileName,
+		line:            instr.second.line,
+		column:          instr.second.column,
+	}
+	if isJumpInstruction(pair[1]) {
+		first.value, second.value = Literal{}, instr.value
+	}
+	return []Instruction{first, second}
+}
+
+// fuse replaces each pair of instructions that a superinstruction covers
+// with it, unless the second can be run other than after the first.
+func (o *optimizer) fuse() bool {
+	changed := false
+	for i := 0; i+1 < len(o.instructions); i++ {
+		if o.leaders[i+1] {
+			continue
+		}
+		first, second := o.instructions[i], o.instructions[i+1]
+		fused := first
+		switch {
+		case first.instructionType == InstructionPush && first.value.Type() == LiteralInt &&
+			second.instructionType == InstructionAdd:
+			fused.instructionType = InstructionAddI
+		case first.instructionType == InstructionCmpe && second.instructionType == InstructionZjmp:
+			fused.instructionType = InstructionCmpeZjmp
+		case first.instructionType == InstructionCmpe && second.instructionType == InstructionNzjmp:
+			fused.instructionType = InstructionCmpeNzjmp
+		case first.instructionType == InstructionPushReg && second.instructionType == InstructionDeref:
+			fused.instructionType = InstructionPushRegDeref
+		default:
+			continue
+		}
+		if isJumpInstruction(second.instructionType) {
+			if _, ok := o.target(i + 1); !ok {
+				continue
+			}
+			fused.value = second.value
+		}
+		fused.second = position{fileName: second.fileName, line: second.line, column: second.column}
+		o.instructions[i] = fused
+		o.noop(i + 1)
+		changed = true
+		i++
+	}
+	return changed
+}
+
+// fusedFaultOp is a superinstruction that failed decoding: running it
+// raises the fault against the given part.
+func fusedFaultOp(part int, kind ErrorKind, message string) op {
+	return op{exec: func(ctx *RuntimeContext, o *op, ip int) int {
+		ctx.part = part
+		panic(ctx.Error(kind, message))
+	}}
+}
+
+// execAddI is push k; add.
+func execAddI(ctx *RuntimeContext, o *op, ip int) int {
+	ctx.part = 0
+	if len(ctx.stack) >= ctx.limits.MaxStack {
+		panic(ctx.Error(KindStackOverflow, "stack overflow"))
+	}
+	ctx.part = 1
+	b := pop(ctx)
+	push(ctx, o.value.Add(b))
+	return ip + 1
+}
+
+// execCmpeZjmp is cmpe; zjmp: it jumps when the two values differ.
+func execCmpeZjmp(ctx *RuntimeContext, o *op, ip int) int {
+	ctx.part = 0
+	b, a := popOperands(ctx)
+	if b.Equal(a) {
+		return ip + 1
+	}
+	ctx.part = 1
+	return jumpTarget(ctx, o)
+}
+
+// execCmpeNzjmp is cmpe; nzjmp: it jumps when the two values are equal.
+func execCmpeNzjmp(ctx *RuntimeContext, o *op, ip int) int {
+	ctx.part = 0
+	b, a := popOperands(ctx)
+	if !b.Equal(a) {
+		return ip + 1
+	}
+	ctx.part = 1
+	return jumpTarget(ctx, o)
+}
+
+// execPushRegDeref is push r; deref.
+func execPushRegDeref(ctx *RuntimeContext, o *op, ip int) int {
+	ctx.part = 0
+	if len(ctx.stack) >= ctx.limits.MaxStack {
+		panic(ctx.Error(KindStackOverflow, "stack overflow"))
+	}
+	ctx.part = 1
+	deref(ctx, ctx.registers[o.arg])
+	return ip + 1
+}
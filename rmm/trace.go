@@ -0,0 +1,113 @@
+package rmm
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// traceStackExcerpt is how many data stack values each frame shows.
+const traceStackExcerpt = 4
+
+// Frame is one active call in a RuntimeError's stack trace.
+type Frame struct {
+	Function string // label of the called function, or of the entrypoint
+	File     string
+	Line     int
+	IP       int // instruction the frame is executing (the call, for callers)
+	// Stack holds the top of the data stack when the frame was suspended
+	// (at the fault, for the innermost frame), top first. Values a callee
+	// has since popped are gone and not shown.
+	Stack []Literal
+}
+
+// frames walks the return stack and returns the active calls, innermost
+// first, for a machine stopped at ip, in the given part of it if it is a
+// superinstruction.
+func (m *Machine) frames(ip, part int) []Frame {
+	labels := labelsByIndex(m.symbols)
+	frames := make([]Frame, 0, len(m.returnStack)+1)
+	depth := len(m.stack)
+	for i := len(m.returnStack); i >= 0; i-- {
+		// The function a frame runs is the target of the call that opened
+		// it; the outermost frame started at the entrypoint.
+		function := m.entrypoint
+		if i > 0 {
+			function = int(m.instructions[m.returnStack[i-1]-1].value.Int())
+		}
+		frame := Frame{
+			Function: functionName(labels, function),
+			IP:       ip,
+			Stack:    stackExcerpt(m.stack[:min(depth, len(m.stack))]),
+		}
+		if ip >= 0 && ip < len(m.instructions) {
+			instr := m.instructionPart(ip, part)
+			frame.File = instr.fileName
+			frame.Line = instr.line
+		}
+		frames = append(frames, frame)
+		if i > 0 {
+			// Callers stopped at a call, which is never fused.
+			ip, part = m.returnStack[i-1]-1, 0
+			depth = m.callDepths[i-1]
+		}
+	}
+	return frames
+}
+
+// labelsByIndex inverts a symbol table, keeping the alphabetically first
+// label when several name the same instruction.
+func labelsByIndex(symbols map[string]int) map[int]string {
+	names := make([]string, 0, len(symbols))
+	for name := range symbols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	labels := make(map[int]string, len(names))
+	for _, name := range names {
+		if _, ok := labels[symbols[name]]; !ok {
+			labels[symbols[name]] = name
+		}
+	}
+	return labels
+}
+
+func functionName(labels map[int]string, index int) string {
+	if name, ok := labels[index]; ok {
+		return name
+	}
+	return fmt.Sprintf("<%d>", index)
+}
+
+func stackExcerpt(stack []Literal) []Literal {
+	excerpt := []Literal{}
+	for i := len(stack) - 1; i >= 0 && len(excerpt) < traceStackExcerpt; i-- {
+		excerpt = append(excerpt, stack[i])
+	}
+	return excerpt
+}
+
+// StackTrace renders the error's call trace, most recent call first, one
+// frame per line.
+func (e *RuntimeError) StackTrace() string {
+	var sb strings.Builder
+	for i, frame := range e.Trace {
+		values := make([]string, len(frame.Stack))
+		for j, value := range frame.Stack {
+			values[j] = traceValue(value)
+		}
+		fmt.Fprintf(&sb, "  #%d %s (%s:%d) ip %d, stack top: [%s]\n", i, frame.Function,
+			filepath.Base(frame.File), frame.Line, frame.IP, strings.Join(values, ", "))
+	}
+	return sb.String()
+}
+
+// traceValue formats a literal for a trace line, quoting characters so that
+// a newline on the stack cannot break the line.
+func traceValue(value Literal) string {
+	if value.Type() == LiteralChar {
+		return fmt.Sprintf("CHAR %q", value.Char())
+	}
+	return value.String()
+}
@@ -0,0 +1,66 @@
+package rmm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRuntimeErrorTrace(t *testing.T) {
+	program, err := Compile(`entrypoint main
+inner:
+	push 3
+	inswap 9
+	ret
+outer:
+	push 'x'
+	push 'y'
+	call inner
+	ret
+main:
+	push 7
+	call outer
+	halt
+`)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	err = NewMachine(Options{}).Run(context.Background(), program)
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Kind != KindStackUnderflow {
+		t.Fatalf("expected inswap to fail, got %v", err)
+	}
+
+	want := []struct {
+		function string
+		line     int
+		stack    []Literal
+	}{
+		{"inner", 4, []Literal{IntLiteral(3), CharLiteral('y'), CharLiteral('x'), IntLiteral(7)}},
+		{"outer", 9, []Literal{CharLiteral('y'), CharLiteral('x'), IntLiteral(7)}},
+		{"main", 13, []Literal{IntLiteral(7)}},
+	}
+	if len(runtimeErr.Trace) != len(want) {
+		t.Fatalf("expected %d frames, got %+v", len(want), runtimeErr.Trace)
+	}
+	for i, frame := range runtimeErr.Trace {
+		if frame.Function != want[i].function || frame.Line != want[i].line || frame.File != "main.rmm" {
+			t.Errorf("frame %d: got %s at %s:%d, want %s at main.rmm:%d", i, frame.Function, frame.File, frame.Line, want[i].function, want[i].line)
+		}
+		if len(frame.Stack) != len(want[i].stack) {
+			t.Errorf("frame %d: got stack %v, want %v", i, frame.Stack, want[i].stack)
+			continue
+		}
+		for j := range frame.Stack {
+			if !frame.Stack[j].Equal(want[i].stack[j]) {
+				t.Errorf("frame %d: got stack %v, want %v", i, frame.Stack, want[i].stack)
+			}
+		}
+	}
+
+	trace := runtimeErr.StackTrace()
+	if !strings.Contains(trace, "#1 outer (main.rmm:9) ip 5, stack top: [CHAR 'y', CHAR 'x', INT 7]") {
+		t.Errorf("unexpected trace:\n%s", trace)
+	}
+}
@@ -0,0 +1,539 @@
+package rmm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// The stack verifier checks a program's data stack without running it. It
+// walks the control-flow graph from the entrypoint, carrying the stack
+// depth through each instruction's stack effect, and reports instructions
+// that would take more values than the stack holds and instructions that
+// two paths reach at different depths. Functions, the targets of calls,
+// are walked on their own with depths relative to the call, which gives
+// each one a stack effect of its own that its callers then use. The string
+// stack and the return stack are not checked.
+
+// StackEffect is what an instruction or a function does to the data
+// stack: it needs Pops values, takes them off and then pushes Pushes.
+type StackEffect struct {
+	Pops   int
+	Pushes int
+}
+
+// Net is the change in the stack depth.
+func (e StackEffect) Net() int {
+	return e.Pushes - e.Pops
+}
+
+func (e StackEffect) String() string {
+	return fmt.Sprintf("pops %d, pushes %d, net %+d", e.Pops, e.Pushes, e.Net())
+}
+
+// stackEffects holds the effect of every instruction except call, native
+// and index, whose effect depends on their operand. indup and inswap also
+// need the stack to reach the index they name, which verify checks where
+// the depth is known.
+var stackEffects = map[InstructionSet]StackEffect{
+	InstructionNoOp:           {0, 0},
+	InstructionPush:           {0, 1},
+	InstructionPushPtr:        {0, 1},
+	InstructionGetStr:         {0, 1},
+	InstructionPop:            {1, 0},
+	InstructionDup:            {1, 2},
+	InstructionInDup:          {0, 1},
+	InstructionSwap:           {2, 2},
+	InstructionInSwap:         {1, 1},
+	InstructionAdd:            {2, 1},
+	InstructionSub:            {2, 1},
+	InstructionMul:            {2, 1},
+	InstructionDiv:            {2, 1},
+	InstructionMod:            {2, 1},
+	InstructionCmpe:           {2, 1},
+	InstructionCmpne:          {2, 1},
+	InstructionCmpg:           {2, 1},
+	InstructionCmpl:           {2, 1},
+	InstructionCmpge:          {2, 1},
+	InstructionCmple:          {2, 1},
+	InstructionZjmp:           {1, 0},
+	InstructionNzjmp:          {1, 0},
+	InstructionJmp:            {0, 0},
+	InstructionPrint:          {1, 0},
+	InstructionRet:            {0, 0},
+	InstructionPushStr:        {0, 0},
+	InstructionPopStr:         {0, 0},
+	InstructionDupStr:         {0, 0},
+	InstructionInDupStr:       {0, 0},
+	InstructionSwapStr:        {0, 0},
+	InstructionInSwapStr:      {0, 0},
+	InstructionCastIntToFloat: {1, 1},
+	InstructionCastFloatToInt: {1, 1},
+	InstructionRef:            {1, 1},
+	InstructionDeref:          {1, 1},
+	InstructionMovStr:         {1, 0},
+	InstructionMov:            {0, 0},
+	InstructionPushReg:        {0, 1},
+	InstructionMovTop:         {1, 0},
+	InstructionHalt:           {0, 0},
+	InstructionAddI:           {1, 1},
+	InstructionCmpeZjmp:       {2, 0},
+	InstructionCmpeNzjmp:      {2, 0},
+	InstructionPushRegDeref:   {0, 1},
+}
+
+// StackReport is the result of VerifyStack.
+type StackReport struct {
+	// Errors lists the problems found, in program order.
+	Errors []*StackError
+	// Functions lists the stack effect of each function, in program order.
+	Functions []FunctionEffect
+}
+
+// StackError is a problem VerifyStack found with the data stack at an
+// instruction.
+type StackError struct {
+	File    string
+	Line    int
+	IP      int
+	Message string
+}
+
+func (e *StackError) Error() string {
+	return errorText(e.File, e.Line, e.Message)
+}
+
+// FunctionEffect is the stack effect of a call to the function at IP,
+// from the call to its return. Returns is false for a function that never
+// returns, for which Effect only counts Pops. Settled is false when the
+// function's effect could not be worked out, because it or a function it
+// calls recurses taking more from the stack on every call; Effect is then
+// meaningless.
+type FunctionEffect struct {
+	Name    string
+	IP      int
+	File    string
+	Line    int
+	Effect  StackEffect
+	Returns bool
+	Settled bool
+}
+
+// VerifyStack checks the program's data stack. It is conservative where
+// the stack depends on the data: a loop that pushes once per iteration is
+// reported as reaching its head at two depths even if the program is
+// correct.
+func (p *Program) VerifyStack() *StackReport {
+	v := newStackVerifier(p)
+	unsettled := v.unsettled
+	report := &StackReport{}
+	walks := []*stackWalk{v.walk(p.entrypoint, false)}
+	calls := make(map[int]map[int]bool)
+	for _, entry := range v.entries {
+		walk := v.walk(entry, true)
+		walks = append(walks, walk)
+		calls[entry] = walk.calls
+		summary := v.functions[entry]
+		instr := p.instructions[entry]
+		report.Functions = append(report.Functions, FunctionEffect{
+			Name:    functionName(v.labels, entry),
+			IP:      entry,
+			File:    instr.fileName,
+			Line:    instr.line,
+			Effect:  summary.effect,
+			Returns: summary.returns,
+			Settled: !unsettled[entry],
+		})
+	}
+	for _, entry := range v.entries {
+		if unsettled[entry] && recurses(calls, entry) {
+			walk := &stackWalk{v: v}
+			walk.errorf(entry, "the stack effect of %s does not settle: each recursive call takes more from the stack",
+				functionName(v.labels, entry))
+			walks = append(walks, walk)
+		}
+	}
+	seen := make(map[StackError]bool)
+	for _, walk := range walks {
+		for _, err := range walk.errors {
+			if !seen[*err] {
+				seen[*err] = true
+				report.Errors = append(report.Errors, err)
+			}
+		}
+	}
+	sort.SliceStable(report.Errors, func(i, j int) bool {
+		return report.Errors[i].IP < report.Errors[j].IP
+	})
+	return report
+}
+
+// WriteReport writes the errors, one per line, followed by the stack
+// effect of each function.
+func (r *StackReport) WriteReport(w io.Writer) error {
+	var sb strings.Builder
+	for _, err := range r.Errors {
+		fmt.Fprintf(&sb, "%s:%d: %s\n", err.File, err.Line, err.Message)
+	}
+	if len(r.Functions) > 0 {
+		sb.WriteString("functions:\n")
+	}
+	for _, fn := range r.Functions {
+		if !fn.Settled {
+			fmt.Fprintf(&sb, "  %s (%s:%d): does not settle\n", fn.Name, fn.File, fn.Line)
+		} else if fn.Returns {
+			fmt.Fprintf(&sb, "  %s (%s:%d): %s\n", fn.Name, fn.File, fn.Line, fn.Effect)
+		} else {
+			fmt.Fprintf(&sb, "  %s (%s:%d): pops %d, never returns\n", fn.Name, fn.File, fn.Line, fn.Effect.Pops)
+		}
+	}
+	switch len(r.Errors) {
+	case 0:
+		sb.WriteString("stack: ok\n")
+	case 1:
+		sb.WriteString("stack: 1 error\n")
+	default:
+		fmt.Fprintf(&sb, "stack: %d errors\n", len(r.Errors))
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+type stackVerifier struct {
+	program *Program
+	labels  map[int]string
+	// entries are the instructions calls go to, in program order.
+	entries []int
+	// functions holds what the walks have found so far about each
+	// function.
+	functions map[int]functionSummary
+	// unsettled holds the functions whose effect is not known once the
+	// walks stop.
+	unsettled map[int]bool
+}
+
+// functionSummary is the stack effect of a function, of which only Pops
+// counts when it does not return.
+type functionSummary struct {
+	effect  StackEffect
+	returns bool
+}
+
+// newStackVerifier works out the stack effect of each of p's functions.
+func newStackVerifier(p *Program) *stackVerifier {
+	v := &stackVerifier{
+		program:   p,
+		labels:    labelsByIndex(p.symbols),
+		functions: make(map[int]functionSummary),
+	}
+	v.findFunctions()
+	// A function's effect depends on the effects of the functions it
+	// calls, so the walks repeat until no effect changes. Walks of
+	// recursive functions only see a return once a walk of the functions
+	// they call has. The bound stops recursion that takes more from the
+	// stack on every call, whose effect never settles.
+	unsettled := make(map[int]bool)
+	for round := 0; round <= len(v.entries)+1; round++ {
+		clear(unsettled)
+		for _, entry := range v.entries {
+			walk := v.walk(entry, true)
+			summary := functionSummary{effect: walk.effect(), returns: walk.returns}
+			if v.functions[entry] != summary {
+				v.functions[entry] = summary
+				unsettled[entry] = true
+			}
+		}
+		if len(unsettled) == 0 {
+			break
+		}
+	}
+	// Code after a call to an unsettled function is not checked.
+	v.unsettled = unsettled
+	return v
+}
+
+func (v *stackVerifier) findFunctions() {
+	seen := make(map[int]bool)
+	for ip, instr := range v.program.instructions {
+		if instr.instructionType != InstructionCall {
+			continue
+		}
+		if target, ok := v.target(ip); ok && !seen[target] {
+			seen[target] = true
+			v.entries = append(v.entries, target)
+		}
+	}
+	sort.Ints(v.entries)
+}
+
+// target returns the target of the jump or call at ip, if it has a valid
+// one.
+func (v *stackVerifier) target(ip int) (int, bool) {
+	return staticTarget(v.program, ip)
+}
+
+// stackWalk is one walk of the control-flow graph from an entry. Depths
+// are absolute when walking from the entrypoint and relative to the entry
+// when walking a function, where they go negative as the function takes
+// its arguments.
+type stackWalk struct {
+	v        *stackVerifier
+	function bool
+	depth    []int
+	from     []int // the instruction that first reached each one, -1 for the entry
+	reached  []bool
+	low      int // the lowest depth any instruction needs
+	ret      int // the depth at the first ret reached
+	retIP    int
+	returns  bool
+	calls    map[int]bool // the functions called
+	errors   []*StackError
+	work     []int
+}
+
+// effect is the stack effect of the walked function.
+func (w *stackWalk) effect() StackEffect {
+	if !w.returns {
+		return StackEffect{Pops: -w.low}
+	}
+	return StackEffect{Pops: -w.low, Pushes: w.ret - w.low}
+}
+
+func (v *stackVerifier) walk(entry int, function bool) *stackWalk {
+	n := len(v.program.instructions)
+	w := &stackWalk{
+		v:        v,
+		function: function,
+		depth:    make([]int, n),
+		from:     make([]int, n),
+		reached:  make([]bool, n),
+		calls:    make(map[int]bool),
+	}
+	if entry < 0 || entry >= n {
+		return w
+	}
+	w.reach(entry, 0, -1)
+	for len(w.work) > 0 {
+		ip := w.work[len(w.work)-1]
+		w.work = w.work[:len(w.work)-1]
+		w.step(ip)
+	}
+	return w
+}
+
+// reach records that ip runs with the stack at depth, coming from the
+// instruction at from.
+func (w *stackWalk) reach(ip, depth, from int) {
+	if ip >= len(w.depth) {
+		return // the program ends
+	}
+	if !w.reached[ip] {
+		w.reached[ip] = true
+		w.depth[ip] = depth
+		w.from[ip] = from
+		w.work = append(w.work, ip)
+		return
+	}
+	if w.depth[ip] != depth {
+		w.errorf(ip, "stack depth differs where paths meet: %d %s, %d %s",
+			w.depth[ip], w.cameFrom(w.from[ip]), depth, w.cameFrom(from))
+	}
+}
+
+// cameFrom describes where a path to an instruction came from.
+func (w *stackWalk) cameFrom(from int) string {
+	if from < 0 {
+		return "on entry"
+	}
+	return fmt.Sprintf("from line %d", w.v.program.instructions[from].line)
+}
+
+func (w *stackWalk) step(ip int) {
+	instr := w.v.program.instructions[ip]
+	depth := w.depth[ip]
+	if target, ok := w.v.target(ip); ok && instr.instructionType == InstructionCall {
+		w.calls[target] = true
+	}
+	effect, ok := w.v.effect(ip)
+	if !ok {
+		// A call to a function that never returns, or an instruction that
+		// faults whatever the stack holds.
+		if instr.instructionType == InstructionCall {
+			w.need(ip, depth, w.v.callNeeds(ip))
+		}
+		return
+	}
+	if _, fused := superinstructions[instr.instructionType]; fused && !w.function {
+		// Check each part on its own, so that an underflow is reported at
+		// the line of the part that takes the values, as when it runs.
+		partDepth := depth
+		for _, part := range instr.parts() {
+			partEffect := stackEffects[part.instructionType]
+			if partDepth < partEffect.Pops {
+				w.errorAt(ip, part, "stack underflow: %s needs %s but the stack holds %d",
+					strings.ToLower(part.instructionType.String()), values(partEffect.Pops), partDepth)
+				return
+			}
+			partDepth += partEffect.Net()
+		}
+	}
+	if !w.need(ip, depth, effect.Pops) {
+		return
+	}
+	if !w.function {
+		switch instr.instructionType {
+		case InstructionInDup, InstructionInSwap:
+			if index := instr.value.Int(); instr.value.Type() == LiteralInt && index >= int64(depth) {
+				w.errorf(ip, "%s %d reaches past the stack, which holds %s",
+					strings.ToLower(instr.instructionType.String()), index, values(depth))
+				return
+			}
+		}
+	}
+	next := depth + effect.Net()
+
+	switch instr.instructionType {
+	case InstructionHalt:
+		return
+	case InstructionRet:
+		if !w.function {
+			return
+		}
+		if !w.returns {
+			w.returns, w.ret, w.retIP = true, next, ip
+		} else if next != w.ret {
+			w.errorf(ip, "ret leaves the stack at %+d here but at %+d on line %d",
+				next, w.ret, w.v.program.instructions[w.retIP].line)
+		}
+		return
+	case InstructionNative:
+		if instr.value.Int() == nativeExitID {
+			return
+		}
+	}
+	if isJumpInstruction(instr.instructionType) && instr.instructionType != InstructionCall {
+		if target, ok := w.v.target(ip); ok {
+			w.reach(target, next, ip)
+		}
+		if instr.instructionType == InstructionJmp {
+			return
+		}
+	}
+	w.reach(ip+1, next, ip)
+}
+
+// need checks that the stack at depth holds the values the instruction at
+// ip takes, and reports whether it does.
+func (w *stackWalk) need(ip, depth, pops int) bool {
+	if w.function {
+		w.low = min(w.low, depth-pops)
+		return true
+	}
+	if depth >= pops {
+		return true
+	}
+	w.errorf(ip, "stack underflow: %s needs %s but the stack holds %d",
+		w.v.opName(ip), values(pops), depth)
+	return false
+}
+
+func (w *stackWalk) errorf(ip int, format string, a ...any) {
+	w.errorAt(ip, w.v.program.instructions[ip], format, a...)
+}
+
+// errorAt reports an error at the instruction at ip, located at instr,
+// which is the instruction or one of its parts.
+func (w *stackWalk) errorAt(ip int, instr Instruction, format string, a ...any) {
+	w.errors = append(w.errors, &StackError{
+		File:    instr.fileName,
+		Line:    instr.line,
+		IP:      ip,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// effect returns the stack effect of the instruction at ip, or false if
+// it never completes: a call to a function that never returns, or an
+// operand that faults.
+func (v *stackVerifier) effect(ip int) (StackEffect, bool) {
+	instr := v.program.instructions[ip]
+	switch instr.instructionType {
+	case InstructionCall:
+		target, ok := v.target(ip)
+		if !ok {
+			return StackEffect{}, false
+		}
+		summary := v.functions[target]
+		return summary.effect, summary.returns && !v.unsettled[target]
+	case InstructionNative:
+		if instr.value.Type() != LiteralInt {
+			return StackEffect{}, false
+		}
+		native, ok := nativeSignatures[instr.value.Int()]
+		return native.Effect(), ok
+	case InstructionIndex:
+		if instr.value.Type() == LiteralChar {
+			return StackEffect{2, 1}, true
+		}
+		return StackEffect{3, 1}, true
+	}
+	effect, ok := stackEffects[instr.instructionType]
+	return effect, ok
+}
+
+// callNeeds returns what the function the call at ip goes to takes from
+// the stack before it stops returning.
+func (v *stackVerifier) callNeeds(ip int) int {
+	target, ok := v.target(ip)
+	if !ok || v.unsettled[target] {
+		return 0
+	}
+	return v.functions[target].effect.Pops
+}
+
+// opName names the instruction at ip for messages.
+func (v *stackVerifier) opName(ip int) string {
+	instr := v.program.instructions[ip]
+	name := strings.ToLower(instr.instructionType.String())
+	switch instr.instructionType {
+	case InstructionCall:
+		if target, ok := v.target(ip); ok {
+			name += " " + functionName(v.labels, target)
+		}
+	case InstructionNative:
+		if native, ok := nativeSignatures[instr.value.Int()]; ok {
+			name += " " + native.Name
+		}
+	}
+	return name
+}
+
+// recurses reports whether the function at entry can call itself, given
+// the functions each function calls.
+func recurses(calls map[int]map[int]bool, entry int) bool {
+	seen := make(map[int]bool)
+	work := []int{entry}
+	for len(work) > 0 {
+		fn := work[len(work)-1]
+		work = work[:len(work)-1]
+		for callee := range calls[fn] {
+			if callee == entry {
+				return true
+			}
+			if !seen[callee] {
+				seen[callee] = true
+				work = append(work, callee)
+			}
+		}
+	}
+	return false
+}
+
+// values formats a count of stack values.
+func values(n int) string {
+	if n == 1 {
+		return "1 value"
+	}
+	return fmt.Sprintf("%d values", n)
+}
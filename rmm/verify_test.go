@@ -0,0 +1,163 @@
+package rmm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestVerifyStack(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		errors []string // "line: message"
+	}{
+		{
+			name:   "balanced",
+			source: "push 1\npush 2\nadd\nprint\n",
+		},
+		{
+			name:   "underflow",
+			source: "push 1\nadd\nprint\n",
+			errors: []string{"2: stack underflow: add needs 2 values but the stack holds 1"},
+		},
+		{
+			name:   "native arity",
+			source: "push 1\npush 2\nnative 0\n",
+			errors: []string{"3: stack underflow: native open needs 3 values but the stack holds 2"},
+		},
+		{
+			name:   "exit ends the path",
+			source: "push 0\nnative 60\npop\n",
+		},
+		{
+			name:   "join at different depths",
+			source: "push 1\npush 0\nzjmp skip\npush 2\nskip:\nprint\n",
+			errors: []string{"6: stack depth differs where paths meet: 1 from line 3, 2 from line 4"},
+		},
+		{
+			name:   "balanced loop",
+			source: "push 3\nloop:\npush 1\nsub\ndup\nnzjmp loop\npop\n",
+		},
+		{
+			name:   "indup past the stack",
+			source: "push 1\nindup 1\n",
+			errors: []string{"2: indup 1 reaches past the stack, which holds 1 value"},
+		},
+		{
+			name:   "call takes too much",
+			source: "entrypoint main\nsquare:\ndup\nmul\nret\nmain:\ncall square\nprint\n",
+			errors: []string{"7: stack underflow: call square needs 1 value but the stack holds 0"},
+		},
+		{
+			name:   "returns at different depths",
+			source: "push 1\ncall f\nhalt\nf:\nzjmp one\nret\none:\npush 1\nret\n",
+			errors: []string{"9: ret leaves the stack at +0 here but at -1 on line 6"},
+		},
+		{
+			name:   "superinstructions",
+			source: "mov r0 1\npush r0\npush 1\nadd\npush 1\ncmpe\nzjmp end\nend:\n",
+		},
+	}
+	for _, tt := range tests {
+		program, err := Compile(tt.source)
+		if err != nil {
+			t.Fatalf("%s: failed to compile: %v", tt.name, err)
+		}
+		for _, level := range []int{0, 2} {
+			var got []string
+			for _, err := range program.Optimize(level).VerifyStack().Errors {
+				got = append(got, fmt.Sprintf("%d: %s", err.Line, err.Message))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.errors, "\n") {
+				t.Errorf("%s at -O%d: expected errors %q, got %q", tt.name, level, tt.errors, got)
+			}
+		}
+	}
+}
+
+func TestVerifyStackFunctions(t *testing.T) {
+	source := `entrypoint main
+fib:
+	dup
+	push 2
+	cmpl
+	nzjmp base
+	dup
+	push 1
+	sub
+	call fib
+	swap
+	push 2
+	sub
+	call fib
+	add
+base:
+	ret
+spin:
+	jmp spin
+pair:
+	push 1
+	push 2
+	ret
+main:
+	push 10
+	call fib
+	print
+	call pair
+	add
+	print
+	call spin
+`
+	program, err := Compile(source)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	report := program.VerifyStack()
+	if len(report.Errors) != 0 {
+		t.Errorf("expected no errors, got %v", report.Errors)
+	}
+	want := map[string]FunctionEffect{
+		"fib":  {Effect: StackEffect{1, 1}, Returns: true, Settled: true, Line: 3},
+		"spin": {Effect: StackEffect{0, 0}, Returns: false, Settled: true, Line: 19},
+		"pair": {Effect: StackEffect{0, 2}, Returns: true, Settled: true, Line: 21},
+	}
+	if len(report.Functions) != len(want) {
+		t.Fatalf("expected %d functions, got %+v", len(want), report.Functions)
+	}
+	for _, fn := range report.Functions {
+		w := want[fn.Name]
+		if fn.Effect != w.Effect || fn.Returns != w.Returns || fn.Settled != w.Settled || fn.Line != w.Line {
+			t.Errorf("%s: expected %+v, got %+v", fn.Name, w, fn)
+		}
+	}
+
+	var sb strings.Builder
+	if err := report.WriteReport(&sb); err != nil {
+		t.Fatalf("failed to write report: %v", err)
+	}
+	for _, line := range []string{
+		"  fib (main.rmm:3): pops 1, pushes 1, net +0\n",
+		"  spin (main.rmm:19): pops 0, never returns\n",
+		"stack: ok\n",
+	} {
+		if !strings.Contains(sb.String(), line) {
+			t.Errorf("expected %q in the report:\n%s", line, sb.String())
+		}
+	}
+}
+
+func TestVerifyStackUnsettledRecursion(t *testing.T) {
+	source := "push 1\ncall f\nhalt\nf:\ndup\nzjmp done\npop\ncall f\ndone:\nret\n"
+	program, err := Compile(source)
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	report := program.VerifyStack()
+	if len(report.Errors) != 1 || !strings.Contains(report.Errors[0].Message, "f does not settle") {
+		t.Errorf("expected f not to settle, got %v", report.Errors)
+	}
+	if len(report.Functions) != 1 || report.Functions[0].Settled {
+		t.Errorf("expected f to be unsettled, got %+v", report.Functions)
+	}
+}
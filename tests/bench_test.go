@@ -0,0 +1,36 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"vm/rmm"
+)
+
+// BenchmarkPrograms runs the fibonacci, prime and string test programs in
+// process, so the interpreter is measured without the cost of go run.
+func BenchmarkPrograms(b *testing.B) {
+	cases := []ProgramTestCase{fib, fibRecTest, isPrime}
+	cases = append(cases, stringTests...)
+	seen := make(map[string]bool)
+	for _, tc := range cases {
+		if tc.expectedError != "" || seen[tc.name] {
+			continue
+		}
+		seen[tc.name] = true
+		b.Run(tc.name, func(b *testing.B) {
+			program, err := compileInProcess(tc)
+			if err != nil {
+				b.Fatalf("failed to compile: %v", err)
+			}
+			b.ReportAllocs()
+			for b.Loop() {
+				machine := rmm.NewMachine(rmm.Options{Stdin: strings.NewReader(tc.input)})
+				if err := machine.Run(context.Background(), program); err != nil {
+					b.Fatalf("failed to run: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -52,3 +52,19 @@ var fibRecTest = ProgramTestCase{
 	expected:        []string{"55"},
 	additionalFiles: StdDefs,
 }
+
+var stackTraceTest = ProgramTestCase{
+	name: "stack_trace_on_fault",
+	program: `
+	entrypoint main
+	helper:
+		pop
+		pop
+		ret
+	main:
+		push 1
+		call helper
+		halt
+	`,
+	expectedError: ".rmm:9) ip 4, stack top: []",
+}
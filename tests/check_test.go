@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestCheckPrograms checks that the type checker passes the test programs
+// that run without errors.
+func TestCheckPrograms(t *testing.T) {
+	cases := []ProgramTestCase{fib, label, floatPush, isPrime, fibRecTest, FizzBuzzTest, NegativeTest}
+	cases = append(cases, stringTests...)
+	cases = append(cases, stdLibTests...)
+	for _, tc := range cases {
+		if tc.expectedError != "" {
+			continue
+		}
+		program, err := compileInProcess(tc)
+		if err != nil {
+			t.Fatalf("%s: compile: %v", tc.name, err)
+		}
+		if errs := program.CheckTypes().Errors; len(errs) > 0 {
+			t.Errorf("%s: expected no type errors, got %v", tc.name, errs)
+		}
+	}
+}
+
+// TestCheckCommand checks that check reports type errors by line and exits
+// 1 when it finds any.
+func TestCheckCommand(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"good.rmm": "push 1.5\nftoi\npush 2\nmul\nprint\n",
+		"bad.rmm":  "push 1\npush 2.5\nadd\nprint\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	binary := buildVM(t, tmpDir)
+
+	good := exec.Command(binary, "check", "good.rmm")
+	good.Dir = tmpDir
+	out, err := good.CombinedOutput()
+	if err != nil || string(out) != "types: ok\n" {
+		t.Errorf("expected types: ok, got %v: %q", err, out)
+	}
+
+	bad := exec.Command(binary, "check", "bad.rmm", "-O2")
+	bad.Dir = tmpDir
+	out, err = bad.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Errorf("expected check to exit 1, got %v", err)
+	}
+	want := "bad.rmm:3: add needs two ints, two floats, or a pointer and an int, but gets an int pushed at line 1 and a float pushed at line 2\ntypes: 1 error\n"
+	if string(out) != want {
+		t.Errorf("expected %q, got %q", want, out)
+	}
+}
@@ -31,6 +31,7 @@ var StdDefs = map[string]string{
 	@def float_to_str native 98
 	@def int_to_str native 99
 	@def assert native 100
+	@def gc native 101
 	`,
 	"std.rmm": `print_newline:
     push '\n'
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCoverFlag checks that --cover prints a report per file to stderr and
+// writes the LCOV file, covering imported files too.
+func TestCoverFlag(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"lib.rmm": `
+		sign:
+			push 0
+			cmpl
+			ret
+		`,
+		"main.rmm": `
+		@imp "lib.rmm"
+		entrypoint main
+		main:
+			push 5
+			call sign
+			print
+		`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	binary := buildVM(t, tmpDir)
+
+	run := exec.Command(binary, "main.rmm", "--cover=cov.info")
+	run.Dir = tmpDir
+	var stdout, stderr bytes.Buffer
+	run.Stdout, run.Stderr = &stdout, &stderr
+	if err := run.Run(); err != nil || stdout.String() != "INT 0\n" {
+		t.Fatalf("run failed: %v\n%s%s", err, stdout.String(), stderr.String())
+	}
+	wantReport := "lib.rmm: 100.0% of lines (3/3), 100.0% of instructions (3/3), 100.0% of branches (0/0)\n" +
+		"main.rmm: 100.0% of lines (3/3), 100.0% of instructions (3/3), 100.0% of branches (0/0)\n"
+	if stderr.String() != wantReport {
+		t.Errorf("unexpected report:\n%s", stderr.String())
+	}
+
+	lcov, err := os.ReadFile(filepath.Join(tmpDir, "cov.info"))
+	if err != nil {
+		t.Fatalf("failed to read LCOV file: %v", err)
+	}
+	if !strings.Contains(string(lcov), "SF:lib.rmm\nFN:3,sign\nFNDA:1,sign\n") ||
+		strings.Count(string(lcov), "end_of_record\n") != 2 {
+		t.Errorf("unexpected LCOV file:\n%s", lcov)
+	}
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestExecProgramBin checks that running program.bin via `exec` behaves
+// exactly like running the source it was built from.
+func TestExecProgramBin(t *testing.T) {
+	tmpDir := t.TempDir()
+	for filename, content := range StdDefs {
+		if err := os.WriteFile(filepath.Join(tmpDir, filename), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file %s: %v", filename, err)
+		}
+	}
+	program := `
+	@imp "stddefs.rmm"
+	entrypoint main
+	push_str "hello from bin\n"
+	main:
+		get_str 0
+		push STDOUT
+		write
+		pop
+		mov r3 42
+		push r3
+		int_to_str
+		push STDOUT
+		write
+		halt
+	`
+	mainFile := filepath.Join(tmpDir, "main.rmm")
+	if err := os.WriteFile(mainFile, []byte(program), 0644); err != nil {
+		t.Fatalf("failed to write main file: %v", err)
+	}
+
+	binary := buildVM(t, tmpDir)
+
+	run := exec.Command(binary, mainFile)
+	run.Dir = tmpDir
+	fromSource, err := run.CombinedOutput()
+	if err != nil {
+		t.Fatalf("source run failed: %v\n%s", err, fromSource)
+	}
+
+	execCmd := exec.Command(binary, "exec", "program.bin")
+	execCmd.Dir = tmpDir
+	fromBinary, err := execCmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("exec run failed: %v\n%s", err, fromBinary)
+	}
+
+	expected := "hello from bin\n42"
+	if string(fromSource) != expected {
+		t.Fatalf("unexpected source output %q", fromSource)
+	}
+	if string(fromBinary) != string(fromSource) {
+		t.Fatalf("exec output %q differs from source output %q", fromBinary, fromSource)
+	}
+}
+
+// buildVM builds the rmm binary into dir and returns its path.
+func buildVM(t *testing.T, dir string) string {
+	t.Helper()
+	vmModuleRoot, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatalf("failed to get VM module root: %v", err)
+	}
+	binary := filepath.Join(dir, "rmm")
+	build := exec.Command("go", "build", "-o", binary, ".")
+	build.Dir = vmModuleRoot
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build vm: %v\n%s", err, out)
+	}
+	return binary
+}
@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestExitCodes checks that each class of failure gets its own exit status.
+func TestExitCodes(t *testing.T) {
+	tmpDir := t.TempDir()
+	binary := buildVM(t, tmpDir)
+
+	sources := map[string]string{
+		"ok.rmm":      "push 1\npop\n",
+		"exit.rmm":    "push 3\nnative 60\n",
+		"syntax.rmm":  "jmp nowhere\n",
+		"runtime.rmm": "push 1\npush 0\ndiv\n",
+		"import.rmm":  "@imp \"missing.rmm\"\n",
+		"loop.rmm":    "loop:\njmp loop\n",
+		"alloc.rmm":   "push 100\nnative 4\n",
+		"garbage.rmm": "loop:\npush 100\nnative 4\npop\njmp loop\n",
+	}
+	for name, src := range sources {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(src), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "bad.bin"), []byte("not a program"), 0644); err != nil {
+		t.Fatalf("failed to write bad.bin: %v", err)
+	}
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"ok.rmm"}, 0},
+		{[]string{"exit.rmm"}, 3},
+		{[]string{"syntax.rmm"}, 65},
+		{[]string{"import.rmm"}, 66},
+		{[]string{"missing.rmm"}, 66},
+		{[]string{"runtime.rmm"}, 70},
+		{[]string{"exec", "bad.bin"}, 65},
+		{[]string{"exec", "missing.bin"}, 66},
+		{[]string{"exec"}, 64},
+		{[]string{"loop.rmm", "--max-steps=100"}, 124},
+		{[]string{"loop.rmm", "--timeout=50ms"}, 124},
+		{[]string{"ok.rmm", "--timeout=soon"}, 64},
+		{[]string{"alloc.rmm", "--max-heap=10"}, 70},
+		{[]string{"alloc.rmm", "--max-heap=0"}, 64},
+		{[]string{"garbage.rmm", "--max-heap=1000", "--max-steps=1000"}, 70},
+		{[]string{"garbage.rmm", "--max-heap=1000", "--max-steps=1000", "--gc"}, 124},
+		{[]string{"garbage.rmm", "--gc-threshold=0"}, 64},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command(binary, tt.args...)
+		cmd.Dir = tmpDir
+		out, err := cmd.CombinedOutput()
+		code := 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		} else if err != nil {
+			t.Fatalf("%v: failed to run: %v", tt.args, err)
+		}
+		if code != tt.code {
+			t.Errorf("%v: expected exit status %d, got %d\n%s", tt.args, tt.code, code, out)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGraphCommand checks that graph writes the blocks and the call graph
+// as DOT and JSON.
+func TestGraphCommand(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := "entrypoint main\nsquare:\ndup\nmul\nret\nmain:\npush 3\ncall square\nprint\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "main.rmm"), []byte(source), 0644); err != nil {
+		t.Fatalf("failed to write main.rmm: %v", err)
+	}
+	binary := buildVM(t, tmpDir)
+
+	run := func(flags ...string) string {
+		t.Helper()
+		cmd := exec.Command(binary, append([]string{"graph", "main.rmm"}, flags...)...)
+		cmd.Dir = tmpDir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("graph %v failed: %v\n%s", flags, err, out)
+		}
+		return string(out)
+	}
+
+	if out := run(); !strings.HasPrefix(out, "digraph cfg {") || !strings.Contains(out, "\tb1 -> b2 [label=\"return\"];\n") {
+		t.Errorf("expected the blocks as DOT, got:\n%s", out)
+	}
+	if out := run("--calls"); !strings.Contains(out, "\tf0 -> f1 [label=\"main.rmm:8\"];\n") {
+		t.Errorf("expected main to call square on line 8, got:\n%s", out)
+	}
+
+	var calls struct {
+		Calls []struct {
+			Caller, Callee string
+			Line           int
+		}
+	}
+	if err := json.Unmarshal([]byte(run("--calls", "--json")), &calls); err != nil {
+		t.Fatalf("failed to decode the call graph: %v", err)
+	}
+	if len(calls.Calls) != 1 || calls.Calls[0].Caller != "main" || calls.Calls[0].Callee != "square" || calls.Calls[0].Line != 8 {
+		t.Errorf("expected main to call square on line 8, got %+v", calls.Calls)
+	}
+
+	var cfg struct {
+		Blocks []struct {
+			Label string
+			Lines []struct{ Line int }
+		}
+	}
+	if err := json.Unmarshal([]byte(run("--json", "-O2")), &cfg); err != nil {
+		t.Fatalf("failed to decode the blocks: %v", err)
+	}
+	if len(cfg.Blocks) != 3 || cfg.Blocks[0].Label != "square" || len(cfg.Blocks[0].Lines) != 3 {
+		t.Errorf("expected 3 blocks starting with square, got %+v", cfg.Blocks)
+	}
+}
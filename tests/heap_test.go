@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestHeapStatsFlag checks that --heap-stats reports freed cells as reused.
+func TestHeapStatsFlag(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := `
+	push 4
+	native 4
+	native 6
+	push 2
+	native 4
+	push 2
+	native 4
+	`
+	if err := os.WriteFile(filepath.Join(tmpDir, "main.rmm"), []byte(source), 0644); err != nil {
+		t.Fatalf("failed to write main.rmm: %v", err)
+	}
+	binary := buildVM(t, tmpDir)
+
+	run := exec.Command(binary, "main.rmm", "--heap-stats")
+	run.Dir = tmpDir
+	var stderr bytes.Buffer
+	run.Stderr = &stderr
+	if err := run.Run(); err != nil {
+		t.Fatalf("run failed: %v\n%s", err, stderr.String())
+	}
+	want := "heap: 4 cells, 4 live in 2 allocations (peak 4), 0 free in 0 blocks, 0.0% fragmented\n"
+	if stderr.String() != want {
+		t.Errorf("expected %q, got %q", want, stderr.String())
+	}
+}
+
+// TestMemcheckFlag checks that --memcheck faults on a use after free and
+// prints the leak report either way.
+func TestMemcheckFlag(t *testing.T) {
+	tmpDir := t.TempDir()
+	sources := map[string]string{
+		"leak.rmm": "push 2\nnative 4\npush 3\nnative 4\nnative 6\n",
+		"uaf.rmm":  "push 2\nnative 4\ndup\nnative 6\nderef\n",
+	}
+	for name, src := range sources {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(src), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	binary := buildVM(t, tmpDir)
+
+	tests := []struct {
+		file   string
+		code   int
+		stderr string
+	}{
+		{"leak.rmm", 0, "memcheck: 2 cells leaked in 1 allocations, of 2 allocations and 1 frees\n  leak.rmm:2: 2 cells in 1 allocations\n"},
+		{"uaf.rmm", 70, "memcheck: no leaks, 1 allocations and 1 frees\nERROR (uaf.rmm:5): memcheck: use after free: cell 0 of the 2-cell block at 0 allocated at uaf.rmm:2 and freed at uaf.rmm:4\n"},
+	}
+	for _, tt := range tests {
+		run := exec.Command(binary, tt.file, "--memcheck")
+		run.Dir = tmpDir
+		var stderr bytes.Buffer
+		run.Stderr = &stderr
+		err := run.Run()
+		code := 0
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		} else if err != nil {
+			t.Fatalf("%s: failed to run: %v", tt.file, err)
+		}
+		if code != tt.code || !strings.HasPrefix(stderr.String(), tt.stderr) {
+			t.Errorf("%s: expected exit status %d and stderr starting %q, got %d and %q", tt.file, tt.code, tt.stderr, code, stderr.String())
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"vm/rmm"
+)
+
+// compileInProcess compiles a test case's program and the files it
+// imports without writing them out.
+func compileInProcess(tc ProgramTestCase) (*rmm.Program, error) {
+	return rmm.CompileFileWith("main.rmm", func(path string) ([]byte, error) {
+		name := filepath.Base(path)
+		if name == "main.rmm" {
+			return []byte(tc.program), nil
+		}
+		if content, ok := tc.additionalFiles[name]; ok {
+			return []byte(content), nil
+		}
+		return nil, fmt.Errorf("no file %s", name)
+	})
+}
+
+// TestOptimizedPrograms runs the test programs at every optimization level
+// and expects the same output and the same errors, at the same lines.
+func TestOptimizedPrograms(t *testing.T) {
+	cases := operatorsTest
+	cases = append(cases, fib, label, label2, floatPush, isPrime, fibRecTest, stackTraceTest, FizzBuzzTest, NegativeTest)
+	cases = append(cases, stringTests...)
+	cases = append(cases, registerTests...)
+	cases = append(cases, registerExtendedTests...)
+	cases = append(cases, LineNumberTests...)
+	cases = append(cases, castTests...)
+	cases = append(cases, pointerTests...)
+	cases = append(cases, indexTests...)
+	cases = append(cases, entrypointTests...)
+	cases = append(cases, stdLibTests...)
+	for _, tc := range cases {
+		if tc.cleanup != nil {
+			continue
+		}
+		program, err := compileInProcess(tc)
+		if err != nil {
+			continue
+		}
+		run := func(p *rmm.Program) string {
+			var stdout, stderr bytes.Buffer
+			machine := rmm.NewMachine(rmm.Options{Stdin: strings.NewReader(tc.input), Stdout: &stdout, Stderr: &stderr})
+			err := machine.Run(context.Background(), p)
+			return fmt.Sprintf("stdout %q\nstderr %q\nerror %v", stdout.String(), stderr.String(), err)
+		}
+		want := run(program)
+		for level := 1; level <= rmm.MaxOptLevel; level++ {
+			if got := run(program.Optimize(level)); got != want {
+				t.Errorf("%s: -O%d changed the run\nwant:\n%s\ngot:\n%s", tc.name, level, want, got)
+			}
+		}
+	}
+}
+
+// TestOptimizeFlag checks that -O1 runs and writes the optimized program
+// and that an unknown level is a usage error.
+func TestOptimizeFlag(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "main.rmm"), []byte("push 2\npush 3\nadd\nprint\n"), 0644); err != nil {
+		t.Fatalf("failed to write main.rmm: %v", err)
+	}
+	binary := buildVM(t, tmpDir)
+
+	run := exec.Command(binary, "main.rmm", "-O1")
+	run.Dir = tmpDir
+	out, err := run.CombinedOutput()
+	if err != nil || string(out) != "INT 5\n" {
+		t.Fatalf("expected INT 5, got %v: %q", err, out)
+	}
+	disasm := exec.Command(binary, "disasm", "program.bin")
+	disasm.Dir = tmpDir
+	out, err = disasm.CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "push 5\n") || strings.Contains(string(out), "add") {
+		t.Errorf("expected program.bin to hold the folded program, got %v:\n%s", err, out)
+	}
+
+	bad := exec.Command(binary, "main.rmm", "-O9")
+	bad.Dir = tmpDir
+	var exitErr *exec.ExitError
+	if err := bad.Run(); !errors.As(err, &exitErr) || exitErr.ExitCode() != 64 {
+		t.Errorf("expected -O9 to exit 64, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestProfileFlag checks that --profile writes a profile go tool pprof can
+// read, with counts per function and per line.
+func TestProfileFlag(t *testing.T) {
+	tmpDir := t.TempDir()
+	program := `
+	entrypoint main
+	square:
+		dup
+		mul
+		ret
+	main:
+		push 3
+		call square
+		call square
+		print
+	`
+	if err := os.WriteFile(filepath.Join(tmpDir, "main.rmm"), []byte(program), 0644); err != nil {
+		t.Fatalf("failed to write main file: %v", err)
+	}
+	binary := buildVM(t, tmpDir)
+
+	run := exec.Command(binary, "main.rmm", "--profile")
+	run.Dir = tmpDir
+	if out, err := run.CombinedOutput(); err != nil || string(out) != "INT 81\n" {
+		t.Fatalf("run failed: %v\n%s", err, out)
+	}
+
+	pprof := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("go", append([]string{"tool", "pprof", "-sample_index=instructions"}, args...)...)
+		cmd.Dir = tmpDir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("pprof %v failed: %v\n%s", args, err, out)
+		}
+		return string(out)
+	}
+	top := pprof("-top", "rmm.pprof")
+	for _, want := range []string{"File: main.rmm", "Total samples = 10", "6 60.00%", "square"} {
+		if !strings.Contains(top, want) {
+			t.Errorf("pprof -top output lacks %q:\n%s", want, top)
+		}
+	}
+	lines := pprof("-top", "-lines", "rmm.pprof")
+	if !strings.Contains(lines, "square main.rmm:4") {
+		t.Errorf("pprof -lines output lacks square's lines:\n%s", lines)
+	}
+}
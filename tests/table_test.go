@@ -10,6 +10,10 @@ import (
 	"testing"
 )
 
+// harnessTimeout stops a program that never halts with an error instead
+// of hanging the test run.
+const harnessTimeout = "--timeout=10s"
+
 type ProgramTestCase struct {
 	name            string
 	program         string
@@ -41,6 +45,7 @@ func TestPrograms(t *testing.T) {
 	cases = append(cases, StrlenTest)
 	cases = append(cases, NativeStringTest...)
 	cases = append(cases, fibRecTest)
+	cases = append(cases, stackTraceTest)
 	cases = append(cases, castTests...)
 	cases = append(cases, castTests...)
 	cases = append(cases, pointerTests...)
@@ -105,7 +110,7 @@ func TestPrograms(t *testing.T) {
 				}
 
 				var stdout, stderr strings.Builder
-				cmd := exec.Command("go", "run", ".", mainFilePath)
+				cmd := exec.Command("go", "run", ".", mainFilePath, harnessTimeout)
 				cmd.Dir = vmModuleRoot
 				cmd.Stdout = &stdout
 				cmd.Stderr = &stderr
@@ -189,7 +194,7 @@ func TestPrograms(t *testing.T) {
 				t.Fatalf("failed to close temp file: %v", err)
 			}
 
-			cmd := exec.Command("go", "run", "..", tmp.Name())
+			cmd := exec.Command("go", "run", "..", tmp.Name(), harnessTimeout)
 			outBytes, err := cmd.CombinedOutput()
 
 			if err != nil {
@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTraceFlags checks that --trace writes the same filtered trace for a
+// source file and for the program.bin built from it.
+func TestTraceFlags(t *testing.T) {
+	tmpDir := t.TempDir()
+	program := `
+	entrypoint main
+	square:
+		dup
+		mul
+		ret
+	main:
+		push 3
+		call square
+		mov r1 top
+		push r1
+		print
+		halt
+	`
+	mainFile := filepath.Join(tmpDir, "main.rmm")
+	if err := os.WriteFile(mainFile, []byte(program), 0644); err != nil {
+		t.Fatalf("failed to write main file: %v", err)
+	}
+	binary := buildVM(t, tmpDir)
+
+	traceRun := func(args ...string) []byte {
+		t.Helper()
+		cmd := exec.Command(binary, args...)
+		cmd.Dir = tmpDir
+		if out, err := cmd.CombinedOutput(); err != nil || string(out) != "INT 9\n" {
+			t.Fatalf("%v failed: %v\n%s", args, err, out)
+		}
+		trace, err := os.ReadFile(filepath.Join(tmpDir, "out.jsonl"))
+		if err != nil {
+			t.Fatalf("failed to read trace: %v", err)
+		}
+		return trace
+	}
+
+	fromSource := traceRun("main.rmm", "--trace=out.jsonl", "--trace-range=square:main", "--trace-top=1")
+	want := `{"ip":0,"op":"dup","file":"main.rmm","line":4,"depth":2,"top":["INT 3"]}
+{"ip":1,"op":"mul","file":"main.rmm","line":5,"depth":1,"top":["INT 9"]}
+{"ip":2,"op":"ret","file":"main.rmm","line":6,"depth":1,"top":["INT 9"]}
+`
+	if string(fromSource) != want {
+		t.Errorf("unexpected trace:\n%s", fromSource)
+	}
+	fromBinary := traceRun("exec", "program.bin", "--trace=out.jsonl", "--trace-range=square:main", "--trace-top=1")
+	if !bytes.Equal(fromBinary, fromSource) {
+		t.Errorf("program.bin trace differs:\n%s", fromBinary)
+	}
+
+	ops := traceRun("main.rmm", "--trace=out.jsonl", "--trace-ops=mov_top,call")
+	lines := strings.Split(strings.TrimSpace(string(ops)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"op":"call","operand":"square"`) ||
+		!strings.Contains(lines[1], `"regs":[{"index":1,"value":"INT 9"}]`) {
+		t.Errorf("unexpected filtered trace:\n%s", ops)
+	}
+
+	cmd := exec.Command(binary, "main.rmm", "--trace=out.jsonl", "--trace-range=nowhere:")
+	cmd.Dir = tmpDir
+	var exitErr *exec.ExitError
+	if out, err := cmd.CombinedOutput(); !errors.As(err, &exitErr) || exitErr.ExitCode() != 64 {
+		t.Errorf("expected an unknown label to exit 64, got %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestVerifyPrograms checks that the stack verifier passes test programs
+// whose stack is balanced on every path.
+func TestVerifyPrograms(t *testing.T) {
+	for _, tc := range []ProgramTestCase{fib, label, isPrime, fibRecTest, FizzBuzzTest, NegativeTest} {
+		program, err := compileInProcess(tc)
+		if err != nil {
+			t.Fatalf("%s: failed to compile: %v", tc.name, err)
+		}
+		if errs := program.VerifyStack().Errors; len(errs) > 0 {
+			t.Errorf("%s: expected no stack errors, got %v", tc.name, errs)
+		}
+	}
+}
+
+// TestVerifyCommand checks that verify reports stack errors by line and
+// exits 1 when it finds any.
+func TestVerifyCommand(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"good.rmm": "entrypoint main\nsquare:\ndup\nmul\nret\nmain:\npush 3\ncall square\nprint\n",
+		"bad.rmm":  "push 1\nadd\nprint\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	binary := buildVM(t, tmpDir)
+
+	good := exec.Command(binary, "verify", "good.rmm")
+	good.Dir = tmpDir
+	out, err := good.CombinedOutput()
+	want := "functions:\n  square (good.rmm:3): pops 1, pushes 1, net +0\nstack: ok\n"
+	if err != nil || string(out) != want {
+		t.Errorf("expected %q, got %v: %q", want, err, out)
+	}
+
+	bad := exec.Command(binary, "verify", "bad.rmm")
+	bad.Dir = tmpDir
+	out, err = bad.CombinedOutput()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Errorf("expected verify to exit 1, got %v", err)
+	}
+	if !strings.Contains(string(out), "bad.rmm:2: stack underflow: add needs 2 values but the stack holds 1\n") {
+		t.Errorf("expected the underflow on line 2, got:\n%s", out)
+	}
+}
@@ -0,0 +1,37 @@
+const vscode = require('vscode');
+const { LanguageClient } = require('vscode-languageclient/node');
+
+let client;
+
+function executable() {
+  return vscode.workspace.getConfiguration('rmm').get('executable', 'rmm');
+}
+
+// Starts `rmm dap` for every rmm debug session. The executable can be
+// overridden with the rmm.executable setting.
+class RmmDebugAdapterFactory {
+  createDebugAdapterDescriptor() {
+    return new vscode.DebugAdapterExecutable(executable(), ['dap']);
+  }
+}
+
+function activate(context) {
+  context.subscriptions.push(
+    vscode.debug.registerDebugAdapterDescriptorFactory('rmm', new RmmDebugAdapterFactory())
+  );
+
+  // `rmm lsp` provides diagnostics, navigation, hover and completion
+  client = new LanguageClient(
+    'rmm',
+    'rmm Language Server',
+    { command: executable(), args: ['lsp'] },
+    { documentSelector: [{ scheme: 'file', language: 'rmm' }] }
+  );
+  client.start();
+}
+
+function deactivate() {
+  return client ? client.stop() : undefined;
+}
+
+module.exports = { activate, deactivate };